@@ -0,0 +1,472 @@
+// Package config loads the application settings.
+// Values are resolved in the following order, later sources overriding earlier ones:
+// built-in defaults, a YAML or TOML config file, environment variables and command-line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix shared by all environment variables read by Load
+const EnvPrefix = "APP_"
+
+// Config is the typed application configuration
+type Config struct {
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	Storage  StorageConfig  `yaml:"storage" toml:"storage"`
+	Catalog  CatalogConfig  `yaml:"catalog" toml:"catalog"`
+	Payments PaymentsConfig `yaml:"payments" toml:"payments"`
+	Tax      TaxConfig      `yaml:"tax" toml:"tax"`
+}
+
+// ServerConfig holds the settings of the HTTP server
+type ServerConfig struct {
+	Host         string        `yaml:"host" toml:"host"`                   // Interface to listen on, empty for all
+	Port         int           `yaml:"port" toml:"port"`                   // Port to listen on
+	AppName      string        `yaml:"app_name" toml:"app_name"`           // Name reported by Fiber
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`   // Maximum duration for reading a request
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"` // Maximum duration for writing a response
+	BodyLimit    int           `yaml:"body_limit" toml:"body_limit"`       // Maximum request body size in bytes
+}
+
+// Supported database drivers
+const (
+	DriverPostgres     = "postgres"      // PostgreSQL, used in production
+	DriverMySQL        = "mysql"         // MySQL or MariaDB
+	DriverSQLite       = "sqlite"        // Embedded SQLite file at Path
+	DriverSQLiteMemory = "sqlite-memory" // Embedded in-memory SQLite, discarded on exit
+)
+
+// Schema migration modes applied at startup
+const (
+	MigrateAuto  = "auto"  // Apply pending migrations before serving
+	MigrateCheck = "check" // Refuse to serve while migrations are pending
+	MigrateOff   = "off"   // Skip the schema check entirely
+)
+
+// DatabaseConfig holds the connection details of the database
+type DatabaseConfig struct {
+	Driver   string `yaml:"driver" toml:"driver"` // One of the Driver constants
+	Path     string `yaml:"path" toml:"path"`     // Database file, only used by the sqlite driver
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"` // Zero selects the driver's default port
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode"`
+	TimeZone string `yaml:"timezone" toml:"timezone"`
+	Migrate  string `yaml:"migrate" toml:"migrate"` // One of the Migrate constants
+}
+
+// AuthConfig holds the token lifetimes and signing keys of the authentication flow
+type AuthConfig struct {
+	KeysDir         string        `yaml:"keys_dir" toml:"keys_dir"`                   // Directory of PEM keys named <kid>.pem, empty for an ephemeral development key
+	SigningKey      string        `yaml:"signing_key" toml:"signing_key"`             // Key ID used to sign new tokens
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`   // Lifetime of access tokens
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"` // Lifetime of refresh tokens
+}
+
+// Supported file storage drivers
+const (
+	StorageLocal = "local" // Directory of the local filesystem, served by the application
+	StorageS3    = "s3"    // Bucket of an S3-compatible object store
+)
+
+// StorageConfig holds the location of uploaded files such as product images
+type StorageConfig struct {
+	Driver          string `yaml:"driver" toml:"driver"`                       // One of the Storage constants
+	Dir             string `yaml:"dir" toml:"dir"`                             // Directory of the files, only used by the local driver
+	BaseURL         string `yaml:"base_url" toml:"base_url"`                   // Path the local directory is served under
+	Endpoint        string `yaml:"endpoint" toml:"endpoint"`                   // Base URL of the S3 service
+	Bucket          string `yaml:"bucket" toml:"bucket"`                       // S3 bucket of the files
+	Region          string `yaml:"region" toml:"region"`                       // S3 region used for signing requests
+	AccessKeyID     string `yaml:"access_key_id" toml:"access_key_id"`         // S3 credentials
+	SecretAccessKey string `yaml:"secret_access_key" toml:"secret_access_key"` // Secret of the S3 access key
+	PublicURL       string `yaml:"public_url" toml:"public_url"`               // URL prefix S3 files are served under, such as a CDN, empty for the bucket URL
+	MaxImageSize    int    `yaml:"max_image_size" toml:"max_image_size"`       // Largest image upload accepted, in bytes
+}
+
+// CatalogConfig holds the housekeeping settings of the product catalog
+type CatalogConfig struct {
+	TrashRetentionDays int `yaml:"trash_retention_days" toml:"trash_retention_days"` // Days deleted products stay restorable before they are purged, zero to keep them
+}
+
+// Supported payment providers
+const (
+	PaymentsFake = "fake" // Built-in gateway simulating card payments, for development and tests
+)
+
+// PaymentsConfig holds the payment provider and the secret its webhook callbacks are signed with
+type PaymentsConfig struct {
+	Provider      string `yaml:"provider" toml:"provider"`             // One of the Payments constants
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret"` // Shared secret of the webhook signatures, empty for an ephemeral development secret
+	PublicURL     string `yaml:"public_url" toml:"public_url"`         // Address the application is reached at, for webhook callbacks and challenge pages
+}
+
+// Tax pricing modes
+const (
+	TaxExclusive = "exclusive" // Product prices exclude tax, which is added to the totals
+	TaxInclusive = "inclusive" // Product prices include tax, which is broken out of the totals
+)
+
+// Tax rounding modes
+const (
+	TaxRoundLine  = "line"  // Tax is rounded on every line
+	TaxRoundOrder = "order" // Tax is rounded once per order and rate
+)
+
+// TaxConfig holds how carts and orders are taxed
+type TaxConfig struct {
+	Jurisdiction string `yaml:"jurisdiction" toml:"jurisdiction"` // Jurisdiction taxed when checkouts name none, such as "US-NY", empty to levy no tax by default
+	Pricing      string `yaml:"pricing" toml:"pricing"`           // One of the TaxExclusive and TaxInclusive constants
+	Rounding     string `yaml:"rounding" toml:"rounding"`         // One of the TaxRound constants
+	RatesFile    string `yaml:"rates_file" toml:"rates_file"`     // CSV table of tax rates imported at startup, replacing the stored rates, empty to keep them
+}
+
+// TrashRetention returns the retention period of deleted products, zero to keep them forever
+func (c CatalogConfig) TrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
+
+// RequestBodyLimit returns the largest request body accepted, which is Fiber's default when
+// body_limit is 0
+func (s ServerConfig) RequestBodyLimit() int {
+	if s.BodyLimit == 0 {
+		return fiber.DefaultBodyLimit
+	}
+	return s.BodyLimit
+}
+
+// Address returns the host:port pair the server listens on
+func (s ServerConfig) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Default returns the configuration used when nothing else is provided
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:         8000,
+			AppName:      "Product Management",
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			BodyLimit:    8 * 1024 * 1024, // Room for a largest image upload and its multipart envelope
+		},
+		Database: DatabaseConfig{
+			Driver:   DriverPostgres,
+			Path:     "product.db",
+			Host:     "localhost",
+			User:     "postgres",
+			Name:     "product",
+			SSLMode:  "disable",
+			TimeZone: "UTC",
+			Migrate:  MigrateCheck,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		Storage: StorageConfig{
+			Driver:       StorageLocal,
+			Dir:          "media",
+			BaseURL:      "/media",
+			Region:       "us-east-1",
+			MaxImageSize: 4 * 1024 * 1024,
+		},
+		Catalog: CatalogConfig{
+			TrashRetentionDays: 30,
+		},
+		Payments: PaymentsConfig{
+			Provider:  PaymentsFake,
+			PublicURL: "http://localhost:8000",
+		},
+		Tax: TaxConfig{
+			Pricing:  TaxExclusive,
+			Rounding: TaxRoundLine,
+		},
+	}
+}
+
+// setting binds one configuration value to its environment variable and flag
+type setting struct {
+	key    string // Dotted key, also used to derive the flag and environment variable names
+	usage  string // Flag usage text
+	target any    // Pointer to the field in Config
+}
+
+// settings lists every value that can be overridden from the environment or the command line
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.host", "interface to listen on", &c.Server.Host},
+		{"server.port", "port to listen on", &c.Server.Port},
+		{"server.app_name", "application name", &c.Server.AppName},
+		{"server.read_timeout", "request read timeout", &c.Server.ReadTimeout},
+		{"server.write_timeout", "response write timeout", &c.Server.WriteTimeout},
+		{"server.body_limit", "maximum request body size in bytes", &c.Server.BodyLimit},
+		{"database.driver", "database driver: postgres, mysql, sqlite or sqlite-memory", &c.Database.Driver},
+		{"database.path", "database file for the sqlite driver", &c.Database.Path},
+		{"database.host", "database host", &c.Database.Host},
+		{"database.port", "database port", &c.Database.Port},
+		{"database.user", "database user", &c.Database.User},
+		{"database.password", "database password", &c.Database.Password},
+		{"database.name", "database name", &c.Database.Name},
+		{"database.sslmode", "database SSL mode", &c.Database.SSLMode},
+		{"database.timezone", "database session time zone", &c.Database.TimeZone},
+		{"database.migrate", "schema migrations at startup: auto, check or off", &c.Database.Migrate},
+		{"auth.keys_dir", "directory of PEM signing and verification keys", &c.Auth.KeysDir},
+		{"auth.signing_key", "key ID used to sign new tokens", &c.Auth.SigningKey},
+		{"auth.access_token_ttl", "lifetime of access tokens", &c.Auth.AccessTokenTTL},
+		{"auth.refresh_token_ttl", "lifetime of refresh tokens", &c.Auth.RefreshTokenTTL},
+		{"storage.driver", "file storage driver: local or s3", &c.Storage.Driver},
+		{"storage.dir", "directory of uploaded files for the local driver", &c.Storage.Dir},
+		{"storage.base_url", "path the local storage directory is served under", &c.Storage.BaseURL},
+		{"storage.endpoint", "base URL of the S3 service", &c.Storage.Endpoint},
+		{"storage.bucket", "S3 bucket of uploaded files", &c.Storage.Bucket},
+		{"storage.region", "S3 region", &c.Storage.Region},
+		{"storage.access_key_id", "S3 access key ID", &c.Storage.AccessKeyID},
+		{"storage.secret_access_key", "S3 secret access key", &c.Storage.SecretAccessKey},
+		{"storage.public_url", "URL prefix S3 files are served under", &c.Storage.PublicURL},
+		{"storage.max_image_size", "largest image upload in bytes", &c.Storage.MaxImageSize},
+		{"catalog.trash_retention_days", "days deleted products can be restored, 0 to keep them forever", &c.Catalog.TrashRetentionDays},
+		{"payments.provider", "payment provider: fake", &c.Payments.Provider},
+		{"payments.webhook_secret", "secret payment webhook callbacks are signed with", &c.Payments.WebhookSecret},
+		{"payments.public_url", "address the application is reached at by the payment provider and buyers", &c.Payments.PublicURL},
+		{"tax.jurisdiction", "jurisdiction taxed when checkouts name none, such as US-NY", &c.Tax.Jurisdiction},
+		{"tax.pricing", "whether product prices include tax: exclusive or inclusive", &c.Tax.Pricing},
+		{"tax.rounding", "when tax is rounded: line or order", &c.Tax.Rounding},
+		{"tax.rates_file", "CSV table of tax rates imported at startup", &c.Tax.RatesFile},
+	}
+}
+
+// flagName converts a dotted key such as "database.host" into the flag name "db-host"
+func (s setting) flagName() string {
+	name := strings.Replace(s.key, "database.", "db.", 1)
+	return strings.NewReplacer(".", "-", "_", "-").Replace(name)
+}
+
+// envName converts a dotted key such as "database.host" into the variable name APP_DATABASE_HOST
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+// set parses the raw string value into the bound field
+func (s setting) set(value string) error {
+	switch target := s.target.(type) {
+	case *string:
+		*target = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.key, value)
+		}
+		*target = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", s.key, value)
+		}
+		*target = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.key, value)
+		}
+		*target = d
+	default:
+		return fmt.Errorf("%s: unsupported setting type %T", s.key, s.target)
+	}
+	return nil
+}
+
+// Load builds the configuration from the given command-line arguments (without the program name),
+// the config file they or APP_CONFIG point to, and the environment.
+// It returns the validated configuration and the arguments left over after flag parsing.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "path to a YAML or TOML config file")
+
+	// Flags are collected first and applied last so that they take precedence over everything else
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		s := s
+		fs.Func(s.flagName(), s.usage+" (env "+s.envName()+")", func(value string) error {
+			flagValues[s.key] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// Load the config file, if any
+	if *configPath != "" {
+		if err := loadFile(*configPath, &cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Apply environment variables, then flags
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("env %s: %w", s.envName(), err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := flagValues[s.key]; ok {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("flag -%s: %w", s.flagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, fs.Args(), nil
+}
+
+// loadFile decodes a YAML or TOML file into cfg, choosing the format from the file extension
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable and reports every problem found
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts must not be negative"))
+	}
+	if c.Server.BodyLimit < 0 {
+		errs = append(errs, errors.New("server.body_limit must not be negative"))
+	}
+
+	switch c.Database.Driver {
+	case DriverPostgres, DriverMySQL:
+		// Networked databases need somewhere to connect to
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("database.host is required"))
+		}
+		if c.Database.Port < 0 || c.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port must be between 0 and 65535, got %d", c.Database.Port))
+		}
+		if c.Database.User == "" {
+			errs = append(errs, errors.New("database.user is required"))
+		}
+		if c.Database.Name == "" {
+			errs = append(errs, errors.New("database.name is required"))
+		}
+	case DriverSQLite:
+		if c.Database.Path == "" {
+			errs = append(errs, errors.New("database.path is required for the sqlite driver"))
+		}
+	case DriverSQLiteMemory:
+		// Nothing to configure
+	default:
+		errs = append(errs, fmt.Errorf("database.driver %q is not supported", c.Database.Driver))
+	}
+	switch c.Database.Migrate {
+	case MigrateAuto, MigrateCheck, MigrateOff:
+	default:
+		errs = append(errs, fmt.Errorf("database.migrate must be auto, check or off, got %q", c.Database.Migrate))
+	}
+	if _, err := time.LoadLocation(c.Database.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("database.timezone %q is not a valid time zone", c.Database.TimeZone))
+	}
+
+	if c.Auth.AccessTokenTTL <= 0 || c.Auth.RefreshTokenTTL <= 0 {
+		errs = append(errs, errors.New("auth token lifetimes must be positive"))
+	}
+	if c.Auth.KeysDir != "" && c.Auth.SigningKey == "" {
+		errs = append(errs, errors.New("auth.signing_key is required when auth.keys_dir is set"))
+	}
+	if c.Auth.RefreshTokenTTL < c.Auth.AccessTokenTTL {
+		errs = append(errs, errors.New("auth.refresh_token_ttl must not be shorter than auth.access_token_ttl"))
+	}
+
+	switch c.Storage.Driver {
+	case StorageLocal:
+		if c.Storage.Dir == "" {
+			errs = append(errs, errors.New("storage.dir is required for the local driver"))
+		}
+		if !strings.HasPrefix(c.Storage.BaseURL, "/") || c.Storage.BaseURL == "/" {
+			errs = append(errs, fmt.Errorf("storage.base_url must be a path below /, got %q", c.Storage.BaseURL))
+		}
+	case StorageS3:
+		if c.Storage.Endpoint == "" || c.Storage.Bucket == "" {
+			errs = append(errs, errors.New("storage.endpoint and storage.bucket are required for the s3 driver"))
+		}
+		if c.Storage.AccessKeyID == "" || c.Storage.SecretAccessKey == "" {
+			errs = append(errs, errors.New("storage.access_key_id and storage.secret_access_key are required for the s3 driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.driver must be local or s3, got %q", c.Storage.Driver))
+	}
+	if c.Storage.MaxImageSize <= 0 {
+		errs = append(errs, errors.New("storage.max_image_size must be positive"))
+	}
+	if limit := c.Server.RequestBodyLimit(); limit <= c.Storage.MaxImageSize {
+		errs = append(errs, fmt.Errorf("server.body_limit (%d) must be greater than storage.max_image_size (%d) to leave room for the multipart envelope of uploads", limit, c.Storage.MaxImageSize))
+	}
+
+	if c.Catalog.TrashRetentionDays < 0 {
+		errs = append(errs, errors.New("catalog.trash_retention_days must not be negative"))
+	}
+
+	if c.Payments.Provider != PaymentsFake {
+		errs = append(errs, fmt.Errorf("payments.provider must be fake, got %q", c.Payments.Provider))
+	}
+	if !strings.HasPrefix(c.Payments.PublicURL, "http://") && !strings.HasPrefix(c.Payments.PublicURL, "https://") {
+		errs = append(errs, fmt.Errorf("payments.public_url must be an http or https URL, got %q", c.Payments.PublicURL))
+	}
+
+	if c.Tax.Pricing != TaxExclusive && c.Tax.Pricing != TaxInclusive {
+		errs = append(errs, fmt.Errorf("tax.pricing must be exclusive or inclusive, got %q", c.Tax.Pricing))
+	}
+	if c.Tax.Rounding != TaxRoundLine && c.Tax.Rounding != TaxRoundOrder {
+		errs = append(errs, fmt.Errorf("tax.rounding must be line or order, got %q", c.Tax.Rounding))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, rest, err := Load([]string{"serve"})
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), *cfg)
+	assert.Equal(t, []string{"serve"}, rest)
+	assert.Equal(t, ":8000", cfg.Server.Address())
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "app.yaml", `
+server:
+  port: 9000
+  read_timeout: 3s
+database:
+  host: db.internal
+  name: fromfile
+  user: fromfile
+`)
+	t.Setenv("APP_DATABASE_NAME", "fromenv")
+	t.Setenv("APP_DATABASE_USER", "fromenv")
+
+	cfg, _, err := Load([]string{"-config", path, "-db-user", "fromflag"})
+	assert.NoError(t, err)
+	assert.Equal(t, 9000, cfg.Server.Port)
+	assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout)
+	assert.Equal(t, "db.internal", cfg.Database.Host)
+	assert.Equal(t, "fromenv", cfg.Database.Name)
+	assert.Equal(t, "fromflag", cfg.Database.User)
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "app.toml", `
+[server]
+port = 8081
+
+[database]
+password = "s3cret"
+timezone = "Europe/Berlin"
+`)
+	t.Setenv("APP_CONFIG", path)
+
+	cfg, _, err := Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 8081, cfg.Server.Port)
+	assert.Equal(t, "s3cret", cfg.Database.Password)
+	assert.Equal(t, "Europe/Berlin", cfg.Database.TimeZone)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	t.Setenv("APP_SERVER_PORT", "not-a-number")
+	_, _, err := Load(nil)
+	assert.Error(t, err)
+
+	t.Setenv("APP_SERVER_PORT", "70000")
+	_, _, err = Load([]string{"-db-timezone", "Nowhere/Special"})
+	assert.ErrorContains(t, err, "server.port")
+	assert.ErrorContains(t, err, "database.timezone")
+}
+
+func TestLoad_Drivers(t *testing.T) {
+	// SQLite needs neither a host nor credentials
+	cfg, _, err := Load([]string{"-db-driver", "sqlite", "-db-host", "", "-db-user", "", "-db-path", "test.db"})
+	assert.NoError(t, err)
+	assert.Equal(t, DriverSQLite, cfg.Database.Driver)
+	assert.Equal(t, "test.db", cfg.Database.Path)
+
+	_, _, err = Load([]string{"-db-driver", "sqlite", "-db-path", ""})
+	assert.ErrorContains(t, err, "database.path")
+
+	_, _, err = Load([]string{"-db-driver", "oracle"})
+	assert.ErrorContains(t, err, "not supported")
+}
+
+func TestLoad_Storage(t *testing.T) {
+	_, _, err := Load([]string{"-storage-driver", "s3", "-storage-bucket", "media"})
+	assert.ErrorContains(t, err, "storage.endpoint")
+	assert.ErrorContains(t, err, "storage.access_key_id")
+
+	t.Setenv("APP_STORAGE_SECRET_ACCESS_KEY", "secret")
+	cfg, _, err := Load([]string{"-storage-driver", "s3", "-storage-bucket", "media", "-storage-endpoint", "http://localhost:9000", "-storage-access-key-id", "minio"})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", cfg.Storage.SecretAccessKey)
+
+	_, _, err = Load([]string{"-storage-base-url", "media"})
+	assert.ErrorContains(t, err, "storage.base_url")
+
+	// Image uploads have to fit into the request body limit
+	_, _, err = Load([]string{"-server-body-limit", "4194304", "-storage-max-image-size", "4194304"})
+	assert.ErrorContains(t, err, "server.body_limit (4194304) must be greater than storage.max_image_size")
+	_, _, err = Load([]string{"-server-body-limit", "0", "-storage-max-image-size", "4194304"})
+	assert.ErrorContains(t, err, "server.body_limit (4194304) must be greater than storage.max_image_size")
+	_, _, err = Load([]string{"-server-body-limit", "0", "-storage-max-image-size", "1048576"})
+	assert.NoError(t, err)
+}
+
+func TestLoad_Payments(t *testing.T) {
+	cfg, _, err := Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, PaymentsFake, cfg.Payments.Provider)
+
+	t.Setenv("APP_PAYMENTS_WEBHOOK_SECRET", "whsec")
+	cfg, _, err = Load([]string{"-payments-public-url", "https://shop.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "whsec", cfg.Payments.WebhookSecret)
+	assert.Equal(t, "https://shop.example.com", cfg.Payments.PublicURL)
+
+	_, _, err = Load([]string{"-payments-provider", "stripe", "-payments-public-url", "shop.example.com"})
+	assert.ErrorContains(t, err, "payments.provider")
+	assert.ErrorContains(t, err, "payments.public_url")
+}
+
+func TestLoad_Tax(t *testing.T) {
+	cfg, _, err := Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TaxExclusive, cfg.Tax.Pricing)
+	assert.Equal(t, TaxRoundLine, cfg.Tax.Rounding)
+
+	t.Setenv("APP_TAX_JURISDICTION", "US-NY")
+	cfg, _, err = Load([]string{"-tax-pricing", "inclusive", "-tax-rounding", "order", "-tax-rates-file", "rates.csv"})
+	assert.NoError(t, err)
+	assert.Equal(t, "US-NY", cfg.Tax.Jurisdiction)
+	assert.Equal(t, TaxInclusive, cfg.Tax.Pricing)
+	assert.Equal(t, TaxRoundOrder, cfg.Tax.Rounding)
+	assert.Equal(t, "rates.csv", cfg.Tax.RatesFile)
+
+	_, _, err = Load([]string{"-tax-pricing", "gross", "-tax-rounding", "invoice"})
+	assert.ErrorContains(t, err, "tax.pricing")
+	assert.ErrorContains(t, err, "tax.rounding")
+}
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/alwilion/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxTagLength is the longest tag accepted on a product
+const maxTagLength = 64
+
+// GetCategoryAttributes returns the attributes products of a category may carry,
+// including those inherited from its ancestors
+func (ctl *Controllers) GetCategoryAttributes(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	if _, err := ctl.Categories.Get(uint(id)); err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Category Not Found"})
+	}
+
+	definitions, err := ctl.attributeDefinitions([]uint{uint(id)})
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch attributes"})
+	}
+	attributes := []models.AttributeDefinition{}
+	for _, definition := range definitions {
+		attributes = append(attributes, definition)
+	}
+	sortAttributes(attributes)
+	return c.JSON(attributes)
+}
+
+// CreateCategoryAttribute defines an attribute for a category and its subcategories.
+// The body takes a name, a type of string, number, enum or boolean, and the options of enums.
+func (ctl *Controllers) CreateCategoryAttribute(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	if _, err := ctl.Categories.Get(uint(id)); err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Category Not Found"})
+	}
+
+	var data struct {
+		Name    string   `json:"name"`
+		Type    string   `json:"type"`
+		Options []string `json:"options"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid attribute"})
+	}
+
+	// Validate the definition
+	attribute := &models.AttributeDefinition{CategoryID: uint(id), Name: data.Name, Type: models.AttributeType(data.Type)}
+	if attribute.Name == "" || utils.Slugify(attribute.Name) != attribute.Name {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid Name, expected lower-case letters and digits separated by dashes"})
+	}
+	if !models.IsValidAttributeType(attribute.Type) {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid Type, expected string, number, enum or boolean"})
+	}
+	if attribute.Type == models.AttributeEnum {
+		for _, option := range data.Options {
+			if option = strings.TrimSpace(option); option != "" {
+				attribute.Options = append(attribute.Options, option)
+			}
+		}
+		if len(attribute.Options) == 0 {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": "Enum attributes need options"})
+		}
+	}
+
+	// The name has to be unique along the branch of the category
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch categories"})
+	}
+	branch := append(models.CategoryAncestors(categories, uint(id)), models.CategoryDescendants(categories, uint(id))...)
+	existing, err := ctl.Categories.Attributes(branch...)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch attributes"})
+	}
+	for _, other := range existing {
+		if other.Name == attribute.Name {
+			c.Status(fiber.StatusConflict)
+			return c.JSON(fiber.Map{"message": "Attribute already defined for a related category"})
+		}
+	}
+
+	if err := ctl.Categories.CreateAttribute(attribute); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to create attribute"})
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(attribute)
+}
+
+// DeleteAttribute removes an attribute definition. Products keep their values until they
+// are next updated.
+func (ctl *Controllers) DeleteAttribute(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err := ctl.Categories.DeleteAttribute(uint(id)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Attribute Not Found"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to delete attribute"})
+	}
+	return c.JSON(fiber.Map{"message": "Attribute deleted successfully"})
+}
+
+// attributeDefinitions returns the attributes defined for the categories and their ancestors by name
+func (ctl *Controllers) attributeDefinitions(categoryIDs []uint) (map[string]models.AttributeDefinition, error) {
+	definitions := make(map[string]models.AttributeDefinition)
+	if len(categoryIDs) == 0 {
+		return definitions, nil
+	}
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := ctl.Categories.Attributes(models.CategoryAncestors(categories, categoryIDs...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range attributes {
+		if _, ok := definitions[attribute.Name]; !ok {
+			definitions[attribute.Name] = attribute
+		}
+	}
+	return definitions, nil
+}
+
+// sortAttributes orders attribute definitions by name
+func sortAttributes(attributes []models.AttributeDefinition) {
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Name < attributes[j].Name })
+}
+
+// applyProductExtras validates the optional category_ids, attributes and tags of a product
+// request body and applies the attributes and tags to the product. It returns the categories
+// the product will be assigned to, and a message describing the first invalid field.
+//
+// Attributes are merged into the existing ones, a null value removes one. They must be defined
+// for one of the product's categories or their ancestors; values of attributes no longer
+// defined are dropped. Tags replace the existing ones.
+func (ctl *Controllers) applyProductExtras(product *models.Product, data map[string]interface{}) ([]uint, string, error) {
+	// The categories from the body, otherwise the current assignments
+	var categoryIDs []uint
+	if value, ok := data["category_ids"]; ok {
+		ids, message, err := ctl.parseCategoryIDs(value)
+		if message != "" || err != nil {
+			return nil, message, err
+		}
+		categoryIDs = ids
+	} else if product.ID != 0 {
+		ids, err := ctl.Products.CategoryIDs(product.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		categoryIDs = ids
+	}
+
+	// Validate the attributes against the definitions of the categories
+	definitions, err := ctl.attributeDefinitions(categoryIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	attributes := models.Attributes{}
+	for name, value := range product.Attributes {
+		if _, ok := definitions[name]; ok {
+			attributes[name] = value
+		}
+	}
+	if value, ok := data["attributes"]; ok {
+		changes, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, "Invalid Attributes, expected an object", nil
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(attributes, name)
+				continue
+			}
+			definition, ok := definitions[name]
+			if !ok {
+				return nil, fmt.Sprintf("Attribute %s is not defined for the product's categories", name), nil
+			}
+			canonical, err := definition.Validate(value)
+			if err != nil {
+				return nil, "Invalid " + err.Error(), nil
+			}
+			attributes[name] = canonical
+		}
+	}
+	product.Attributes = attributes
+
+	// Tags are normalized like slugs
+	if value, ok := data["tags"]; ok {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, "Invalid Tags, expected a list of strings", nil
+		}
+		tags := models.Tags{}
+		for _, item := range list {
+			text, ok := item.(string)
+			tag := utils.Slugify(text)
+			if !ok || tag == "" || len(tag) > maxTagLength {
+				return nil, "Invalid Tags, expected a list of strings", nil
+			}
+			if !tags.Has(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		product.Tags = tags
+	}
+	return categoryIDs, "", nil
+}
+
+// parseCategoryIDs validates a list of category IDs decoded from JSON, dropping duplicates
+func (ctl *Controllers) parseCategoryIDs(value interface{}) ([]uint, string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, "Invalid category_ids, expected a list of category IDs", nil
+	}
+	ids := []uint{}
+	for _, item := range list {
+		number, ok := item.(float64)
+		if !ok || number < 1 {
+			return nil, "Invalid category_ids, expected a list of category IDs", nil
+		}
+		id := uint(number)
+		if _, err := ctl.Categories.Get(id); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return nil, fmt.Sprintf("Category %d not found", id), nil
+			}
+			return nil, "", err
+		}
+		if !containsID(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, "", nil
+}
+
+// containsID reports whether id is in the list
+func containsID(ids []uint, id uint) bool {
+	for _, have := range ids {
+		if have == id {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeFilters reads the attr.<name>=<value> and tag parameters of the product listing
+// into the query. Tags may be repeated or comma-separated; products must carry all of them.
+// It returns a message describing the first invalid parameter.
+func (ctl *Controllers) attributeFilters(c *fiber.Ctx, query *repository.ProductQuery) (string, error) {
+	var attributes map[string]string
+	for key, value := range c.Queries() {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok {
+			continue
+		}
+		definition, err := ctl.attributeByName(name)
+		if err != nil {
+			return "", err
+		}
+		if definition == nil {
+			return "Unknown attribute " + name, nil
+		}
+		filter, err := definition.FilterValue(value)
+		if err != nil {
+			return "Invalid " + err.Error(), nil
+		}
+		if attributes == nil {
+			attributes = make(map[string]string)
+		}
+		attributes[name] = filter
+	}
+
+	var tags []string
+	for _, value := range c.Context().QueryArgs().PeekMulti("tag") {
+		for _, tag := range strings.Split(string(value), ",") {
+			if tag = utils.Slugify(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	query.Attributes, query.Tags = attributes, tags
+	return "", nil
+}
+
+// attributeByName finds the definition of an attribute in any category, nil if there is none
+func (ctl *Controllers) attributeByName(name string) (*models.AttributeDefinition, error) {
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(categories))
+	for i, category := range categories {
+		ids[i] = category.ID
+	}
+	attributes, err := ctl.Categories.Attributes(ids...)
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range attributes {
+		if attribute.Name == name {
+			return &attribute, nil
+		}
+	}
+	return nil, nil
+}
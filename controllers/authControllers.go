@@ -1,20 +1,216 @@
 package controllers
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/alwilion/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 )
 
-// authentication is a helper function to parse and validate JWT tokens from the Authorization header
-func authentication(c *fiber.Ctx) (*jwt.Token, error) {
-	// Get the Authorization header value, which should contain the JWT token
-	authorizationHeader := c.Get("Authorization")
+// errTokenRevoked is returned by authentication for access tokens on the revocation list
+var errTokenRevoked = errors.New("token has been revoked")
+
+// tokenLocal is the fiber.Ctx local under which an authenticated token is cached
+const tokenLocal = "token"
+
+// Claims are the JWT claims of an access token
+type Claims struct {
+	jwt.StandardClaims
+	Roles models.Roles `json:"roles,omitempty"` // Roles of the user when the token was issued
+}
+
+// UserID returns the ID of the user the token was issued to
+func (c *Claims) UserID() uint {
+	id, _ := strconv.ParseUint(c.Issuer, 10, 64)
+	return uint(id)
+}
+
+// authentication is a helper function to parse and validate JWT tokens from the Authorization header.
+// The result is cached on the request, so middleware and handler can both call it cheaply.
+func (ctl *Controllers) authentication(c *fiber.Ctx) (*jwt.Token, error) {
+	if token, ok := c.Locals(tokenLocal).(*jwt.Token); ok {
+		return token, nil
+	}
+
+	// Get the Authorization header value, which should contain the JWT token, optionally as a Bearer token
+	authorizationHeader := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
 
 	// Parse and validate the JWT token with the specified claims and key
-	token, err := jwt.ParseWithClaims(authorizationHeader, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SecretKey), nil // Using the SecretKey which was generated in the Login function
+	token, err := jwt.ParseWithClaims(authorizationHeader, &Claims{}, ctl.Keys.Keyfunc)
+	if err != nil {
+		return token, err
+	}
+
+	// Reject tokens that were revoked by a logout before they expired
+	claims := token.Claims.(*Claims)
+	revoked, err := ctl.Tokens.IsAccessTokenRevoked(claims.Id)
+	if err != nil {
+		return token, err
+	}
+	if revoked {
+		return token, errTokenRevoked
+	}
+
+	// Cache and return the parsed token
+	c.Locals(tokenLocal, token)
+	return token, nil
+}
+
+// issueTokens creates a new access token and a new refresh token in the given token family
+func (ctl *Controllers) issueTokens(user *models.User, family string) (fiber.Map, error) {
+	now := time.Now()
+
+	// Create a short-lived access token with the user ID as the issuer and a unique ID for revocation,
+	// signed with the current signing key
+	accessToken, err := ctl.Keys.Sign(Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Issuer:    strconv.Itoa(int(user.ID)),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ctl.AccessTokenTTL).Unix(),
+		},
+		Roles: user.Roles,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the refresh token, storing only its hash
+	refreshToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	err = ctl.Tokens.CreateRefreshToken(&models.RefreshToken{
+		UserID:    user.ID,
+		Family:    family,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: now.Add(ctl.RefreshTokenTTL),
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"message":       "success",
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(ctl.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token.
+// Each refresh token can be used once; presenting it again revokes the whole token family,
+// because it means the token was stolen or replayed.
+func (ctl *Controllers) Refresh(c *fiber.Ctx) error {
+	var data map[string]string
+
+	// Parse request body into a map
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+
+	// Find the stored refresh token
+	stored, err := ctl.Tokens.GetRefreshToken(utils.HashToken(data["refresh_token"]))
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "invalid refresh token"})
+	}
+
+	// Check that it is still valid
+	now := time.Now()
+	if stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "invalid refresh token"})
+	}
+
+	// Mark it as used, detecting replays of tokens that were already exchanged
+	if err := ctl.Tokens.UseRefreshToken(stored.ID, now); err != nil {
+		if errors.Is(err, repository.ErrTokenReused) {
+			if err := ctl.Tokens.RevokeFamily(stored.Family, now); err != nil {
+				c.Status(fiber.StatusInternalServerError)
+				return c.JSON(fiber.Map{"message": "could not revoke tokens"})
+			}
+			c.Status(fiber.StatusUnauthorized)
+			return c.JSON(fiber.Map{"message": "refresh token reuse detected"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "could not refresh token"})
+	}
+
+	// Issue the next pair in the same family
+	user, err := ctl.Users.Get(stored.UserID)
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "invalid refresh token"})
+	}
+	tokens, err := ctl.issueTokens(user, stored.Family)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "could not refresh token"})
+	}
+	return c.JSON(tokens)
+}
+
+// Logout revokes the presented access token and the refresh tokens of the session.
+// When the body names a refresh_token only its family is revoked, otherwise every
+// refresh token of the user is, logging them out everywhere.
+func (ctl *Controllers) Logout(c *fiber.Ctx) error {
+	// Authenticate the request
+	token, err := ctl.authentication(c)
+
+	// Handle authentication errors
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{
+			"message": "unauthenticated",
+		})
+	}
+	claims := token.Claims.(*Claims)
+	userID := claims.UserID()
+
+	// The body is optional
+	var data map[string]string
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&data); err != nil {
+			return err
+		}
+	}
+
+	// Revoke the access token until it expires
+	now := time.Now()
+	if err := ctl.Tokens.RevokeAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "could not logout"})
+	}
+
+	// Revoke the refresh tokens
+	if data["refresh_token"] != "" {
+		stored, err := ctl.Tokens.GetRefreshToken(utils.HashToken(data["refresh_token"]))
+		if err == nil && stored.UserID == userID {
+			err = ctl.Tokens.RevokeFamily(stored.Family, now)
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "could not logout"})
+		}
+	} else if err := ctl.Tokens.RevokeUserRefreshTokens(userID, now); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "could not logout"})
+	}
+
+	return c.JSON(fiber.Map{"message": "success"})
+}
 
-	// Return the parsed token and any parsing error
-	return token, err
+// JWKS publishes the public verification keys so other services can validate our tokens
+func (ctl *Controllers) JWKS(c *fiber.Ctx) error {
+	// Let clients cache the key set for a few minutes; rotations keep old keys around longer than that
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(ctl.Keys.JWKS())
 }
@@ -0,0 +1,557 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/promotions"
+	"github.com/alwilion/repository"
+	"github.com/alwilion/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CartTokenHeader carries the token of a guest cart. It is sent back when a guest cart is
+// created, and has to accompany every later request for that cart.
+const CartTokenHeader = "X-Cart-Token"
+
+// DefaultGuestCartTTL is how long guest carts are kept after their last change unless configured otherwise
+const DefaultGuestCartTTL = 30 * 24 * time.Hour
+
+// MaxCartQuantity is the largest quantity of one product or variant a cart may hold
+const MaxCartQuantity = 999
+
+// Codes of the warnings about cart items that cannot be bought as they are
+const (
+	CartWarningPriceChanged      = "price_changed"      // The price changed since the item was added
+	CartWarningInsufficientStock = "insufficient_stock" // Fewer units are in stock than the quantity
+	CartWarningUnavailable       = "unavailable"        // The product was deleted or the variant removed
+)
+
+// CartWarning tells the buyer about an item of the cart that changed since it was added
+type CartWarning struct {
+	ItemID  uint   `json:"item_id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CartLine is an item of a cart together with the current price and stock of its product
+type CartLine struct {
+	models.CartItem
+	Name      string        `json:"name"`                // Name of the product, empty once it is unavailable
+	SKU       string        `json:"sku,omitempty"`       // SKU of the variant
+	UnitPrice *models.Money `json:"unit_price"`          // Current price of one unit, nil once the product is unavailable
+	Total     *models.Money `json:"total"`               // Current price of the quantity, nil once the product is unavailable
+	Available int           `json:"available"`           // Units in stock that are not reserved
+	TaxClass  string        `json:"tax_class,omitempty"` // Tax class the product inherits from its categories
+	Tax       *models.Money `json:"tax,omitempty"`       // Tax levied on the line in the display currency, nil once the product is unavailable
+
+	sellerID *uint // Seller of the product, which promotions may be scoped to
+}
+
+// CartView is a cart as shown to the buyer. Totals use the current prices; the price snapshot
+// of each item only serves to warn about changes. Discounts break down what each running
+// promotion, and the coupon entered, takes off which item; taxes what each rate of the
+// jurisdiction levies on what is left.
+type CartView struct {
+	ID           uint                  `json:"id"`              // Zero until the first item is added
+	Token        string                `json:"token,omitempty"` // Token of a new guest cart, to send in the X-Cart-Token header from now on
+	Items        []CartLine            `json:"items"`
+	Subtotal     models.Money          `json:"subtotal"` // Sum of the available items, converted into the display currency
+	Discounts    []promotions.Discount `json:"discounts"`
+	Discount     models.Money          `json:"discount"`               // Sum of the discounts
+	Tax          models.Money          `json:"tax"`                    // Sum of the taxes
+	TaxInclusive bool                  `json:"tax_inclusive"`          // Whether the prices, and so the subtotal, include the tax
+	Jurisdiction string                `json:"jurisdiction,omitempty"` // Jurisdiction the cart is taxed in
+	Taxes        models.TaxLines       `json:"taxes"`
+	Total        models.Money          `json:"total"`                  // Subtotal less the discount, plus the tax unless included
+	FreeShipping bool                  `json:"free_shipping"`          // Whether a promotion makes shipping free
+	Coupon       string                `json:"coupon,omitempty"`       // Coupon code applied to the cart
+	CouponError  string                `json:"coupon_error,omitempty"` // Why the coupon entered cannot be used
+	Warnings     []CartWarning         `json:"warnings"`
+
+	discounts *promotions.Result // Discounts to grant the orders placed from the cart
+}
+
+// cartProduct is the product or variant of a cart item as it can be bought now
+type cartProduct struct {
+	product   *models.Product
+	sku       string
+	price     models.Money // Current unit price
+	available int          // Units in stock that are not reserved
+}
+
+// lookupCartProduct finds the product or variant a cart item refers to with its current price
+// and stock. It returns ErrNotFound when the product was deleted or the variant removed.
+func (ctl *Controllers) lookupCartProduct(productID, variantID uint) (*cartProduct, error) {
+	product, err := ctl.Products.Get(productID)
+	if err != nil {
+		return nil, err
+	}
+	item := &cartProduct{product: product, price: product.Price}
+	if variantID != 0 {
+		variant, err := ctl.Variants.Get(variantID)
+		if err != nil {
+			return nil, err
+		}
+		if variant.ProductID != productID {
+			return nil, repository.ErrNotFound
+		}
+		item.sku, item.price = variant.SKU, variant.EffectivePrice(*product)
+	}
+	levels, err := ctl.stockLevels(productID)
+	if err != nil {
+		return nil, err
+	}
+	item.available = levels[variantID].Available()
+	return item, nil
+}
+
+// cartPricing is how the totals of a cart are computed
+type cartPricing struct {
+	currency     string // Currency the totals are shown in
+	jurisdiction string // Jurisdiction the cart is taxed in, empty for none
+}
+
+// requestPricing reads the currency the cart totals are shown in and the jurisdiction they
+// are taxed in. On failure it returns the status and message to answer with.
+func (ctl *Controllers) requestPricing(c *fiber.Ctx) (cartPricing, int, string) {
+	view, message, err := ctl.displayCurrency(c)
+	if err != nil {
+		return cartPricing{}, fiber.StatusInternalServerError, "failed to fetch exchange rates"
+	}
+	if message != "" {
+		return cartPricing{}, fiber.StatusBadRequest, message
+	}
+	jurisdiction, status, message := ctl.requestJurisdiction(c)
+	if status != 0 {
+		return cartPricing{}, status, message
+	}
+	return cartPricing{currency: view.Currency, jurisdiction: jurisdiction}, 0, ""
+}
+
+// requestCart finds the cart of the request: the cart of the authenticated user, or else the
+// guest cart named by the X-Cart-Token header. A missing cart is returned empty, with an ID of
+// zero, unless create is set; then it is created, and a new guest cart's token is returned
+// and set as the X-Cart-Token response header. On failure it returns the status and message
+// to answer with.
+func (ctl *Controllers) requestCart(c *fiber.Ctx, create bool) (*models.Cart, string, int, string) {
+	if c.Get(fiber.HeaderAuthorization) != "" {
+		token, err := ctl.authentication(c)
+		if err != nil {
+			return nil, "", fiber.StatusUnauthorized, "unauthenticated"
+		}
+		userID := token.Claims.(*Claims).UserID()
+		cart, err := ctl.Carts.ForUser(userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			cart, err = &models.Cart{UserID: &userID, Items: []models.CartItem{}}, nil
+			if create {
+				err = ctl.Carts.Create(cart)
+			}
+		}
+		if err != nil {
+			return nil, "", fiber.StatusInternalServerError, "failed to fetch cart"
+		}
+		return cart, "", 0, ""
+	}
+
+	if token := c.Get(CartTokenHeader); token != "" {
+		cart, err := ctl.Carts.ForToken(utils.HashToken(token))
+		if err == nil {
+			return cart, "", 0, ""
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return nil, "", fiber.StatusInternalServerError, "failed to fetch cart"
+		}
+	}
+
+	// Unknown and purged tokens start over with a new guest cart
+	cart := &models.Cart{Items: []models.CartItem{}}
+	if !create {
+		return cart, "", 0, ""
+	}
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", fiber.StatusInternalServerError, "failed to create cart"
+	}
+	hash := utils.HashToken(token)
+	cart.TokenHash = &hash
+	if err := ctl.Carts.Create(cart); err != nil {
+		return nil, "", fiber.StatusInternalServerError, "failed to create cart"
+	}
+	c.Set(CartTokenHeader, token)
+	return cart, token, 0, ""
+}
+
+// cartView prices the items of a cart at their current prices, converting the subtotal into
+// the currency of the pricing, applies the running promotions and the coupon, if any, taxes
+// what is left in the jurisdiction of the pricing and collects the warnings about items that
+// changed since they were added
+func (ctl *Controllers) cartView(cart *models.Cart, pricing cartPricing, coupon string) (*CartView, error) {
+	rates, err := ctl.rates()
+	if err != nil {
+		return nil, err
+	}
+	currency := pricing.currency
+	view := &CartView{ID: cart.ID, Items: []CartLine{}, Subtotal: models.Money{Currency: currency}, Warnings: []CartWarning{}}
+	for _, item := range cart.Items {
+		line := CartLine{CartItem: item}
+		current, err := ctl.lookupCartProduct(item.ProductID, item.VariantID)
+		if errors.Is(err, repository.ErrNotFound) {
+			view.Items = append(view.Items, line)
+			view.Warnings = append(view.Warnings, CartWarning{ItemID: item.ID, Code: CartWarningUnavailable, Message: "This product is no longer available"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		total := current.price.Mul(int64(item.Quantity))
+		line.Name, line.SKU, line.Available = current.product.Name, current.sku, current.available
+		line.UnitPrice, line.Total = &current.price, &total
+		line.sellerID = current.product.SellerID
+		view.Items = append(view.Items, line)
+		// Convert the unit price before multiplying, as checkout does, so the cart shows what it charges
+		unit, err := rates.Convert(current.price, currency)
+		if err != nil {
+			return nil, err
+		}
+		view.Subtotal.Amount += unit.Mul(int64(item.Quantity)).Amount
+
+		if current.price != item.Price {
+			view.Warnings = append(view.Warnings, CartWarning{
+				ItemID:  item.ID,
+				Code:    CartWarningPriceChanged,
+				Message: fmt.Sprintf("The price of %s changed from %s to %s", line.Name, item.Price, current.price),
+			})
+		}
+		if current.available < item.Quantity {
+			view.Warnings = append(view.Warnings, CartWarning{
+				ItemID:  item.ID,
+				Code:    CartWarningInsufficientStock,
+				Message: fmt.Sprintf("Only %d of %s left in stock", max(current.available, 0), line.Name),
+			})
+		}
+	}
+	if err := ctl.cartPromotions(view, cart, coupon, rates, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := ctl.cartTaxes(view, pricing.jurisdiction, rates); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// sendCart answers with the current state of the cart, reloading it after changes
+func (ctl *Controllers) sendCart(c *fiber.Ctx, cart *models.Cart, token string, pricing cartPricing) error {
+	if cart.ID != 0 {
+		reloaded, err := ctl.Carts.Get(cart.ID)
+		if err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to fetch cart"})
+		}
+		cart = reloaded
+	}
+	view, err := ctl.cartView(cart, pricing, c.Query("coupon"))
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to price cart"})
+	}
+	view.Token = token
+	return c.JSON(view)
+}
+
+// cartQuantity checks a quantity from a request body, which has to be an integer between
+// min and MaxCartQuantity
+func cartQuantity(value float64, min int) (int, bool) {
+	if value != math.Trunc(value) || value < float64(min) || value > MaxCartQuantity {
+		return 0, false
+	}
+	return int(value), true
+}
+
+// insufficientStock answers a change that would put more units into the cart than are available
+func insufficientStock(c *fiber.Ctx, available int) error {
+	c.Status(fiber.StatusConflict)
+	return c.JSON(fiber.Map{"message": "Insufficient stock", "available": max(available, 0)})
+}
+
+// GetCart returns the cart of the authenticated user, or of the guest whose token is in the
+// X-Cart-Token header, priced at the current prices. The currency parameter converts the
+// subtotal, the jurisdiction parameter chooses where it is taxed. Warnings tell about prices
+// that changed and items that ran out of stock since they were added.
+func (ctl *Controllers) GetCart(c *fiber.Ctx) error {
+	pricing, status, message := ctl.requestPricing(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	cart, _, status, message := ctl.requestCart(c, false)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	return ctl.sendCart(c, cart, "", pricing)
+}
+
+// AddCartItem puts a quantity, one by default, of the product_id into the cart, or of the
+// variant_id for products with variants. Adding a product already in the cart increases its
+// quantity. The quantity has to be in stock. Guests without a cart get a new one, whose token
+// is returned.
+func (ctl *Controllers) AddCartItem(c *fiber.Ctx) error {
+	pricing, status, message := ctl.requestPricing(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	var data struct {
+		ProductID uint     `json:"product_id"`
+		VariantID uint     `json:"variant_id"`
+		Quantity  *float64 `json:"quantity"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid cart item"})
+	}
+	quantity := 1
+	if data.Quantity != nil {
+		var ok bool
+		if quantity, ok = cartQuantity(*data.Quantity, 1); !ok {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": fmt.Sprintf("Invalid Quantity, expected an integer from 1 to %d", MaxCartQuantity)})
+		}
+	}
+	product, err := ctl.Products.Get(data.ProductID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	if message, err := ctl.checkStockVariant(product, data.VariantID); err != nil || message != "" {
+		if err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to fetch variants"})
+		}
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	current, err := ctl.lookupCartProduct(product.ID, data.VariantID)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch stock"})
+	}
+
+	cart, token, status, message := ctl.requestCart(c, true)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	item := &models.CartItem{CartID: cart.ID, ProductID: product.ID, VariantID: data.VariantID}
+	if existing := cart.Item(product.ID, data.VariantID); existing != nil {
+		item = existing
+	}
+	item.Quantity += quantity
+	if item.Quantity > MaxCartQuantity {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": fmt.Sprintf("A cart holds at most %d units of a product", MaxCartQuantity)})
+	}
+	if current.available < item.Quantity {
+		return insufficientStock(c, current.available)
+	}
+	item.Price = current.price
+	if err := ctl.Carts.SaveItem(item); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to update cart"})
+	}
+	c.Status(fiber.StatusCreated)
+	return ctl.sendCart(c, cart, token, pricing)
+}
+
+// cartItem looks up the item of the ":itemId" route parameter in the cart
+func cartItem(c *fiber.Ctx, cart *models.Cart) (*models.CartItem, bool) {
+	id, err := strconv.ParseUint(c.Params("itemId"), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	for i := range cart.Items {
+		if cart.Items[i].ID == uint(id) {
+			return &cart.Items[i], true
+		}
+	}
+	return nil, false
+}
+
+// UpdateCartItem sets the quantity of an item of the cart; a quantity of zero removes it. The
+// quantity has to be in stock. Updating an item takes its current price as the new snapshot,
+// acknowledging a price change.
+func (ctl *Controllers) UpdateCartItem(c *fiber.Ctx) error {
+	pricing, status, message := ctl.requestPricing(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	cart, _, status, message := ctl.requestCart(c, false)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	item, ok := cartItem(c, cart)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Cart item not found"})
+	}
+
+	var data struct {
+		Quantity *float64 `json:"quantity"`
+	}
+	if err := c.BodyParser(&data); err != nil || data.Quantity == nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Missing Quantity"})
+	}
+	quantity, ok := cartQuantity(*data.Quantity, 0)
+	if !ok {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": fmt.Sprintf("Invalid Quantity, expected an integer from 0 to %d", MaxCartQuantity)})
+	}
+	if quantity == 0 {
+		if err := ctl.Carts.DeleteItem(cart.ID, item.ID); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to update cart"})
+		}
+		return ctl.sendCart(c, cart, "", pricing)
+	}
+
+	current, err := ctl.lookupCartProduct(item.ProductID, item.VariantID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "This product is no longer available"})
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch stock"})
+	}
+	if current.available < quantity {
+		return insufficientStock(c, current.available)
+	}
+	item.Quantity, item.Price = quantity, current.price
+	if err := ctl.Carts.SaveItem(item); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to update cart"})
+	}
+	return ctl.sendCart(c, cart, "", pricing)
+}
+
+// RemoveCartItem removes an item from the cart
+func (ctl *Controllers) RemoveCartItem(c *fiber.Ctx) error {
+	pricing, status, message := ctl.requestPricing(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	cart, _, status, message := ctl.requestCart(c, false)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	item, ok := cartItem(c, cart)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Cart item not found"})
+	}
+	if err := ctl.Carts.DeleteItem(cart.ID, item.ID); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to update cart"})
+	}
+	return ctl.sendCart(c, cart, "", pricing)
+}
+
+// ClearCart removes every item from the cart
+func (ctl *Controllers) ClearCart(c *fiber.Ctx) error {
+	pricing, status, message := ctl.requestPricing(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	cart, _, status, message := ctl.requestCart(c, false)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	if cart.ID != 0 {
+		if err := ctl.Carts.Clear(cart.ID); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to update cart"})
+		}
+	}
+	return ctl.sendCart(c, cart, "", pricing)
+}
+
+// mergeGuestCart moves the items of the guest cart with the token into the cart of the user,
+// creating it if needed. Quantities of products in both carts add up, to at most
+// MaxCartQuantity and the available stock. The guest cart is deleted, so its token stops
+// working.
+func (ctl *Controllers) mergeGuestCart(userID uint, token string) error {
+	if token == "" {
+		return nil
+	}
+	guest, err := ctl.Carts.ForToken(utils.HashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cart, err := ctl.Carts.ForUser(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		cart = &models.Cart{UserID: &userID}
+		err = ctl.Carts.Create(cart)
+	}
+	if err != nil {
+		return err
+	}
+	merged, err := ctl.Carts.Merge(guest.ID, cart.ID)
+	if err != nil {
+		return err
+	}
+
+	// Lower the quantities the merge raised above what AddCartItem accepts. Items out of
+	// stock or no longer available are kept for the cart view to warn about.
+	for _, item := range merged.Items {
+		if guest.Item(item.ProductID, item.VariantID) == nil {
+			continue
+		}
+		current, err := ctl.lookupCartProduct(item.ProductID, item.VariantID)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		quantity := item.Quantity
+		if quantity > MaxCartQuantity {
+			quantity = MaxCartQuantity
+		}
+		if quantity > current.available && current.available > 0 {
+			quantity = current.available
+		}
+		if quantity != item.Quantity {
+			item.Quantity = quantity
+			if err := ctl.Carts.SaveItem(&item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PurgeGuestCarts deletes the guest carts that have not changed for longer than the guest cart
+// lifetime. It is run periodically by a background job; a lifetime of zero keeps them forever.
+func (ctl *Controllers) PurgeGuestCarts(now time.Time) error {
+	if ctl.GuestCartTTL <= 0 {
+		return nil
+	}
+	return ctl.Carts.PurgeGuests(now.Add(-ctl.GuestCartTTL))
+}
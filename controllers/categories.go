@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/alwilion/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListCategories returns all categories as a flat list ordered by ID
+func (ctl *Controllers) ListCategories(c *fiber.Ctx) error {
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch categories"})
+	}
+	return c.JSON(categories)
+}
+
+// GetCategoryTree returns the categories nested under their parents
+func (ctl *Controllers) GetCategoryTree(c *fiber.Ctx) error {
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch categories"})
+	}
+	return c.JSON(models.CategoryTree(categories))
+}
+
+// GetCategory returns a single category by its ID
+func (ctl *Controllers) GetCategory(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	category, err := ctl.Categories.Get(uint(id))
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Category Not Found"})
+	}
+	return c.JSON(category)
+}
+
+// CreateCategory adds a category. The body takes a name, an optional slug derived
+// from the name when missing, an optional parent_id and an optional tax_class for the
+// products in the category and below it.
+func (ctl *Controllers) CreateCategory(c *fiber.Ctx) error {
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+
+	category := &models.Category{}
+	if _, ok := data["name"]; !ok {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Missing Name"})
+	}
+	if message := ctl.applyCategory(category, data); message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	if err := ctl.Categories.Create(category); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to create category"})
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(category)
+}
+
+// UpdateCategory renames a category or moves it, with its whole subtree, under another
+// parent. A parent_id of null moves it to the top level. A tax_class of null or "" makes it
+// inherit the tax class of its parent. Product assignments are kept.
+func (ctl *Controllers) UpdateCategory(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	category, err := ctl.Categories.Get(uint(id))
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Category Not Found"})
+	}
+
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	if message := ctl.applyCategory(category, data); message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	if err := ctl.Categories.Update(category); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to update category"})
+	}
+	return c.JSON(category)
+}
+
+// DeleteCategory removes a category and unassigns its products. Categories that still
+// have subcategories cannot be deleted; move or delete those first.
+func (ctl *Controllers) DeleteCategory(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch categories"})
+	}
+	for _, category := range categories {
+		if category.ParentID != nil && *category.ParentID == uint(id) {
+			c.Status(fiber.StatusConflict)
+			return c.JSON(fiber.Map{"message": "Category has subcategories"})
+		}
+	}
+
+	if err := ctl.Categories.Delete(uint(id)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Category Not Found"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to delete category"})
+	}
+	return c.JSON(fiber.Map{"message": "Category deleted successfully"})
+}
+
+// applyCategory validates the name, slug, tax_class and parent_id fields of a request body
+// and copies them onto the category. It returns a message describing the first invalid field.
+func (ctl *Controllers) applyCategory(category *models.Category, data map[string]interface{}) string {
+	if value, ok := data["name"]; ok {
+		name, ok := value.(string)
+		if !ok || strings.TrimSpace(name) == "" {
+			return "Invalid Name"
+		}
+		category.Name = strings.TrimSpace(name)
+	}
+
+	// Derive the slug from the name of new categories unless one is given
+	slug := category.Slug
+	if value, ok := data["slug"]; ok {
+		s, ok := value.(string)
+		if !ok || utils.Slugify(s) != s || s == "" {
+			return "Invalid Slug, expected lower-case letters and digits separated by dashes"
+		}
+		slug = s
+	} else if slug == "" {
+		slug = utils.Slugify(category.Name)
+	}
+	if slug == "" {
+		return "Missing Slug"
+	}
+	if existing, err := ctl.Categories.GetBySlug(slug); err == nil && existing.ID != category.ID {
+		return "Slug already in use"
+	}
+	category.Slug = slug
+
+	if value, ok := data["tax_class"]; ok {
+		text, isText := value.(string)
+		if value != nil && !isText {
+			return "Invalid tax_class, expected a name such as reduced"
+		}
+		category.TaxClass = ""
+		if text != "" {
+			class, err := models.NormalizeTaxClass(text)
+			if err != nil {
+				return "Invalid tax_class, expected a name such as reduced"
+			}
+			category.TaxClass = class
+		}
+	}
+
+	if value, ok := data["parent_id"]; ok {
+		if value == nil {
+			category.ParentID = nil
+			return ""
+		}
+		number, ok := value.(float64)
+		if !ok {
+			return "Invalid Parent ID"
+		}
+		parentID := uint(number)
+		categories, err := ctl.Categories.List()
+		if err != nil {
+			return "failed to fetch categories"
+		}
+		if !categoryExists(categories, parentID) {
+			return "Parent category not found"
+		}
+
+		// A category cannot move below itself or one of its descendants
+		if category.ID != 0 {
+			for _, id := range models.CategoryDescendants(categories, category.ID) {
+				if id == parentID {
+					return "Cannot move a category below itself"
+				}
+			}
+		}
+		category.ParentID = &parentID
+	}
+	return ""
+}
+
+// categoryExists reports whether a category with the given ID is in the list
+func categoryExists(categories []models.Category, id uint) bool {
+	for _, category := range categories {
+		if category.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// categoryFilter resolves the category parameter of the product listing, an ID or a slug,
+// into that category and all of its descendants
+func (ctl *Controllers) categoryFilter(value string) ([]uint, error) {
+	var category *models.Category
+	var err error
+	if id, convErr := strconv.ParseUint(value, 10, 64); convErr == nil {
+		category, err = ctl.Categories.Get(uint(id))
+	} else {
+		category, err = ctl.Categories.GetBySlug(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		return nil, err
+	}
+	return models.CategoryDescendants(categories, category.ID), nil
+}
+
+// GetProductCategories returns the categories a product is assigned to
+func (ctl *Controllers) GetProductCategories(c *fiber.Ctx) error {
+	id, err := productID(c)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	if _, err := ctl.Products.Get(id); err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+
+	ids, err := ctl.Products.CategoryIDs(id)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch categories"})
+	}
+
+	// Skip assignments to categories deleted in the meantime
+	categories := []models.Category{}
+	for _, categoryID := range ids {
+		if category, err := ctl.Categories.Get(categoryID); err == nil {
+			categories = append(categories, *category)
+		}
+	}
+	return c.JSON(categories)
+}
+
+// SetProductCategories replaces the categories of a product with the category_ids of the
+// request body. Like other product changes it is limited to the seller and admins and needs
+// an If-Match header with the ETag of the product, as attributes may be dropped.
+func (ctl *Controllers) SetProductCategories(c *fiber.Ctx) error {
+	// Authenticate the request
+	token, err := ctl.authentication(c)
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "unauthenticated"})
+	}
+
+	id, err := productID(c)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	product, err := ctl.Products.Get(id)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	if !canModify(token.Claims.(*Claims), product) {
+		c.Status(fiber.StatusForbidden)
+		return c.JSON(fiber.Map{"message": "forbidden"})
+	}
+	if status, message := checkIfMatch(c, product); status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+
+	// Every category has to exist; attributes the new categories do not define are dropped
+	ids, message, err := ctl.applyProductExtras(product, fiber.Map{"category_ids": data["category_ids"]})
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch categories"})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	err = ctl.Products.UpdateCategories(product, ids)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		c.Status(fiber.StatusPreconditionFailed)
+		return c.JSON(fiber.Map{"message": "Product was modified since it was read, fetch it again"})
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to assign categories"})
+	}
+	return ctl.GetProductCategories(c)
+}
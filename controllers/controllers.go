@@ -1,23 +1,72 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"strconv"
 	"time"
 
-	"github.com/alwilion/database"
+	"github.com/alwilion/keys"
 	"github.com/alwilion/models"
+	"github.com/alwilion/payments"
+	"github.com/alwilion/repository"
+	"github.com/alwilion/storage"
+	"github.com/alwilion/tax"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// SecretKey is the secret key used for JWT token creation and validation
-const SecretKey = "secret"
+// Controllers holds the dependencies shared by the HTTP handlers
+type Controllers struct {
+	*repository.Store                 // Storage for all records
+	Keys              *keys.KeySet    // Keys used for JWT token creation and validation
+	PasswordCost      int             // bcrypt cost used when hashing passwords
+	AccessTokenTTL    time.Duration   // Lifetime of access tokens
+	RefreshTokenTTL   time.Duration   // Lifetime of refresh tokens
+	ReservationTTL    time.Duration   // Default time stock reservations hold their units
+	BaseCurrency      string          // Currency exchange rates are relative to, used to filter and sort by price
+	Storage           storage.Storage // Storage for uploaded product images, nil to disable uploads
+	MaxImageSize      int64           // Largest image upload accepted, in bytes
+	TrashRetention    time.Duration   // Time deleted products stay restorable before they are purged, zero to keep them
+	GuestCartTTL      time.Duration   // Time guest carts are kept after their last change, zero to keep them
+	PaymentTTL        time.Duration   // Time pending orders hold their stock while waiting for payment
+
+	PaymentProvider payments.PaymentProvider // Provider orders are paid through, nil to disable payments
+	WebhookSecret   string                   // Secret the webhook callbacks of the payment provider are signed with
+
+	TaxJurisdiction string       // Jurisdiction taxed when checkouts name none, empty to levy no tax by default
+	TaxInclusive    bool         // Whether product prices include tax
+	TaxRounding     tax.Rounding // Whether tax is rounded on every line or once per order and rate
+}
+
+// New creates the handlers on top of the given repositories and signing keys
+func New(store *repository.Store, keySet *keys.KeySet) *Controllers {
+	return &Controllers{
+		Store:           store,
+		Keys:            keySet,
+		PasswordCost:    14,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		ReservationTTL:  15 * time.Minute,
+		BaseCurrency:    DefaultBaseCurrency,
+		MaxImageSize:    DefaultMaxImageSize,
+		TrashRetention:  DefaultTrashRetention,
+		GuestCartTTL:    DefaultGuestCartTTL,
+		PaymentTTL:      DefaultPaymentTTL,
+		TaxRounding:     tax.RoundLine,
+	}
+}
+
+// productID parses the ":id" route parameter
+func productID(c *fiber.Ctx) (uint, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	return uint(id), err
+}
 
 // Register handles user registration
-func Register(c *fiber.Ctx) error {
+func (ctl *Controllers) Register(c *fiber.Ctx) error {
 	var data map[string]string
 
 	// Parse request body into a map
@@ -34,13 +83,27 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// Hash the password
-	password, _ := bcrypt.GenerateFromPassword([]byte(data["password"]), 14)
+	password, _ := bcrypt.GenerateFromPassword([]byte(data["password"]), ctl.PasswordCost)
+
+	// Users register as buyers, and may also sign up as sellers; admin rights are only granted by an admin
+	roles := models.Roles{models.RoleBuyer}
+	switch data["role"] {
+	case "", models.RoleBuyer:
+	case models.RoleSeller:
+		roles = roles.With(models.RoleSeller)
+	default:
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": "Invalid role",
+		})
+	}
 
 	// Create a new user with the provided data
 	user := models.User{
 		Name:     data["name"],
 		Email:    data["email"],
 		Password: password,
+		Roles:    roles,
 	}
 
 	// Validate the user struct using the validator package
@@ -51,7 +114,6 @@ func Register(c *fiber.Ctx) error {
 	if err != nil {
 		errorMsg := ""
 		for _, err := range err.(validator.ValidationErrors) {
-			fmt.Println("field:", err.Field())
 			if err.Field() == "Email" {
 				errorMsg = "Issue in Email"
 			} else if err.Field() == "Password" {
@@ -67,10 +129,10 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// Insert the user into the database
-	result := database.DB.Create(&user)
+	err = ctl.Users.Create(&user)
 
 	// Check for errors during insertion
-	if result.Error != nil {
+	if err != nil {
 		c.Status(fiber.StatusInternalServerError)
 		return c.JSON(fiber.Map{
 			"message": "failed to insert record into the database",
@@ -80,7 +142,7 @@ func Register(c *fiber.Ctx) error {
 }
 
 // Login handles user login
-func Login(c *fiber.Ctx) error {
+func (ctl *Controllers) Login(c *fiber.Ctx) error {
 	var data map[string]string
 
 	// Parse request body into a map
@@ -89,11 +151,10 @@ func Login(c *fiber.Ctx) error {
 	}
 
 	// Find the user in the database by email
-	var user models.User
-	database.DB.Where("email = ?", data["email"]).First(&user)
+	user, err := ctl.Users.GetByEmail(data["email"])
 
 	// Check if the user exists
-	if user.ID == 0 {
+	if err != nil {
 		c.Status(fiber.StatusNotFound)
 		return c.JSON(fiber.Map{"message": "User not found"})
 	}
@@ -106,14 +167,8 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create a new JWT token with user ID as the issuer and an expiration time of 24 hours
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Issuer:    strconv.Itoa(int(user.ID)),
-		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	// Sign the token with the secret key
-	token, err := claims.SignedString([]byte(SecretKey))
+	// Issue an access token and a refresh token starting a new token family
+	tokens, err := ctl.issueTokens(user, uuid.NewString())
 
 	// Check for errors during token creation
 	if err != nil {
@@ -123,17 +178,24 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Return the success message along with the generated token
-	return c.JSON(fiber.Map{
-		"message": "success",
-		"token":   token,
-	})
+	// Move the guest cart, named in the body or the cart token header, into the user's cart;
+	// the login succeeds even if that fails
+	cartToken := data["cart_token"]
+	if cartToken == "" {
+		cartToken = c.Get(CartTokenHeader)
+	}
+	if err := ctl.mergeGuestCart(user.ID, cartToken); err != nil {
+		log.Printf("merge guest cart of user %d: %v", user.ID, err)
+	}
+
+	// Return the success message along with the generated tokens
+	return c.JSON(tokens)
 }
 
 // User retrieves user details based on the provided JWT token
-func User(c *fiber.Ctx) error {
+func (ctl *Controllers) User(c *fiber.Ctx) error {
 	// Authenticate the request and retrieve the JWT token
-	token, err := authentication(c)
+	token, err := ctl.authentication(c)
 
 	// Handle authentication errors
 	if err != nil {
@@ -144,25 +206,23 @@ func User(c *fiber.Ctx) error {
 	}
 
 	// Extract the claims from the JWT token
-	claims := token.Claims.(*jwt.StandardClaims)
+	claims := token.Claims.(*Claims)
 
 	// Retrieve the user from the database based on the user ID in the JWT claims
-	var user models.User
-	database.DB.Where("id = ?", claims.Issuer).First(&user)
-
-	// Print the type of user ID for debugging
-	fmt.Printf("type of id %T", user.ID)
+	user, err := ctl.Users.Get(claims.UserID())
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "User not found"})
+	}
 
 	// Return the user details as JSON
 	return c.JSON(user)
 }
 
 // AddProduct handles the addition of a new product
-func AddProduct(c *fiber.Ctx) error {
-	fmt.Print("Add Product")
-
+func (ctl *Controllers) AddProduct(c *fiber.Ctx) error {
 	// Authenticate the request
-	_, err := authentication(c)
+	token, err := ctl.authentication(c)
 
 	// Handle authentication errors
 	if err != nil {
@@ -178,28 +238,29 @@ func AddProduct(c *fiber.Ctx) error {
 		return err
 	}
 
-	fmt.Print("Add Product1")
-
 	// Create a new product instance
 	var product models.Product
 
 	// Validate and set the product fields from the parsed data
-	if value, ok := data["price"]; ok {
-		// Type assertion to float64
-		price, ok := value.(float64)
-		if !ok || price <= 0 {
-			c.Status(fiber.StatusBadRequest)
-			return c.JSON(fiber.Map{
-				"message": "Invalid or missing positive Price",
-			})
-		}
-		product.Price = price
-	} else {
+	if _, ok := data["price"]; !ok {
 		c.Status(fiber.StatusBadRequest)
 		return c.JSON(fiber.Map{
 			"message": "Price Key Missing",
 		})
 	}
+	message, err := ctl.applyPrice(&product, data)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch exchange rates",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
 
 	if value, ok := data["description"]; ok {
 		// Type assertion to string
@@ -235,23 +296,53 @@ func AddProduct(c *fiber.Ctx) error {
 		})
 	}
 
+	// Validate the optional categories, attributes and tags
+	categoryIDs, message, err := ctl.applyProductExtras(&product, data)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch categories",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+
+	// The authenticated user becomes the seller of the product
+	sellerID := token.Claims.(*Claims).UserID()
+	product.SellerID = &sellerID
+
 	// Insert the product into the database
-	result := database.DB.Create(&product)
+	err = ctl.Products.Create(&product)
 
 	// Check for errors during insertion
-	if result.Error != nil {
+	if err != nil {
 		c.Status(fiber.StatusInternalServerError)
 		return c.JSON(fiber.Map{
 			"message": "failed to insert record into the database",
 		})
 	}
+
+	// Assign the product to its categories
+	if len(categoryIDs) > 0 {
+		if err := ctl.Products.SetCategories(product.ID, categoryIDs); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{
+				"message": "failed to assign categories",
+			})
+		}
+	}
 	return c.JSON(product)
 }
 
-// UpdateProduct handles the update of an existing product
-func UpdateProduct(c *fiber.Ctx) error {
+// UpdateProduct handles the update of an existing product. Only the fields given in the body
+// change; If-Match has to name the current version of the product, as sent in its ETag.
+func (ctl *Controllers) UpdateProduct(c *fiber.Ctx) error {
 	// Authenticate the request
-	_, err := authentication(c)
+	token, err := ctl.authentication(c)
 
 	// Handle authentication errors
 	if err != nil {
@@ -261,11 +352,28 @@ func UpdateProduct(c *fiber.Ctx) error {
 		})
 	}
 
-	var updatedProduct models.Product
-
-	if err := database.DB.First(&updatedProduct, c.Params("id")).Error; err != nil {
+	id, err := productID(c)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
 		return c.JSON(fiber.Map{"message": "Product Not Found"})
 	}
+	updatedProduct, err := ctl.Products.Get(id)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+
+	// Only the seller of the product, or an admin, may change it
+	if !canModify(token.Claims.(*Claims), updatedProduct) {
+		c.Status(fiber.StatusForbidden)
+		return c.JSON(fiber.Map{"message": "forbidden"})
+	}
+
+	// The client has to prove it saw the current version, so that concurrent edits are not lost
+	if status, message := checkIfMatch(c, updatedProduct); status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
 
 	data := make(map[string]interface{})
 	if err := c.BodyParser(&data); err != nil {
@@ -273,16 +381,39 @@ func UpdateProduct(c *fiber.Ctx) error {
 	}
 
 	// Validate and update the product fields from the parsed data
-	if value, ok := data["price"]; ok {
-		// Type assertion to float64
-		price, ok := value.(float64)
-		if !ok || price <= 0 {
-			c.Status(fiber.StatusBadRequest)
+	oldPrice := updatedProduct.Price
+	currency := oldPrice.Currency
+	message, err := ctl.applyPrice(updatedProduct, data)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch exchange rates",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+
+	// Variant price overrides are kept in minor units of the product currency
+	if updatedProduct.Price.Currency != currency {
+		variants, err := ctl.Variants.List(updatedProduct.ID)
+		if err != nil {
+			c.Status(fiber.StatusInternalServerError)
 			return c.JSON(fiber.Map{
-				"message": "Invalid or missing positive Price",
+				"message": "failed to fetch variants",
 			})
 		}
-		updatedProduct.Price = price
+		for _, variant := range variants {
+			if variant.Price != nil {
+				c.Status(fiber.StatusConflict)
+				return c.JSON(fiber.Map{
+					"message": "Remove the variant price overrides before changing the Currency",
+				})
+			}
+		}
 	}
 	if value, ok := data["description"]; ok {
 		// Type assertion to string
@@ -307,15 +438,352 @@ func UpdateProduct(c *fiber.Ctx) error {
 		updatedProduct.Name = name
 	}
 
-	// Save the updated product in the database
-	result := database.DB.Save(&updatedProduct)
+	// Validate the optional categories, attributes and tags
+	categoryIDs, message, err := ctl.applyProductExtras(updatedProduct, data)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch categories",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+
+	// Save the updated product and its categories in the database, keeping a trace of price
+	// changes in the price history in the same transaction
+	if updatedProduct.Price != oldPrice {
+		userID := token.Claims.(*Claims).UserID()
+		change := &models.PriceChange{ProductID: updatedProduct.ID, OldPrice: oldPrice, NewPrice: updatedProduct.Price, Reason: models.PriceChangeManual, UserID: &userID}
+		err = ctl.Prices.Change(updatedProduct, change, categoryIDs)
+	} else {
+		err = ctl.Products.UpdateCategories(updatedProduct, categoryIDs)
+	}
 
 	// Check for errors during update
-	if result.Error != nil {
+	if errors.Is(err, repository.ErrVersionConflict) {
+		c.Status(fiber.StatusPreconditionFailed)
+		return c.JSON(fiber.Map{
+			"message": "Product was modified since it was read, fetch it again",
+		})
+	}
+	if err != nil {
 		c.Status(fiber.StatusInternalServerError)
 		return c.JSON(fiber.Map{
 			"message": "failed to update record into the database",
 		})
 	}
-	return c.JSON(updatedProduct)
+
+	return sendProduct(c, updatedProduct.Version, updatedProduct)
+}
+
+// GetProductList retrieves a filtered, sorted page of products
+func (ctl *Controllers) GetProductList(c *fiber.Ctx) error {
+	// Authenticate the request
+	_, err := ctl.authentication(c)
+
+	// Handle authentication errors
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{
+			"message": "unauthenticated",
+		})
+	}
+
+	// Prices are shown, and filtered by, in the currency asked for
+	view, message, err := ctl.displayCurrency(c)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch exchange rates",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+
+	// Read the filters, sort order and page requested in the query string
+	query, err := parseProductQuery(c, view)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	// Narrow the listing down to a category and everything below it
+	if value := c.Query("category"); value != "" {
+		query.CategoryIDs, err = ctl.categoryFilter(value)
+		if err != nil {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{
+				"message": "Unknown category",
+			})
+		}
+	}
+
+	// Filter by attribute values and tags
+	message, err = ctl.attributeFilters(c, &query)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch attributes",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+
+	// Facets are only counted when asked for, which also wraps the products in an object
+	kinds, err := parseFacetKinds(c.Query("facets"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	// Fetch one page of matching products from the database
+	page, err := ctl.Products.Find(query)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{
+				"message": "Invalid cursor",
+			})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch products from the database",
+		})
+	}
+
+	// Describe the neighbouring pages in the headers and return the products
+	setPaginationHeaders(c, query, page)
+	listed := make([]*models.Product, len(page.Products))
+	for i := range page.Products {
+		view.apply(&page.Products[i])
+		listed[i] = &page.Products[i]
+	}
+	if err := ctl.attachPrimaryImages(listed...); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch images",
+		})
+	}
+	if kinds == nil {
+		return c.JSON(page.Products)
+	}
+
+	// Count the facets over every matching product, not just this page
+	facets, message, err := ctl.productFacets(c, query, kinds, view)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to count facets",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"products": page.Products,
+		"facets":   facets,
+	})
+}
+
+// GetProductById retrieves a single product by its ID together with its variant matrix. The
+// ETag of the response is needed to change the product; with If-None-Match it answers
+// 304 Not Modified while the cached copy of the client is current.
+func (ctl *Controllers) GetProductById(c *fiber.Ctx) error {
+	// Authenticate the request
+	_, err := ctl.authentication(c)
+
+	// Handle authentication errors
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{
+			"message": "unauthenticated",
+		})
+	}
+
+	// Look up the product, answering 404 for unknown or malformed IDs
+	id, err := productID(c)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	product, err := ctl.Products.Get(id)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	view, message, err := ctl.displayCurrency(c)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch exchange rates"})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	view.apply(product)
+
+	// Include the variant matrix
+	detail, err := ctl.productDetail(product)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch variants"})
+	}
+
+	// Include the image gallery, with the primary image also shown as in listings
+	if detail.Images, err = ctl.productImages(product.ID); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch images"})
+	}
+	for i := range detail.Images {
+		if detail.Images[i].Primary {
+			product.PrimaryImage = &detail.Images[i]
+		}
+	}
+	return sendProduct(c, product.Version, detail)
+}
+
+// DeleteProductById moves a single product to the trash, where it can be restored until the
+// retention job purges it. With purge=true an admin deletes it permanently right away, from
+// the trash or not. If-Match has to name the current version of the product.
+func (ctl *Controllers) DeleteProductById(c *fiber.Ctx) error {
+	// Authenticate the request
+	token, err := ctl.authentication(c)
+
+	// Handle authentication errors
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{
+			"message": "unauthenticated",
+		})
+	}
+
+	id, err := productID(c)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+
+	// Permanent deletes are reserved to admins
+	if c.QueryBool("purge") {
+		if !Allowed(token.Claims.(*Claims).Roles, PermPurgeProducts) {
+			c.Status(fiber.StatusForbidden)
+			return c.JSON(fiber.Map{"message": "forbidden"})
+		}
+		product, err := ctl.Products.Get(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			product, err = ctl.Products.GetDeleted(id)
+		}
+		if err != nil {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Product Not Found"})
+		}
+		if status, message := checkIfMatch(c, product); status != 0 {
+			c.Status(status)
+			return c.JSON(fiber.Map{"message": message})
+		}
+		if err := ctl.purgeProduct(id); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.Status(fiber.StatusNotFound)
+				return c.JSON(fiber.Map{"message": "Product Not Found"})
+			}
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to purge product"})
+		}
+		return c.JSON(fiber.Map{"message": "Product Purged"})
+	}
+
+	product, err := ctl.Products.Get(id)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+
+	// Only the seller of the product, or an admin, may delete it
+	if !canModify(token.Claims.(*Claims), product) {
+		c.Status(fiber.StatusForbidden)
+		return c.JSON(fiber.Map{"message": "forbidden"})
+	}
+	if status, message := checkIfMatch(c, product); status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	// Delete the product from the database
+	if err := ctl.Products.Delete(id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Product Not Found"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to delete record from the database",
+		})
+	}
+	return c.JSON(fiber.Map{"message": "Product Deleted"})
+}
+
+// GetMyProducts retrieves the products listed by the authenticated user
+func (ctl *Controllers) GetMyProducts(c *fiber.Ctx) error {
+	// Authenticate the request
+	token, err := ctl.authentication(c)
+
+	// Handle authentication errors
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{
+			"message": "unauthenticated",
+		})
+	}
+	return ctl.sellerProducts(c, token.Claims.(*Claims).UserID())
+}
+
+// GetSellerProducts retrieves the products listed by the seller named in the ":id" route parameter
+func (ctl *Controllers) GetSellerProducts(c *fiber.Ctx) error {
+	sellerID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "User not found"})
+	}
+	return ctl.sellerProducts(c, uint(sellerID))
+}
+
+// sellerProducts responds with the products of one seller
+func (ctl *Controllers) sellerProducts(c *fiber.Ctx, sellerID uint) error {
+	products, err := ctl.Products.ListBySeller(sellerID)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch products from the database",
+		})
+	}
+	listed := make([]*models.Product, len(products))
+	for i := range products {
+		listed[i] = &products[i]
+	}
+	if err := ctl.attachPrimaryImages(listed...); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch images",
+		})
+	}
+	return c.JSON(products)
 }
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alwilion/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// productETag returns the entity tag of a product representation, such as "3-9f86d081884c7d65".
+// The part before the dash is the version of the product, which If-Match preconditions are
+// checked against. The digest of the body changes with everything else the representation
+// shows, such as stock levels, images and converted prices, so cached copies are refreshed.
+func productETag(version uint, body []byte) string {
+	digest := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%x"`, version, digest[:8])
+}
+
+// sendProduct responds with the JSON of a product representation and its ETag, or with
+// 304 Not Modified when If-None-Match names the ETag of the copy the client already has
+func sendProduct(c *fiber.Ctx, version uint, value interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	tag := productETag(version, body)
+	c.Set(fiber.HeaderETag, tag)
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" && etagListed(noneMatch, func(candidate string) bool {
+		// If-None-Match uses the weak comparison
+		return strings.TrimPrefix(candidate, "W/") == tag
+	}) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.Send(body)
+}
+
+// checkIfMatch enforces the If-Match precondition of requests changing a product: the header
+// is required and has to name the current version of the product, or be "*". On failure it
+// returns the status and message to answer with.
+func checkIfMatch(c *fiber.Ctx, product *models.Product) (int, string) {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return fiber.StatusPreconditionRequired, "If-Match header with the ETag of the product is required"
+	}
+	if !etagListed(header, func(candidate string) bool {
+		version, ok := etagVersion(candidate)
+		return ok && version == product.Version
+	}) {
+		return fiber.StatusPreconditionFailed, "Product was modified since it was read, fetch it again"
+	}
+	return 0, ""
+}
+
+// etagListed reports whether a header holding "*" or a comma-separated list of entity tags
+// names a tag accepted by match
+func etagListed(header string, match func(tag string) bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagVersion extracts the product version from a strong entity tag made by productETag.
+// Weak tags never match, as If-Match requires the strong comparison.
+func etagVersion(tag string) (uint, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	value, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+	version, err := strconv.ParseUint(value, 10, 64)
+	return uint(version), err == nil
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Facet kinds accepted by the facets parameter of the product listing
+const (
+	FacetCategory  = "category"
+	FacetPrice     = "price"
+	FacetTag       = "tag"
+	FacetAttribute = "attribute"
+)
+
+// maxFacetValues caps the number of tag and attribute values returned per facet
+const maxFacetValues = 20
+
+// defaultPriceBreaks split prices, in the display currency, into ranges unless the price_ranges
+// parameter gives others
+const defaultPriceBreaks = "10,25,50,100,250,500,1000"
+
+// Facets are the counts shown next to a product listing, computed over all matching products
+type Facets struct {
+	Categories []CategoryFacet         `json:"categories,omitempty"` // Subcategories of the filtered category, or the top-level categories
+	Prices     []PriceFacet            `json:"prices,omitempty"`     // Price ranges in ascending order
+	Tags       []ValueFacet            `json:"tags,omitempty"`       // Most frequent tags first
+	Attributes map[string][]ValueFacet `json:"attributes,omitempty"` // Most frequent values first, by attribute name
+}
+
+// CategoryFacet counts the matching products in a category and its descendants
+type CategoryFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int64  `json:"count"`
+}
+
+// PriceFacet counts the matching products with a price from Min up to but excluding Max
+type PriceFacet struct {
+	Min   *models.Money `json:"min"` // Nil for the lowest range
+	Max   *models.Money `json:"max"` // Nil for the highest range
+	Count int64         `json:"count"`
+}
+
+// ValueFacet counts the matching products with a tag or attribute value
+type ValueFacet struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// parseFacetKinds reads the facets parameter: a comma-separated list of facet kinds, or
+// "all" or "true" for every kind. It returns nil when no facets are requested.
+func parseFacetKinds(value string) (map[string]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	kinds := make(map[string]bool)
+	for _, kind := range strings.Split(value, ",") {
+		switch kind = strings.TrimSpace(kind); kind {
+		case "all", "true":
+			return map[string]bool{FacetCategory: true, FacetPrice: true, FacetTag: true, FacetAttribute: true}, nil
+		case FacetCategory, FacetPrice, FacetTag, FacetAttribute:
+			kinds[kind] = true
+		default:
+			return nil, errors.New("Invalid facets, expected category, price, tag, attribute or all")
+		}
+	}
+	return kinds, nil
+}
+
+// parsePriceBreaks reads the price_ranges parameter, ascending prices in the display currency
+// separating the ranges. It returns the prices as given and converted to the base currency.
+func parsePriceBreaks(value string, view PriceView) ([]models.Money, []int64, error) {
+	if value == "" {
+		value = defaultPriceBreaks
+	}
+	var prices []models.Money
+	var breaks []int64
+	for _, part := range strings.Split(value, ",") {
+		price, base, err := view.parse(part)
+		if err != nil || price.Amount <= 0 || (len(breaks) > 0 && base <= breaks[len(breaks)-1]) {
+			return nil, nil, errors.New("Invalid price_ranges, expected ascending positive prices")
+		}
+		prices = append(prices, price)
+		breaks = append(breaks, base)
+	}
+	return prices, breaks, nil
+}
+
+// productFacets computes the requested facets over all products matching the listing query,
+// with price ranges in the currency of the view.
+// Category counts cover the children of the filtered category, or the top-level categories
+// without a category filter, each including the products of its descendants.
+func (ctl *Controllers) productFacets(c *fiber.Ctx, query repository.ProductQuery, kinds map[string]bool, view PriceView) (*Facets, string, error) {
+	request := repository.FacetRequest{Tags: kinds[FacetTag], Attributes: kinds[FacetAttribute]}
+	var prices []models.Money
+	if kinds[FacetPrice] {
+		var err error
+		prices, request.PriceBreaks, err = parsePriceBreaks(c.Query("price_ranges"), view)
+		if err != nil {
+			return nil, err.Error(), nil
+		}
+	}
+
+	var categories []models.Category
+	if kinds[FacetCategory] || kinds[FacetAttribute] {
+		var err error
+		if categories, err = ctl.Categories.List(); err != nil {
+			return nil, "", err
+		}
+	}
+	if kinds[FacetCategory] {
+		request.CategoryGroups = make(map[uint][]uint)
+		for _, category := range categories {
+			isRoot := category.ParentID == nil && len(query.CategoryIDs) == 0
+			isChild := category.ParentID != nil && len(query.CategoryIDs) > 0 && *category.ParentID == query.CategoryIDs[0]
+			if isRoot || isChild {
+				request.CategoryGroups[category.ID] = models.CategoryDescendants(categories, category.ID)
+			}
+		}
+	}
+
+	counts, err := ctl.Products.Facets(query, request)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Turn the raw counts into named, sorted buckets
+	facets := &Facets{}
+	for _, category := range categories {
+		if count := counts.Categories[category.ID]; count > 0 {
+			facets.Categories = append(facets.Categories, CategoryFacet{ID: category.ID, Name: category.Name, Slug: category.Slug, Count: count})
+		}
+	}
+	sort.Slice(facets.Categories, func(i, j int) bool { return facets.Categories[i].Name < facets.Categories[j].Name })
+
+	for i, count := range counts.Prices {
+		if count == 0 {
+			continue
+		}
+		bucket := PriceFacet{Count: count}
+		if i > 0 {
+			bucket.Min = &prices[i-1]
+		}
+		if i < len(prices) {
+			bucket.Max = &prices[i]
+		}
+		facets.Prices = append(facets.Prices, bucket)
+	}
+
+	for tag, count := range counts.Tags {
+		facets.Tags = append(facets.Tags, ValueFacet{Value: tag, Count: count})
+	}
+	facets.Tags = topValues(facets.Tags)
+
+	if len(counts.Attributes) > 0 {
+		ids := make([]uint, len(categories))
+		for i, category := range categories {
+			ids[i] = category.ID
+		}
+		definitions, err := ctl.Categories.Attributes(ids...)
+		if err != nil {
+			return nil, "", err
+		}
+		facets.Attributes = make(map[string][]ValueFacet)
+		for _, definition := range definitions {
+			values, ok := counts.Attributes[definition.Name]
+			if !ok || facets.Attributes[definition.Name] != nil {
+				continue
+			}
+			var buckets []ValueFacet
+			for value, count := range values {
+				buckets = append(buckets, ValueFacet{Value: attributeDisplayValue(definition, value), Count: count})
+			}
+			facets.Attributes[definition.Name] = topValues(buckets)
+		}
+	}
+	return facets, "", nil
+}
+
+// topValues orders value buckets by count, then by value, and keeps the most frequent
+func topValues(buckets []ValueFacet) []ValueFacet {
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return models.AttributeIndexValue(buckets[i].Value) < models.AttributeIndexValue(buckets[j].Value)
+	})
+	if len(buckets) > maxFacetValues {
+		buckets = buckets[:maxFacetValues]
+	}
+	return buckets
+}
+
+// attributeDisplayValue converts an indexed attribute value back into the type of its
+// definition, restoring the spelling of enum options
+func attributeDisplayValue(definition models.AttributeDefinition, value string) interface{} {
+	switch definition.Type {
+	case models.AttributeNumber:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case models.AttributeBoolean:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case models.AttributeEnum:
+		for _, option := range definition.Options {
+			if strings.EqualFold(option, value) {
+				return option
+			}
+		}
+	}
+	return value
+}
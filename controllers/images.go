@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/alwilion/images"
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxImageSize is the largest image upload accepted unless configured otherwise
+const DefaultMaxImageSize = 4 * 1024 * 1024
+
+// imageTypes are the content types accepted for product images
+var imageTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// ProductImages is the gallery of a product
+type ProductImages struct {
+	Images []models.ProductImage `json:"images"` // Ordered by position
+}
+
+// imageURLs fills in the public addresses of an image and its thumbnails
+func (ctl *Controllers) imageURLs(image *models.ProductImage) {
+	if ctl.Storage == nil {
+		return
+	}
+	image.URL = ctl.Storage.URL(image.OriginalKey())
+	image.Thumbnails = make(map[string]string, len(images.Sizes))
+	for _, size := range images.Sizes {
+		image.Thumbnails[size.Name] = ctl.Storage.URL(image.ThumbnailKey(size.Name))
+	}
+}
+
+// productImages returns the gallery of a product with the addresses of its files
+func (ctl *Controllers) productImages(productID uint) ([]models.ProductImage, error) {
+	list, err := ctl.Images.List(productID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		ctl.imageURLs(&list[i])
+	}
+	return list, nil
+}
+
+// attachPrimaryImages sets the primary image shown in listings on each product
+func (ctl *Controllers) attachPrimaryImages(products ...*models.Product) error {
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	primary, err := ctl.Images.Primary(ids)
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		if image, ok := primary[product.ID]; ok {
+			ctl.imageURLs(&image)
+			product.PrimaryImage = &image
+		}
+	}
+	return nil
+}
+
+// ListProductImages returns the images of a product in gallery order
+func (ctl *Controllers) ListProductImages(c *fiber.Ctx) error {
+	product, ok := ctl.variantProduct(c)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	list, err := ctl.productImages(product.ID)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch images"})
+	}
+	return c.JSON(ProductImages{Images: list})
+}
+
+// UploadProductImage adds an image to the end of the gallery of a product. The file is sent as
+// the "image" field of a multipart form. Its type is sniffed from the content, whatever the
+// client claims: JPEG, PNG and GIF images up to MaxImageSize bytes are accepted. The original
+// is stored together with a thumbnail for every size in images.Sizes. The first image of a
+// product becomes its primary image.
+func (ctl *Controllers) UploadProductImage(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	if ctl.Storage == nil {
+		c.Status(fiber.StatusServiceUnavailable)
+		return c.JSON(fiber.Map{"message": "Image storage is not configured"})
+	}
+
+	header, err := c.FormFile("image")
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Missing image file, expected a multipart form field named image"})
+	}
+	tooLarge := fmt.Sprintf("Image is larger than %d bytes", ctl.MaxImageSize)
+	if header.Size > ctl.MaxImageSize {
+		c.Status(fiber.StatusRequestEntityTooLarge)
+		return c.JSON(fiber.Map{"message": tooLarge})
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid image file"})
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, ctl.MaxImageSize+1))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid image file"})
+	}
+	if int64(len(data)) > ctl.MaxImageSize {
+		c.Status(fiber.StatusRequestEntityTooLarge)
+		return c.JSON(fiber.Map{"message": tooLarge})
+	}
+
+	// Trust the content, not the file name or the Content-Type of the part
+	contentType := mimetype.Detect(data).String()
+	if !mimetype.EqualsAny(contentType, imageTypes...) {
+		c.Status(fiber.StatusUnsupportedMediaType)
+		return c.JSON(fiber.Map{"message": "Unsupported image type, expected JPEG, PNG or GIF"})
+	}
+	decoded, _, err := images.Decode(data)
+	if err != nil {
+		if errors.Is(err, images.ErrTooLarge) {
+			c.Status(fiber.StatusRequestEntityTooLarge)
+			return c.JSON(fiber.Map{"message": fmt.Sprintf("Image has more than %d pixels", images.MaxPixels)})
+		}
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid image file"})
+	}
+
+	thumbnails, err := images.Render(decoded)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to render thumbnails"})
+	}
+
+	bounds := decoded.Bounds()
+	image := &models.ProductImage{
+		ProductID:     product.ID,
+		Key:           fmt.Sprintf("products/%d/images/%s", product.ID, uuid.NewString()),
+		ContentType:   contentType,
+		ThumbnailType: thumbnails[0].ContentType,
+		Size:          int64(len(data)),
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+	}
+	if err := ctl.storeImage(c.Context(), image, data, thumbnails); err != nil {
+		ctl.deleteImageFiles(image)
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to store image"})
+	}
+	if err := ctl.Images.Create(image); err != nil {
+		ctl.deleteImageFiles(image)
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to save image"})
+	}
+	ctl.imageURLs(image)
+	c.Status(fiber.StatusCreated)
+	return c.JSON(image)
+}
+
+// storeImage writes the original and the thumbnails of an image to the storage
+func (ctl *Controllers) storeImage(ctx context.Context, image *models.ProductImage, data []byte, thumbnails []images.Rendered) error {
+	if err := ctl.Storage.Put(ctx, image.OriginalKey(), data, image.ContentType); err != nil {
+		return err
+	}
+	for _, thumbnail := range thumbnails {
+		if err := ctl.Storage.Put(ctx, image.ThumbnailKey(thumbnail.Size.Name), thumbnail.Data, thumbnail.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReorderProductImages sets the gallery order of a product. The body lists the IDs of all
+// images of the product in their new order as "image_ids".
+func (ctl *Controllers) ReorderProductImages(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	var data struct {
+		ImageIDs []uint `json:"image_ids"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid image_ids, expected a list of image IDs"})
+	}
+	list, err := ctl.Images.Reorder(product.ID, data.ImageIDs)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidOrder) {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": "Invalid image_ids, expected every image of the product exactly once"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to reorder images"})
+	}
+	for i := range list {
+		ctl.imageURLs(&list[i])
+	}
+	return c.JSON(ProductImages{Images: list})
+}
+
+// SetPrimaryProductImage makes an image the one shown in product listings
+func (ctl *Controllers) SetPrimaryProductImage(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	image, err := ctl.productImage(c, product.ID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Image not found"})
+	}
+	if err := ctl.Images.SetPrimary(image.ID); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to set primary image"})
+	}
+	image.Primary = true
+	ctl.imageURLs(image)
+	return c.JSON(image)
+}
+
+// DeleteProductImage removes an image and its files. When it was the primary image, the
+// first remaining image of the gallery takes its place.
+func (ctl *Controllers) DeleteProductImage(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	image, err := ctl.productImage(c, product.ID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Image not found"})
+	}
+	if err := ctl.Images.Delete(image.ID); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to delete image"})
+	}
+	ctl.deleteImageFiles(image)
+	return c.JSON(fiber.Map{"message": "Image deleted successfully"})
+}
+
+// productImage looks up the image of the ":imageId" route parameter, which has to belong to the product
+func (ctl *Controllers) productImage(c *fiber.Ctx, productID uint) (*models.ProductImage, error) {
+	id, err := strconv.ParseUint(c.Params("imageId"), 10, 64)
+	if err != nil {
+		return nil, repository.ErrNotFound
+	}
+	image, err := ctl.Images.Get(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if image.ProductID != productID {
+		return nil, repository.ErrNotFound
+	}
+	return image, nil
+}
+
+// deleteImageFiles removes the original and the thumbnails of an image from the storage.
+// Failures are only logged: the record is gone and leftover files are merely unreferenced.
+func (ctl *Controllers) deleteImageFiles(image *models.ProductImage) {
+	if ctl.Storage == nil {
+		return
+	}
+	keys := []string{image.OriginalKey()}
+	if image.ThumbnailType != "" {
+		for _, size := range images.Sizes {
+			keys = append(keys, image.ThumbnailKey(size.Name))
+		}
+	}
+	for _, key := range keys {
+		if err := ctl.Storage.Delete(context.Background(), key); err != nil {
+			log.Printf("delete image file %s: %v", key, err)
+		}
+	}
+}
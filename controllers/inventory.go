@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxReservationTTL is the longest a reservation may hold stock
+const MaxReservationTTL = 24 * time.Hour
+
+// Number of ledger entries returned with the inventory of a product
+const (
+	defaultMovementLimit = 50
+	maxMovementLimit     = 500
+)
+
+// InventoryLevel is the stock of a product, or of one of its variants
+type InventoryLevel struct {
+	VariantID uint   `json:"variant_id"`    // Zero for products without variants
+	SKU       string `json:"sku,omitempty"` // SKU of the variant
+	OnHand    int    `json:"on_hand"`       // Units in stock
+	Reserved  int    `json:"reserved"`      // Units held by active reservations
+	Available int    `json:"available"`     // Units that can still be reserved or sold
+}
+
+// Inventory is the stock of a product and its latest ledger entries
+type Inventory struct {
+	Levels    []InventoryLevel       `json:"levels"`
+	Movements []models.StockMovement `json:"movements"` // Newest first
+}
+
+// GetInventory returns the stock of a product, per variant for products with variants, and
+// the latest entries of its inventory ledger. The limit parameter caps the number of entries.
+// Only the seller of the product and admins may see it.
+func (ctl *Controllers) GetInventory(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	limit := c.QueryInt("limit", defaultMovementLimit)
+	if limit < 1 || limit > maxMovementLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid limit"})
+	}
+
+	inventory, err := ctl.inventory(product, limit)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch inventory"})
+	}
+	return c.JSON(inventory)
+}
+
+// inventory collects the stock levels and latest ledger entries of a product
+func (ctl *Controllers) inventory(product *models.Product, limit int) (*Inventory, error) {
+	variants, err := ctl.Variants.List(product.ID)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := ctl.stockLevels(product.ID)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &Inventory{Levels: []InventoryLevel{}}
+	if len(variants) == 0 {
+		variants = []models.Variant{{}}
+	}
+	for _, variant := range variants {
+		level := levels[variant.ID]
+		inventory.Levels = append(inventory.Levels, InventoryLevel{
+			VariantID: variant.ID,
+			SKU:       variant.SKU,
+			OnHand:    level.OnHand,
+			Reserved:  level.Reserved,
+			Available: level.Available(),
+		})
+	}
+	inventory.Movements, err = ctl.Inventory.Movements(product.ID, limit)
+	return inventory, err
+}
+
+// RecordInventory changes the stock of a product. The body takes the type of movement:
+//
+//   - receive adds a positive quantity of arrived units
+//   - adjust corrects the stock by a positive or negative quantity
+//   - reserve holds a quantity for ttl seconds, answering with the reservation
+//   - release returns the units of the reservation_id to the available stock
+//   - sell takes the units of the reservation_id, or a quantity of available units, out of the stock
+//
+// Products with variants take the variant_id the movement applies to. An optional note and,
+// for reservations, a reference such as a cart or order ID are kept with the movement.
+func (ctl *Controllers) RecordInventory(c *fiber.Ctx) error {
+	product, claims, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	var data struct {
+		Type          models.MovementKind `json:"type"`
+		VariantID     uint                `json:"variant_id"`
+		Quantity      float64             `json:"quantity"`
+		ReservationID uint                `json:"reservation_id"`
+		TTL           int                 `json:"ttl"`
+		Reference     string              `json:"reference"`
+		Note          string              `json:"note"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid inventory movement"})
+	}
+	if data.Quantity != math.Trunc(data.Quantity) || math.Abs(data.Quantity) > math.MaxInt32 {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid Quantity, expected an integer"})
+	}
+	quantity := int(data.Quantity)
+	userID := claims.UserID()
+	now := time.Now()
+
+	// Releases and sales of reservations name the reservation instead of the stock
+	if data.ReservationID != 0 && (data.Type == models.MovementRelease || data.Type == models.MovementSell) {
+		reservation, err := ctl.Inventory.Reservation(data.ReservationID)
+		if err != nil || reservation.ProductID != product.ID {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Reservation Not Found"})
+		}
+		if data.Type == models.MovementRelease {
+			reservation, err = ctl.Inventory.Release(reservation.ID, &userID, now)
+		} else {
+			reservation, err = ctl.Inventory.Sell(reservation.ID, &userID, now)
+		}
+		if err != nil {
+			return inventoryError(c, err)
+		}
+		return c.JSON(reservation)
+	}
+
+	// Everything else applies to the product or one of its variants
+	if message, err := ctl.checkStockVariant(product, data.VariantID); err != nil || message != "" {
+		if err != nil {
+			return inventoryError(c, err)
+		}
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	switch data.Type {
+	case models.MovementReceive, models.MovementAdjust, models.MovementSell:
+		if data.Type == models.MovementSell {
+			quantity = -quantity
+		}
+		movement := &models.StockMovement{ProductID: product.ID, VariantID: data.VariantID, Kind: data.Type, Quantity: quantity, UserID: &userID, Note: data.Note}
+		if err := ctl.Inventory.Record(movement); err != nil {
+			return inventoryError(c, err)
+		}
+		c.Status(fiber.StatusCreated)
+		return c.JSON(movement)
+
+	case models.MovementReserve:
+		ttl := ctl.ReservationTTL
+		if data.TTL != 0 {
+			ttl = time.Duration(data.TTL) * time.Second
+		}
+		if quantity <= 0 || ttl <= 0 || ttl > MaxReservationTTL {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": "Invalid reservation, expected a positive quantity and a ttl of up to 24 hours"})
+		}
+		reservation := &models.Reservation{ProductID: product.ID, VariantID: data.VariantID, Quantity: quantity, ExpiresAt: now.Add(ttl), Reference: data.Reference}
+		if err := ctl.Inventory.Reserve(reservation, now); err != nil {
+			return inventoryError(c, err)
+		}
+		c.Status(fiber.StatusCreated)
+		return c.JSON(reservation)
+
+	case models.MovementRelease:
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Missing reservation_id"})
+	}
+	c.Status(fiber.StatusBadRequest)
+	return c.JSON(fiber.Map{"message": "Invalid type, expected receive, adjust, reserve, release or sell"})
+}
+
+// checkStockVariant verifies that the variant ID names a variant of the product, or is zero
+// for products without variants. It returns a message describing the problem.
+func (ctl *Controllers) checkStockVariant(product *models.Product, variantID uint) (string, error) {
+	variants, err := ctl.Variants.List(product.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(variants) == 0 {
+		if variantID != 0 {
+			return "Product has no variants", nil
+		}
+		return "", nil
+	}
+	for _, variant := range variants {
+		if variant.ID == variantID {
+			return "", nil
+		}
+	}
+	if variantID == 0 {
+		return "Missing variant_id, the stock of this product is kept per variant", nil
+	}
+	return "Unknown variant_id", nil
+}
+
+// inventoryError answers a failed inventory change with the response matching the repository error
+func inventoryError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrInsufficientStock):
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Insufficient stock"})
+	case errors.Is(err, repository.ErrReservationInactive):
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Reservation is no longer active"})
+	case errors.Is(err, repository.ErrInvalidMovement):
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid Quantity for this type of movement"})
+	}
+	c.Status(fiber.StatusInternalServerError)
+	return c.JSON(fiber.Map{"message": "failed to record inventory movement"})
+}
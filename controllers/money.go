@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultBaseCurrency is the currency exchange rates are relative to unless configured otherwise
+const DefaultBaseCurrency = "USD"
+
+// ExchangeRates is the exchange rate table together with the currency it is relative to
+type ExchangeRates struct {
+	Base  string                `json:"base"`
+	Rates []models.ExchangeRate `json:"rates"`
+}
+
+// rates loads the exchange rate table into a converter
+func (ctl *Controllers) rates() (*models.Rates, error) {
+	table, err := ctl.Rates.List()
+	if err != nil {
+		return nil, err
+	}
+	return models.NewRates(ctl.BaseCurrency, table)
+}
+
+// applyPrice validates the price and currency fields of a product request body and sets the
+// price and base price of the product. Prices are decimal numbers or strings with at most the
+// decimals of their currency; the currency defaults to the current one, or the base currency
+// for new products, and needs an exchange rate. It returns a message describing the first
+// invalid field.
+func (ctl *Controllers) applyPrice(product *models.Product, data map[string]interface{}) (string, error) {
+	value, hasPrice := data["price"]
+	currency := product.Price.Currency
+	if currency == "" {
+		currency = ctl.BaseCurrency
+	}
+	if raw, ok := data["currency"]; ok {
+		text, _ := raw.(string)
+		code, err := models.NormalizeCurrency(text)
+		if err != nil {
+			return "Invalid Currency, expected an ISO 4217 code", nil
+		}
+		if code != product.Price.Currency && !hasPrice {
+			return "Changing the Currency requires a Price", nil
+		}
+		currency = code
+	}
+	if !hasPrice {
+		return "", nil
+	}
+
+	rates, err := ctl.rates()
+	if err != nil {
+		return "", err
+	}
+	if !rates.Has(currency) {
+		return "No exchange rate for " + currency, nil
+	}
+	price, err := models.MoneyFromJSON(value, currency)
+	if err != nil || price.Amount <= 0 {
+		message := "Invalid or missing positive Price"
+		if err != nil && strings.Contains(err.Error(), "decimals") {
+			message = "Invalid Price, " + err.Error()
+		}
+		return message, nil
+	}
+	base, err := rates.Convert(price, rates.Base)
+	if err != nil {
+		return "", err
+	}
+	product.Price, product.BasePrice = price, base.Amount
+	return "", nil
+}
+
+// PriceView is the currency a client asked to see prices in, defaulting to the base currency
+type PriceView struct {
+	Rates    *models.Rates // Exchange rates, nil when no currency was asked for
+	Currency string        // Currency prices and price filters are given in
+}
+
+// displayCurrency reads the currency parameter clients use to see prices converted. It returns
+// a message describing an invalid currency.
+func (ctl *Controllers) displayCurrency(c *fiber.Ctx) (PriceView, string, error) {
+	view := PriceView{Currency: ctl.BaseCurrency}
+	value := c.Query("currency")
+	if value == "" {
+		return view, "", nil
+	}
+	currency, err := models.NormalizeCurrency(value)
+	if err != nil {
+		return view, "Invalid currency, expected an ISO 4217 code", nil
+	}
+	rates, err := ctl.rates()
+	if err != nil {
+		return view, "", err
+	}
+	if !rates.Has(currency) {
+		return view, "No exchange rate for " + currency, nil
+	}
+	return PriceView{Rates: rates, Currency: currency}, "", nil
+}
+
+// parse reads a decimal price in the display currency and converts it into minor units of
+// the base currency
+func (v PriceView) parse(text string) (models.Money, int64, error) {
+	price, err := models.ParseMoney(text, v.Currency)
+	if err != nil || v.Rates == nil {
+		return price, price.Amount, err
+	}
+	base, err := v.Rates.Convert(price, v.Rates.Base)
+	return price, base.Amount, err
+}
+
+// apply converts the price of a product into the display currency
+func (v PriceView) apply(product *models.Product) {
+	if v.Rates == nil {
+		return
+	}
+	if price, err := v.Rates.Convert(product.Price, v.Currency); err == nil {
+		product.DisplayPrice = &price
+	}
+}
+
+// ListExchangeRates returns the base currency and the exchange rates of all other currencies
+func (ctl *Controllers) ListExchangeRates(c *fiber.Ctx) error {
+	rates, err := ctl.Rates.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch exchange rates"})
+	}
+	return c.JSON(ExchangeRates{Base: ctl.BaseCurrency, Rates: rates})
+}
+
+// SetExchangeRate creates or replaces the rate of the ":currency" route parameter, the units of
+// that currency worth one unit of the base currency, given as a decimal string. The base
+// prices of products listed in the currency are recomputed, so listings filter and sort by
+// the new rate.
+func (ctl *Controllers) SetExchangeRate(c *fiber.Ctx) error {
+	currency, err := models.NormalizeCurrency(c.Params("currency"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid currency, expected an ISO 4217 code"})
+	}
+	if currency == ctl.BaseCurrency {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "The base currency has no exchange rate"})
+	}
+
+	var data struct {
+		Rate string `json:"rate"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid rate, expected a positive decimal string"})
+	}
+	if _, err := models.ParseRate(data.Rate); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid rate, expected a positive decimal string"})
+	}
+
+	rate := &models.ExchangeRate{Currency: currency, Rate: strings.TrimSpace(data.Rate)}
+	if err := ctl.Rates.Save(rate); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to save exchange rate"})
+	}
+	rates, err := ctl.rates()
+	if err == nil {
+		err = ctl.Products.Reprice(currency, rates)
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to reprice products"})
+	}
+	return c.JSON(rate)
+}
+
+// DeleteExchangeRate removes the rate of the ":currency" route parameter. Rates still used by
+// product prices cannot be removed.
+func (ctl *Controllers) DeleteExchangeRate(c *fiber.Ctx) error {
+	currency, err := models.NormalizeCurrency(c.Params("currency"))
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Exchange rate not found"})
+	}
+	count, err := ctl.Products.CountByCurrency(currency)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch products"})
+	}
+	if count > 0 {
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Exchange rate is used by product prices"})
+	}
+	if err := ctl.Rates.Delete(currency); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Exchange rate not found"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to delete exchange rate"})
+	}
+	return c.JSON(fiber.Map{"message": "Exchange rate deleted successfully"})
+}
@@ -0,0 +1,451 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/promotions"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultPaymentTTL is how long pending orders hold their stock while waiting for payment unless configured otherwise
+const DefaultPaymentTTL = 30 * time.Minute
+
+// Number of orders returned by a listing
+const (
+	defaultOrderLimit = 50
+	maxOrderLimit     = 500
+)
+
+// sellerStatuses are the states sellers may move the orders of their listings to. Payments
+// are confirmed by admins, or by the payment provider.
+var sellerStatuses = map[models.OrderStatus]bool{
+	models.OrderFulfilled: true,
+	models.OrderShipped:   true,
+	models.OrderDelivered: true,
+	models.OrderCancelled: true,
+	models.OrderRefunded:  true,
+}
+
+// Checkout places the cart of the authenticated user: one pending order per seller, holding
+// the stock of its lines until it is paid or expires. The currency parameter chooses the
+// currency the orders are priced in, the jurisdiction parameter where they are taxed, the
+// configured jurisdiction by default. Carts whose items changed since they were added have to
+// be reviewed first: the warnings are returned with 409 Conflict. The coupon parameter applies
+// a coupon on top of the running promotions; a coupon that cannot be used fails with 400 Bad
+// Request. The discounts are spread over the lines they were granted on and counted against
+// the usage caps of their promotions.
+func (ctl *Controllers) Checkout(c *fiber.Ctx) error {
+	pricing, status, message := ctl.requestPricing(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	cart, _, status, message := ctl.requestCart(c, false)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	if len(cart.Items) == 0 {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Cart is empty"})
+	}
+
+	// One checkout of a cart at a time, so a double submit cannot place its orders twice; the
+	// cart is read again as the checkout before may just have emptied it
+	now := time.Now()
+	cart, err := ctl.Carts.StartCheckout(cart.ID, now)
+	if errors.Is(err, repository.ErrCheckoutInProgress) {
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Cart is already being checked out"})
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch cart"})
+	}
+	defer func() {
+		if err := ctl.Carts.FinishCheckout(cart.ID); err != nil {
+			log.Printf("finish checkout of cart %d: %v", cart.ID, err)
+		}
+	}()
+	if len(cart.Items) == 0 {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Cart is empty"})
+	}
+	view, err := ctl.cartView(cart, pricing, c.Query("coupon"))
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to price cart"})
+	}
+	if len(view.Warnings) > 0 {
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Cart has changed, review the warnings", "warnings": view.Warnings})
+	}
+	if view.CouponError != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid coupon: " + view.CouponError})
+	}
+
+	orders, err := ctl.placeOrders(cart, pricing, view.discounts, now)
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientStock) {
+			c.Status(fiber.StatusConflict)
+			return c.JSON(fiber.Map{"message": "Insufficient stock"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to place order"})
+	}
+	if err := ctl.redeemPromotions(*cart.UserID, view.discounts, orders); err != nil {
+		// The orders were priced with a discount that is gone, so they are not kept
+		for _, order := range orders {
+			if _, err := ctl.transitionOrder(&order, models.OrderCancelled, nil, now); err != nil {
+				log.Printf("cancel order %d: %v", order.ID, err)
+			}
+		}
+		if errors.Is(err, repository.ErrPromotionExhausted) {
+			c.Status(fiber.StatusConflict)
+			return c.JSON(fiber.Map{"message": "Promotion is no longer available, review the cart"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to redeem promotions"})
+	}
+
+	// Orders the discounts cover in full have nothing to charge, so they are paid right away;
+	// any left pending can still be paid, without the provider, through PayOrder
+	for i := range orders {
+		if orders[i].Total.Amount > 0 {
+			continue
+		}
+		paid, err := ctl.transitionOrder(&orders[i], models.OrderPaid, cart.UserID, now)
+		if err != nil {
+			log.Printf("mark free order %d paid: %v", orders[i].ID, err)
+			continue
+		}
+		orders[i] = *paid
+	}
+	if err := ctl.Carts.Clear(cart.ID); err != nil {
+		log.Printf("clear cart %d after checkout: %v", cart.ID, err)
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(orders)
+}
+
+// placeOrders reserves the stock of every item of the cart and creates one pending order per
+// seller, priced in the currency of the pricing, reduced by the discounts of the cart items,
+// if any, and taxed in the jurisdiction of the pricing. When a reservation or an order fails,
+// the reservations made and the orders placed so far are undone.
+func (ctl *Controllers) placeOrders(cart *models.Cart, pricing cartPricing, discounts *promotions.Result, now time.Time) ([]models.Order, error) {
+	rates, err := ctl.rates()
+	if err != nil {
+		return nil, err
+	}
+	table, err := ctl.taxTable()
+	if err != nil {
+		return nil, err
+	}
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		return nil, err
+	}
+	currency := pricing.currency
+	expiresAt := now.Add(ctl.PaymentTTL)
+	var reservations []uint
+	orders := []models.Order{}
+	undo := func() {
+		for _, id := range reservations {
+			if _, err := ctl.Inventory.Release(id, nil, now); err != nil && !errors.Is(err, repository.ErrReservationInactive) {
+				log.Printf("release reservation %d: %v", id, err)
+			}
+		}
+		for _, order := range orders {
+			if _, err := ctl.Orders.Transition(order.ID, models.OrderCancelled, nil, now); err != nil {
+				log.Printf("cancel order %d: %v", order.ID, err)
+			}
+		}
+	}
+
+	// Price and reserve every item, grouping the lines by seller
+	bySeller := make(map[uint]*models.Order)
+	itemIDs := make(map[uint][]uint)
+	for _, item := range cart.Items {
+		line, sellerID, err := ctl.orderLine(item, rates, currency)
+		if err == nil {
+			line.TaxClass, err = ctl.taxClass(item.ProductID, categories)
+		}
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		reservation := &models.Reservation{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			ExpiresAt: expiresAt,
+			Reference: fmt.Sprintf("cart-%d", cart.ID),
+		}
+		if err := ctl.Inventory.Reserve(reservation, now); err != nil {
+			undo()
+			return nil, err
+		}
+		reservations = append(reservations, reservation.ID)
+		line.ReservationID = &reservation.ID
+		line.Discount = models.Money{Currency: currency}
+		if discounts != nil {
+			line.Discount.Amount = discounts.Line(item.ID).Amount
+		}
+
+		key := uint(0)
+		if sellerID != nil {
+			key = *sellerID
+		}
+		order, ok := bySeller[key]
+		if !ok {
+			order = &models.Order{
+				BuyerID:   *cart.UserID,
+				SellerID:  sellerID,
+				Subtotal:  models.Money{Currency: currency},
+				Discount:  models.Money{Currency: currency},
+				ExpiresAt: &expiresAt,
+			}
+			bySeller[key] = order
+		}
+		order.Lines = append(order.Lines, *line)
+		itemIDs[key] = append(itemIDs[key], item.ID)
+		order.Subtotal.Amount += line.Total.Amount
+		order.Discount.Amount += line.Discount.Amount
+	}
+
+	// Place the orders in the order of the sellers' IDs
+	keys := make([]uint, 0, len(bySeller))
+	for key := range bySeller {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		order := bySeller[key]
+		if err := ctl.taxOrder(table, order, itemIDs[key], pricing.jurisdiction); err != nil {
+			undo()
+			return nil, err
+		}
+		if err := ctl.Orders.Create(order); err != nil {
+			undo()
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	return orders, nil
+}
+
+// orderLine prices a cart item at its current price, converted into the currency of the
+// order, and returns it with the seller of its product
+func (ctl *Controllers) orderLine(item models.CartItem, rates *models.Rates, currency string) (*models.OrderLine, *uint, error) {
+	current, err := ctl.lookupCartProduct(item.ProductID, item.VariantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	unit, err := rates.Convert(current.price, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	productID := item.ProductID
+	line := &models.OrderLine{
+		ProductID: &productID,
+		VariantID: item.VariantID,
+		Name:      current.product.Name,
+		SKU:       current.sku,
+		Quantity:  item.Quantity,
+		UnitPrice: unit,
+		Total:     unit.Mul(int64(item.Quantity)),
+	}
+	return line, current.product.SellerID, nil
+}
+
+// orderAccess tells how the token holder relates to an order: as its buyer, as its seller or
+// as an admin managing all orders
+func orderAccess(claims *Claims, order *models.Order) (buyer, seller, admin bool) {
+	userID := claims.UserID()
+	buyer = order.BuyerID == userID
+	seller = order.SellerID != nil && *order.SellerID == userID && Allowed(claims.Roles, PermFulfillOrders)
+	admin = Allowed(claims.Roles, PermManageOrders)
+	return buyer, seller, admin
+}
+
+// requestOrder authenticates the request and looks up the order of the ":id" route parameter,
+// which only its buyer, its seller and admins may see. Others get 404 Not Found, so that order
+// IDs do not leak. On failure it returns the status and message to answer with.
+func (ctl *Controllers) requestOrder(c *fiber.Ctx) (*models.Order, *Claims, int, string) {
+	token, err := ctl.authentication(c)
+	if err != nil {
+		return nil, nil, fiber.StatusUnauthorized, "unauthenticated"
+	}
+	claims := token.Claims.(*Claims)
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, nil, fiber.StatusNotFound, "Order not found"
+	}
+	order, err := ctl.Orders.Get(uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, fiber.StatusNotFound, "Order not found"
+	}
+	if err != nil {
+		return nil, nil, fiber.StatusInternalServerError, "failed to fetch order"
+	}
+	if buyer, seller, admin := orderAccess(claims, order); !buyer && !seller && !admin {
+		return nil, nil, fiber.StatusNotFound, "Order not found"
+	}
+	return order, claims, 0, ""
+}
+
+// ListOrders returns the orders of the authenticated user, newest first. By default these are
+// the orders the user placed; with as=seller the orders of the user's listings, and for admins
+// with as=all every order. The status parameter filters by state and limit caps the number of
+// orders.
+func (ctl *Controllers) ListOrders(c *fiber.Ctx) error {
+	token, err := ctl.authentication(c)
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "unauthenticated"})
+	}
+	claims := token.Claims.(*Claims)
+	userID := claims.UserID()
+
+	q := repository.OrderQuery{Status: models.OrderStatus(c.Query("status")), Limit: c.QueryInt("limit", defaultOrderLimit)}
+	if q.Status != "" && !q.Status.IsValid() {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid status"})
+	}
+	if q.Limit < 1 || q.Limit > maxOrderLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid limit"})
+	}
+	switch c.Query("as", "buyer") {
+	case "buyer":
+		q.BuyerID = &userID
+	case "seller":
+		if !Allowed(claims.Roles, PermFulfillOrders) {
+			c.Status(fiber.StatusForbidden)
+			return c.JSON(fiber.Map{"message": "forbidden"})
+		}
+		q.SellerID = &userID
+	case "all":
+		if !Allowed(claims.Roles, PermManageOrders) {
+			c.Status(fiber.StatusForbidden)
+			return c.JSON(fiber.Map{"message": "forbidden"})
+		}
+	default:
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid as, expected buyer, seller or all"})
+	}
+
+	orders, err := ctl.Orders.List(q)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch orders"})
+	}
+	return c.JSON(orders)
+}
+
+// GetOrder returns an order with its lines to its buyer, its seller or an admin
+func (ctl *Controllers) GetOrder(c *fiber.Ctx) error {
+	order, _, status, message := ctl.requestOrder(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	return c.JSON(order)
+}
+
+// UpdateOrderStatus moves an order to the status in the body. Only transitions of the order
+// lifecycle are allowed: pending orders are paid or cancelled, paid orders are fulfilled,
+// shipped and delivered, and paid orders may be refunded at any later point. Buyers may
+// cancel their pending orders, sellers may move the orders of their listings along except for
+// confirming payments, and admins may make any transition. Refunds return the captured
+// payments of the order and cancellations void the authorized ones.
+func (ctl *Controllers) UpdateOrderStatus(c *fiber.Ctx) error {
+	order, claims, status, message := ctl.requestOrder(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	var data struct {
+		Status models.OrderStatus `json:"status"`
+	}
+	if err := c.BodyParser(&data); err != nil || !data.Status.IsValid() {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid status"})
+	}
+
+	buyer, seller, admin := orderAccess(claims, order)
+	allowed := admin || (seller && sellerStatuses[data.Status]) || (buyer && data.Status == models.OrderCancelled)
+	if !allowed {
+		c.Status(fiber.StatusForbidden)
+		return c.JSON(fiber.Map{"message": "forbidden"})
+	}
+
+	// Return the money before the order says so
+	if !order.Status.CanTransition(data.Status) {
+		return orderError(c, order, data.Status, repository.ErrInvalidTransition)
+	}
+	if err := ctl.settlePayments(c.Context(), order, data.Status); err != nil {
+		log.Printf("settle payments of order %d: %v", order.ID, err)
+		c.Status(fiber.StatusBadGateway)
+		return c.JSON(fiber.Map{"message": "Payment provider error"})
+	}
+	userID := claims.UserID()
+	updated, err := ctl.transitionOrder(order, data.Status, &userID, time.Now())
+	if err != nil {
+		return orderError(c, order, data.Status, err)
+	}
+	return c.JSON(updated)
+}
+
+// transitionOrder moves an order to another state together with the stock of its lines:
+// payments sell the reserved units, cancellations release them. Refunds leave the stock
+// alone; returned units are received like any other delivery.
+func (ctl *Controllers) transitionOrder(order *models.Order, to models.OrderStatus, userID *uint, now time.Time) (*models.Order, error) {
+	if !order.Status.CanTransition(to) {
+		return nil, repository.ErrInvalidTransition
+	}
+	return ctl.Orders.Transition(order.ID, to, userID, now)
+}
+
+// orderError answers a failed order transition with the response matching the error
+func orderError(c *fiber.Ctx, order *models.Order, to models.OrderStatus, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrInvalidTransition):
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": fmt.Sprintf("Cannot move a %s order to %s", order.Status, to)})
+	case errors.Is(err, repository.ErrReservationInactive):
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Order has expired, its stock was released"})
+	}
+	c.Status(fiber.StatusInternalServerError)
+	return c.JSON(fiber.Map{"message": "failed to update order"})
+}
+
+// ExpireOrders cancels the pending orders that were not paid in time, releasing their stock and
+// voiding their unfinished payments. It is run periodically by a background job.
+func (ctl *Controllers) ExpireOrders(now time.Time) error {
+	orders, err := ctl.Orders.Expired(now)
+	if err != nil {
+		return err
+	}
+	for i := range orders {
+		// One failing order must not hold up the others
+		if err := ctl.settlePayments(context.Background(), &orders[i], models.OrderCancelled); err != nil {
+			log.Printf("expire order %d: %v", orders[i].ID, err)
+			continue
+		}
+		if _, err := ctl.transitionOrder(&orders[i], models.OrderCancelled, nil, now); err != nil && !errors.Is(err, repository.ErrInvalidTransition) {
+			log.Printf("expire order %d: %v", orders[i].ID, err)
+		}
+	}
+	return nil
+}
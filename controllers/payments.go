@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/payments"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// errPaymentProvider wraps the errors of the payment provider, which are answered with 502 Bad Gateway
+var errPaymentProvider = errors.New("payment provider error")
+
+// providerError marks an error as coming from the payment provider
+func providerError(err error) error {
+	return fmt.Errorf("%w: %v", errPaymentProvider, err)
+}
+
+// PayOrder pays a pending order with the payment method in the body, such as a card token
+// collected by the client. Authorized payments are captured right away and the order is paid.
+// Declined payments are answered with 402 Payment Required; the buyer may try again. Payments
+// that need the buyer to complete a challenge are answered with 202 Accepted and the action
+// URL to send the buyer to; the provider reports the outcome with a webhook callback. Orders
+// the discounts cover in full are paid without a payment method.
+func (ctl *Controllers) PayOrder(c *fiber.Ctx) error {
+	order, claims, status, message := ctl.requestOrder(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	if buyer, _, _ := orderAccess(claims, order); !buyer {
+		c.Status(fiber.StatusForbidden)
+		return c.JSON(fiber.Map{"message": "forbidden"})
+	}
+	now := time.Now()
+	if order.Status != models.OrderPending {
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Order is not awaiting payment"})
+	}
+	if order.ExpiresAt != nil && !now.Before(*order.ExpiresAt) {
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Order has expired, its stock was released"})
+	}
+	userID := claims.UserID()
+
+	// Providers refuse to charge nothing, so orders discounted to zero are paid without them
+	if order.Total.Amount <= 0 {
+		paid, err := ctl.transitionOrder(order, models.OrderPaid, &userID, now)
+		if err != nil {
+			return orderError(c, order, models.OrderPaid, err)
+		}
+		return c.JSON(fiber.Map{"order": paid})
+	}
+	if ctl.PaymentProvider == nil {
+		c.Status(fiber.StatusServiceUnavailable)
+		return c.JSON(fiber.Map{"message": "Payments are not available"})
+	}
+	var data struct {
+		PaymentMethod string `json:"payment_method"`
+	}
+	if err := c.BodyParser(&data); err != nil || data.PaymentMethod == "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid payment_method"})
+	}
+
+	result, err := ctl.PaymentProvider.Authorize(c.Context(), payments.AuthorizeRequest{
+		Amount:      order.Total,
+		Method:      data.PaymentMethod,
+		Description: fmt.Sprintf("Order %d", order.ID),
+	})
+	if err != nil {
+		log.Printf("authorize payment of order %d: %v", order.ID, err)
+		c.Status(fiber.StatusBadGateway)
+		return c.JSON(fiber.Map{"message": "Payment provider error"})
+	}
+	payment := &models.Payment{
+		OrderID:       order.ID,
+		Provider:      ctl.PaymentProvider.Name(),
+		Reference:     result.Reference,
+		Status:        result.Status,
+		Amount:        result.Amount,
+		ActionURL:     result.ActionURL,
+		FailureReason: result.FailureReason,
+	}
+	if err := ctl.Payments.Create(payment); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to save payment"})
+	}
+
+	switch payment.Status {
+	case models.PaymentDeclined:
+		c.Status(fiber.StatusPaymentRequired)
+		return c.JSON(fiber.Map{"message": "Payment declined", "payment": payment})
+	case models.PaymentRequiresAction:
+		c.Status(fiber.StatusAccepted)
+		return c.JSON(fiber.Map{"message": "Payment requires action", "payment": payment})
+	}
+	paid, payment, err := ctl.completePayment(c.Context(), payment, &userID, now)
+	if err != nil {
+		if errors.Is(err, errPaymentProvider) {
+			c.Status(fiber.StatusBadGateway)
+			return c.JSON(fiber.Map{"message": "Payment provider error"})
+		}
+		return orderError(c, order, models.OrderPaid, err)
+	}
+	return c.JSON(fiber.Map{"order": paid, "payment": payment})
+}
+
+// completePayment captures an authorized payment and marks its order paid. When the order can
+// no longer be paid, because it expired or was paid or cancelled meanwhile, the authorization
+// is voided, or the captured amount refunded, and the order's error is returned.
+func (ctl *Controllers) completePayment(ctx context.Context, payment *models.Payment, userID *uint, now time.Time) (*models.Order, *models.Payment, error) {
+	order, err := ctl.Orders.Get(payment.OrderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !order.Status.CanTransition(models.OrderPaid) {
+		return nil, nil, ctl.releasePayment(ctx, payment, repository.ErrInvalidTransition)
+	}
+	if order.ExpiresAt != nil && !now.Before(*order.ExpiresAt) {
+		return nil, nil, ctl.releasePayment(ctx, payment, repository.ErrReservationInactive)
+	}
+
+	if _, err := ctl.PaymentProvider.Capture(ctx, payment.Reference); err != nil {
+		return nil, nil, providerError(err)
+	}
+	payment, err = ctl.Payments.Transition(payment.ID, models.PaymentAuthorized, models.PaymentCaptured, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	paid, err := ctl.transitionOrder(order, models.OrderPaid, userID, now)
+	if err != nil {
+		return nil, nil, ctl.releasePayment(ctx, payment, err)
+	}
+	return paid, payment, nil
+}
+
+// releasePayment gives the money of a payment that cannot pay its order back: authorizations
+// are voided, captured amounts refunded. It returns the reason the order could not be paid.
+func (ctl *Controllers) releasePayment(ctx context.Context, payment *models.Payment, reason error) error {
+	var err error
+	switch payment.Status {
+	case models.PaymentAuthorized:
+		if _, err = ctl.PaymentProvider.Void(ctx, payment.Reference); err == nil {
+			_, err = ctl.Payments.Transition(payment.ID, models.PaymentAuthorized, models.PaymentVoided, "")
+		}
+	case models.PaymentCaptured:
+		if _, err = ctl.PaymentProvider.Refund(ctx, payment.Reference, payment.Amount); err == nil {
+			_, err = ctl.Payments.Transition(payment.ID, models.PaymentCaptured, models.PaymentRefunded, "")
+		}
+	}
+	if err != nil {
+		log.Printf("release payment %d of order %d: %v", payment.ID, payment.OrderID, err)
+	}
+	return reason
+}
+
+// settlePayments settles the payments of an order moving to another state: refunds return
+// the captured payments, cancellations void the authorized ones and those waiting for their
+// challenge. Payments the provider already settled otherwise are left alone.
+func (ctl *Controllers) settlePayments(ctx context.Context, order *models.Order, to models.OrderStatus) error {
+	if ctl.PaymentProvider == nil || (to != models.OrderRefunded && to != models.OrderCancelled) {
+		return nil
+	}
+	orderPayments, err := ctl.Payments.ForOrder(order.ID)
+	if err != nil {
+		return err
+	}
+	for _, payment := range orderPayments {
+		if payment.Provider != ctl.PaymentProvider.Name() {
+			continue
+		}
+		var settled models.PaymentStatus
+		switch {
+		case to == models.OrderRefunded && payment.Status == models.PaymentCaptured:
+			_, err = ctl.PaymentProvider.Refund(ctx, payment.Reference, payment.Amount)
+			settled = models.PaymentRefunded
+		case to == models.OrderCancelled && (payment.Status == models.PaymentAuthorized || payment.Status == models.PaymentRequiresAction):
+			_, err = ctl.PaymentProvider.Void(ctx, payment.Reference)
+			settled = models.PaymentVoided
+		default:
+			continue
+		}
+		if errors.Is(err, payments.ErrInvalidState) {
+			continue
+		}
+		if err != nil {
+			return providerError(err)
+		}
+		_, err = ctl.Payments.Transition(payment.ID, payment.Status, settled, "")
+		if err != nil && !errors.Is(err, repository.ErrPaymentChanged) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PaymentWebhook receives the signed callbacks of the payment provider. Authorized payments
+// are captured and pay their order, failed challenges are recorded so the buyer may try
+// again, and refunds made at the provider refund the order. Events are applied at most once:
+// redelivered events find their payment already moved on and are acknowledged. Callbacks
+// about unknown payments are acknowledged too, so the provider stops retrying them.
+func (ctl *Controllers) PaymentWebhook(c *fiber.Ctx) error {
+	if ctl.PaymentProvider == nil {
+		c.Status(fiber.StatusServiceUnavailable)
+		return c.JSON(fiber.Map{"message": "Payments are not available"})
+	}
+	now := time.Now()
+	event, err := payments.ParseEvent(ctl.WebhookSecret, c.Body(), c.Get(payments.SignatureHeader), now)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "Invalid signature"})
+	}
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid event"})
+	}
+	payment, err := ctl.Payments.ByReference(ctl.PaymentProvider.Name(), event.Payment.Reference)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.JSON(fiber.Map{"message": "Unknown payment, ignored"})
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch payment"})
+	}
+
+	if err := ctl.applyPaymentEvent(c.Context(), event, payment, now); err != nil {
+		log.Printf("payment webhook %s for %s: %v", event.ID, payment.Reference, err)
+		// Failures other than the order refusing the payment are retried by the provider
+		if !errors.Is(err, repository.ErrInvalidTransition) && !errors.Is(err, repository.ErrReservationInactive) {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to process event"})
+		}
+	}
+	return c.JSON(fiber.Map{"message": "Event processed"})
+}
+
+// applyPaymentEvent moves a payment, and its order, on as reported by a webhook event
+func (ctl *Controllers) applyPaymentEvent(ctx context.Context, event *payments.Event, payment *models.Payment, now time.Time) error {
+	var err error
+	switch event.Type {
+	case payments.EventAuthorized:
+		if payment.Status == models.PaymentRequiresAction {
+			payment, err = ctl.Payments.Transition(payment.ID, models.PaymentRequiresAction, models.PaymentAuthorized, "")
+		}
+		// Payments still authorized were not captured by an earlier delivery that failed
+		if err == nil && payment.Status == models.PaymentAuthorized {
+			_, _, err = ctl.completePayment(ctx, payment, nil, now)
+		}
+	case payments.EventFailed:
+		_, err = ctl.Payments.Transition(payment.ID, models.PaymentRequiresAction, models.PaymentFailed, event.Payment.FailureReason)
+	case payments.EventCaptured:
+		if payment, err = ctl.Payments.Transition(payment.ID, models.PaymentAuthorized, models.PaymentCaptured, ""); err == nil {
+			err = ctl.paymentOrder(payment, models.OrderPaid, now)
+		}
+	case payments.EventRefunded:
+		if payment, err = ctl.Payments.Transition(payment.ID, models.PaymentCaptured, models.PaymentRefunded, ""); err == nil {
+			err = ctl.paymentOrder(payment, models.OrderRefunded, now)
+		}
+	}
+	if errors.Is(err, repository.ErrPaymentChanged) {
+		return nil
+	}
+	return err
+}
+
+// paymentOrder moves the order of a payment to another state, unless it is past that point
+func (ctl *Controllers) paymentOrder(payment *models.Payment, to models.OrderStatus, now time.Time) error {
+	order, err := ctl.Orders.Get(payment.OrderID)
+	if err != nil {
+		return err
+	}
+	if !order.Status.CanTransition(to) {
+		return nil
+	}
+	_, err = ctl.transitionOrder(order, to, nil, now)
+	return err
+}
+
+// CompleteFakePayment resolves the challenge of a payment of the fake provider, standing in
+// for the page of the card issuer the buyer is sent to. The approve field of the body, true by
+// default, chooses the outcome, which the fake provider reports with a webhook callback.
+func (ctl *Controllers) CompleteFakePayment(c *fiber.Ctx) error {
+	fake, ok := ctl.PaymentProvider.(*payments.Fake)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Not Found"})
+	}
+	data := struct {
+		Approve bool `json:"approve"`
+	}{Approve: true}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&data); err != nil {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": "Invalid approve"})
+		}
+	}
+
+	result, err := fake.Complete(c.Context(), c.Params("reference"), data.Approve)
+	switch {
+	case errors.Is(err, payments.ErrUnknownPayment):
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Payment not found"})
+	case errors.Is(err, payments.ErrInvalidState):
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Payment does not require action"})
+	case err != nil:
+		log.Printf("deliver fake payment webhook: %v", err)
+		c.Status(fiber.StatusBadGateway)
+		return c.JSON(fiber.Map{"message": "Webhook delivery failed", "payment": result})
+	}
+	return c.JSON(result)
+}
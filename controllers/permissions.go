@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permission names an operation guarded by role-based access control
+type Permission string
+
+// Permissions checked by the routes
+const (
+	PermReadProducts     Permission = "products:read"     // List and view products
+	PermCreateProducts   Permission = "products:create"   // Add new products
+	PermUpdateProducts   Permission = "products:update"   // Edit own products
+	PermDeleteProducts   Permission = "products:delete"   // Delete own products
+	PermManageProducts   Permission = "products:manage"   // Edit and delete products of any seller
+	PermPurgeProducts    Permission = "products:purge"    // Permanently delete products
+	PermManageRoles      Permission = "roles:manage"      // Grant and revoke roles
+	PermManageCategories Permission = "categories:manage" // Create, edit, move and delete categories
+	PermManageRates      Permission = "rates:manage"      // Maintain the exchange rate table
+	PermPlaceOrders      Permission = "orders:place"      // Check out the cart
+	PermFulfillOrders    Permission = "orders:fulfill"    // Fulfill, ship and refund orders of own listings
+	PermManageOrders     Permission = "orders:manage"     // See and change the orders of every buyer and seller
+	PermManagePromotions Permission = "promotions:manage" // Create, edit and delete promotions and coupons
+	PermManageTaxes      Permission = "taxes:manage"      // Maintain the tax rate table
+)
+
+// rolePermissions lists what each role may do
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin:  {PermReadProducts, PermCreateProducts, PermUpdateProducts, PermDeleteProducts, PermManageProducts, PermPurgeProducts, PermManageRoles, PermManageCategories, PermManageRates, PermPlaceOrders, PermFulfillOrders, PermManageOrders, PermManagePromotions, PermManageTaxes},
+	models.RoleSeller: {PermReadProducts, PermCreateProducts, PermUpdateProducts, PermDeleteProducts, PermFulfillOrders},
+	models.RoleBuyer:  {PermReadProducts, PermPlaceOrders},
+}
+
+// Allowed reports whether any of the roles grants the permission
+func Allowed(roles models.Roles, perm Permission) bool {
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Require returns a middleware that only lets requests through whose token grants all of perms.
+// It answers 401 for missing or invalid tokens and 403 for insufficient roles.
+func (ctl *Controllers) Require(perms ...Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Authenticate the request
+		token, err := ctl.authentication(c)
+
+		// Handle authentication errors
+		if err != nil {
+			c.Status(fiber.StatusUnauthorized)
+			return c.JSON(fiber.Map{
+				"message": "unauthenticated",
+			})
+		}
+
+		// Check the roles embedded in the token
+		claims := token.Claims.(*Claims)
+		for _, perm := range perms {
+			if !Allowed(claims.Roles, perm) {
+				c.Status(fiber.StatusForbidden)
+				return c.JSON(fiber.Map{
+					"message": "forbidden",
+				})
+			}
+		}
+		return c.Next()
+	}
+}
+
+// canModify reports whether the token holder may edit or delete the product:
+// sellers only their own listings, admins any listing
+func canModify(claims *Claims, product *models.Product) bool {
+	if Allowed(claims.Roles, PermManageProducts) {
+		return true
+	}
+	return product.SellerID != nil && *product.SellerID == claims.UserID()
+}
+
+// GrantRole adds a role to a user. The user receives it in the next token issued at login or refresh.
+func (ctl *Controllers) GrantRole(c *fiber.Ctx) error {
+	var data map[string]string
+
+	// Parse request body into a map
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	return ctl.changeRole(c, data["role"], true)
+}
+
+// RevokeRole removes a role from a user. Tokens already issued keep it until they expire.
+func (ctl *Controllers) RevokeRole(c *fiber.Ctx) error {
+	return ctl.changeRole(c, c.Params("role"), false)
+}
+
+// changeRole grants or revokes a role of the user named by the ":id" route parameter
+func (ctl *Controllers) changeRole(c *fiber.Ctx, role string, grant bool) error {
+	// Validate the role
+	if !models.IsValidRole(role) {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid role"})
+	}
+
+	// Find the user
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	user, err := ctl.Users.Get(uint(id))
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "User not found"})
+	}
+
+	// Admins cannot lock themselves out
+	token, _ := ctl.authentication(c)
+	if !grant && role == models.RoleAdmin && user.ID == token.Claims.(*Claims).UserID() {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "cannot revoke your own admin role"})
+	}
+
+	// Save the new roles
+	if grant {
+		user.Roles = user.Roles.With(role)
+	} else {
+		user.Roles = user.Roles.Without(role)
+	}
+	if err := ctl.Users.SetRoles(user.ID, user.Roles); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "User not found"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to update roles"})
+	}
+	return c.JSON(fiber.Map{"id": user.ID, "roles": user.Roles})
+}
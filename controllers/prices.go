@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Number of price changes returned with the price history of a product
+const (
+	defaultPriceHistoryLimit = 50
+	maxPriceHistoryLimit     = 500
+)
+
+// PriceHistory is the current price of a product and its latest changes
+type PriceHistory struct {
+	Price   models.Money         `json:"price"`
+	Changes []models.PriceChange `json:"changes"` // Newest first
+}
+
+// GetPriceHistory returns the current price of a product and its latest price changes, made by
+// hand or by schedules. The limit parameter caps the number of changes.
+func (ctl *Controllers) GetPriceHistory(c *fiber.Ctx) error {
+	product, ok := ctl.variantProduct(c)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	limit := c.QueryInt("limit", defaultPriceHistoryLimit)
+	if limit < 1 || limit > maxPriceHistoryLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid limit"})
+	}
+	changes, err := ctl.Prices.History(product.ID, limit)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch price history"})
+	}
+	return c.JSON(PriceHistory{Price: product.Price, Changes: changes})
+}
+
+// ListPriceSchedules returns the scheduled price changes and sales of a product, including
+// finished and cancelled ones. Only the seller of the product and admins may see them.
+func (ctl *Controllers) ListPriceSchedules(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	schedules, err := ctl.Prices.Schedules(product.ID)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch price schedules"})
+	}
+	return c.JSON(schedules)
+}
+
+// CreatePriceSchedule schedules a price change of a product. The body takes the price, in the
+// currency of the product, and the future starts_at time it takes effect. With an ends_at
+// time the change is a sale: the price before the sale is restored when it ends. Sales of a
+// product cannot overlap.
+func (ctl *Controllers) CreatePriceSchedule(c *fiber.Ctx) error {
+	product, claims, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	price, err := models.MoneyFromJSON(data["price"], product.Price.Currency)
+	if err != nil || price.Amount <= 0 {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid Price, expected a positive amount in " + product.Price.Currency})
+	}
+	now := time.Now()
+	text, _ := data["starts_at"].(string)
+	startsAt, err := parseTime(text)
+	if err != nil || !startsAt.After(now) {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid starts_at, expected a future date or RFC 3339 timestamp"})
+	}
+	userID := claims.UserID()
+	schedule := &models.PriceSchedule{ProductID: product.ID, Price: price, StartsAt: startsAt, UserID: &userID}
+	if value, ok := data["ends_at"]; ok && value != nil {
+		text, _ := value.(string)
+		endsAt, err := parseTime(text)
+		if err != nil || !endsAt.After(startsAt) {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": "Invalid ends_at, expected a time after starts_at"})
+		}
+		schedule.EndsAt = &endsAt
+	}
+
+	if err := ctl.Prices.CreateSchedule(schedule); err != nil {
+		if errors.Is(err, repository.ErrScheduleConflict) {
+			c.Status(fiber.StatusConflict)
+			return c.JSON(fiber.Map{"message": "Sale overlaps another sale of this product"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to schedule price change"})
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(schedule)
+}
+
+// CancelPriceSchedule cancels a pending price change. Cancelling a running sale ends it right
+// away and restores the price before it.
+func (ctl *Controllers) CancelPriceSchedule(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	schedule, err := ctl.productSchedule(c, product.ID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Price schedule not found"})
+	}
+
+	now := time.Now()
+	schedule, err = ctl.Prices.CancelSchedule(schedule.ID, now)
+	if err != nil {
+		if errors.Is(err, repository.ErrScheduleInactive) {
+			c.Status(fiber.StatusConflict)
+			return c.JSON(fiber.Map{"message": "Price schedule is already finished"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to cancel price schedule"})
+	}
+	if schedule.Status == models.ScheduleActive {
+		if err := ctl.applySchedule(schedule.ID, now); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to end sale"})
+		}
+		if schedule, err = ctl.Prices.Schedule(schedule.ID); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+			return c.JSON(fiber.Map{"message": "failed to fetch price schedule"})
+		}
+	}
+	return c.JSON(schedule)
+}
+
+// productSchedule looks up the schedule of the ":scheduleId" route parameter, which has to belong to the product
+func (ctl *Controllers) productSchedule(c *fiber.Ctx, productID uint) (*models.PriceSchedule, error) {
+	id, err := strconv.ParseUint(c.Params("scheduleId"), 10, 64)
+	if err != nil {
+		return nil, repository.ErrNotFound
+	}
+	schedule, err := ctl.Prices.Schedule(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if schedule.ProductID != productID {
+		return nil, repository.ErrNotFound
+	}
+	return schedule, nil
+}
+
+// ApplyPriceSchedules applies the scheduled price changes that have started and restores the
+// prices of the sales that have ended. It is run periodically by a background job.
+func (ctl *Controllers) ApplyPriceSchedules(now time.Time) error {
+	due, err := ctl.Prices.Due(now)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range due {
+		// One failing schedule must not hold up the others
+		if err := ctl.applySchedule(schedule.ID, now); err != nil {
+			log.Printf("price schedule %d: %v", schedule.ID, err)
+		}
+	}
+	return nil
+}
+
+// applySchedule applies a due schedule with the current exchange rates
+func (ctl *Controllers) applySchedule(id uint, now time.Time) error {
+	rates, err := ctl.rates()
+	if err != nil {
+		return err
+	}
+	_, err = ctl.Prices.Apply(id, rates, now)
+	return err
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderTotalCount carries the number of products matching a listing across all pages
+const HeaderTotalCount = "X-Total-Count"
+
+// parseTime accepts RFC 3339 timestamps as well as plain dates
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseProductQuery reads the filter, sort and pagination parameters of a product listing:
+// min_price, max_price, name, created_after, created_before, sort, limit, offset and cursor.
+// Prices are decimals in the currency of the view.
+func parseProductQuery(c *fiber.Ctx, view PriceView) (repository.ProductQuery, error) {
+	var q repository.ProductQuery
+
+	for _, p := range []struct {
+		name   string
+		target **int64
+	}{{"min_price", &q.MinPrice}, {"max_price", &q.MaxPrice}} {
+		if value := c.Query(p.name); value != "" {
+			_, base, err := view.parse(value)
+			if err != nil {
+				return q, fmt.Errorf("Invalid %s, expected a decimal amount in %s", p.name, view.Currency)
+			}
+			*p.target = &base
+		}
+	}
+
+	for _, p := range []struct {
+		name   string
+		target **time.Time
+	}{{"created_after", &q.CreatedAfter}, {"created_before", &q.CreatedBefore}} {
+		if value := c.Query(p.name); value != "" {
+			t, err := parseTime(value)
+			if err != nil {
+				return q, fmt.Errorf("Invalid %s, expected a date or an RFC 3339 timestamp", p.name)
+			}
+			*p.target = &t
+		}
+	}
+
+	q.Name = strings.TrimSpace(c.Query("name"))
+
+	sort, err := repository.ParseProductSort(c.Query("sort"))
+	if err != nil {
+		return q, errors.New("Invalid sort, expected price, created_at or name, optionally prefixed with -")
+	}
+	q.Sort = sort
+
+	if q.Limit, q.Offset, err = parseLimitOffset(c); err != nil {
+		return q, err
+	}
+	if value := c.Query("cursor"); value != "" {
+		if c.Query("offset") != "" {
+			return q, errors.New("Use either offset or cursor, not both")
+		}
+		cursor, err := repository.DecodeCursor(value)
+		if err != nil {
+			return q, errors.New("Invalid cursor")
+		}
+		q.Cursor = cursor
+	}
+	return q, nil
+}
+
+// setPaginationHeaders adds the total count and the next/prev links of a listing page.
+// Offset pagination links to neighbouring offsets, otherwise opaque cursors are used.
+func setPaginationHeaders(c *fiber.Ctx, q repository.ProductQuery, page *repository.ProductPage) {
+	if c.Query("offset") != "" {
+		setOffsetHeaders(c, q.Limit, q.Offset, page.Total)
+		return
+	}
+
+	c.Set(HeaderTotalCount, strconv.FormatInt(page.Total, 10))
+	var links []string
+	if page.NextCursor != "" {
+		links = append(links, pageLink(c, "next", "cursor", page.NextCursor))
+	}
+	if page.PrevCursor != "" {
+		links = append(links, pageLink(c, "prev", "cursor", page.PrevCursor))
+	}
+	if len(links) > 0 {
+		c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+	}
+}
+
+// setOffsetHeaders adds the total count and links to the neighbouring offsets of a page
+func setOffsetHeaders(c *fiber.Ctx, limit, offset int, total int64) {
+	c.Set(HeaderTotalCount, strconv.FormatInt(total, 10))
+	if limit == 0 {
+		limit = repository.DefaultPageSize
+	}
+
+	var links []string
+	if int64(offset+limit) < total {
+		links = append(links, pageLink(c, "next", "offset", strconv.Itoa(offset+limit)))
+	}
+	if offset > 0 {
+		links = append(links, pageLink(c, "prev", "offset", strconv.Itoa(max(offset-limit, 0))))
+	}
+	if len(links) > 0 {
+		c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+	}
+}
+
+// pageLink rebuilds the request URL with one parameter replaced, as a Link header entry
+func pageLink(c *fiber.Ctx, rel, param, value string) string {
+	values := url.Values{}
+	for k, v := range c.Queries() {
+		values.Set(k, v)
+	}
+	values.Set(param, value)
+	return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, c.BaseURL(), c.Path(), values.Encode(), rel)
+}
+
+// parseLimitOffset reads the limit and offset parameters of a listing
+func parseLimitOffset(c *fiber.Ctx) (limit, offset int, err error) {
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > repository.MaxPageSize {
+			return 0, 0, fmt.Errorf("Invalid limit, expected 1 to %d", repository.MaxPageSize)
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("Invalid offset")
+		}
+	}
+	return limit, offset, nil
+}
+
+// SearchProducts runs a full-text search over product names and descriptions. The q parameter
+// takes words, which tolerate typos, prefixes ending in * and "quoted phrases". Results come
+// most relevant first, with their score and highlighted matches, paginated by limit and offset.
+func (ctl *Controllers) SearchProducts(c *fiber.Ctx) error {
+	// Parse the search and the page requested
+	query, err := repository.ParseSearch(c.Query("q"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": "Missing search query",
+		})
+	}
+	query.Limit, query.Offset, err = parseLimitOffset(c)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	view, message, err := ctl.displayCurrency(c)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch exchange rates",
+		})
+	}
+	if message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{
+			"message": message,
+		})
+	}
+
+	// Run the search
+	page, err := ctl.Products.Search(query)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to search products",
+		})
+	}
+	listed := make([]*models.Product, len(page.Results))
+	for i := range page.Results {
+		view.apply(&page.Results[i].Product)
+		listed[i] = &page.Results[i].Product
+	}
+	if err := ctl.attachPrimaryImages(listed...); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{
+			"message": "failed to fetch images",
+		})
+	}
+
+	// Describe the neighbouring pages in the headers and return the results
+	setOffsetHeaders(c, query.Limit, query.Offset, page.Total)
+	return c.JSON(page.Results)
+}
@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/promotions"
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// couponPattern matches the coupon codes admins may choose, after conversion to upper case
+var couponPattern = regexp.MustCompile(`^[A-Z0-9_-]{3,64}$`)
+
+// ListPromotions returns all promotions and coupons ordered by ID, including inactive ones
+func (ctl *Controllers) ListPromotions(c *fiber.Ctx) error {
+	list, err := ctl.Promotions.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch promotions"})
+	}
+	return c.JSON(list)
+}
+
+// GetPromotion returns a single promotion by its ID
+func (ctl *Controllers) GetPromotion(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	promotion, err := ctl.Promotions.Get(uint(id))
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Promotion Not Found"})
+	}
+	return c.JSON(promotion)
+}
+
+// CreatePromotion adds a promotion. The body takes a name and a kind: percent with a percent,
+// fixed with an amount in a currency, the base currency by default, buy_x_get_y with a
+// buy_quantity and get_quantity and optionally the percent off the units got, 100 by default,
+// or free_shipping. A scope of products, categories or sellers limits the promotion to the
+// target_ids; by default it applies to all lines. A code turns the promotion into a coupon.
+// Optional starts_at and ends_at times, max_uses and max_uses_per_user caps, exclusive and
+// priority settle when and how it applies; active switches it off.
+func (ctl *Controllers) CreatePromotion(c *fiber.Ctx) error {
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	for _, field := range []string{"name", "kind"} {
+		if _, ok := data[field]; !ok {
+			c.Status(fiber.StatusBadRequest)
+			return c.JSON(fiber.Map{"message": "Missing " + field})
+		}
+	}
+
+	promotion := &models.Promotion{Scope: models.PromotionScopeAll, TargetIDs: models.IDList{}, Active: true}
+	if data["kind"] == string(models.PromotionBuyXGetY) {
+		promotion.Percent = 100
+	}
+	if message := ctl.applyPromotion(promotion, data); message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	if err := ctl.Promotions.Create(promotion); err != nil {
+		return promotionError(c, err, "failed to create promotion")
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(promotion)
+}
+
+// UpdatePromotion changes the fields of a promotion given in the body, which takes the fields
+// of CreatePromotion. A code of null turns a coupon into an automatic promotion. The use
+// count is kept.
+func (ctl *Controllers) UpdatePromotion(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	promotion, err := ctl.Promotions.Get(uint(id))
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Promotion Not Found"})
+	}
+
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	if message := ctl.applyPromotion(promotion, data); message != "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	if err := ctl.Promotions.Update(promotion); err != nil {
+		return promotionError(c, err, "failed to update promotion")
+	}
+	return c.JSON(promotion)
+}
+
+// DeletePromotion removes a promotion together with its redemptions. Orders keep the discounts
+// it granted.
+func (ctl *Controllers) DeletePromotion(c *fiber.Ctx) error {
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err := ctl.Promotions.Delete(uint(id)); err != nil {
+		return promotionError(c, err, "failed to delete promotion")
+	}
+	return c.JSON(fiber.Map{"message": "Promotion deleted successfully"})
+}
+
+// promotionError answers a failed change of a promotion with the response matching the error
+func promotionError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Promotion Not Found"})
+	case errors.Is(err, repository.ErrDuplicateCode):
+		c.Status(fiber.StatusConflict)
+		return c.JSON(fiber.Map{"message": "Code already in use"})
+	}
+	c.Status(fiber.StatusInternalServerError)
+	return c.JSON(fiber.Map{"message": message})
+}
+
+// jsonInt reads an integer decoded from JSON
+func jsonInt(value interface{}) (int, bool) {
+	number, ok := value.(float64)
+	if !ok || number != math.Trunc(number) || math.Abs(number) > math.MaxInt32 {
+		return 0, false
+	}
+	return int(number), true
+}
+
+// applyPromotion validates the fields of a request body and copies them onto the promotion,
+// then checks that the fields fit together. It returns a message describing the first
+// problem found.
+func (ctl *Controllers) applyPromotion(promotion *models.Promotion, data map[string]interface{}) string {
+	if value, ok := data["name"]; ok {
+		name, ok := value.(string)
+		if !ok || strings.TrimSpace(name) == "" {
+			return "Invalid Name"
+		}
+		promotion.Name = strings.TrimSpace(name)
+	}
+	if value, ok := data["code"]; ok {
+		if value == nil {
+			promotion.Code = nil
+		} else {
+			text, _ := value.(string)
+			code := strings.ToUpper(strings.TrimSpace(text))
+			if !couponPattern.MatchString(code) {
+				return "Invalid Code, expected 3 to 64 letters, digits, dashes or underscores"
+			}
+			promotion.Code = &code
+		}
+	}
+	if value, ok := data["kind"]; ok {
+		kind, _ := value.(string)
+		if !models.PromotionKind(kind).IsValid() {
+			return "Invalid Kind, expected percent, fixed, buy_x_get_y or free_shipping"
+		}
+		promotion.Kind = models.PromotionKind(kind)
+	}
+	if value, ok := data["scope"]; ok {
+		scope, _ := value.(string)
+		if !models.PromotionScope(scope).IsValid() {
+			return "Invalid Scope, expected all, products, categories or sellers"
+		}
+		promotion.Scope = models.PromotionScope(scope)
+	}
+	if value, ok := data["target_ids"]; ok {
+		list, ok := value.([]interface{})
+		if !ok {
+			return "Invalid target_ids, expected a list of IDs"
+		}
+		ids := models.IDList{}
+		for _, item := range list {
+			id, ok := jsonInt(item)
+			if !ok || id < 1 {
+				return "Invalid target_ids, expected a list of IDs"
+			}
+			if !ids.Has(uint(id)) {
+				ids = append(ids, uint(id))
+			}
+		}
+		promotion.TargetIDs = ids
+	}
+
+	integers := []struct {
+		field  string
+		target *int
+	}{
+		{"percent", &promotion.Percent},
+		{"buy_quantity", &promotion.BuyQuantity},
+		{"get_quantity", &promotion.GetQuantity},
+		{"max_uses", &promotion.MaxUses},
+		{"max_uses_per_user", &promotion.MaxUsesPerUser},
+		{"priority", &promotion.Priority},
+	}
+	for _, field := range integers {
+		if value, ok := data[field.field]; ok {
+			number, ok := jsonInt(value)
+			if !ok {
+				return "Invalid " + field.field + ", expected an integer"
+			}
+			*field.target = number
+		}
+	}
+	flags := []struct {
+		field  string
+		target *bool
+	}{
+		{"exclusive", &promotion.Exclusive},
+		{"active", &promotion.Active},
+	}
+	for _, field := range flags {
+		if value, ok := data[field.field]; ok {
+			flag, ok := value.(bool)
+			if !ok {
+				return "Invalid " + field.field + ", expected true or false"
+			}
+			*field.target = flag
+		}
+	}
+	times := []struct {
+		field  string
+		target **time.Time
+	}{
+		{"starts_at", &promotion.StartsAt},
+		{"ends_at", &promotion.EndsAt},
+	}
+	for _, field := range times {
+		if value, ok := data[field.field]; ok {
+			if value == nil {
+				*field.target = nil
+				continue
+			}
+			text, _ := value.(string)
+			at, err := parseTime(text)
+			if err != nil {
+				return "Invalid " + field.field + ", expected a date or RFC 3339 timestamp"
+			}
+			*field.target = &at
+		}
+	}
+
+	// Amounts are read in the currency given with them, or else the one they had
+	currency := promotion.Amount.Currency
+	if value, ok := data["currency"]; ok {
+		text, _ := value.(string)
+		normalized, err := models.NormalizeCurrency(text)
+		if err != nil {
+			return "Invalid Currency"
+		}
+		if _, ok := data["amount"]; !ok {
+			return "Missing Amount, changing the currency needs an amount"
+		}
+		currency = normalized
+	}
+	if value, ok := data["amount"]; ok {
+		if currency == "" {
+			currency = ctl.BaseCurrency
+		}
+		amount, err := models.MoneyFromJSON(value, currency)
+		if err != nil {
+			return "Invalid Amount, expected a decimal amount in " + currency
+		}
+		promotion.Amount = amount
+	}
+	return validatePromotion(promotion)
+}
+
+// validatePromotion checks that the fields of a promotion fit together and returns a message
+// describing the first problem found
+func validatePromotion(p *models.Promotion) string {
+	switch p.Kind {
+	case models.PromotionPercent:
+		if p.Percent < 1 || p.Percent > 100 {
+			return "Invalid percent, expected 1 to 100"
+		}
+	case models.PromotionFixed:
+		if p.Amount.Amount <= 0 {
+			return "Invalid Amount, fixed promotions need a positive amount"
+		}
+	case models.PromotionBuyXGetY:
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return "Invalid buy_quantity or get_quantity, expected positive integers"
+		}
+		if p.Percent < 1 || p.Percent > 100 {
+			return "Invalid percent, expected 1 to 100"
+		}
+	}
+	if p.Scope == models.PromotionScopeAll && len(p.TargetIDs) > 0 {
+		return "Invalid target_ids, promotions for all lines have no targets"
+	}
+	if p.Scope != models.PromotionScopeAll && len(p.TargetIDs) == 0 {
+		return fmt.Sprintf("Missing target_ids, promotions for %s need at least one", p.Scope)
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return "Invalid ends_at, expected a time after starts_at"
+	}
+	if p.MaxUses < 0 || p.MaxUsesPerUser < 0 {
+		return "Invalid max_uses, expected zero for no limit or a positive integer"
+	}
+	return ""
+}
+
+// cartPromotions evaluates the active promotions against the available lines of a cart view
+// and adds the discount breakdown and total to the view. When the coupon the buyer entered
+// cannot be used, the reason is set as the coupon error and only the automatic promotions
+// apply.
+func (ctl *Controllers) cartPromotions(view *CartView, cart *models.Cart, coupon string, rates *models.Rates, now time.Time) error {
+	active, err := ctl.Promotions.ListActive()
+	if err != nil {
+		return err
+	}
+	var categories []models.Category
+	lines := []promotions.Line{}
+	for _, line := range view.Items {
+		if line.UnitPrice == nil {
+			continue
+		}
+		if categories == nil && len(active) > 0 {
+			if categories, err = ctl.Categories.List(); err != nil {
+				return err
+			}
+		}
+		ids, err := ctl.Products.CategoryIDs(line.ProductID)
+		if err != nil {
+			return err
+		}
+		unit, err := rates.Convert(*line.UnitPrice, view.Subtotal.Currency)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, promotions.Line{
+			ItemID:      line.ID,
+			ProductID:   line.ProductID,
+			SellerID:    line.sellerID,
+			CategoryIDs: models.CategoryAncestors(categories, ids...),
+			UnitPrice:   unit,
+			Quantity:    line.Quantity,
+		})
+	}
+
+	opts := promotions.Options{Currency: view.Subtotal.Currency, Rates: rates, Now: now, Code: coupon}
+	if cart.UserID != nil {
+		if opts.UserUses, err = ctl.Promotions.UserUses(*cart.UserID); err != nil {
+			return err
+		}
+	}
+	result, err := promotions.Evaluate(lines, active, opts)
+	if couponError(err) {
+		view.CouponError = err.Error()
+		opts.Code = ""
+		result, err = promotions.Evaluate(lines, active, opts)
+	} else if coupon != "" {
+		view.Coupon = strings.ToUpper(strings.TrimSpace(coupon))
+	}
+	if err != nil {
+		return err
+	}
+
+	view.discounts = result
+	view.Discounts = result.Discounts
+	view.Discount = result.Total
+	view.FreeShipping = result.FreeShipping
+	view.Total = models.Money{Amount: max(view.Subtotal.Amount-result.Total.Amount, 0), Currency: view.Subtotal.Currency}
+	return nil
+}
+
+// couponError reports whether an error of the promotion engine is about the coupon entered
+func couponError(err error) bool {
+	for _, target := range []error{promotions.ErrUnknownCoupon, promotions.ErrCouponExpired, promotions.ErrCouponExhausted, promotions.ErrCouponNotApplicable} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// redeemPromotions records that the buyer used the promotions of a checkout, counting them
+// against their caps. It returns repository.ErrPromotionExhausted when a concurrent checkout
+// used up a promotion meanwhile.
+func (ctl *Controllers) redeemPromotions(buyerID uint, discounts *promotions.Result, orders []models.Order) error {
+	if discounts == nil || len(discounts.Discounts) == 0 || len(orders) == 0 {
+		return nil
+	}
+	redemptions := make([]models.PromotionRedemption, len(discounts.Discounts))
+	for i, discount := range discounts.Discounts {
+		redemptions[i] = models.PromotionRedemption{PromotionID: discount.PromotionID, UserID: buyerID, OrderID: &orders[0].ID}
+	}
+	return ctl.Promotions.Redeem(redemptions)
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/tax"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListTaxRates returns the tax rate table ordered by jurisdiction, class and name. With
+// format=csv the table is sent as a CSV file in the format ImportTaxRates reads, so finance
+// can edit it in a spreadsheet and upload it again.
+func (ctl *Controllers) ListTaxRates(c *fiber.Ctx) error {
+	rates, err := ctl.TaxRates.List()
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch tax rates"})
+	}
+	if c.Query("format") != "csv" {
+		return c.JSON(rates)
+	}
+	var body bytes.Buffer
+	if err := tax.WriteCSV(&body, rates); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to write tax rates"})
+	}
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="tax-rates.csv"`)
+	return c.Send(body.Bytes())
+}
+
+// ImportTaxRates replaces the whole tax rate table with the CSV table in the body: a header
+// row naming the columns jurisdiction, class, name and rate, then one rate per row, rates
+// being percents such as 8.875. Nothing changes when a row is invalid; the answer is 400 Bad
+// Request naming the row.
+func (ctl *Controllers) ImportTaxRates(c *fiber.Ctx) error {
+	rates, err := tax.ReadCSV(bytes.NewReader(c.Body()))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Invalid tax rates: " + err.Error()})
+	}
+	if err := ctl.TaxRates.Replace(rates); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to save tax rates"})
+	}
+	return c.JSON(rates)
+}
+
+// taxTable loads the current tax rate table
+func (ctl *Controllers) taxTable() (*tax.Table, error) {
+	rates, err := ctl.TaxRates.List()
+	if err != nil {
+		return nil, err
+	}
+	return tax.NewTable(rates)
+}
+
+// taxOptions describes how orders in the currency are taxed in the jurisdiction
+func (ctl *Controllers) taxOptions(jurisdiction, currency string) tax.Options {
+	return tax.Options{Jurisdiction: jurisdiction, Currency: currency, Inclusive: ctl.TaxInclusive, Rounding: ctl.TaxRounding}
+}
+
+// requestJurisdiction reads the jurisdiction parameter carts and checkouts are taxed in,
+// defaulting to the configured jurisdiction. On failure it returns the status and message to
+// answer with: jurisdictions have to have tax rates.
+func (ctl *Controllers) requestJurisdiction(c *fiber.Ctx) (string, int, string) {
+	jurisdiction := ctl.TaxJurisdiction
+	if value := c.Query("jurisdiction"); value != "" {
+		normalized, err := models.NormalizeJurisdiction(value)
+		if err != nil {
+			return "", fiber.StatusBadRequest, "Invalid jurisdiction, expected a code such as US-NY"
+		}
+		jurisdiction = normalized
+	}
+	if jurisdiction == "" {
+		return "", 0, ""
+	}
+	table, err := ctl.taxTable()
+	if err != nil {
+		return "", fiber.StatusInternalServerError, "failed to fetch tax rates"
+	}
+	if !table.Has(jurisdiction) {
+		return "", fiber.StatusBadRequest, "No tax rates for jurisdiction " + jurisdiction
+	}
+	return jurisdiction, 0, ""
+}
+
+// taxClass returns the tax class a product inherits from its categories
+func (ctl *Controllers) taxClass(productID uint, categories []models.Category) (string, error) {
+	ids, err := ctl.Products.CategoryIDs(productID)
+	if err != nil {
+		return "", err
+	}
+	return models.CategoryTaxClass(categories, ids...), nil
+}
+
+// cartTaxes computes the taxes of the available lines of a cart view, less their discounts,
+// and adds the breakdown to the view. The lines of each seller are taxed as the order they
+// will be placed as, so the cart shows the taxes its checkout levies.
+func (ctl *Controllers) cartTaxes(view *CartView, jurisdiction string, rates *models.Rates) error {
+	table, err := ctl.taxTable()
+	if err != nil {
+		return err
+	}
+	categories, err := ctl.Categories.List()
+	if err != nil {
+		return err
+	}
+	currency := view.Subtotal.Currency
+	opts := ctl.taxOptions(jurisdiction, currency)
+	result, err := table.Calculate(nil, opts)
+	if err != nil {
+		return err
+	}
+
+	bySeller := make(map[uint][]tax.Line)
+	for i := range view.Items {
+		line := &view.Items[i]
+		if line.UnitPrice == nil {
+			continue
+		}
+		if line.TaxClass, err = ctl.taxClass(line.ProductID, categories); err != nil {
+			return err
+		}
+		unit, err := rates.Convert(*line.UnitPrice, currency)
+		if err != nil {
+			return err
+		}
+		amount := unit.Mul(int64(line.Quantity))
+		if view.discounts != nil {
+			amount.Amount -= view.discounts.Line(line.ID).Amount
+		}
+		key := uint(0)
+		if line.sellerID != nil {
+			key = *line.sellerID
+		}
+		bySeller[key] = append(bySeller[key], tax.Line{ItemID: line.ID, Class: line.TaxClass, Amount: amount})
+	}
+	keys := make([]uint, 0, len(bySeller))
+	for key := range bySeller {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		order, err := table.Calculate(bySeller[key], opts)
+		if err != nil {
+			return err
+		}
+		result.Add(order)
+	}
+
+	for i := range view.Items {
+		if view.Items[i].UnitPrice != nil {
+			lineTax := result.Line(view.Items[i].ID)
+			view.Items[i].Tax = &lineTax
+		}
+	}
+	view.Tax, view.Taxes, view.TaxInclusive, view.Jurisdiction = result.Total, result.Taxes, result.Inclusive, result.Jurisdiction
+	if !result.Inclusive {
+		view.Total.Amount += result.Total.Amount
+	}
+	return nil
+}
+
+// taxOrder computes the taxes of an order placed from the cart items with the given IDs, one
+// for each line, and sets them on the order and its lines together with the total
+func (ctl *Controllers) taxOrder(table *tax.Table, order *models.Order, itemIDs []uint, jurisdiction string) error {
+	currency := order.Subtotal.Currency
+	lines := make([]tax.Line, len(order.Lines))
+	for i, line := range order.Lines {
+		lines[i] = tax.Line{ItemID: itemIDs[i], Class: line.TaxClass, Amount: models.Money{Amount: line.Total.Amount - line.Discount.Amount, Currency: currency}}
+	}
+	result, err := table.Calculate(lines, ctl.taxOptions(jurisdiction, currency))
+	if err != nil {
+		return err
+	}
+	for i := range order.Lines {
+		order.Lines[i].Tax = result.Line(itemIDs[i])
+	}
+	order.Tax, order.Taxes, order.TaxInclusive, order.Jurisdiction = result.Total, result.Taxes, result.Inclusive, result.Jurisdiction
+	order.Total = models.Money{Amount: order.Subtotal.Amount - order.Discount.Amount, Currency: currency}
+	if !order.TaxInclusive {
+		order.Total.Amount += order.Tax.Amount
+	}
+	return nil
+}
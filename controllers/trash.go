@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/alwilion/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultTrashRetention is how long deleted products stay restorable unless configured otherwise
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// ListTrash returns the deleted products that can still be restored, most recently deleted
+// first: sellers see their own, admins those of every seller
+func (ctl *Controllers) ListTrash(c *fiber.Ctx) error {
+	token, err := ctl.authentication(c)
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "unauthenticated"})
+	}
+	claims := token.Claims.(*Claims)
+	var sellerID *uint
+	if !Allowed(claims.Roles, PermManageProducts) {
+		userID := claims.UserID()
+		sellerID = &userID
+	}
+	products, err := ctl.Products.Trash(sellerID)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch deleted products"})
+	}
+	return c.JSON(products)
+}
+
+// RestoreProduct takes a deleted product out of the trash. Only its seller and admins may
+// restore it.
+func (ctl *Controllers) RestoreProduct(c *fiber.Ctx) error {
+	token, err := ctl.authentication(c)
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized)
+		return c.JSON(fiber.Map{"message": "unauthenticated"})
+	}
+	id, err := productID(c)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	product, err := ctl.Products.GetDeleted(id)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	if !canModify(token.Claims.(*Claims), product) {
+		c.Status(fiber.StatusForbidden)
+		return c.JSON(fiber.Map{"message": "forbidden"})
+	}
+
+	if err := ctl.Products.Restore(id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+			return c.JSON(fiber.Map{"message": "Product Not Found"})
+		}
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to restore product"})
+	}
+	if product, err = ctl.Products.Get(id); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch product"})
+	}
+	return c.JSON(product)
+}
+
+// purgeProduct permanently deletes a product, in the trash or not, and the files of its images
+func (ctl *Controllers) purgeProduct(id uint) error {
+	images, err := ctl.Images.List(id)
+	if err != nil {
+		return err
+	}
+	if err := ctl.Products.Purge(id); err != nil {
+		return err
+	}
+	for i := range images {
+		ctl.deleteImageFiles(&images[i])
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes the products that have been in the trash for longer than the
+// retention period. It is run periodically by a background job; a retention of zero keeps
+// deleted products forever.
+func (ctl *Controllers) PurgeTrash(now time.Time) error {
+	if ctl.TrashRetention <= 0 {
+		return nil
+	}
+	products, err := ctl.Products.Trash(nil)
+	if err != nil {
+		return err
+	}
+	cutoff := now.Add(-ctl.TrashRetention)
+	for _, product := range products {
+		if !product.DeletedAt.Time.Before(cutoff) {
+			continue
+		}
+		// One failing product must not hold up the others
+		if err := ctl.purgeProduct(product.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("purge product %d: %v", product.ID, err)
+		}
+	}
+	return nil
+}
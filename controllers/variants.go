@@ -0,0 +1,346 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+	"github.com/alwilion/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProductDetail is a product together with its variant matrix and image gallery
+type ProductDetail struct {
+	*models.Product
+	Available int                    `json:"available"`        // Units that can be bought, over all variants
+	Options   []models.VariantOption `json:"options"`          // Dimensions of the matrix and their values
+	Variants  []VariantDetail        `json:"variants"`         // Every variant of the product
+	Images    []models.ProductImage  `json:"images,omitempty"` // Images of the product in gallery order, only on the product detail
+}
+
+// VariantDetail is a variant with the price it sells at and its stock
+type VariantDetail struct {
+	models.Variant
+	EffectivePrice models.Money `json:"effective_price"` // The price override, or the product price without one
+	Available      int          `json:"available"`       // Units on hand that are not reserved
+}
+
+// stockLevels returns the stock levels of a product by variant ID
+func (ctl *Controllers) stockLevels(productID uint) (map[uint]models.StockLevel, error) {
+	levels, err := ctl.Inventory.Levels(productID)
+	if err != nil {
+		return nil, err
+	}
+	byVariant := make(map[uint]models.StockLevel, len(levels))
+	for _, level := range levels {
+		byVariant[level.VariantID] = level
+	}
+	return byVariant, nil
+}
+
+// variantDetail fills in the stock of a variant and the price it sells at
+func variantDetail(product *models.Product, variant models.Variant, levels map[uint]models.StockLevel) VariantDetail {
+	level := levels[variant.ID]
+	variant.Stock = level.OnHand
+	return VariantDetail{Variant: variant, EffectivePrice: variant.EffectivePrice(*product), Available: level.Available()}
+}
+
+// productDetail loads the variants and stock of a product and arranges them into its matrix
+func (ctl *Controllers) productDetail(product *models.Product) (*ProductDetail, error) {
+	variants, err := ctl.Variants.List(product.ID)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := ctl.stockLevels(product.ID)
+	if err != nil {
+		return nil, err
+	}
+	detail := &ProductDetail{Product: product, Options: models.VariantOptions(variants), Variants: []VariantDetail{}}
+	for _, variant := range variants {
+		v := variantDetail(product, variant, levels)
+		detail.Variants = append(detail.Variants, v)
+		detail.Available += v.Available
+	}
+	if len(variants) == 0 {
+		detail.Available = levels[0].Available()
+	}
+	return detail, nil
+}
+
+// ListVariants returns the variants of a product with their effective prices
+func (ctl *Controllers) ListVariants(c *fiber.Ctx) error {
+	product, ok := ctl.variantProduct(c)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	detail, err := ctl.productDetail(product)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch variants"})
+	}
+	return c.JSON(detail.Variants)
+}
+
+// GetVariant returns a single variant of a product
+func (ctl *Controllers) GetVariant(c *fiber.Ctx) error {
+	product, ok := ctl.variantProduct(c)
+	if !ok {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Product Not Found"})
+	}
+	variant, err := ctl.productVariant(c, product.ID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Variant Not Found"})
+	}
+	levels, err := ctl.stockLevels(product.ID)
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to fetch inventory"})
+	}
+	return c.JSON(variantDetail(product, *variant, levels))
+}
+
+// CreateVariant adds a variant to a product. The body takes a sku, the options identifying the
+// variant, an optional price overriding the product price and the stock, which is recorded as
+// received in the inventory ledger. All variants of a product share the same option names and
+// no two have the same option values.
+func (ctl *Controllers) CreateVariant(c *fiber.Ctx) error {
+	product, claims, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	if _, ok := data["sku"]; !ok {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Missing SKU"})
+	}
+	if _, ok := data["options"]; !ok {
+		c.Status(fiber.StatusBadRequest)
+		return c.JSON(fiber.Map{"message": "Missing Options"})
+	}
+
+	variant := &models.Variant{ProductID: product.ID}
+	if status, message, err := ctl.applyVariant(product, variant, data); err != nil || status != 0 {
+		return variantError(c, status, message, err)
+	}
+	if err := ctl.Variants.Create(variant); err != nil {
+		return variantError(c, 0, "", err)
+	}
+	if variant.Stock > 0 {
+		userID := claims.UserID()
+		movement := &models.StockMovement{ProductID: product.ID, VariantID: variant.ID, Kind: models.MovementReceive, Quantity: variant.Stock, UserID: &userID, Note: "initial stock"}
+		if err := ctl.Inventory.Record(movement); err != nil {
+			return variantError(c, 0, "", err)
+		}
+	}
+	levels, err := ctl.stockLevels(product.ID)
+	if err != nil {
+		return variantError(c, 0, "", err)
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(variantDetail(product, *variant, levels))
+}
+
+// UpdateVariant changes the fields of a variant given in the body. A price of null removes the
+// price override; a new stock is recorded as an adjustment in the inventory ledger.
+func (ctl *Controllers) UpdateVariant(c *fiber.Ctx) error {
+	product, claims, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	variant, err := ctl.productVariant(c, product.ID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Variant Not Found"})
+	}
+
+	levels, err := ctl.stockLevels(product.ID)
+	if err != nil {
+		return variantError(c, 0, "", err)
+	}
+	onHand := levels[variant.ID].OnHand
+	variant.Stock = onHand
+
+	data := make(map[string]interface{})
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+	if status, message, err := ctl.applyVariant(product, variant, data); err != nil || status != 0 {
+		return variantError(c, status, message, err)
+	}
+	if variant.Stock < levels[variant.ID].Reserved {
+		return variantError(c, 0, "", repository.ErrInsufficientStock)
+	}
+	if err := ctl.Variants.Update(variant); err != nil {
+		return variantError(c, 0, "", err)
+	}
+	if variant.Stock != onHand {
+		userID := claims.UserID()
+		movement := &models.StockMovement{ProductID: product.ID, VariantID: variant.ID, Kind: models.MovementAdjust, Quantity: variant.Stock - onHand, UserID: &userID}
+		if err := ctl.Inventory.Record(movement); err != nil {
+			return variantError(c, 0, "", err)
+		}
+	}
+	if levels, err = ctl.stockLevels(product.ID); err != nil {
+		return variantError(c, 0, "", err)
+	}
+	return c.JSON(variantDetail(product, *variant, levels))
+}
+
+// DeleteVariant removes a variant from a product. Its inventory ledger is kept.
+func (ctl *Controllers) DeleteVariant(c *fiber.Ctx) error {
+	product, _, status, message := ctl.modifiableProduct(c)
+	if status != 0 {
+		c.Status(status)
+		return c.JSON(fiber.Map{"message": message})
+	}
+	variant, err := ctl.productVariant(c, product.ID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound)
+		return c.JSON(fiber.Map{"message": "Variant Not Found"})
+	}
+	if err := ctl.Variants.Delete(variant.ID); err != nil {
+		c.Status(fiber.StatusInternalServerError)
+		return c.JSON(fiber.Map{"message": "failed to delete variant"})
+	}
+	return c.JSON(fiber.Map{"message": "Variant deleted successfully"})
+}
+
+// variantProduct looks up the product of the ":id" route parameter
+func (ctl *Controllers) variantProduct(c *fiber.Ctx) (*models.Product, bool) {
+	id, err := productID(c)
+	if err != nil {
+		return nil, false
+	}
+	product, err := ctl.Products.Get(id)
+	return product, err == nil
+}
+
+// modifiableProduct authenticates the request and looks up the product of the ":id" route
+// parameter, which only its seller or an admin may change. On failure it returns the status
+// and message to answer with.
+func (ctl *Controllers) modifiableProduct(c *fiber.Ctx) (*models.Product, *Claims, int, string) {
+	token, err := ctl.authentication(c)
+	if err != nil {
+		return nil, nil, fiber.StatusUnauthorized, "unauthenticated"
+	}
+	product, ok := ctl.variantProduct(c)
+	if !ok {
+		return nil, nil, fiber.StatusNotFound, "Product Not Found"
+	}
+	claims := token.Claims.(*Claims)
+	if !canModify(claims, product) {
+		return nil, nil, fiber.StatusForbidden, "forbidden"
+	}
+	return product, claims, 0, ""
+}
+
+// productVariant looks up the variant of the ":variantId" route parameter, which has to belong to the product
+func (ctl *Controllers) productVariant(c *fiber.Ctx, productID uint) (*models.Variant, error) {
+	id, err := strconv.ParseUint(c.Params("variantId"), 10, 64)
+	if err != nil {
+		return nil, repository.ErrNotFound
+	}
+	variant, err := ctl.Variants.Get(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if variant.ProductID != productID {
+		return nil, repository.ErrNotFound
+	}
+	return variant, nil
+}
+
+// applyVariant validates the sku, options, price and stock fields of a request body and copies
+// them onto the variant. Prices are decimals in the currency of the product. On failure it
+// returns the status and a message describing the first invalid field.
+func (ctl *Controllers) applyVariant(product *models.Product, variant *models.Variant, data map[string]interface{}) (int, string, error) {
+	if value, ok := data["sku"]; ok {
+		text, _ := value.(string)
+		sku, err := models.NormalizeSKU(text)
+		if err != nil {
+			return fiber.StatusBadRequest, "Invalid " + err.Error(), nil
+		}
+		variant.SKU = sku
+	}
+
+	if value, ok := data["options"]; ok {
+		object, ok := value.(map[string]interface{})
+		if !ok || len(object) == 0 {
+			return fiber.StatusBadRequest, "Invalid Options, expected an object of option names and values", nil
+		}
+		options := models.OptionValues{}
+		for name, value := range object {
+			text, ok := value.(string)
+			if !ok || strings.TrimSpace(text) == "" || utils.Slugify(name) != name {
+				return fiber.StatusBadRequest, "Invalid Options, expected lower-case option names with non-empty string values", nil
+			}
+			options[name] = strings.TrimSpace(text)
+		}
+		variant.Options = options
+	}
+
+	if value, ok := data["price"]; ok {
+		if value == nil {
+			variant.Price = nil
+		} else {
+			price, err := models.MoneyFromJSON(value, product.Price.Currency)
+			if err != nil || price.Amount <= 0 {
+				return fiber.StatusBadRequest, "Invalid Price, expected a positive amount in " + product.Price.Currency + " or null", nil
+			}
+			variant.Price = &price.Amount
+		}
+	}
+
+	if value, ok := data["stock"]; ok {
+		stock, ok := value.(float64)
+		if !ok || stock < 0 || stock != math.Trunc(stock) {
+			return fiber.StatusBadRequest, "Invalid Stock, expected a non-negative integer", nil
+		}
+		variant.Stock = int(stock)
+	}
+
+	// The options have to fit the matrix formed by the other variants of the product
+	siblings, err := ctl.Variants.List(variant.ProductID)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, sibling := range siblings {
+		if sibling.ID == variant.ID {
+			continue
+		}
+		if strings.Join(sibling.Options.Names(), ",") != strings.Join(variant.Options.Names(), ",") {
+			return fiber.StatusBadRequest, "Invalid Options, variants of this product have the options " + strings.Join(sibling.Options.Names(), ", "), nil
+		}
+		if sibling.Options.Equal(variant.Options) {
+			return fiber.StatusConflict, "A variant with these options already exists", nil
+		}
+	}
+	return 0, "", nil
+}
+
+// variantError answers a failed variant change with the status and message, or with the
+// response matching a repository error
+func variantError(c *fiber.Ctx, status int, message string, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrDuplicateSKU):
+		status, message = fiber.StatusConflict, "SKU already in use"
+	case errors.Is(err, repository.ErrInsufficientStock):
+		status, message = fiber.StatusConflict, "Insufficient stock, units are reserved"
+	case err != nil:
+		status, message = fiber.StatusInternalServerError, "failed to save variant"
+	}
+	c.Status(status)
+	return c.JSON(fiber.Map{"message": message})
+}
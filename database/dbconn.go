@@ -5,39 +5,131 @@ package database
 import (
 	"fmt"
 	"log"
+	"net/url"
 
-	"github.com/alwilion/models"
+	"github.com/alwilion/config"
+	"github.com/alwilion/migrations"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-// Connection details for the PostgreSQL database
+// DB is a global variable representing the GORM database instance
+var DB *gorm.DB
+
+// Default ports used when the configuration leaves the port at zero
 const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "admin123" // Enter your password for the DB
-	dbname   = "product"
+	defaultPostgresPort = 5432
+	defaultMySQLPort    = 3306
 )
 
-// Database source name (DSN) string for connecting to PostgreSQL
-var dsn string = fmt.Sprintf("host=%s port=%d user=%s "+
-	"password=%s dbname=%s sslmode=disable TimeZone=Asia/Shanghai",
-	host, port, user, password, dbname)
+// dialector builds the GORM dialector for the configured driver
+func dialector(cfg config.DatabaseConfig) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case config.DriverPostgres:
+		port := cfg.Port
+		if port == 0 {
+			port = defaultPostgresPort
+		}
+		dsn := fmt.Sprintf("host=%s port=%d user=%s "+
+			"password=%s dbname=%s sslmode=%s TimeZone=%s",
+			cfg.Host, port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode, cfg.TimeZone)
+		return postgres.Open(dsn), nil
 
-// DB is a global variable representing the GORM database instance
-var DB *gorm.DB
+	case config.DriverMySQL:
+		port := cfg.Port
+		if port == 0 {
+			port = defaultMySQLPort
+		}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=%s",
+			cfg.User, cfg.Password, cfg.Host, port, cfg.Name, url.QueryEscape(cfg.TimeZone))
+		return mysql.Open(dsn), nil
+
+	case config.DriverSQLite:
+		return sqlite.Open(cfg.Path + "?_foreign_keys=on&_busy_timeout=5000"), nil
+
+	case config.DriverSQLiteMemory:
+		return sqlite.Open("file::memory:?_foreign_keys=on"), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+}
+
+// Open connects to the configured database without touching the global DB handle
+func Open(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	dialect, err := dialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialect, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every connection to an in-memory SQLite database gets its own empty database,
+	// so the pool is limited to a single connection that lives as long as the process
+	if cfg.Driver == config.DriverSQLiteMemory {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+	}
+	return db, nil
+}
 
-// DBconn initializes the database connection and performs migrations
-func DBconn() {
-	// Open a connection to the PostgreSQL database
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Migrate brings the schema of db up to date according to the configured migration mode.
+// In check mode it fails if any migration is pending; an in-memory database starts empty
+// and is therefore always migrated.
+func Migrate(db *gorm.DB, cfg config.DatabaseConfig) error {
+	mode := cfg.Migrate
+	if cfg.Driver == config.DriverSQLiteMemory {
+		mode = config.MigrateAuto
+	}
+	if mode == config.MigrateOff {
+		return nil
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Verify(); err != nil {
+		return err
+	}
+
+	if mode == config.MigrateAuto {
+		applied, err := migrator.Up(0)
+		for _, m := range applied {
+			log.Printf("applied migration %04d_%s", m.Version, m.Name)
+		}
+		return err
+	}
+
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is %d migration(s) behind, run \"migrate up\" before starting the server", len(pending))
+	}
+	return nil
+}
+
+// DBconn initializes the database connection and checks or applies the schema migrations
+func DBconn(cfg config.DatabaseConfig) {
+	// Open a connection to the configured database
+	db, err := Open(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	DB = db
 
-	// Perform automatic migrations for the User and Product models
-	db.AutoMigrate(&models.User{})
-	db.AutoMigrate(&models.Product{})
+	// Refuse to continue with a schema the code does not expect
+	if err := Migrate(db, cfg); err != nil {
+		log.Fatal(err)
+	}
+	DB = db
 }
@@ -0,0 +1,185 @@
+// Package images decodes uploaded pictures and renders the thumbnails shown by clients.
+// JPEG, PNG and GIF images are supported; animated GIFs are reduced to their first frame.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxPixels is the largest image area decoded. The dimensions are read from the header
+// before decoding, so small files claiming huge dimensions are rejected without allocating.
+const MaxPixels = 50_000_000
+
+// JPEGQuality is the quality thumbnails are encoded with
+const JPEGQuality = 85
+
+// ErrUnsupported is returned for data that is not a JPEG, PNG or GIF image
+var ErrUnsupported = errors.New("unsupported image format")
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// Size is a thumbnail size, fitting the image into a square box
+type Size struct {
+	Name string // Name used in URLs and storage keys, such as "small"
+	Max  int    // Longest side of the thumbnail in pixels
+}
+
+// Sizes lists the thumbnails rendered for every uploaded image, smallest first
+var Sizes = []Size{
+	{Name: "small", Max: 150},
+	{Name: "medium", Max: 400},
+	{Name: "large", Max: 800},
+}
+
+// Decode checks the dimensions of an image and decodes it. It returns the image and its
+// format: "jpeg", "png" or "gif".
+func Decode(data []byte) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupported
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", ErrUnsupported
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	var img image.Image
+	switch format {
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, "", ErrUnsupported
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, format, nil
+}
+
+// Fit returns the dimensions of a width × height image scaled down to fit a max × max box,
+// keeping its aspect ratio. Images that already fit keep their size.
+func Fit(width, height, max int) (int, int) {
+	if width <= max && height <= max {
+		return width, height
+	}
+	if width >= height {
+		h := (height*max + width/2) / width
+		if h < 1 {
+			h = 1
+		}
+		return max, h
+	}
+	w := (width*max + height/2) / height
+	if w < 1 {
+		w = 1
+	}
+	return w, max
+}
+
+// Thumbnail scales an image down to fit a max × max box. Every pixel of the thumbnail is
+// the average of the source pixels it covers, which avoids the aliasing of nearest-neighbour
+// scaling.
+func Thumbnail(src image.Image, max int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := Fit(sw, sh, max)
+
+	rgba := toRGBA(src)
+	if dw == sw && dh == sh {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += uint64(row[i])
+					sum[1] += uint64(row[i+1])
+					sum[2] += uint64(row[i+2])
+					sum[3] += uint64(row[i+3])
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			offset := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// toRGBA converts an image to premultiplied RGBA pixels starting at the origin, so that
+// transparent pixels do not bleed their colour when averaged
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, src, bounds.Min, draw.Src)
+	return rgba
+}
+
+// Rendered is an encoded thumbnail
+type Rendered struct {
+	Size        Size
+	Data        []byte
+	ContentType string
+}
+
+// Render renders the thumbnails of every size in Sizes. They are all JPEG, or all PNG when
+// the image has transparent pixels.
+func Render(src image.Image) ([]Rendered, error) {
+	rgba := toRGBA(src)
+	opaque := rgba.Opaque()
+	thumbnails := make([]Rendered, 0, len(Sizes))
+	for _, size := range Sizes {
+		data, contentType, err := encode(Thumbnail(rgba, size.Max), opaque)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, Rendered{Size: size, Data: data, ContentType: contentType})
+	}
+	return thumbnails, nil
+}
+
+// Encode encodes a thumbnail as JPEG, or as PNG when it has transparent pixels. It returns
+// the encoded data and its content type.
+func Encode(img *image.RGBA) ([]byte, string, error) {
+	return encode(img, img.Opaque())
+}
+
+// encode encodes an image as JPEG or PNG
+func encode(img *image.RGBA, asJPEG bool) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if asJPEG {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEGQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
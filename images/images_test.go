@@ -0,0 +1,78 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFit(t *testing.T) {
+	cases := []struct{ w, h, max, ew, eh int }{
+		{100, 50, 150, 100, 50},
+		{1600, 800, 400, 400, 200},
+		{800, 1600, 400, 200, 400},
+		{1000, 1, 150, 150, 1},
+		{300, 300, 150, 150, 150},
+	}
+	for _, c := range cases {
+		w, h := Fit(c.w, c.h, c.max)
+		assert.Equal(t, [2]int{c.ew, c.eh}, [2]int{w, h}, "%dx%d in %d", c.w, c.h, c.max)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// Alternating black and white columns average to grey
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	thumb := Thumbnail(src, 100)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), thumb.Bounds())
+	assert.Equal(t, color.RGBA{128, 128, 128, 255}, thumb.RGBAAt(10, 10))
+	data, contentType, err := Encode(thumb)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	_, format, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	// Small images are not enlarged, transparent ones stay PNG
+	small := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	small.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	thumb = Thumbnail(small, 150)
+	assert.Equal(t, image.Rect(0, 0, 20, 10), thumb.Bounds())
+	_, contentType, err = Encode(thumb)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2))))
+	img, format, err := Decode(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), img.Bounds())
+
+	_, _, err = Decode([]byte("not an image"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	// A tiny GIF whose header claims 65535 × 65535 pixels is rejected before decoding
+	buf.Reset()
+	assert.NoError(t, gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil))
+	bomb := buf.Bytes()
+	copy(bomb[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+	_, _, err = Decode(bomb)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
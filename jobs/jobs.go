@@ -0,0 +1,26 @@
+// Package jobs runs periodic background maintenance tasks.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every calls fn once per interval until ctx is cancelled, logging any error it returns.
+// It blocks, so callers normally start it in its own goroutine.
+func Every(ctx context.Context, interval time.Duration, name string, fn func(now time.Time) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := fn(now); err != nil {
+				log.Printf("job %s: %v", name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package keys manages the asymmetric keys used to sign and verify JWTs.
+// Keys are PEM files in a directory, each named after its key ID (kid): a private key
+// can sign and verify, a public key only verifies. Keeping the previous key's public half
+// next to the new signing key lets tokens issued before a rotation stay valid until they expire.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key is one verification key, optionally able to sign
+type Key struct {
+	ID         string            // Key ID published in the kid header and the JWKS
+	Method     jwt.SigningMethod // RS256 for RSA keys, EdDSA for Ed25519 keys
+	PublicKey  crypto.PublicKey
+	PrivateKey crypto.PrivateKey // Nil for verification-only keys
+}
+
+// KeySet holds the signing key and every key accepted for verification
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// ErrUnknownKey is returned when a token names a key ID that is not in the set
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// New builds a KeySet from the given keys, signing with the key whose ID is signingID
+func New(signingID string, keys ...*Key) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if _, ok := set.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", k.ID)
+		}
+		set.keys[k.ID] = k
+	}
+
+	signing, ok := set.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingID)
+	}
+	if signing.PrivateKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingID)
+	}
+	set.signing = signing
+	return set, nil
+}
+
+// LoadDir reads every .pem file in dir and signs with the key whose file name is signingID
+func LoadDir(dir, signingID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem keys found in %s", dir)
+	}
+
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := ParsePEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return New(signingID, keys...)
+}
+
+// ParsePEM parses an RSA or Ed25519 key, private (PKCS#8 or PKCS#1) or public (PKIX)
+func ParsePEM(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key := &Key{ID: kid}
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey = priv
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey = priv
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.PublicKey = pub
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	// Derive the public key and the algorithm from the key type
+	switch k := key.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		key.PublicKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.PublicKey = k.Public()
+	}
+	switch key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, expected RSA or Ed25519", key.PublicKey)
+	}
+	return key, nil
+}
+
+// Generate creates a KeySet with a fresh Ed25519 key. It is meant for development and
+// tests: tokens signed with it stop verifying when the process exits.
+func Generate() (*KeySet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := "ephemeral-" + base64.RawURLEncoding.EncodeToString(pub[:6])
+	return New(kid, &Key{ID: kid, Method: jwt.SigningMethodEdDSA, PublicKey: pub, PrivateKey: priv})
+}
+
+// Sign creates a token for claims signed with the current signing key
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.PrivateKey)
+}
+
+// Keyfunc resolves the verification key of a token for jwt.Parse. It only accepts
+// the algorithm that belongs to the named key, ruling out algorithm substitution.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.PublicKey, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is a JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of all verification keys, ordered by key ID
+func (s *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
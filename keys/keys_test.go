@@ -0,0 +1,110 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKeys writes an RSA private key "old", an Ed25519 private key "new" and
+// the public half of a second Ed25519 key "retired" into a temporary directory
+func writeKeys(t *testing.T) string {
+	dir := t.TempDir()
+	write := func(name, blockType string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"), data, 0o600))
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	write("old", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+	write("new", "PRIVATE KEY", der)
+
+	retired, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(retired)
+	assert.NoError(t, err)
+	write("retired", "PUBLIC KEY", der)
+	return dir
+}
+
+func claims() jwt.StandardClaims {
+	return jwt.StandardClaims{Issuer: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+}
+
+func TestLoadDir_Rotation(t *testing.T) {
+	dir := writeKeys(t)
+
+	// Tokens issued with the old RSA key...
+	before, err := LoadDir(dir, "old")
+	assert.NoError(t, err)
+	oldToken, err := before.Sign(claims())
+	assert.NoError(t, err)
+
+	// ...still verify after switching the signing key to the Ed25519 key
+	after, err := LoadDir(dir, "new")
+	assert.NoError(t, err)
+	newToken, err := after.Sign(claims())
+	assert.NoError(t, err)
+
+	for _, raw := range []string{oldToken, newToken} {
+		token, err := jwt.ParseWithClaims(raw, &jwt.StandardClaims{}, after.Keyfunc)
+		assert.NoError(t, err)
+		assert.True(t, token.Valid)
+	}
+
+	parsed, _ := jwt.Parse(newToken, after.Keyfunc)
+	assert.Equal(t, "new", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+
+	// A public key cannot be used for signing
+	_, err = LoadDir(dir, "retired")
+	assert.Error(t, err)
+}
+
+func TestKeyfunc_RejectsForeignTokens(t *testing.T) {
+	set, err := Generate()
+	assert.NoError(t, err)
+
+	// HS256 token claiming to use our key ID
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = set.signing.ID
+	raw, _ := forged.SignedString([]byte("secret"))
+	_, err = jwt.Parse(raw, set.Keyfunc)
+	assert.Error(t, err)
+
+	// Token from an unknown key
+	other, _ := Generate()
+	raw, _ = other.Sign(claims())
+	_, err = jwt.Parse(raw, set.Keyfunc)
+	assert.Error(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	set, err := LoadDir(writeKeys(t), "new")
+	assert.NoError(t, err)
+
+	jwks := set.JWKS()
+	assert.Len(t, jwks.Keys, 3)
+	assert.Equal(t, "new", jwks.Keys[0].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[0].Curve)
+	assert.Equal(t, "old", jwks.Keys[1].KeyID)
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	assert.Equal(t, "RS256", jwks.Keys[1].Algorithm)
+}
@@ -1,10 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/alwilion/config"
+	"github.com/alwilion/controllers"
 	"github.com/alwilion/database"
+	"github.com/alwilion/jobs"
+	"github.com/alwilion/keys"
+	"github.com/alwilion/models"
+	"github.com/alwilion/payments"
+	"github.com/alwilion/repository"
 	"github.com/alwilion/routes"
+	"github.com/alwilion/storage"
+	"github.com/alwilion/tax"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
@@ -13,20 +29,159 @@ func main() {
 	// Print a message indicating the start of the application
 	fmt.Println("Product Management")
 
+	// Load the configuration from the config file, environment and command-line flags
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Run a subcommand instead of the server when requested
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(cfg.Database, args[1:])
+		case "roles":
+			err = runRoles(cfg.Database, args[1:])
+		case "search":
+			err = runSearch(cfg.Database, args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q, expected migrate, roles or search", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Establish a connection to the database
-	database.DBconn()
+	database.DBconn(cfg.Database)
 
 	// Create a new Fiber app instance
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		AppName:      cfg.Server.AppName,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		BodyLimit:    cfg.Server.RequestBodyLimit(),
+	})
 
 	// Use CORS middleware to handle Cross-Origin Resource Sharing
 	app.Use(cors.New(cors.Config{
 		AllowCredentials: true, // Important when using an HTTP-only cookie, allows frontend to access and send back the cookie
 	}))
 
-	// Set up routes for the application
-	routes.Setup(app)
+	// Load the JWT signing keys, falling back to an ephemeral key for development
+	var keySet *keys.KeySet
+	if cfg.Auth.KeysDir != "" {
+		keySet, err = keys.LoadDir(cfg.Auth.KeysDir, cfg.Auth.SigningKey)
+	} else {
+		log.Println("auth.keys_dir is not set, signing tokens with an ephemeral key")
+		keySet, err = keys.Generate()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up routes for the application on top of the database-backed repositories
+	ctl := controllers.New(repository.NewGormStore(database.DB), keySet)
+	ctl.AccessTokenTTL = cfg.Auth.AccessTokenTTL
+	ctl.RefreshTokenTTL = cfg.Auth.RefreshTokenTTL
+	ctl.TrashRetention = cfg.Catalog.TrashRetention()
+
+	// Keep uploaded images in the configured storage, serving a local directory ourselves
+	ctl.MaxImageSize = int64(cfg.Storage.MaxImageSize)
+	switch cfg.Storage.Driver {
+	case config.StorageS3:
+		ctl.Storage = &storage.S3{
+			Endpoint:        cfg.Storage.Endpoint,
+			Bucket:          cfg.Storage.Bucket,
+			Region:          cfg.Storage.Region,
+			AccessKeyID:     cfg.Storage.AccessKeyID,
+			SecretAccessKey: cfg.Storage.SecretAccessKey,
+			PublicURL:       cfg.Storage.PublicURL,
+		}
+	default:
+		local, err := storage.NewLocal(cfg.Storage.Dir, cfg.Storage.BaseURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctl.Storage = local
+		app.Static(local.BaseURL, local.Dir)
+	}
+	// Take payments through the configured provider, whose callbacks come back to public_url
+	ctl.WebhookSecret = cfg.Payments.WebhookSecret
+	if ctl.WebhookSecret == "" {
+		log.Println("payments.webhook_secret is not set, signing payment webhooks with an ephemeral secret")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal(err)
+		}
+		ctl.WebhookSecret = hex.EncodeToString(secret)
+	}
+	publicURL := strings.TrimSuffix(cfg.Payments.PublicURL, "/")
+	ctl.PaymentProvider = payments.NewFake(ctl.WebhookSecret, publicURL+"/payments/webhook", publicURL+"/payments/fake")
+
+	// Tax carts and orders as configured, importing the rate table finance maintains
+	if ctl.TaxJurisdiction, err = normalizeJurisdiction(cfg.Tax.Jurisdiction); err != nil {
+		log.Fatal(err)
+	}
+	ctl.TaxInclusive = cfg.Tax.Pricing == config.TaxInclusive
+	ctl.TaxRounding = tax.Rounding(cfg.Tax.Rounding)
+	if cfg.Tax.RatesFile != "" {
+		if err := importTaxRates(ctl, cfg.Tax.RatesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	routes.Setup(app, ctl)
+
+	// Periodically drop expired refresh tokens and revocation entries
+	go jobs.Every(context.Background(), time.Hour, "purge expired tokens", ctl.Tokens.PurgeExpired)
+
+	// Return the units of expired stock reservations to the available stock
+	go jobs.Every(context.Background(), time.Minute, "expire stock reservations", ctl.Inventory.ExpireReservations)
+
+	// Apply scheduled price changes and end sales
+	go jobs.Every(context.Background(), time.Minute, "apply price schedules", ctl.ApplyPriceSchedules)
+
+	// Permanently delete products that have been in the trash for longer than the retention period
+	go jobs.Every(context.Background(), time.Hour, "purge product trash", ctl.PurgeTrash)
+
+	// Delete guest carts that have been abandoned
+	go jobs.Every(context.Background(), time.Hour, "purge guest carts", ctl.PurgeGuestCarts)
+
+	// Cancel pending orders that were not paid in time, releasing their stock
+	go jobs.Every(context.Background(), time.Minute, "expire pending orders", ctl.ExpireOrders)
+
+	// Start the application and listen on the configured address
+	log.Fatal(app.Listen(cfg.Server.Address()))
+}
+
+// normalizeJurisdiction validates the configured default jurisdiction, which may be empty
+func normalizeJurisdiction(code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	code, err := models.NormalizeJurisdiction(code)
+	if err != nil {
+		return "", fmt.Errorf("tax.jurisdiction: %w", err)
+	}
+	return code, nil
+}
 
-	// Start the application and listen on port 8000
-	app.Listen(":8000")
+// importTaxRates replaces the stored tax rates with the CSV table in the file
+func importTaxRates(ctl *controllers.Controllers, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("tax.rates_file: %w", err)
+	}
+	defer file.Close()
+	rates, err := tax.ReadCSV(file)
+	if err != nil {
+		return fmt.Errorf("tax.rates_file %s: %w", path, err)
+	}
+	if err := ctl.TaxRates.Replace(rates); err != nil {
+		return err
+	}
+	log.Printf("imported %d tax rates from %s", len(rates), path)
+	return nil
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/alwilion/config"
+	"github.com/alwilion/database"
+	"github.com/alwilion/migrations"
+)
+
+// migrateUsage describes the migrate subcommand
+const migrateUsage = `usage: app [flags] migrate <command>
+
+commands:
+  up [n]     apply all pending migrations, or only the next n
+  down [n]   revert the last applied migration, or the last n
+  status     list migrations and whether they are applied
+  redo       revert and re-apply the last applied migration`
+
+// runMigrate executes the migrate subcommand with the remaining command-line arguments
+func runMigrate(cfg config.DatabaseConfig, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	// Parse the optional step count used by up and down
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid step count %q\n%s", args[1], migrateUsage)
+		}
+		steps = n
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(steps)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		reverted, err := migrator.Down(steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+
+	case "redo":
+		m, err := migrator.Redo()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("redone   %04d_%s\n", m.Version, m.Name)
+		return nil
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			state, appliedAt := "pending", ""
+			if s.Applied {
+				state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state = "modified"
+			}
+			if s.Missing {
+				state = "missing"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
@@ -0,0 +1,415 @@
+// Package migrations manages the versioned database schema.
+// Each migration is a pair of numbered SQL files, NNNN_name.up.sql and NNNN_name.down.sql,
+// embedded into the binary. The files are text/template documents rendered with the Dialect
+// of the connected database, so one pair serves PostgreSQL, MySQL and SQLite alike.
+// Applied versions are recorded in the schema_migrations table together with a checksum of
+// their files, which is verified before any further migration runs.
+package migrations
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// Files returns the migration files compiled into the binary
+func Files() fs.FS {
+	sub, err := fs.Sub(embedded, "sql")
+	if err != nil {
+		panic(err) // The embedded directory always exists
+	}
+	return sub
+}
+
+// Dialect holds the database specific snippets available to migration templates
+type Dialect struct {
+	Name        string // Dialect name as reported by GORM: postgres, mysql or sqlite
+	Postgres    bool
+	MySQL       bool
+	SQLite      bool
+	PrimaryKey  string // Auto-incrementing integer primary key column definition
+	Reference   string // Integer type matching PrimaryKey, for foreign key columns
+	Timestamp   string // Timestamp with time zone, or the closest equivalent
+	Blob        string // Binary data
+	Decimal     string // Floating point number
+	IfNotExists string // "IF NOT EXISTS" for CREATE INDEX where supported
+}
+
+// dialects lists the supported databases by GORM dialector name
+var dialects = map[string]Dialect{
+	"postgres": {
+		Name:        "postgres",
+		Postgres:    true,
+		PrimaryKey:  "BIGSERIAL PRIMARY KEY",
+		Reference:   "BIGINT",
+		Timestamp:   "TIMESTAMPTZ",
+		Blob:        "BYTEA",
+		Decimal:     "DECIMAL",
+		IfNotExists: "IF NOT EXISTS",
+	},
+	"mysql": {
+		Name:       "mysql",
+		MySQL:      true,
+		PrimaryKey: "BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY",
+		Reference:  "BIGINT UNSIGNED",
+		Timestamp:  "DATETIME(3)",
+		Blob:       "LONGBLOB",
+		Decimal:    "DOUBLE",
+	},
+	"sqlite": {
+		Name:        "sqlite",
+		SQLite:      true,
+		PrimaryKey:  "INTEGER PRIMARY KEY AUTOINCREMENT",
+		Reference:   "INTEGER",
+		Timestamp:   "DATETIME",
+		Blob:        "BLOB",
+		Decimal:     "REAL",
+		IfNotExists: "IF NOT EXISTS",
+	},
+}
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version  int64  // Number taken from the file name prefix
+	Name     string // Rest of the file name, without direction and extension
+	Up       string // Raw SQL template applying the change
+	Down     string // Raw SQL template reverting the change
+	Checksum string // SHA-256 of the up and down templates
+}
+
+// Record is a row of the schema_migrations table
+type Record struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	Checksum  string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName overrides the table name used by GORM
+func (Record) TableName() string {
+	return "schema_migrations"
+}
+
+// Status describes one migration known to the files, the database or both
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	Missing   bool // Applied in the database but no longer present in the files
+	Modified  bool // Applied with a checksum that differs from the current files
+}
+
+// ErrChecksumMismatch is returned when an applied migration no longer matches its files
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// fileName matches migration file names such as 0003_add_price_index.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load reads and pairs the migration files found in fsys, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s: name must look like 0001_description.up.sql", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+		m.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations on a database
+type Migrator struct {
+	db         *gorm.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New creates a Migrator for db using the migrations embedded in the binary
+func New(db *gorm.DB) (*Migrator, error) {
+	return NewFromFS(db, Files())
+}
+
+// NewFromFS creates a Migrator for db using the migrations found in fsys
+func NewFromFS(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	dialect, ok := dialects[db.Dialector.Name()]
+	if !ok {
+		return nil, fmt.Errorf("migrations do not support the %s dialect", db.Dialector.Name())
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{db: db, dialect: dialect, migrations: migrations}
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ensureTable creates the schema_migrations table if needed
+func (m *Migrator) ensureTable() error {
+	return m.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at %s NOT NULL
+)`, m.dialect.Timestamp)).Error
+}
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied() (map[int64]Record, error) {
+	var records []Record
+	if err := m.db.Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]Record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Status reports every known migration in version order
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name}
+		if r, ok := applied[mig.Version]; ok {
+			appliedAt := r.AppliedAt
+			s.Applied = true
+			s.AppliedAt = &appliedAt
+			s.Modified = r.Checksum != mig.Checksum
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, r := range applied {
+		appliedAt := r.AppliedAt
+		statuses = append(statuses, Status{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: &appliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Verify fails with ErrChecksumMismatch if an applied migration was edited after it ran
+func (m *Migrator) Verify() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.Modified {
+			return fmt.Errorf("%w: %04d_%s was changed after it was applied", ErrChecksumMismatch, s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// Pending returns the migrations that have not been applied yet, in version order
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies up to n pending migrations, or all of them when n <= 0
+func (m *Migrator) Up(n int) ([]Migration, error) {
+	if err := m.Verify(); err != nil {
+		return nil, err
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n < len(pending) {
+		pending = pending[:n]
+	}
+
+	for i, mig := range pending {
+		if err := m.run(mig, true); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// Down reverts the n most recently applied migrations
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	if err := m.Verify(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if err := m.run(mig, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, mig)
+	}
+	return reverted, nil
+}
+
+// Redo reverts and re-applies the most recently applied migration
+func (m *Migrator) Redo() (*Migration, error) {
+	reverted, err := m.Down(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(reverted) == 0 {
+		return nil, errors.New("no applied migration to redo")
+	}
+	if err := m.run(reverted[0], true); err != nil {
+		return nil, err
+	}
+	return &reverted[0], nil
+}
+
+// run executes one direction of a migration and updates schema_migrations in a single transaction
+func (m *Migrator) run(mig Migration, up bool) error {
+	source, direction := mig.Down, "down"
+	if up {
+		source, direction = mig.Up, "up"
+	}
+	statements, err := m.render(source)
+	if err != nil {
+		return fmt.Errorf("migration %04d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		if up {
+			return tx.Create(&Record{Version: mig.Version, Name: mig.Name, Checksum: mig.Checksum, AppliedAt: time.Now().UTC()}).Error
+		}
+		return tx.Delete(&Record{}, mig.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %04d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	return nil
+}
+
+// render expands the template for the current dialect and splits it into statements
+func (m *Migrator) render(source string) ([]string, error) {
+	tmpl, err := template.New("migration").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, m.dialect); err != nil {
+		return nil, err
+	}
+	return splitStatements(buf.String()), nil
+}
+
+// splitStatements splits SQL on semicolons that end a line, keeping dollar-quoted
+// PostgreSQL function bodies intact. Comment-only statements are dropped.
+func splitStatements(sql string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		inDollar   bool
+	)
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.Count(line, "$$")%2 == 1 {
+			inDollar = !inDollar
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if !inDollar && strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = appendStatement(statements, current.String())
+			current.Reset()
+		}
+	}
+	return appendStatement(statements, current.String())
+}
+
+// appendStatement adds stmt to statements unless it only holds whitespace and comments
+func appendStatement(statements []string, stmt string) []string {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return append(statements, strings.TrimSpace(stmt))
+		}
+	}
+	return statements
+}
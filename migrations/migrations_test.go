@@ -0,0 +1,124 @@
+package migrations
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+func TestEmbeddedMigrations_UpDownRedo(t *testing.T) {
+	db := openTestDB(t)
+	m, err := New(db)
+	assert.NoError(t, err)
+
+	all, err := Load(Files())
+	assert.NoError(t, err)
+
+	applied, err := m.Up(0)
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(all))
+	assert.True(t, db.Migrator().HasTable("products"))
+
+	pending, err := m.Pending()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Every migration must revert cleanly and apply again
+	reverted, err := m.Down(len(all))
+	assert.NoError(t, err)
+	assert.Len(t, reverted, len(all))
+	assert.False(t, db.Migrator().HasTable("users"))
+
+	_, err = m.Up(0)
+	assert.NoError(t, err)
+	redone, err := m.Redo()
+	assert.NoError(t, err)
+	assert.Equal(t, all[len(all)-1].Version, redone.Version)
+}
+
+func TestMigrator_ChecksumVerification(t *testing.T) {
+	db := openTestDB(t)
+	files := fstest.MapFS{
+		"0001_create_things.up.sql":   {Data: []byte("CREATE TABLE things (id {{.PrimaryKey}});")},
+		"0001_create_things.down.sql": {Data: []byte("DROP TABLE things;")},
+	}
+	m, err := NewFromFS(db, files)
+	assert.NoError(t, err)
+	_, err = m.Up(0)
+	assert.NoError(t, err)
+
+	// Editing an applied migration must be detected
+	files["0001_create_things.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE things (id {{.PrimaryKey}}, name TEXT);")}
+	m, err = NewFromFS(db, files)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, m.Verify(), ErrChecksumMismatch)
+
+	statuses, err := m.Status()
+	assert.NoError(t, err)
+	assert.True(t, statuses[0].Modified)
+}
+
+func TestLoad_RequiresBothDirections(t *testing.T) {
+	_, err := Load(fstest.MapFS{"0001_only_up.up.sql": {Data: []byte("SELECT 1;")}})
+	assert.Error(t, err)
+
+	_, err = Load(fstest.MapFS{"create_things.up.sql": {Data: []byte("SELECT 1;")}})
+	assert.Error(t, err)
+}
+
+func TestSplitStatements(t *testing.T) {
+	sql := `-- leading comment
+CREATE TABLE a (id INT);
+CREATE FUNCTION f() RETURNS trigger AS $$
+BEGIN
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+`
+	statements := splitStatements(sql)
+	assert.Len(t, statements, 2)
+	assert.Contains(t, statements[1], "RETURN NEW;")
+}
+
+// MySQL ignores foreign keys declared inline on a column, so every cascading reference needs
+// a table constraint in the same CREATE TABLE statement as well
+func TestMySQLForeignKeys(t *testing.T) {
+	all, err := Load(Files())
+	assert.NoError(t, err)
+	m := &Migrator{dialect: dialects["mysql"]}
+	table := regexp.MustCompile(`CREATE TABLE (\w+)`)
+	inline := regexp.MustCompile(`(?m)^\s*(\w+) .*REFERENCES (\w+) \(id\) ON DELETE (CASCADE|SET NULL)`)
+	checked := 0
+	for _, mig := range all {
+		statements, err := m.render(mig.Up)
+		assert.NoError(t, err)
+		for _, stmt := range statements {
+			name := table.FindStringSubmatch(stmt)
+			if name == nil {
+				continue
+			}
+			for _, ref := range inline.FindAllStringSubmatch(stmt, -1) {
+				if ref[1] == "CONSTRAINT" {
+					continue
+				}
+				constraint := fmt.Sprintf(`CONSTRAINT \w+ FOREIGN KEY \(%s\) REFERENCES %s \(id\) ON DELETE %s`, ref[1], ref[2], ref[3])
+				assert.Regexp(t, constraint, stmt, "%s.%s", name[1], ref[1])
+				checked++
+			}
+		}
+	}
+	assert.Greater(t, checked, 20)
+}
@@ -0,0 +1,11 @@
+-- Users table. IF NOT EXISTS lets databases previously managed by AutoMigrate adopt this migration as-is.
+CREATE TABLE IF NOT EXISTS users (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    deleted_at {{.Timestamp}},
+    name TEXT,
+    email VARCHAR(255) UNIQUE,
+    password {{.Blob}}
+);
+CREATE INDEX {{.IfNotExists}} idx_users_deleted_at ON users (deleted_at);
@@ -0,0 +1,11 @@
+-- Products table. IF NOT EXISTS lets databases previously managed by AutoMigrate adopt this migration as-is.
+CREATE TABLE IF NOT EXISTS products (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    deleted_at {{.Timestamp}},
+    name TEXT,
+    description TEXT,
+    price {{.Decimal}}
+);
+CREATE INDEX {{.IfNotExists}} idx_products_deleted_at ON products (deleted_at);
@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    user_id {{.Reference}} NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at {{.Timestamp}} NOT NULL,
+    used_at {{.Timestamp}},
+    revoked_at {{.Timestamp}}{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_refresh_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at {{.Timestamp}} NOT NULL
+);
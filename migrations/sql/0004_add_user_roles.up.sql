@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN roles VARCHAR(255) NOT NULL DEFAULT 'buyer';
+-- Users created before roles existed could manage products, keep it that way
+UPDATE users SET roles = 'buyer,seller';
@@ -0,0 +1,7 @@
+{{if .MySQL}}
+ALTER TABLE products DROP FOREIGN KEY fk_products_seller;
+DROP INDEX idx_products_seller_id ON products;
+{{else}}
+DROP INDEX idx_products_seller_id;
+{{end}}
+ALTER TABLE products DROP COLUMN seller_id;
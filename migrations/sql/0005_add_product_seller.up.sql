@@ -0,0 +1,6 @@
+-- SQLite cannot drop a column that takes part in a foreign key, so the constraint is only declared on PostgreSQL and MySQL
+ALTER TABLE products ADD COLUMN seller_id {{.Reference}}{{if .Postgres}} REFERENCES users (id){{end}};
+CREATE INDEX idx_products_seller_id ON products (seller_id);
+{{if .MySQL}}
+ALTER TABLE products ADD CONSTRAINT fk_products_seller FOREIGN KEY (seller_id) REFERENCES users (id);
+{{end}}
@@ -0,0 +1,9 @@
+{{if .MySQL}}
+DROP INDEX idx_products_name_id ON products;
+DROP INDEX idx_products_created_at_id ON products;
+DROP INDEX idx_products_price_id ON products;
+{{else}}
+DROP INDEX idx_products_name_id;
+DROP INDEX idx_products_created_at_id;
+DROP INDEX idx_products_price_id;
+{{end}}
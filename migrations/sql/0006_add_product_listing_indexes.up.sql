@@ -0,0 +1,5 @@
+-- Composite indexes matching the sort orders of the product listing, with the ID as tie-breaker
+CREATE INDEX idx_products_price_id ON products (price, id);
+CREATE INDEX idx_products_created_at_id ON products (created_at, id);
+-- MySQL can only index a prefix of a TEXT column
+CREATE INDEX idx_products_name_id ON products ({{if .MySQL}}name(191){{else}}name{{end}}, id);
@@ -0,0 +1,5 @@
+{{if .Postgres}}
+DROP INDEX idx_products_search_vector;
+ALTER TABLE products DROP COLUMN search_vector;
+{{end}}
+DROP TABLE product_terms;
@@ -0,0 +1,19 @@
+-- Distinct lower-case words of each product's name and description, maintained by the product
+-- repository. It is the search index on MySQL and SQLite and the vocabulary for typo tolerance
+-- everywhere. Existing products are indexed by running "app search reindex".
+CREATE TABLE product_terms (
+    term VARCHAR(64) NOT NULL,
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    PRIMARY KEY (term, product_id){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_product_terms_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_product_terms_product_id ON product_terms (product_id);
+{{if .Postgres}}
+-- PostgreSQL matches and ranks with a weighted tsvector: A for the name, B for the description
+ALTER TABLE products ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(description, '')), 'B')
+) STORED;
+CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector);
+{{end}}
@@ -0,0 +1,20 @@
+-- Product taxonomy: categories nest through parent_id and products are assigned many-to-many
+CREATE TABLE categories (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(255) NOT NULL UNIQUE,
+    parent_id {{.Reference}} REFERENCES categories (id)
+);
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
+
+CREATE TABLE product_categories (
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    category_id {{.Reference}} NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    PRIMARY KEY (product_id, category_id){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_product_categories_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    CONSTRAINT fk_product_categories_category FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_product_categories_category_id ON product_categories (category_id);
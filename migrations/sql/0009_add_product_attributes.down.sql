@@ -0,0 +1,5 @@
+DROP TABLE product_tags;
+DROP TABLE product_attributes;
+DROP TABLE attribute_definitions;
+ALTER TABLE products DROP COLUMN tags;
+ALTER TABLE products DROP COLUMN attributes;
@@ -0,0 +1,37 @@
+-- Attribute values and tags are stored on the product for reading and copied into
+-- product_attributes and product_tags, which the listing filters use
+ALTER TABLE products ADD COLUMN attributes TEXT;
+ALTER TABLE products ADD COLUMN tags TEXT;
+
+CREATE TABLE attribute_definitions (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    category_id {{.Reference}} NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    options TEXT,
+    UNIQUE (category_id, name){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_attribute_definitions_category FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE{{end}}
+);
+
+-- Values are indexed in the form of models.AttributeIndexValue
+CREATE TABLE product_attributes (
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    PRIMARY KEY (product_id, name){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_product_attributes_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_product_attributes_name_value ON product_attributes (name, value);
+
+CREATE TABLE product_tags (
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (product_id, tag){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_product_tags_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_product_tags_tag ON product_tags (tag);
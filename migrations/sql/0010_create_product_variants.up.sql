@@ -0,0 +1,15 @@
+-- Variants are the purchasable versions of a product, such as one size and color of a shirt.
+-- Each has its own SKU and stock and may override the price of its product.
+CREATE TABLE product_variants (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    sku VARCHAR(64) NOT NULL UNIQUE,
+    options TEXT,
+    price {{.Decimal}},
+    stock INTEGER NOT NULL DEFAULT 0{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_product_variants_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_product_variants_product_id ON product_variants (product_id);
@@ -0,0 +1,8 @@
+ALTER TABLE product_variants ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+UPDATE product_variants SET stock = COALESCE((
+    SELECT on_hand FROM inventory_levels
+    WHERE inventory_levels.product_id = product_variants.product_id AND inventory_levels.variant_id = product_variants.id
+), 0);
+DROP TABLE stock_movements;
+DROP TABLE stock_reservations;
+DROP TABLE inventory_levels;
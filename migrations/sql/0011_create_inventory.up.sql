@@ -0,0 +1,51 @@
+-- Stock per product, or per variant for products with variants. The levels are derived
+-- from stock_movements, the append-only ledger every change is recorded in.
+CREATE TABLE inventory_levels (
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    variant_id {{.Reference}} NOT NULL DEFAULT 0,
+    on_hand INTEGER NOT NULL DEFAULT 0,
+    reserved INTEGER NOT NULL DEFAULT 0,
+    updated_at {{.Timestamp}},
+    PRIMARY KEY (product_id, variant_id){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_inventory_levels_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+
+CREATE TABLE stock_reservations (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    variant_id {{.Reference}} NOT NULL DEFAULT 0,
+    quantity INTEGER NOT NULL,
+    expires_at {{.Timestamp}} NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    reference VARCHAR(255){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_stock_reservations_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_stock_reservations_status_expires_at ON stock_reservations (status, expires_at);
+
+CREATE TABLE stock_movements (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    variant_id {{.Reference}} NOT NULL DEFAULT 0,
+    kind VARCHAR(16) NOT NULL,
+    quantity INTEGER NOT NULL,
+    on_hand INTEGER NOT NULL,
+    reserved INTEGER NOT NULL,
+    reservation_id {{.Reference}} REFERENCES stock_reservations (id),
+    user_id {{.Reference}} REFERENCES users (id),
+    note VARCHAR(255){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_stock_movements_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_stock_movements_product_id ON stock_movements (product_id, id);
+
+-- The stock of variants moves into the ledger as opening balances
+INSERT INTO inventory_levels (product_id, variant_id, on_hand, reserved, updated_at)
+SELECT product_id, id, stock, 0, CURRENT_TIMESTAMP FROM product_variants WHERE stock <> 0;
+INSERT INTO stock_movements (created_at, product_id, variant_id, kind, quantity, on_hand, reserved, note)
+SELECT CURRENT_TIMESTAMP, product_id, id, 'receive', stock, stock, 0, 'opening balance' FROM product_variants WHERE stock <> 0;
+ALTER TABLE product_variants DROP COLUMN stock;
@@ -0,0 +1,17 @@
+DROP TABLE exchange_rates;
+
+ALTER TABLE product_variants ADD COLUMN price {{.Decimal}};
+UPDATE product_variants SET price = price_amount / 100.0 WHERE price_amount IS NOT NULL;
+ALTER TABLE product_variants DROP COLUMN price_amount;
+
+ALTER TABLE products ADD COLUMN price {{.Decimal}};
+UPDATE products SET price = base_price / 100.0;
+{{if .MySQL}}
+DROP INDEX idx_products_base_price_id ON products;
+{{else}}
+DROP INDEX idx_products_base_price_id;
+{{end}}
+ALTER TABLE products DROP COLUMN base_price;
+ALTER TABLE products DROP COLUMN price_currency;
+ALTER TABLE products DROP COLUMN price_amount;
+CREATE INDEX idx_products_price_id ON products (price, id);
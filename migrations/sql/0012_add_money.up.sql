@@ -0,0 +1,26 @@
+-- Prices are exact amounts in minor units of an ISO 4217 currency. base_price holds the price
+-- converted into the base currency so listings can filter and sort across currencies.
+-- Existing prices were entered without a currency and are taken to be US dollars.
+ALTER TABLE products ADD COLUMN price_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN price_currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE products ADD COLUMN base_price BIGINT NOT NULL DEFAULT 0;
+UPDATE products SET price_amount = ROUND(price * 100), base_price = ROUND(price * 100) WHERE price IS NOT NULL;
+{{if .MySQL}}
+DROP INDEX idx_products_price_id ON products;
+{{else}}
+DROP INDEX idx_products_price_id;
+{{end}}
+ALTER TABLE products DROP COLUMN price;
+CREATE INDEX idx_products_base_price_id ON products (base_price, id);
+
+-- Variant prices override the product price in the currency of the product
+ALTER TABLE product_variants ADD COLUMN price_amount BIGINT;
+UPDATE product_variants SET price_amount = ROUND(price * 100) WHERE price IS NOT NULL;
+ALTER TABLE product_variants DROP COLUMN price;
+
+-- Units of each currency worth one unit of the base currency, maintained by admins
+CREATE TABLE exchange_rates (
+    currency CHAR(3) NOT NULL PRIMARY KEY,
+    rate VARCHAR(32) NOT NULL,
+    updated_at {{.Timestamp}}
+);
@@ -0,0 +1,2 @@
+DROP TABLE price_schedules;
+DROP TABLE price_changes;
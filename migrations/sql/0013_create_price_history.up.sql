@@ -0,0 +1,35 @@
+-- Every change of a product price, whether made by hand or by a schedule
+CREATE TABLE price_changes (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    old_amount BIGINT NOT NULL,
+    old_currency CHAR(3) NOT NULL,
+    new_amount BIGINT NOT NULL,
+    new_currency CHAR(3) NOT NULL,
+    reason VARCHAR(16) NOT NULL,
+    schedule_id {{.Reference}},
+    user_id {{.Reference}} REFERENCES users (id){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_price_changes_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_price_changes_product_id ON price_changes (product_id, id);
+
+-- Future price changes and time-boxed sales, applied and reverted by a background job
+CREATE TABLE price_schedules (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    price_amount BIGINT NOT NULL,
+    price_currency CHAR(3) NOT NULL,
+    starts_at {{.Timestamp}} NOT NULL,
+    ends_at {{.Timestamp}},
+    revert_amount BIGINT,
+    status VARCHAR(16) NOT NULL,
+    user_id {{.Reference}} REFERENCES users (id){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_price_schedules_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_price_schedules_product_id ON price_schedules (product_id, starts_at);
+CREATE INDEX idx_price_schedules_status ON price_schedules (status);
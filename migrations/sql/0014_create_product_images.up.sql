@@ -0,0 +1,18 @@
+-- Pictures of products. The files live in the file storage under storage_key; position orders the
+-- gallery and the primary image is the one shown in listings.
+CREATE TABLE product_images (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    storage_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(32) NOT NULL,
+    thumbnail_type VARCHAR(32) NOT NULL,
+    size BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_product_images_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE INDEX idx_product_images_product_id ON product_images (product_id, position);
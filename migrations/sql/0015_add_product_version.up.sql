@@ -0,0 +1,3 @@
+-- Every update of a product increments its version, which clients send back in If-Match
+-- so that concurrent edits fail instead of overwriting each other
+ALTER TABLE products ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
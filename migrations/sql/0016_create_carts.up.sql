@@ -0,0 +1,32 @@
+-- Shopping carts of users, at most one each, and of guests, who are identified by the hash of
+-- an anonymous token. Guest carts are purged once they have not changed for a while.
+CREATE TABLE carts (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    user_id {{.Reference}} REFERENCES users (id) ON DELETE CASCADE,
+    token_hash VARCHAR(64){{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_carts_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE{{end}}
+);
+CREATE UNIQUE INDEX idx_carts_user_id ON carts (user_id);
+CREATE UNIQUE INDEX idx_carts_token_hash ON carts (token_hash);
+CREATE INDEX idx_carts_updated_at ON carts (updated_at);
+
+-- The price is a snapshot of the unit price taken when the item was added, so buyers can be
+-- warned when the price changes before they check out
+CREATE TABLE cart_items (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    cart_id {{.Reference}} NOT NULL REFERENCES carts (id) ON DELETE CASCADE,
+    product_id {{.Reference}} NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    variant_id {{.Reference}} NOT NULL DEFAULT 0,
+    quantity INTEGER NOT NULL,
+    price_amount BIGINT NOT NULL,
+    price_currency CHAR(3) NOT NULL{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_cart_items_cart FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE,
+    CONSTRAINT fk_cart_items_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE{{end}}
+);
+CREATE UNIQUE INDEX idx_cart_items_product ON cart_items (cart_id, product_id, variant_id);
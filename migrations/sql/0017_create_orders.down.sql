@@ -0,0 +1,3 @@
+ALTER TABLE carts DROP COLUMN checkout_started_at;
+DROP TABLE order_lines;
+DROP TABLE orders;
@@ -0,0 +1,48 @@
+-- Orders placed by checking out a cart, one per seller. Each state an order reached is
+-- recorded with the time of the transition.
+CREATE TABLE orders (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    buyer_id {{.Reference}} NOT NULL REFERENCES users (id),
+    seller_id {{.Reference}} REFERENCES users (id),
+    status VARCHAR(16) NOT NULL,
+    subtotal_amount BIGINT NOT NULL,
+    subtotal_currency CHAR(3) NOT NULL,
+    total_amount BIGINT NOT NULL,
+    total_currency CHAR(3) NOT NULL,
+    expires_at {{.Timestamp}},
+    paid_at {{.Timestamp}},
+    fulfilled_at {{.Timestamp}},
+    shipped_at {{.Timestamp}},
+    delivered_at {{.Timestamp}},
+    cancelled_at {{.Timestamp}},
+    refunded_at {{.Timestamp}}
+);
+CREATE INDEX idx_orders_buyer_id ON orders (buyer_id, id);
+CREATE INDEX idx_orders_seller_id ON orders (seller_id, id);
+CREATE INDEX idx_orders_status_expires_at ON orders (status, expires_at);
+
+-- Lines keep a copy of the name and price at checkout, so they outlive purged products
+CREATE TABLE order_lines (
+    id {{.PrimaryKey}},
+    order_id {{.Reference}} NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    product_id {{.Reference}} REFERENCES products (id) ON DELETE SET NULL,
+    variant_id {{.Reference}} NOT NULL DEFAULT 0,
+    name VARCHAR(255) NOT NULL,
+    sku VARCHAR(64) NOT NULL DEFAULT '',
+    quantity INTEGER NOT NULL,
+    unit_price_amount BIGINT NOT NULL,
+    unit_price_currency CHAR(3) NOT NULL,
+    total_amount BIGINT NOT NULL,
+    total_currency CHAR(3) NOT NULL,
+    reservation_id {{.Reference}} REFERENCES stock_reservations (id) ON DELETE SET NULL{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_order_lines_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    CONSTRAINT fk_order_lines_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE SET NULL,
+    CONSTRAINT fk_order_lines_reservation FOREIGN KEY (reservation_id) REFERENCES stock_reservations (id) ON DELETE SET NULL{{end}}
+);
+CREATE INDEX idx_order_lines_order_id ON order_lines (order_id);
+
+-- Set while a checkout of the cart runs, so a second submit does not place the orders again
+ALTER TABLE carts ADD COLUMN checkout_started_at {{.Timestamp}};
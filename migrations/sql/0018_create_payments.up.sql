@@ -0,0 +1,18 @@
+-- Attempts to pay orders through a payment provider, which knows them by reference
+CREATE TABLE payments (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    order_id {{.Reference}} NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    provider VARCHAR(32) NOT NULL,
+    reference VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    amount_amount BIGINT NOT NULL,
+    amount_currency CHAR(3) NOT NULL,
+    action_url VARCHAR(255) NOT NULL DEFAULT '',
+    failure_reason VARCHAR(255) NOT NULL DEFAULT ''{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_payments_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE{{end}}
+);
+CREATE UNIQUE INDEX idx_payments_provider_reference ON payments (provider, reference);
+CREATE INDEX idx_payments_order_id ON payments (order_id);
@@ -0,0 +1,6 @@
+ALTER TABLE order_lines DROP COLUMN discount_currency;
+ALTER TABLE order_lines DROP COLUMN discount_amount;
+ALTER TABLE orders DROP COLUMN discount_currency;
+ALTER TABLE orders DROP COLUMN discount_amount;
+DROP TABLE promotion_redemptions;
+DROP TABLE promotions;
@@ -0,0 +1,49 @@
+-- Discount rules maintained by admins. Promotions with a code are coupons buyers enter,
+-- the others apply to every cart they match.
+CREATE TABLE promotions (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    updated_at {{.Timestamp}},
+    name VARCHAR(255) NOT NULL,
+    code VARCHAR(64),
+    kind VARCHAR(16) NOT NULL,
+    percent INTEGER NOT NULL DEFAULT 0,
+    amount_amount BIGINT NOT NULL DEFAULT 0,
+    amount_currency CHAR(3) NOT NULL DEFAULT '',
+    buy_quantity INTEGER NOT NULL DEFAULT 0,
+    get_quantity INTEGER NOT NULL DEFAULT 0,
+    scope VARCHAR(16) NOT NULL,
+    target_ids TEXT,
+    starts_at {{.Timestamp}},
+    ends_at {{.Timestamp}},
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    max_uses_per_user INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    exclusive BOOLEAN NOT NULL DEFAULT FALSE,
+    priority INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+CREATE UNIQUE INDEX idx_promotions_code ON promotions (code);
+
+-- Checkouts that used a promotion, counted against its caps per user
+CREATE TABLE promotion_redemptions (
+    id {{.PrimaryKey}},
+    created_at {{.Timestamp}},
+    promotion_id {{.Reference}} NOT NULL REFERENCES promotions (id) ON DELETE CASCADE,
+    user_id {{.Reference}} NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    order_id {{.Reference}} REFERENCES orders (id) ON DELETE SET NULL{{if .MySQL}},
+    -- MySQL ignores the inline references
+    CONSTRAINT fk_promotion_redemptions_promotion FOREIGN KEY (promotion_id) REFERENCES promotions (id) ON DELETE CASCADE,
+    CONSTRAINT fk_promotion_redemptions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_promotion_redemptions_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE SET NULL{{end}}
+);
+CREATE INDEX idx_promotion_redemptions_promotion_user ON promotion_redemptions (promotion_id, user_id);
+CREATE INDEX idx_promotion_redemptions_user ON promotion_redemptions (user_id);
+
+-- Discounts of orders and their lines, in the currency of the order
+ALTER TABLE orders ADD COLUMN discount_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN discount_currency CHAR(3) NOT NULL DEFAULT '';
+UPDATE orders SET discount_currency = total_currency;
+ALTER TABLE order_lines ADD COLUMN discount_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_lines ADD COLUMN discount_currency CHAR(3) NOT NULL DEFAULT '';
+UPDATE order_lines SET discount_currency = unit_price_currency;
@@ -0,0 +1,10 @@
+ALTER TABLE order_lines DROP COLUMN tax_currency;
+ALTER TABLE order_lines DROP COLUMN tax_amount;
+ALTER TABLE order_lines DROP COLUMN tax_class;
+ALTER TABLE orders DROP COLUMN taxes;
+ALTER TABLE orders DROP COLUMN jurisdiction;
+ALTER TABLE orders DROP COLUMN tax_inclusive;
+ALTER TABLE orders DROP COLUMN tax_currency;
+ALTER TABLE orders DROP COLUMN tax_amount;
+ALTER TABLE categories DROP COLUMN tax_class;
+DROP TABLE tax_rates;
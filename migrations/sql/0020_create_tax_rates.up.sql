@@ -0,0 +1,25 @@
+-- Tax rates maintained by finance, usually imported from a CSV table. A jurisdiction levies
+-- its own rates and those of the jurisdictions containing it, one per name and tax class.
+CREATE TABLE tax_rates (
+    id {{.PrimaryKey}},
+    jurisdiction VARCHAR(64) NOT NULL,
+    class VARCHAR(32) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    rate VARCHAR(32) NOT NULL
+);
+CREATE UNIQUE INDEX idx_tax_rates_jurisdiction_class_name ON tax_rates (jurisdiction, class, name);
+
+-- Categories name the tax class of their products, or inherit the one of their parent
+ALTER TABLE categories ADD COLUMN tax_class VARCHAR(32) NOT NULL DEFAULT '';
+
+-- Taxes of orders and their lines, in the currency of the order
+ALTER TABLE orders ADD COLUMN tax_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tax_currency CHAR(3) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE orders ADD COLUMN jurisdiction VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN taxes TEXT;
+UPDATE orders SET tax_currency = total_currency;
+ALTER TABLE order_lines ADD COLUMN tax_class VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE order_lines ADD COLUMN tax_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_lines ADD COLUMN tax_currency CHAR(3) NOT NULL DEFAULT '';
+UPDATE order_lines SET tax_currency = unit_price_currency;
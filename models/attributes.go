@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AttributeType is the kind of value an attribute holds
+type AttributeType string
+
+// Supported attribute types
+const (
+	AttributeString  AttributeType = "string"  // Free text
+	AttributeNumber  AttributeType = "number"  // Any number
+	AttributeEnum    AttributeType = "enum"    // One of a fixed list of options
+	AttributeBoolean AttributeType = "boolean" // true or false
+)
+
+// IsValidAttributeType reports whether t is one of the supported attribute types
+func IsValidAttributeType(t AttributeType) bool {
+	switch t {
+	case AttributeString, AttributeNumber, AttributeEnum, AttributeBoolean:
+		return true
+	}
+	return false
+}
+
+// AttributeDefinition declares an attribute that products of a category, or of any of its
+// subcategories, may carry
+type AttributeDefinition struct {
+	ID         uint          `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	CategoryID uint          `json:"category_id"`                        // Category the attribute belongs to
+	Name       string        `json:"name"`                               // Key of the attribute on products, such as "color"
+	Type       AttributeType `json:"type"`                               // Kind of value
+	Options    StringList    `json:"options,omitempty" gorm:"type:text"` // Allowed values of enum attributes
+}
+
+// Validate checks a value decoded from JSON against the definition and returns it in canonical form
+func (d AttributeDefinition) Validate(value interface{}) (interface{}, error) {
+	switch d.Type {
+	case AttributeNumber:
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("attribute %s must be a number", d.Name)
+	case AttributeBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("attribute %s must be true or false", d.Name)
+	}
+
+	s, ok := value.(string)
+	if !ok || strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("attribute %s must be a non-empty string", d.Name)
+	}
+	s = strings.TrimSpace(s)
+	if d.Type == AttributeEnum {
+		for _, option := range d.Options {
+			if strings.EqualFold(option, s) {
+				return option, nil
+			}
+		}
+		return nil, fmt.Errorf("attribute %s must be one of %s", d.Name, strings.Join(d.Options, ", "))
+	}
+	return s, nil
+}
+
+// FilterValue parses a value given in a query string into the indexed form of AttributeIndexValue
+func (d AttributeDefinition) FilterValue(text string) (string, error) {
+	switch d.Type {
+	case AttributeNumber:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return "", fmt.Errorf("attribute %s must be a number", d.Name)
+		}
+		return AttributeIndexValue(n), nil
+	case AttributeBoolean:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return "", fmt.Errorf("attribute %s must be true or false", d.Name)
+		}
+		return AttributeIndexValue(b), nil
+	}
+	return AttributeIndexValue(text), nil
+}
+
+// AttributeIndexValue returns the form an attribute value is indexed and filtered by:
+// numbers in shortest notation, booleans as true or false and strings in lower case
+func AttributeIndexValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return strings.ToLower(strings.TrimSpace(v))
+	}
+	return fmt.Sprint(value)
+}
+
+// Attributes holds the attribute values of a product by attribute name. Values are strings,
+// float64 numbers or booleans, as decoded from JSON.
+type Attributes map[string]interface{}
+
+// Value implements driver.Valuer
+func (a Attributes) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(a)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (a *Attributes) Scan(value interface{}) error {
+	*a = Attributes{}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return scanJSON(v, a)
+	case []byte:
+		return scanJSON(string(v), a)
+	}
+	return fmt.Errorf("cannot scan %T into Attributes", value)
+}
+
+// StringList is a list of strings stored as a JSON array
+type StringList []string
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(value interface{}) error {
+	*l = StringList{}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return scanJSON(v, l)
+	case []byte:
+		return scanJSON(string(v), l)
+	}
+	return fmt.Errorf("cannot scan %T into StringList", value)
+}
+
+// Tags are the lower-case labels of a product, stored comma-separated
+type Tags []string
+
+// Value implements driver.Valuer
+func (t Tags) Value() (driver.Value, error) {
+	return strings.Join(t, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (t *Tags) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", value)
+	}
+
+	*t = Tags{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*t = append(*t, tag)
+		}
+	}
+	return nil
+}
+
+// Has reports whether the tag is present
+func (t Tags) Has(tag string) bool {
+	for _, have := range t {
+		if have == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// scanJSON decodes a JSON column, treating an empty string as no value
+func scanJSON(s string, target interface{}) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), target)
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Cart collects the products a buyer intends to purchase. Every user has at most one cart;
+// guests get a cart identified by an anonymous token, merged into the user's cart on login.
+type Cart struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"` // Last change of the cart or its items
+	UserID            *uint      `json:"user_id"`    // Owner of the cart, nil for guest carts
+	TokenHash         *string    `json:"-"`          // SHA-256 of the guest token, nil for carts of users
+	CheckoutStartedAt *time.Time `json:"-"`          // Start of the checkout running on the cart, nil if none is
+	Items             []CartItem `json:"items" gorm:"-"`
+}
+
+// IsGuest reports whether the cart belongs to a guest rather than a user
+func (c Cart) IsGuest() bool {
+	return c.UserID == nil
+}
+
+// Item returns the item of the cart holding the product or variant, or nil
+func (c Cart) Item(productID, variantID uint) *CartItem {
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID && c.Items[i].VariantID == variantID {
+			return &c.Items[i]
+		}
+	}
+	return nil
+}
+
+// CartItem is a product, or one of its variants, in a cart
+type CartItem struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CartID    uint      `json:"cart_id"`
+	ProductID uint      `json:"product_id"`
+	VariantID uint      `json:"variant_id"` // Zero for products without variants
+	Quantity  int       `json:"quantity"`
+	Price     Money     `json:"snapshot_price" gorm:"embedded;embeddedPrefix:price_"` // Unit price when the item was added or its quantity last changed
+}
+
+// TableName maps CartItem onto the cart_items table
+func (CartItem) TableName() string {
+	return "cart_items"
+}
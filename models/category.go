@@ -0,0 +1,132 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// Category is a node of the product taxonomy. Top-level departments have no parent.
+type Category struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`      // Display name
+	Slug      string    `json:"slug"`      // Unique URL-friendly name, usable instead of the ID in filters
+	ParentID  *uint     `json:"parent_id"` // Parent category, nil for top-level categories
+	TaxClass  string    `json:"tax_class"` // Tax class of the products in the category, empty to inherit the one of the parent
+}
+
+// CategoryNode is a category together with its subcategories
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// CategoryTree arranges categories into trees, ordered by name on every level
+func CategoryTree(categories []Category) []*CategoryNode {
+	nodes := make(map[uint]*CategoryNode, len(categories))
+	for _, c := range categories {
+		nodes[c.ID] = &CategoryNode{Category: c, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, c := range categories {
+		if parent, ok := nodes[derefID(c.ParentID)]; ok && c.ParentID != nil {
+			parent.Children = append(parent.Children, nodes[c.ID])
+		} else {
+			roots = append(roots, nodes[c.ID])
+		}
+	}
+
+	// Sort every level by name, then by ID
+	var sortNodes func([]*CategoryNode)
+	sortNodes = func(level []*CategoryNode) {
+		sort.Slice(level, func(i, j int) bool {
+			if level[i].Name != level[j].Name {
+				return level[i].Name < level[j].Name
+			}
+			return level[i].ID < level[j].ID
+		})
+		for _, n := range level {
+			sortNodes(n.Children)
+		}
+	}
+	sortNodes(roots)
+	return roots
+}
+
+// CategoryDescendants returns the given category IDs together with the IDs of all categories below them
+func CategoryDescendants(categories []Category, ids ...uint) []uint {
+	children := make(map[uint][]uint)
+	for _, c := range categories {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+
+	seen := make(map[uint]bool)
+	result := []uint{}
+	queue := append([]uint{}, ids...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+		queue = append(queue, children[id]...)
+	}
+	return result
+}
+
+// CategoryAncestors returns the given category IDs together with the IDs of all categories above them
+func CategoryAncestors(categories []Category, ids ...uint) []uint {
+	parents := make(map[uint]*uint, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c.ParentID
+	}
+
+	seen := make(map[uint]bool)
+	result := []uint{}
+	for _, id := range ids {
+		for !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+			parent := parents[id]
+			if parent == nil {
+				break
+			}
+			id = *parent
+		}
+	}
+	return result
+}
+
+// CategoryTaxClass returns the tax class of a product in the given categories: the class of
+// the first category, by ID, that names one itself or through the categories above it, or
+// DefaultTaxClass when none does
+func CategoryTaxClass(categories []Category, ids ...uint) string {
+	byID := make(map[uint]Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+	sorted := append([]uint{}, ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, id := range sorted {
+		for _, ancestor := range CategoryAncestors(categories, id) {
+			if class := byID[ancestor].TaxClass; class != "" {
+				return class
+			}
+		}
+	}
+	return DefaultTaxClass
+}
+
+// derefID returns the ID a pointer refers to, or zero for nil
+func derefID(id *uint) uint {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// ProductImage is a picture of a product. The uploaded file and its thumbnails are kept in
+// the file storage under Key; the record only describes them.
+type ProductImage struct {
+	ID            uint              `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time         `json:"created_at"`
+	ProductID     uint              `json:"product_id"`
+	Key           string            `json:"-" gorm:"column:storage_key"`      // Storage key prefix of the original and its thumbnails
+	ContentType   string            `json:"content_type"`                     // Sniffed type of the original: image/jpeg, image/png or image/gif
+	ThumbnailType string            `json:"-"`                                // Type of the thumbnails, JPEG unless the image has transparent pixels
+	Size          int64             `json:"size"`                             // Size of the original in bytes
+	Width         int               `json:"width"`                            // Width of the original in pixels
+	Height        int               `json:"height"`                           // Height of the original in pixels
+	Position      int               `json:"position"`                         // Place in the gallery of the product, starting at 1
+	Primary       bool              `json:"primary" gorm:"column:is_primary"` // The image shown in listings, exactly one per product with images
+	URL           string            `json:"url" gorm:"-"`                     // Public address of the original
+	Thumbnails    map[string]string `json:"thumbnails" gorm:"-"`              // Public addresses of the thumbnails by size name
+}
+
+// TableName maps ProductImage onto the product_images table
+func (ProductImage) TableName() string {
+	return "product_images"
+}
+
+// imageExtensions maps the supported image types to file extensions
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// OriginalKey returns the storage key of the uploaded file
+func (i ProductImage) OriginalKey() string {
+	return i.Key + "/original." + imageExtensions[i.ContentType]
+}
+
+// ThumbnailKey returns the storage key of the thumbnail of the named size
+func (i ProductImage) ThumbnailKey(size string) string {
+	return i.Key + "/" + size + "." + imageExtensions[i.ThumbnailType]
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// MovementKind is the reason stock changed
+type MovementKind string
+
+// Kinds of stock movements
+const (
+	MovementReceive MovementKind = "receive" // Units arrived
+	MovementAdjust  MovementKind = "adjust"  // Correction after a count, loss or damage
+	MovementReserve MovementKind = "reserve" // Units held for a pending purchase
+	MovementRelease MovementKind = "release" // A hold was cancelled or expired
+	MovementSell    MovementKind = "sell"    // Units left the stock with a purchase
+)
+
+// StockLevel is the stock of a product, or of one of its variants
+type StockLevel struct {
+	ProductID uint      `json:"product_id" gorm:"primaryKey;autoIncrement:false"`
+	VariantID uint      `json:"variant_id" gorm:"primaryKey;autoIncrement:false"` // Zero for products without variants
+	OnHand    int       `json:"on_hand"`                                          // Units in stock
+	Reserved  int       `json:"reserved"`                                         // Units held by active reservations
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName maps StockLevel onto the inventory_levels table
+func (StockLevel) TableName() string {
+	return "inventory_levels"
+}
+
+// Available returns the units that can still be reserved or sold
+func (l StockLevel) Available() int {
+	return l.OnHand - l.Reserved
+}
+
+// StockMovement is an entry of the append-only inventory ledger
+type StockMovement struct {
+	ID            uint         `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time    `json:"created_at"`
+	ProductID     uint         `json:"product_id"`
+	VariantID     uint         `json:"variant_id"`     // Zero for products without variants
+	Kind          MovementKind `json:"kind"`           // Reason of the change
+	Quantity      int          `json:"quantity"`       // Change of the on-hand units, or of the reserved units for reserve and release
+	OnHand        int          `json:"on_hand"`        // On-hand units after the movement
+	Reserved      int          `json:"reserved"`       // Reserved units after the movement
+	ReservationID *uint        `json:"reservation_id"` // Reservation reserved, released or sold
+	UserID        *uint        `json:"user_id"`        // User who recorded the movement, nil for automatic ones
+	Note          string       `json:"note"`
+}
+
+// ReservationStatus is the state of a stock reservation
+type ReservationStatus string
+
+// States of stock reservations. Only active reservations hold stock.
+const (
+	ReservationActive   ReservationStatus = "active"
+	ReservationReleased ReservationStatus = "released"
+	ReservationExpired  ReservationStatus = "expired"
+	ReservationSold     ReservationStatus = "sold"
+)
+
+// Reservation holds units of a product for a limited time while a purchase completes
+type Reservation struct {
+	ID        uint              `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	ProductID uint              `json:"product_id"`
+	VariantID uint              `json:"variant_id"` // Zero for products without variants
+	Quantity  int               `json:"quantity"`
+	ExpiresAt time.Time         `json:"expires_at"` // The units return to the available stock after this time
+	Status    ReservationStatus `json:"status"`
+	Reference string            `json:"reference"` // Identifier of the purchase, such as a cart or order
+}
+
+// TableName maps Reservation onto the stock_reservations table
+func (Reservation) TableName() string {
+	return "stock_reservations"
+}
@@ -1,19 +1,50 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // User represents the model for user data
 type User struct {
 	gorm.Model
-	Name     string `json:"name" validate:"required"`                   // User's name
+	Name     string `json:"name" validate:"required"`                      // User's name
 	Email    string `json:"email" gorm:"unique" validate:"required,email"` // User's email (unique constraint)
-	Password []byte `json:"password" validate:"required"`                // User's hashed password
+	Password []byte `json:"password" validate:"required"`                  // User's hashed password
+	Roles    Roles  `json:"roles" gorm:"type:varchar(255)"`                // Roles granted to the user
 }
 
 // Product represents the model for product data
 type Product struct {
 	gorm.Model
-	Name        string  `json:"name" validate:"required"`          // Product name
-	Description string  `json:"description" validate:"required"`   // Product description
-	Price       float64 `json:"price" validate:"required"`         // Product price
+	Version      uint          `json:"version"`                                     // Incremented by every update, sent as the ETag of the product
+	Name         string        `json:"name" validate:"required"`                    // Product name
+	Description  string        `json:"description" validate:"required"`             // Product description
+	Price        Money         `json:"price" gorm:"embedded;embeddedPrefix:price_"` // Price in the currency the seller lists the product in
+	BasePrice    int64         `json:"-"`                                           // Price converted into minor units of the base currency, for filtering and sorting
+	DisplayPrice *Money        `json:"display_price,omitempty" gorm:"-"`            // Price converted into the currency a client asked for
+	SellerID     *uint         `json:"seller_id"`                                   // User who listed the product, nil for listings older than ownership
+	Attributes   Attributes    `json:"attributes" gorm:"type:text"`                 // Values of the attributes defined for the product's categories
+	Tags         Tags          `json:"tags" gorm:"type:text"`                       // Free-form lower-case labels
+	PrimaryImage *ProductImage `json:"primary_image,omitempty" gorm:"-"`            // Image shown in listings, nil for products without images
+}
+
+// RefreshToken is a server-side record of an issued refresh token.
+// Tokens are rotated on every use; all tokens descending from one login share a Family.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    uint       `json:"user_id"`    // Owner of the token
+	Family    string     `json:"family"`     // Identifier shared by all rotations of one login
+	TokenHash string     `json:"-"`          // SHA-256 of the token, the token itself is never stored
+	ExpiresAt time.Time  `json:"expires_at"` // Time after which the token can no longer be used
+	UsedAt    *time.Time `json:"used_at"`    // Set once the token has been exchanged
+	RevokedAt *time.Time `json:"revoked_at"` // Set when the token or its family is revoked
+}
+
+// RevokedToken is an access token that was revoked before its expiry, identified by its JWT ID
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey"`
+	ExpiresAt time.Time // The entry can be purged once the token would have expired anyway
 }
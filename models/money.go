@@ -0,0 +1,224 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// currencyDigits lists the ISO 4217 currencies accepted for prices with their number of decimals
+var currencyDigits = map[string]int{
+	"AED": 2, "ARS": 2, "AUD": 2, "BGN": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0,
+	"CNY": 2, "COP": 2, "CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2,
+	"IDR": 2, "ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KES": 2, "KRW": 0, "KWD": 3,
+	"MAD": 2, "MXN": 2, "MYR": 2, "NGN": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PEN": 2, "PHP": 2,
+	"PKR": 2, "PLN": 2, "QAR": 2, "RON": 2, "RSD": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2,
+	"TND": 3, "TRY": 2, "TWD": 2, "UAH": 2, "UGX": 0, "USD": 2, "VND": 0, "XAF": 0, "XOF": 0,
+	"ZAR": 2,
+}
+
+// maxMoneyDigits bounds the integer digits of parsed amounts so minor units fit into an int64
+const maxMoneyDigits = 15
+
+// decimalPattern matches non-negative decimal numbers such as 12, 12.5 and 0.05
+var decimalPattern = regexp.MustCompile(`^([0-9]+)(?:\.([0-9]+))?$`)
+
+// ErrUnknownCurrency is returned for codes that are not supported ISO 4217 currencies
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// CurrencyDigits returns the number of decimals of a currency, such as 2 for USD and 0 for JPY
+func CurrencyDigits(code string) (int, bool) {
+	digits, ok := currencyDigits[code]
+	return digits, ok
+}
+
+// NormalizeCurrency trims a currency code, converts it to upper case and checks that it is supported
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := currencyDigits[code]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+	return code, nil
+}
+
+// Money is an exact amount in the minor units of a currency, such as 1250 cents for 12.50 USD
+type Money struct {
+	Amount   int64  `json:"amount"`   // Minor units
+	Currency string `json:"currency"` // ISO 4217 code
+}
+
+// ParseMoney parses a non-negative decimal amount such as "12.50" in the given currency.
+// Amounts with more decimals than the currency has are rejected rather than rounded.
+func ParseMoney(text, currency string) (Money, error) {
+	digits, ok := currencyDigits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+	parts := decimalPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if parts == nil {
+		return Money{}, fmt.Errorf("%q is not a decimal amount", text)
+	}
+	whole, fraction := strings.TrimLeft(parts[1], "0"), strings.TrimRight(parts[2], "0")
+	if len(fraction) > digits {
+		return Money{}, fmt.Errorf("%s amounts have at most %d decimals", currency, digits)
+	}
+	if len(whole) > maxMoneyDigits {
+		return Money{}, fmt.Errorf("%q is too large", text)
+	}
+	minor := strings.TrimLeft(whole+fraction+strings.Repeat("0", digits-len(fraction)), "0")
+	if minor == "" {
+		return Money{Currency: currency}, nil
+	}
+	amount, err := strconv.ParseInt(minor, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%q is too large", text)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// MoneyFromJSON parses an amount decoded from JSON, either a number or a decimal string.
+// Numbers are read in their shortest decimal form, so 0.1 stays 0.1.
+func MoneyFromJSON(value interface{}, currency string) (Money, error) {
+	switch v := value.(type) {
+	case float64:
+		return ParseMoney(strconv.FormatFloat(v, 'f', -1, 64), currency)
+	case string:
+		return ParseMoney(v, currency)
+	}
+	return Money{}, errors.New("amount must be a number or a decimal string")
+}
+
+// Decimal formats the amount with the decimals of its currency, such as "12.50"
+func (m Money) Decimal() string {
+	digits := currencyDigits[m.Currency]
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	text := strconv.FormatInt(amount, 10)
+	if digits == 0 {
+		return sign + text
+	}
+	if len(text) <= digits {
+		text = strings.Repeat("0", digits-len(text)+1) + text
+	}
+	return sign + text[:len(text)-digits] + "." + text[len(text)-digits:]
+}
+
+// String formats the amount with its currency, such as "12.50 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns the sum of two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns the amount multiplied by a quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// ExchangeRate is the number of units of a currency worth one unit of the base currency
+type ExchangeRate struct {
+	Currency  string    `json:"currency" gorm:"primaryKey"` // ISO 4217 code
+	Rate      string    `json:"rate"`                       // Exact decimal, such as "0.9215"
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ParseRate parses a positive decimal exchange rate
+func ParseRate(text string) (*big.Rat, error) {
+	if !decimalPattern.MatchString(strings.TrimSpace(text)) {
+		return nil, fmt.Errorf("rate %q is not a decimal number", text)
+	}
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(text))
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("rate %q must be positive", text)
+	}
+	return rate, nil
+}
+
+// Rates converts amounts between currencies through the base currency
+type Rates struct {
+	Base  string              // Currency all rates are relative to
+	rates map[string]*big.Rat // Units of each currency per unit of the base currency
+}
+
+// ErrNoRate is returned when converting from or to a currency without an exchange rate
+var ErrNoRate = errors.New("no exchange rate")
+
+// NewRates builds a converter from the rates of an exchange rate table
+func NewRates(base string, table []ExchangeRate) (*Rates, error) {
+	r := &Rates{Base: base, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for _, entry := range table {
+		rate, err := ParseRate(entry.Rate)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Currency != base {
+			r.rates[entry.Currency] = rate
+		}
+	}
+	return r, nil
+}
+
+// Has reports whether amounts can be converted from and to the currency
+func (r *Rates) Has(currency string) bool {
+	_, ok := r.rates[currency]
+	return ok
+}
+
+// Convert converts an amount into another currency, rounding half away from zero to the
+// minor units of the target currency
+func (r *Rates) Convert(m Money, to string) (Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+	from, ok := r.rates[m.Currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w for %s", ErrNoRate, m.Currency)
+	}
+	target, ok := r.rates[to]
+	if !ok {
+		return Money{}, fmt.Errorf("%w for %s", ErrNoRate, to)
+	}
+
+	// amount / 10^fromDigits / from * target * 10^toDigits
+	value := new(big.Rat).SetInt64(m.Amount)
+	value.Mul(value, target)
+	value.Quo(value, from)
+	value.Mul(value, pow10(currencyDigits[to]))
+	value.Quo(value, pow10(currencyDigits[m.Currency]))
+	return Money{Amount: RoundRat(value), Currency: to}, nil
+}
+
+// pow10 returns 10^n as a rational number
+func pow10(n int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
+
+// RoundRat rounds a rational number half away from zero
+func RoundRat(value *big.Rat) int64 {
+	num, den := new(big.Int).Abs(value.Num()), value.Denom()
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	if remainder.Mul(remainder, big.NewInt(2)).Cmp(den) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if value.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+	return quotient.Int64()
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	for text, want := range map[string]Money{
+		"12.5":   {Amount: 1250, Currency: "USD"},
+		"0.10":   {Amount: 10, Currency: "USD"},
+		"007.00": {Amount: 700, Currency: "USD"},
+	} {
+		got, err := ParseMoney(text, "USD")
+		assert.NoError(t, err, text)
+		assert.Equal(t, want, got, text)
+	}
+
+	// Precision depends on the currency
+	got, err := ParseMoney("1.005", "KWD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1005), got.Amount)
+	for _, text := range []string{"1.005", "-1", "1e3", "", "abc"} {
+		_, err := ParseMoney(text, "USD")
+		assert.Error(t, err, text)
+	}
+	_, err = ParseMoney("1.5", "JPY")
+	assert.Error(t, err)
+	_, err = ParseMoney("1", "XYZ")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+
+	// Numbers decoded from JSON keep their shortest decimal form, float sums are not cents
+	a, b := 0.1, 0.2
+	_, err = MoneyFromJSON(a+b, "USD")
+	assert.Error(t, err)
+	got, err = MoneyFromJSON(19.99, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "19.99 USD", got.String())
+}
+
+func TestRatesConvert(t *testing.T) {
+	rates, err := NewRates("USD", []ExchangeRate{{Currency: "EUR", Rate: "0.8"}, {Currency: "JPY", Rate: "150"}})
+	assert.NoError(t, err)
+
+	got, err := rates.Convert(Money{Amount: 1000, Currency: "EUR"}, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, Money{Amount: 1250, Currency: "USD"}, got)
+
+	// Cross rates go through the base currency and round half away from zero
+	got, err = rates.Convert(Money{Amount: 1, Currency: "EUR"}, "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, Money{Amount: 2, Currency: "JPY"}, got)
+	got, err = rates.Convert(Money{Amount: 199, Currency: "JPY"}, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(133), got.Amount)
+
+	_, err = rates.Convert(Money{Amount: 1, Currency: "GBP"}, "USD")
+	assert.ErrorIs(t, err, ErrNoRate)
+}
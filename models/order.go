@@ -0,0 +1,118 @@
+package models
+
+import "time"
+
+// OrderStatus is the state of an order in its lifecycle
+type OrderStatus string
+
+// States of orders. Orders start pending and end delivered, cancelled or refunded.
+const (
+	OrderPending   OrderStatus = "pending"   // Placed and waiting for payment, holding the stock of its lines
+	OrderPaid      OrderStatus = "paid"      // Payment received, the stock is sold
+	OrderFulfilled OrderStatus = "fulfilled" // Picked and packed by the seller
+	OrderShipped   OrderStatus = "shipped"   // Handed to the carrier
+	OrderDelivered OrderStatus = "delivered" // Received by the buyer
+	OrderCancelled OrderStatus = "cancelled" // Abandoned before payment, the stock is released
+	OrderRefunded  OrderStatus = "refunded"  // Payment returned to the buyer
+)
+
+// orderTransitions lists the states each state may move on to
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderFulfilled, OrderRefunded},
+	OrderFulfilled: {OrderShipped, OrderRefunded},
+	OrderShipped:   {OrderDelivered, OrderRefunded},
+	OrderDelivered: {OrderRefunded},
+}
+
+// IsValid reports whether the status is one of the known states
+func (s OrderStatus) IsValid() bool {
+	switch s {
+	case OrderPending, OrderPaid, OrderFulfilled, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether an order in this state may move to the other state
+func (s OrderStatus) CanTransition(to OrderStatus) bool {
+	for _, next := range orderTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Order is a purchase from one seller, placed by checking out a cart. Checking out a cart with
+// products of several sellers places one order per seller, each with its own lifecycle.
+type Order struct {
+	ID           uint        `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	BuyerID      uint        `json:"buyer_id"`
+	SellerID     *uint       `json:"seller_id"` // Seller of every line, nil for listings older than ownership
+	Status       OrderStatus `json:"status"`
+	Subtotal     Money       `json:"subtotal" gorm:"embedded;embeddedPrefix:subtotal_"` // Sum of the line totals
+	Discount     Money       `json:"discount" gorm:"embedded;embeddedPrefix:discount_"` // Sum of the line discounts granted by promotions
+	Tax          Money       `json:"tax" gorm:"embedded;embeddedPrefix:tax_"`           // Sum of the taxes, included in the subtotal when TaxInclusive is set
+	TaxInclusive bool        `json:"tax_inclusive"`                                     // Whether the prices of the lines include tax
+	Jurisdiction string      `json:"jurisdiction"`                                      // Jurisdiction the order is taxed in, empty when untaxed
+	Taxes        TaxLines    `json:"taxes" gorm:"type:text"`                            // Itemized taxes
+	Total        Money       `json:"total" gorm:"embedded;embeddedPrefix:total_"`       // Amount the buyer pays, the subtotal less the discount, plus the tax unless included
+	ExpiresAt    *time.Time  `json:"expires_at"`                                        // Pending orders are cancelled after this time
+	PaidAt       *time.Time  `json:"paid_at"`
+	FulfilledAt  *time.Time  `json:"fulfilled_at"`
+	ShippedAt    *time.Time  `json:"shipped_at"`
+	DeliveredAt  *time.Time  `json:"delivered_at"`
+	CancelledAt  *time.Time  `json:"cancelled_at"`
+	RefundedAt   *time.Time  `json:"refunded_at"`
+	Lines        []OrderLine `json:"lines" gorm:"-"`
+}
+
+// SetStatus moves the order to another state and records the time of the transition. It
+// returns false, leaving the order unchanged, unless the transition is allowed.
+func (o *Order) SetStatus(to OrderStatus, now time.Time) bool {
+	if !o.Status.CanTransition(to) {
+		return false
+	}
+	o.Status = to
+	switch to {
+	case OrderPaid:
+		o.PaidAt = &now
+	case OrderFulfilled:
+		o.FulfilledAt = &now
+	case OrderShipped:
+		o.ShippedAt = &now
+	case OrderDelivered:
+		o.DeliveredAt = &now
+	case OrderCancelled:
+		o.CancelledAt = &now
+	case OrderRefunded:
+		o.RefundedAt = &now
+	}
+	return true
+}
+
+// OrderLine is a product, or one of its variants, bought with an order. Name, SKU and prices
+// are copied at checkout so the order stays intact when the product changes or is deleted.
+type OrderLine struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	OrderID       uint   `json:"order_id"`
+	ProductID     *uint  `json:"product_id"` // Nil once the product was purged
+	VariantID     uint   `json:"variant_id"` // Zero for products without variants
+	Name          string `json:"name"`
+	SKU           string `json:"sku" gorm:"column:sku"`
+	Quantity      int    `json:"quantity"`
+	UnitPrice     Money  `json:"unit_price" gorm:"embedded;embeddedPrefix:unit_price_"` // Price of one unit in the currency of the order
+	Total         Money  `json:"total" gorm:"embedded;embeddedPrefix:total_"`           // Unit price times quantity
+	Discount      Money  `json:"discount" gorm:"embedded;embeddedPrefix:discount_"`     // Part of the total taken off by promotions
+	TaxClass      string `json:"tax_class"`                                             // Tax class of the product at checkout
+	Tax           Money  `json:"tax" gorm:"embedded;embeddedPrefix:tax_"`               // Tax levied on the total less the discount
+	ReservationID *uint  `json:"reservation_id"`                                        // Stock held for the line while the order is pending
+}
+
+// TableName maps OrderLine onto the order_lines table
+func (OrderLine) TableName() string {
+	return "order_lines"
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderSetStatus(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	order := Order{Status: OrderPending}
+
+	// The happy path records the time of every step
+	for _, status := range []OrderStatus{OrderPaid, OrderFulfilled, OrderShipped, OrderDelivered, OrderRefunded} {
+		assert.True(t, order.SetStatus(status, now), status)
+		assert.Equal(t, status, order.Status)
+	}
+	for _, at := range []*time.Time{order.PaidAt, order.FulfilledAt, order.ShippedAt, order.DeliveredAt, order.RefundedAt} {
+		assert.Equal(t, &now, at)
+	}
+	assert.Nil(t, order.CancelledAt)
+
+	// Steps cannot be skipped or undone, and final states stay final
+	for from, to := range map[OrderStatus]OrderStatus{
+		OrderPending:   OrderShipped,
+		OrderPaid:      OrderCancelled,
+		OrderShipped:   OrderFulfilled,
+		OrderCancelled: OrderPaid,
+		OrderRefunded:  OrderPending,
+		OrderDelivered: OrderDelivered,
+	} {
+		order := Order{Status: from}
+		assert.False(t, order.SetStatus(to, now), "%s to %s", from, to)
+		assert.Equal(t, from, order.Status)
+	}
+	assert.False(t, OrderStatus("lost").IsValid())
+}
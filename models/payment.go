@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// PaymentStatus is the state of a payment at the payment provider
+type PaymentStatus string
+
+// States of payments. Payments are authorized, possibly after the buyer completed a challenge,
+// then captured; authorizations can be voided and captures refunded.
+const (
+	PaymentRequiresAction PaymentStatus = "requires_action" // The buyer has to complete a challenge, such as 3-D Secure
+	PaymentAuthorized     PaymentStatus = "authorized"      // The amount is held on the buyer's card
+	PaymentCaptured       PaymentStatus = "captured"        // The amount was collected
+	PaymentVoided         PaymentStatus = "voided"          // The authorization was released without collecting
+	PaymentRefunded       PaymentStatus = "refunded"        // The captured amount was returned
+	PaymentDeclined       PaymentStatus = "declined"        // The card issuer refused the payment
+	PaymentFailed         PaymentStatus = "failed"          // The challenge failed or was abandoned
+)
+
+// Payment is an attempt to pay an order through a payment provider
+type Payment struct {
+	ID            uint          `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	OrderID       uint          `json:"order_id"`
+	Provider      string        `json:"provider"`  // Name of the payment provider
+	Reference     string        `json:"reference"` // Identifier of the payment at the provider
+	Status        PaymentStatus `json:"status"`
+	Amount        Money         `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	ActionURL     string        `json:"action_url,omitempty"`     // Where the buyer completes a challenge, while the payment requires action
+	FailureReason string        `json:"failure_reason,omitempty"` // Why the payment was declined or failed
+}
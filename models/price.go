@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// PriceChangeReason tells why the price of a product changed
+type PriceChangeReason string
+
+// Reasons of price changes
+const (
+	PriceChangeManual    PriceChangeReason = "manual"     // The seller or an admin edited the product
+	PriceChangeScheduled PriceChangeReason = "scheduled"  // A scheduled price change took effect
+	PriceChangeSaleStart PriceChangeReason = "sale_start" // A sale price took effect
+	PriceChangeSaleEnd   PriceChangeReason = "sale_end"   // A sale ended and the price before it was restored
+)
+
+// PriceChange is an entry of the price history of a product
+type PriceChange struct {
+	ID         uint              `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ProductID  uint              `json:"product_id"`
+	OldPrice   Money             `json:"old_price" gorm:"embedded;embeddedPrefix:old_"`
+	NewPrice   Money             `json:"new_price" gorm:"embedded;embeddedPrefix:new_"`
+	Reason     PriceChangeReason `json:"reason"`
+	ScheduleID *uint             `json:"schedule_id"` // Schedule that made the change, nil for manual changes
+	UserID     *uint             `json:"user_id"`     // User who made or scheduled the change
+}
+
+// ScheduleStatus is the state of a price schedule
+type ScheduleStatus string
+
+// States of price schedules. Pending schedules wait for their start, active ones are sales
+// waiting for their end.
+const (
+	SchedulePending   ScheduleStatus = "pending"
+	ScheduleActive    ScheduleStatus = "active"
+	ScheduleDone      ScheduleStatus = "done"
+	ScheduleCancelled ScheduleStatus = "cancelled"
+)
+
+// PriceSchedule is a future price change of a product. Schedules with an end are sales:
+// the price before the sale is restored when it ends, unless the price was changed meanwhile.
+type PriceSchedule struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	ProductID    uint           `json:"product_id"`
+	Price        Money          `json:"price" gorm:"embedded;embeddedPrefix:price_"` // Price in the currency of the product
+	StartsAt     time.Time      `json:"starts_at"`
+	EndsAt       *time.Time     `json:"ends_at"`       // End of a sale, nil for permanent changes
+	RevertAmount *int64         `json:"revert_amount"` // Price before the sale, set once it started
+	Status       ScheduleStatus `json:"status"`
+	UserID       *uint          `json:"user_id"` // User who scheduled the change
+}
+
+// TableName maps PriceSchedule onto the price_schedules table
+func (PriceSchedule) TableName() string {
+	return "price_schedules"
+}
+
+// IsSale reports whether the schedule restores the previous price when it ends
+func (s PriceSchedule) IsSale() bool {
+	return s.EndsAt != nil
+}
+
+// Overlaps reports whether two sales run at the same time
+func (s PriceSchedule) Overlaps(other PriceSchedule) bool {
+	if !s.IsSale() || !other.IsSale() {
+		return false
+	}
+	return s.StartsAt.Before(*other.EndsAt) && other.StartsAt.Before(*s.EndsAt)
+}
+
+// DueAt returns when the schedule next has to be applied, and false once it is finished
+func (s PriceSchedule) DueAt() (time.Time, bool) {
+	switch s.Status {
+	case SchedulePending:
+		return s.StartsAt, true
+	case ScheduleActive:
+		return *s.EndsAt, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PromotionKind tells how a promotion discounts the cart
+type PromotionKind string
+
+// Kinds of promotions
+const (
+	PromotionPercent      PromotionKind = "percent"       // Percent off the matching lines
+	PromotionFixed        PromotionKind = "fixed"         // Fixed amount off the matching lines, spread over them
+	PromotionBuyXGetY     PromotionKind = "buy_x_get_y"   // Of every BuyQuantity plus GetQuantity matching units, the GetQuantity cheapest are Percent off
+	PromotionFreeShipping PromotionKind = "free_shipping" // Shipping is free when the cart holds a matching line
+)
+
+// IsValid reports whether the kind is one of the known kinds
+func (k PromotionKind) IsValid() bool {
+	switch k {
+	case PromotionPercent, PromotionFixed, PromotionBuyXGetY, PromotionFreeShipping:
+		return true
+	}
+	return false
+}
+
+// PromotionScope tells which cart lines a promotion applies to
+type PromotionScope string
+
+// Scopes of promotions. Except for PromotionScopeAll the promotion lists the IDs it targets.
+const (
+	PromotionScopeAll        PromotionScope = "all"        // Every line
+	PromotionScopeProducts   PromotionScope = "products"   // Lines of the listed products
+	PromotionScopeCategories PromotionScope = "categories" // Lines of products in the listed categories or below them
+	PromotionScopeSellers    PromotionScope = "sellers"    // Lines of products listed by the listed sellers
+)
+
+// IsValid reports whether the scope is one of the known scopes
+func (s PromotionScope) IsValid() bool {
+	switch s {
+	case PromotionScopeAll, PromotionScopeProducts, PromotionScopeCategories, PromotionScopeSellers:
+		return true
+	}
+	return false
+}
+
+// IDList is a list of record IDs, stored comma-separated
+type IDList []uint
+
+// Value implements driver.Valuer
+func (l IDList) Value() (driver.Value, error) {
+	parts := make([]string, len(l))
+	for i, id := range l {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (l *IDList) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into IDList", value)
+	}
+
+	*l = IDList{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ID %q in IDList", part)
+		}
+		*l = append(*l, uint(id))
+	}
+	return nil
+}
+
+// Has reports whether the ID is in the list
+func (l IDList) Has(id uint) bool {
+	for _, have := range l {
+		if have == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Promotion is a discount rule maintained by admins. Promotions without a code apply to every
+// cart they match; coupons, promotions with a code, only when the buyer enters the code.
+type Promotion struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	Name           string         `json:"name"`                                          // Shown to buyers in the discount breakdown
+	Code           *string        `json:"code"`                                          // Upper-case coupon code, nil for automatic promotions
+	Kind           PromotionKind  `json:"kind"`                                          // How the promotion discounts the cart
+	Percent        int            `json:"percent"`                                       // Percent off, for percent and buy-X-get-Y promotions
+	Amount         Money          `json:"amount" gorm:"embedded;embeddedPrefix:amount_"` // Amount off, for fixed promotions
+	BuyQuantity    int            `json:"buy_quantity"`                                  // Units bought at full price, for buy-X-get-Y promotions
+	GetQuantity    int            `json:"get_quantity"`                                  // Units discounted after them, for buy-X-get-Y promotions
+	Scope          PromotionScope `json:"scope"`                                         // Which lines the promotion applies to
+	TargetIDs      IDList         `json:"target_ids" gorm:"type:text"`                   // Products, categories or sellers of the scope
+	StartsAt       *time.Time     `json:"starts_at"`                                     // The promotion applies from this time on, nil for right away
+	EndsAt         *time.Time     `json:"ends_at"`                                       // The promotion applies until this time, nil for no end
+	MaxUses        int            `json:"max_uses"`                                      // Checkouts that may redeem the promotion, zero for no limit
+	MaxUsesPerUser int            `json:"max_uses_per_user"`                             // Checkouts of one buyer that may redeem the promotion, zero for no limit
+	Uses           int            `json:"uses"`                                          // Checkouts that redeemed the promotion so far
+	Exclusive      bool           `json:"exclusive"`                                     // Exclusive promotions are not combined with any other promotion
+	Priority       int            `json:"priority"`                                      // Promotions with a higher priority are applied first
+	Active         bool           `json:"active"`                                        // Inactive promotions never apply
+}
+
+// Running reports whether the promotion is active and its validity window contains now
+func (p Promotion) Running(now time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return false
+	}
+	return p.EndsAt == nil || now.Before(*p.EndsAt)
+}
+
+// Matches reports whether the promotion applies to a line of the product, listed by the
+// seller and assigned to the categories, which have to include all their ancestors
+func (p Promotion) Matches(productID uint, sellerID *uint, categoryIDs []uint) bool {
+	switch p.Scope {
+	case PromotionScopeAll:
+		return true
+	case PromotionScopeProducts:
+		return p.TargetIDs.Has(productID)
+	case PromotionScopeSellers:
+		return sellerID != nil && p.TargetIDs.Has(*sellerID)
+	case PromotionScopeCategories:
+		for _, id := range categoryIDs {
+			if p.TargetIDs.Has(id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PromotionRedemption records that a checkout used a promotion, counting towards its usage caps
+type PromotionRedemption struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"created_at"`
+	PromotionID uint      `json:"promotion_id"`
+	UserID      uint      `json:"user_id"`
+	OrderID     *uint     `json:"order_id"` // First order placed by the checkout
+}
+
+// TableName maps PromotionRedemption onto the promotion_redemptions table
+func (PromotionRedemption) TableName() string {
+	return "promotion_redemptions"
+}
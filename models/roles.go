@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Roles known to the application
+const (
+	RoleAdmin  = "admin"  // Manages the whole marketplace
+	RoleSeller = "seller" // Lists and manages products
+	RoleBuyer  = "buyer"  // Browses and buys products
+)
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleSeller || role == RoleBuyer
+}
+
+// Roles is a set of role names, stored as a comma-separated column
+type Roles []string
+
+// Has reports whether role is in the set
+func (r Roles) Has(role string) bool {
+	for _, have := range r {
+		if have == role {
+			return true
+		}
+	}
+	return false
+}
+
+// With returns a sorted copy of the set including role
+func (r Roles) With(role string) Roles {
+	if r.Has(role) {
+		return r
+	}
+	roles := append(append(Roles{}, r...), role)
+	sort.Strings(roles)
+	return roles
+}
+
+// Without returns a copy of the set excluding role
+func (r Roles) Without(role string) Roles {
+	roles := Roles{}
+	for _, have := range r {
+		if have != role {
+			roles = append(roles, have)
+		}
+	}
+	return roles
+}
+
+// Value implements driver.Valuer
+func (r Roles) Value() (driver.Value, error) {
+	return strings.Join(r, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (r *Roles) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Roles", value)
+	}
+
+	*r = Roles{}
+	for _, role := range strings.Split(s, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			*r = append(*r, role)
+		}
+	}
+	return nil
+}
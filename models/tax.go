@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// DefaultTaxClass is the tax class of products none of whose categories name one
+const DefaultTaxClass = "standard"
+
+// taxCodePattern matches jurisdiction codes and tax class names, such as "US-NY" or "reduced"
+var taxCodePattern = regexp.MustCompile(`^[A-Za-z0-9_]+(-[A-Za-z0-9_]+)*$`)
+
+// NormalizeJurisdiction validates a jurisdiction code and converts it to upper case.
+// Codes name a region by its parts, from the widest to the narrowest, separated by dashes,
+// such as "US", "US-NY" and "US-NY-NYC".
+func NormalizeJurisdiction(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) > 64 || !taxCodePattern.MatchString(code) {
+		return "", fmt.Errorf("jurisdiction %q is not a code such as US-NY", code)
+	}
+	return code, nil
+}
+
+// NormalizeTaxClass validates the name of a tax class and converts it to lower case
+func NormalizeTaxClass(class string) (string, error) {
+	class = strings.ToLower(strings.TrimSpace(class))
+	if len(class) > 32 || !taxCodePattern.MatchString(class) {
+		return "", fmt.Errorf("tax class %q is not a name such as reduced", class)
+	}
+	return class, nil
+}
+
+// TaxRate is one tax levied in a jurisdiction on a tax class, such as a state or a city sales
+// tax. Jurisdictions also levy the taxes of the jurisdictions containing them: US-NY-NYC
+// levies the taxes of US-NY-NYC, US-NY and US.
+type TaxRate struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	Jurisdiction string `json:"jurisdiction"` // Upper-case code such as "US-NY"
+	Class        string `json:"class"`        // Tax class of the products taxed, such as "standard"
+	Name         string `json:"name"`         // Name shown in tax breakdowns, such as "NY State Sales Tax"
+	Rate         string `json:"rate"`         // Exact decimal percent, such as "8.875"
+}
+
+// ParseTaxRate parses a decimal percent between 0 and 100 into a fraction
+func ParseTaxRate(text string) (*big.Rat, error) {
+	if !decimalPattern.MatchString(strings.TrimSpace(text)) {
+		return nil, fmt.Errorf("tax rate %q is not a decimal percent", text)
+	}
+	rate, _ := new(big.Rat).SetString(strings.TrimSpace(text))
+	if rate.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, fmt.Errorf("tax rate %q exceeds 100 percent", text)
+	}
+	return rate.Quo(rate, big.NewRat(100, 1)), nil
+}
+
+// TaxLine is the tax one rate levies on an order or a cart
+type TaxLine struct {
+	Name         string `json:"name"`
+	Jurisdiction string `json:"jurisdiction"` // Jurisdiction levying the tax, which contains the one taxed
+	Class        string `json:"class"`        // Tax class of the lines taxed
+	Rate         string `json:"rate"`         // Decimal percent
+	Taxable      Money  `json:"taxable"`      // Sum of the line totals taxed, less their discounts
+	Amount       Money  `json:"amount"`       // Tax levied
+}
+
+// TaxLines is an itemized tax breakdown, stored as a JSON array
+type TaxLines []TaxLine
+
+// Value implements driver.Valuer
+func (l TaxLines) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (l *TaxLines) Scan(value interface{}) error {
+	*l = TaxLines{}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return scanJSON(v, l)
+	case []byte:
+		return scanJSON(string(v), l)
+	}
+	return fmt.Errorf("cannot scan %T into TaxLines", value)
+}
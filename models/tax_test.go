@@ -0,0 +1,36 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTaxRate(t *testing.T) {
+	rate, err := ParseTaxRate("8.875")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(71, 800), rate)
+	rate, err = ParseTaxRate("0")
+	assert.NoError(t, err)
+	assert.Zero(t, rate.Sign())
+	for _, text := range []string{"", "-1", "abc", "100.01"} {
+		_, err := ParseTaxRate(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestCategoryTaxClass(t *testing.T) {
+	books, fiction, toys := uint(1), uint(2), uint(3)
+	categories := []Category{
+		{ID: books, TaxClass: "reduced"},
+		{ID: fiction, ParentID: &books},
+		{ID: toys},
+	}
+
+	// Categories inherit the class of their parent; the first category by ID naming one wins
+	assert.Equal(t, "reduced", CategoryTaxClass(categories, fiction))
+	assert.Equal(t, "reduced", CategoryTaxClass(categories, toys, fiction))
+	assert.Equal(t, DefaultTaxClass, CategoryTaxClass(categories, toys))
+	assert.Equal(t, DefaultTaxClass, CategoryTaxClass(categories))
+}
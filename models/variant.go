@@ -0,0 +1,139 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSKULength is the longest SKU accepted
+const MaxSKULength = 64
+
+// skuPattern matches normalized SKUs: upper-case letters, digits, dashes, dots and underscores
+var skuPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]*$`)
+
+// Variant is a purchasable version of a product, identified by its option values,
+// such as size M in red
+type Variant struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	ProductID uint         `json:"product_id"`                              // Parent product sharing name, description and categories
+	SKU       string       `json:"sku" gorm:"column:sku"`                   // Stock keeping unit, unique across all products
+	Options   OptionValues `json:"options" gorm:"type:text"`                // Value of every option of the product, such as size and color
+	Price     *int64       `json:"price_amount" gorm:"column:price_amount"` // Overrides the product price, in minor units of its currency; nil to inherit it
+	Stock     int          `json:"stock" gorm:"-"`                          // Units on hand, kept in the inventory ledger
+}
+
+// TableName maps Variant onto the product_variants table
+func (Variant) TableName() string {
+	return "product_variants"
+}
+
+// EffectivePrice returns the price of the variant, falling back to the price of its product
+func (v Variant) EffectivePrice(product Product) Money {
+	if v.Price != nil {
+		return Money{Amount: *v.Price, Currency: product.Price.Currency}
+	}
+	return product.Price
+}
+
+// NormalizeSKU trims a SKU and converts it to upper case. It returns an error unless the
+// result is a valid SKU.
+func NormalizeSKU(sku string) (string, error) {
+	sku = strings.ToUpper(strings.TrimSpace(sku))
+	if len(sku) > MaxSKULength || !skuPattern.MatchString(sku) {
+		return "", fmt.Errorf("SKU must be up to %d letters, digits, dashes, dots or underscores", MaxSKULength)
+	}
+	return sku, nil
+}
+
+// OptionValues holds the option values of a variant by option name
+type OptionValues map[string]string
+
+// Value implements driver.Valuer
+func (o OptionValues) Value() (driver.Value, error) {
+	if len(o) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(o)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (o *OptionValues) Scan(value interface{}) error {
+	*o = OptionValues{}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return scanJSON(v, o)
+	case []byte:
+		return scanJSON(string(v), o)
+	}
+	return fmt.Errorf("cannot scan %T into OptionValues", value)
+}
+
+// Names returns the option names in alphabetical order
+func (o OptionValues) Names() []string {
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Equal reports whether both hold the same options, comparing values case-insensitively
+func (o OptionValues) Equal(other OptionValues) bool {
+	if len(o) != len(other) {
+		return false
+	}
+	for name, value := range o {
+		if !strings.EqualFold(other[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// VariantOption is one dimension of the variant matrix of a product, such as size with S, M and L
+type VariantOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"` // In the order the variants were added
+}
+
+// VariantOptions returns the dimensions of the variant matrix, ordered by option name
+func VariantOptions(variants []Variant) []VariantOption {
+	options := []VariantOption{}
+	index := make(map[string]int)
+	for _, variant := range variants {
+		for _, name := range variant.Options.Names() {
+			i, ok := index[name]
+			if !ok {
+				i = len(options)
+				index[name] = i
+				options = append(options, VariantOption{Name: name})
+			}
+			if !containsFold(options[i].Values, variant.Options[name]) {
+				options[i].Values = append(options[i].Values, variant.Options[name])
+			}
+		}
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
+	return options
+}
+
+// containsFold reports whether the list holds the value, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, have := range list {
+		if strings.EqualFold(have, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/google/uuid"
+)
+
+// Card numbers the fake provider understands. Any other payment method is declined.
+const (
+	FakeCardSuccess           = "4242424242424242" // Authorized right away
+	FakeCardDecline           = "4000000000000002" // Declined by the issuer
+	FakeCardInsufficientFunds = "4000000000009995" // Declined for insufficient funds
+	FakeCardChallenge         = "4000000000003220" // Requires a 3-D Secure style challenge
+)
+
+// FakeProviderName is the name the fake provider stores its payments under
+const FakeProviderName = "fake"
+
+// Fake is a PaymentProvider simulating card payments in memory, for development and tests. The
+// outcome of an authorization depends on the card number passed as payment method. Challenges
+// are resolved by calling Complete, as the buyer would at the action URL, which reports the
+// outcome with a signed webhook callback.
+type Fake struct {
+	Secret     string       // Secret webhook callbacks are signed with
+	WebhookURL string       // Address webhook callbacks are posted to
+	ActionURL  string       // Base address of the challenge pages, the payment reference is appended
+	Client     *http.Client // Client posting webhook callbacks, http.DefaultClient if nil
+
+	// Deliver, when set, is called with the body and signature of webhook callbacks instead of
+	// posting them to WebhookURL
+	Deliver func(ctx context.Context, body []byte, signature string) error
+
+	mu       sync.Mutex
+	payments map[string]*Result
+}
+
+// NewFake creates a fake provider signing its callbacks with secret and posting them to webhookURL
+func NewFake(secret, webhookURL, actionURL string) *Fake {
+	return &Fake{Secret: secret, WebhookURL: webhookURL, ActionURL: actionURL}
+}
+
+// Name identifies the fake provider
+func (f *Fake) Name() string {
+	return FakeProviderName
+}
+
+// Authorize authorizes, declines or challenges the payment depending on its card number
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error) {
+	if req.Amount.Amount <= 0 {
+		return nil, fmt.Errorf("invalid payment amount %d", req.Amount.Amount)
+	}
+	result := &Result{Reference: "fake_" + uuid.NewString(), Amount: req.Amount}
+	switch strings.ReplaceAll(req.Method, " ", "") {
+	case FakeCardSuccess:
+		result.Status = models.PaymentAuthorized
+	case FakeCardDecline:
+		result.Status, result.FailureReason = models.PaymentDeclined, "card_declined"
+	case FakeCardInsufficientFunds:
+		result.Status, result.FailureReason = models.PaymentDeclined, "insufficient_funds"
+	case FakeCardChallenge:
+		result.Status = models.PaymentRequiresAction
+		result.ActionURL = strings.TrimSuffix(f.ActionURL, "/") + "/" + result.Reference
+	default:
+		result.Status, result.FailureReason = models.PaymentDeclined, "invalid_payment_method"
+	}
+	return f.store(result), nil
+}
+
+// Capture collects an authorized payment
+func (f *Fake) Capture(ctx context.Context, reference string) (*Result, error) {
+	return f.update(reference, func(p *Result) error {
+		if p.Status != models.PaymentAuthorized {
+			return ErrInvalidState
+		}
+		p.Status = models.PaymentCaptured
+		return nil
+	})
+}
+
+// Void releases an authorized payment, or abandons one waiting for its challenge
+func (f *Fake) Void(ctx context.Context, reference string) (*Result, error) {
+	return f.update(reference, func(p *Result) error {
+		if p.Status != models.PaymentAuthorized && p.Status != models.PaymentRequiresAction {
+			return ErrInvalidState
+		}
+		p.Status, p.ActionURL = models.PaymentVoided, ""
+		return nil
+	})
+}
+
+// Refund returns up to the captured amount to the buyer
+func (f *Fake) Refund(ctx context.Context, reference string, amount models.Money) (*Result, error) {
+	return f.update(reference, func(p *Result) error {
+		if p.Status != models.PaymentCaptured {
+			return ErrInvalidState
+		}
+		if amount.Currency != p.Amount.Currency || amount.Amount <= 0 || amount.Amount > p.Amount.Amount {
+			return fmt.Errorf("invalid refund amount %d %s", amount.Amount, amount.Currency)
+		}
+		p.Status = models.PaymentRefunded
+		return nil
+	})
+}
+
+// Complete resolves the challenge of a payment as the buyer would: approved payments are
+// authorized, others fail. The outcome is reported with a signed webhook callback; when the
+// callback cannot be delivered the outcome stands and the error is returned.
+func (f *Fake) Complete(ctx context.Context, reference string, approve bool) (*Result, error) {
+	result, err := f.update(reference, func(p *Result) error {
+		if p.Status != models.PaymentRequiresAction {
+			return ErrInvalidState
+		}
+		p.ActionURL = ""
+		if approve {
+			p.Status = models.PaymentAuthorized
+		} else {
+			p.Status, p.FailureReason = models.PaymentFailed, "authentication_failed"
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	event := Event{ID: "evt_" + uuid.NewString(), Type: EventAuthorized, CreatedAt: time.Now().UTC(), Payment: *result}
+	if !approve {
+		event.Type = EventFailed
+	}
+	return result, f.send(ctx, event)
+}
+
+// send signs an event and delivers it to the webhook
+func (f *Fake) send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	signature := Sign(f.Secret, body, time.Now())
+	if f.Deliver != nil {
+		return f.Deliver(ctx, body, signature)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", f.WebhookURL, res.Status)
+	}
+	return nil
+}
+
+// store keeps a new payment and returns a copy of it
+func (f *Fake) store(result *Result) *Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.payments == nil {
+		f.payments = make(map[string]*Result)
+	}
+	f.payments[result.Reference] = result
+	copied := *result
+	return &copied
+}
+
+// update changes a payment while holding the lock and returns a copy of it
+func (f *Fake) update(reference string, change func(*Result) error) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[reference]
+	if !ok {
+		return nil, ErrUnknownPayment
+	}
+	if err := change(payment); err != nil {
+		return nil, err
+	}
+	copied := *payment
+	return &copied, nil
+}
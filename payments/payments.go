@@ -0,0 +1,47 @@
+// Package payments talks to payment providers. A PaymentProvider authorizes, captures, voids
+// and refunds card payments; providers report the outcome of payments that need the buyer to
+// complete a challenge, such as 3-D Secure, through signed webhook callbacks. Fake is a local
+// provider simulating these outcomes for development and tests.
+package payments
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alwilion/models"
+)
+
+// ErrUnknownPayment is returned when the provider does not know the payment reference
+var ErrUnknownPayment = errors.New("unknown payment")
+
+// ErrInvalidState is returned when a payment cannot be captured, voided or refunded in its current state
+var ErrInvalidState = errors.New("payment cannot do this in its current state")
+
+// AuthorizeRequest asks the provider to hold an amount on the buyer's card
+type AuthorizeRequest struct {
+	Amount      models.Money // Amount to hold
+	Method      string       // Payment method collected by the client, such as a card token
+	Description string       // Shown on the buyer's statement, such as "Order 42"
+}
+
+// Result is the state of a payment at the provider. Declines are results, not errors: errors
+// mean the provider could not be reached or refused the request.
+type Result struct {
+	Reference     string               `json:"reference"` // Identifier of the payment at the provider
+	Status        models.PaymentStatus `json:"status"`
+	Amount        models.Money         `json:"amount"`                   // Authorized amount
+	ActionURL     string               `json:"action_url,omitempty"`     // Where the buyer completes a challenge, while the payment requires action
+	FailureReason string               `json:"failure_reason,omitempty"` // Why the payment was declined or failed
+}
+
+// PaymentProvider processes card payments. Payments are authorized first, which may require
+// the buyer to complete a challenge at the result's ActionURL; its outcome is reported by a
+// webhook. Authorized payments are captured to collect the amount or voided to release it,
+// and captured payments can be refunded.
+type PaymentProvider interface {
+	Name() string                                                                       // Name identifies the provider in stored payments
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)               // Authorize holds the amount on the buyer's card
+	Capture(ctx context.Context, reference string) (*Result, error)                     // Capture collects an authorized payment
+	Void(ctx context.Context, reference string) (*Result, error)                        // Void releases an authorized payment without collecting it
+	Refund(ctx context.Context, reference string, amount models.Money) (*Result, error) // Refund returns a captured amount to the buyer
+}
@@ -0,0 +1,118 @@
+package payments
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	signature := Sign("secret", body, now)
+
+	assert.NoError(t, Verify("secret", body, signature, now))
+	assert.NoError(t, Verify("secret", body, signature, now.Add(SignatureTolerance)))
+	assert.ErrorIs(t, Verify("secret", body, signature, now.Add(SignatureTolerance+time.Second)), ErrInvalidSignature, "replayed too late")
+	assert.ErrorIs(t, Verify("other", body, signature, now), ErrInvalidSignature, "wrong secret")
+	assert.ErrorIs(t, Verify("", body, Sign("", body, now), now), ErrInvalidSignature, "no secret configured")
+	assert.ErrorIs(t, Verify("secret", []byte(`{"id":"evt_2"}`), signature, now), ErrInvalidSignature, "body changed")
+	assert.ErrorIs(t, Verify("secret", body, "", now), ErrInvalidSignature)
+
+	// Moving the timestamp breaks the signature
+	forged := Sign("secret", body, now)[len("t=1700000000"):]
+	assert.ErrorIs(t, Verify("secret", body, "t=1700000100"+forged, now), ErrInvalidSignature)
+}
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake("secret", "", "http://localhost/payments/fake")
+	amount := models.Money{Amount: 1999, Currency: "EUR"}
+
+	paid, err := fake.Authorize(ctx, AuthorizeRequest{Amount: amount, Method: "4242 4242 4242 4242"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentAuthorized, paid.Status)
+	_, err = fake.Refund(ctx, paid.Reference, amount)
+	assert.ErrorIs(t, err, ErrInvalidState, "refunding before capture")
+	captured, err := fake.Capture(ctx, paid.Reference)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentCaptured, captured.Status)
+	_, err = fake.Void(ctx, paid.Reference)
+	assert.ErrorIs(t, err, ErrInvalidState, "voiding after capture")
+	_, err = fake.Refund(ctx, paid.Reference, models.Money{Amount: 1999, Currency: "USD"})
+	assert.Error(t, err, "refunding in another currency")
+	refunded, err := fake.Refund(ctx, paid.Reference, amount)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentRefunded, refunded.Status)
+
+	declined, err := fake.Authorize(ctx, AuthorizeRequest{Amount: amount, Method: FakeCardDecline})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentDeclined, declined.Status)
+	assert.Equal(t, "card_declined", declined.FailureReason)
+	_, err = fake.Capture(ctx, declined.Reference)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = fake.Capture(ctx, "fake_missing")
+	assert.ErrorIs(t, err, ErrUnknownPayment)
+
+	voided, err := fake.Authorize(ctx, AuthorizeRequest{Amount: amount, Method: FakeCardSuccess})
+	assert.NoError(t, err)
+	voided, err = fake.Void(ctx, voided.Reference)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentVoided, voided.Status)
+}
+
+func TestFakeChallenge(t *testing.T) {
+	ctx := context.Background()
+	var received []*Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		event, err := ParseEvent("secret", body, r.Header.Get(SignatureHeader), time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+	fake := NewFake("secret", server.URL, "http://localhost/payments/fake/")
+	amount := models.Money{Amount: 500, Currency: "EUR"}
+
+	pending, err := fake.Authorize(ctx, AuthorizeRequest{Amount: amount, Method: FakeCardChallenge})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentRequiresAction, pending.Status)
+	assert.Equal(t, "http://localhost/payments/fake/"+pending.Reference, pending.ActionURL)
+	_, err = fake.Capture(ctx, pending.Reference)
+	assert.ErrorIs(t, err, ErrInvalidState, "capturing before the challenge")
+
+	approved, err := fake.Complete(ctx, pending.Reference, true)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentAuthorized, approved.Status)
+	_, err = fake.Complete(ctx, pending.Reference, true)
+	assert.ErrorIs(t, err, ErrInvalidState, "completing twice")
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, EventAuthorized, received[0].Type)
+		assert.Equal(t, pending.Reference, received[0].Payment.Reference)
+	}
+
+	failing, err := fake.Authorize(ctx, AuthorizeRequest{Amount: amount, Method: FakeCardChallenge})
+	assert.NoError(t, err)
+	failed, err := fake.Complete(ctx, failing.Reference, false)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PaymentFailed, failed.Status)
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, EventFailed, received[1].Type)
+	}
+
+	// Callbacks the webhook rejects are reported
+	fake.Secret = "wrong"
+	rejected, err := fake.Authorize(ctx, AuthorizeRequest{Amount: amount, Method: FakeCardChallenge})
+	assert.NoError(t, err)
+	_, err = fake.Complete(ctx, rejected.Reference, true)
+	assert.Error(t, err)
+}
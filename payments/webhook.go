@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the HTTP header carrying the signature of webhook callbacks
+const SignatureHeader = "X-Payment-Signature"
+
+// SignatureTolerance is how old a signed callback may be before it is rejected as a replay
+const SignatureTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned when a webhook callback is not signed with the shared secret,
+// or was signed too long ago
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// EventType tells what happened to a payment
+type EventType string
+
+// Events reported by webhook callbacks
+const (
+	EventAuthorized EventType = "payment.authorized" // The buyer completed the challenge, the payment is authorized
+	EventFailed     EventType = "payment.failed"     // The buyer failed or abandoned the challenge
+	EventCaptured   EventType = "payment.captured"   // The payment was captured
+	EventRefunded   EventType = "payment.refunded"   // The payment was refunded
+)
+
+// Event is the body of a webhook callback
+type Event struct {
+	ID        string    `json:"id"` // Unique per event, redelivered events keep their ID
+	Type      EventType `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Payment   Result    `json:"payment"` // State of the payment after the event
+}
+
+// Sign signs a callback body sent at the given time. The signature has the form
+// "t=<unix time>,v1=<hex HMAC-SHA256 of the time, a dot and the body>", so that the time
+// cannot be changed without breaking the signature.
+func Sign(secret string, body []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac(secret, timestamp, body))
+}
+
+// Verify checks the signature of a callback body, rejecting signatures made more than
+// SignatureTolerance before or after now
+func Verify(secret string, body []byte, signature string, now time.Time) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+	var timestamp string
+	var sums [][]byte
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sum, err := hex.DecodeString(value); err == nil {
+				sums = append(sums, sum)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrInvalidSignature
+	}
+	expected := mac(secret, timestamp, body)
+	for _, sum := range sums {
+		if hmac.Equal(sum, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// mac computes the HMAC-SHA256 of a timestamp and a body
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// ParseEvent verifies the signature of a callback and decodes its event
+func ParseEvent(secret string, body []byte, signature string, now time.Time) (*Event, error) {
+	if err := Verify(secret, body, signature, now); err != nil {
+		return nil, err
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("decode webhook event: %w", err)
+	}
+	if event.Payment.Reference == "" {
+		return nil, errors.New("webhook event without payment reference")
+	}
+	return &event, nil
+}
@@ -0,0 +1,290 @@
+// Package promotions evaluates carts against the promotion rules maintained by admins and
+// breaks the resulting discounts down by promotion and cart line.
+//
+// Promotions are applied in order of priority, highest first, then by ID. Each one discounts
+// what the promotions before it left of the matching lines, so discounts never exceed the
+// line totals. Exclusive promotions are not combined: the engine compares each exclusive
+// promotion on its own with all other promotions combined and keeps whichever saves the
+// buyer more, preferring the combination on a tie.
+package promotions
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alwilion/models"
+)
+
+// Reasons a coupon cannot be used
+var (
+	ErrUnknownCoupon       = errors.New("unknown coupon")
+	ErrCouponExpired       = errors.New("coupon is not valid at this time")
+	ErrCouponExhausted     = errors.New("coupon has been used up")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to this cart")
+)
+
+// Line is a cart line promotions are evaluated against
+type Line struct {
+	ItemID      uint         // ID of the cart item
+	ProductID   uint         // Product of the line
+	SellerID    *uint        // Seller of the product, nil for listings without one
+	CategoryIDs []uint       // Categories of the product together with all categories above them
+	UnitPrice   models.Money // Price of one unit in the currency of the cart
+	Quantity    int
+}
+
+// total returns the price of the line's quantity in minor units
+func (l Line) total() int64 {
+	return l.UnitPrice.Amount * int64(l.Quantity)
+}
+
+// LineDiscount is the part of a discount taken off one cart line
+type LineDiscount struct {
+	ItemID uint         `json:"item_id"`
+	Amount models.Money `json:"amount"`
+}
+
+// Discount is what one promotion takes off the cart
+type Discount struct {
+	PromotionID  uint                 `json:"promotion_id"`
+	Name         string               `json:"name"`
+	Code         string               `json:"code,omitempty"` // Coupon code, empty for automatic promotions
+	Kind         models.PromotionKind `json:"kind"`
+	Amount       models.Money         `json:"amount"`                  // Sum of the line discounts
+	Lines        []LineDiscount       `json:"lines"`                   // Discount of every line the promotion reduced
+	FreeShipping bool                 `json:"free_shipping,omitempty"` // Set by free shipping promotions
+}
+
+// Result is the discount breakdown of a cart
+type Result struct {
+	Discounts    []Discount   `json:"discounts"`     // Discounts in the order they were applied
+	Total        models.Money `json:"total"`         // Sum of all discounts
+	FreeShipping bool         `json:"free_shipping"` // Whether a promotion makes shipping free
+}
+
+// Line returns the sum of the discounts of a cart line
+func (r *Result) Line(itemID uint) models.Money {
+	sum := models.Money{Currency: r.Total.Currency}
+	for _, discount := range r.Discounts {
+		for _, line := range discount.Lines {
+			if line.ItemID == itemID {
+				sum.Amount += line.Amount.Amount
+			}
+		}
+	}
+	return sum
+}
+
+// Options describe the checkout the cart is evaluated for
+type Options struct {
+	Currency string        // Currency of the cart, the line prices and the discounts
+	Rates    *models.Rates // Converts the amounts of fixed promotions into the currency
+	Now      time.Time     // Promotions have to be running at this time
+	Code     string        // Coupon code the buyer entered, empty for none
+	UserUses map[uint]int  // Redemptions of each promotion by the buyer, nil for guests
+}
+
+// Check reports why the buyer cannot use a promotion now: ErrCouponExpired when it is
+// inactive or outside its validity window, ErrCouponExhausted when a usage cap is reached.
+// Per-user caps are only checked for known buyers.
+func Check(p models.Promotion, now time.Time, userUses map[uint]int) error {
+	if !p.Running(now) {
+		return ErrCouponExpired
+	}
+	if p.MaxUses > 0 && p.Uses >= p.MaxUses {
+		return ErrCouponExhausted
+	}
+	if p.MaxUsesPerUser > 0 && userUses != nil && userUses[p.ID] >= p.MaxUsesPerUser {
+		return ErrCouponExhausted
+	}
+	return nil
+}
+
+// Evaluate applies the promotions to the lines of a cart. Automatic promotions that cannot be
+// used are skipped. A coupon the buyer entered has to exist, be usable and discount the cart,
+// or else its error is returned.
+func Evaluate(lines []Line, promotions []models.Promotion, opts Options) (*Result, error) {
+	code := strings.ToUpper(strings.TrimSpace(opts.Code))
+	var coupon *models.Promotion
+	var stacked, exclusive []models.Promotion
+	for _, p := range promotions {
+		if p.Code != nil {
+			if code == "" || *p.Code != code {
+				continue
+			}
+			if err := Check(p, opts.Now, opts.UserUses); err != nil {
+				return nil, err
+			}
+			found := p
+			coupon = &found
+		} else if Check(p, opts.Now, opts.UserUses) != nil {
+			continue
+		}
+		if p.Exclusive {
+			exclusive = append(exclusive, p)
+		} else {
+			stacked = append(stacked, p)
+		}
+	}
+	if code != "" && coupon == nil {
+		return nil, ErrUnknownCoupon
+	}
+
+	best, err := apply(lines, stacked, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range exclusive {
+		alone, err := apply(lines, []models.Promotion{p}, opts)
+		if err != nil {
+			return nil, err
+		}
+		if alone.Total.Amount > best.Total.Amount || (alone.Total.Amount == best.Total.Amount && len(best.Discounts) == 0) {
+			best = alone
+		}
+	}
+	if coupon != nil && !best.has(coupon.ID) {
+		return nil, ErrCouponNotApplicable
+	}
+	return best, nil
+}
+
+// has reports whether the promotion contributed to the result
+func (r *Result) has(promotionID uint) bool {
+	for _, discount := range r.Discounts {
+		if discount.PromotionID == promotionID {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies promotions one after the other in order of priority, each to what the ones
+// before it left of the lines
+func apply(lines []Line, promotions []models.Promotion, opts Options) (*Result, error) {
+	sort.SliceStable(promotions, func(i, j int) bool {
+		if promotions[i].Priority != promotions[j].Priority {
+			return promotions[i].Priority > promotions[j].Priority
+		}
+		return promotions[i].ID < promotions[j].ID
+	})
+	remaining := make([]int64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.total()
+	}
+
+	result := &Result{Discounts: []Discount{}, Total: models.Money{Currency: opts.Currency}}
+	for _, p := range promotions {
+		matching := []int{}
+		for i, line := range lines {
+			if p.Matches(line.ProductID, line.SellerID, line.CategoryIDs) {
+				matching = append(matching, i)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		discount := Discount{PromotionID: p.ID, Name: p.Name, Kind: p.Kind, Amount: models.Money{Currency: opts.Currency}, Lines: []LineDiscount{}}
+		if p.Code != nil {
+			discount.Code = *p.Code
+		}
+		amounts, err := discounts(p, lines, matching, remaining, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range matching {
+			amount := min(amounts[i], remaining[i])
+			if amount <= 0 {
+				continue
+			}
+			remaining[i] -= amount
+			discount.Amount.Amount += amount
+			discount.Lines = append(discount.Lines, LineDiscount{ItemID: lines[i].ItemID, Amount: models.Money{Amount: amount, Currency: opts.Currency}})
+		}
+		if p.Kind == models.PromotionFreeShipping {
+			discount.FreeShipping, result.FreeShipping = true, true
+		} else if discount.Amount.Amount == 0 {
+			continue
+		}
+		result.Discounts = append(result.Discounts, discount)
+		result.Total.Amount += discount.Amount.Amount
+	}
+	return result, nil
+}
+
+// discounts computes what a promotion takes off each matching line, given what is left of them
+func discounts(p models.Promotion, lines []Line, matching []int, remaining []int64, opts Options) (map[int]int64, error) {
+	amounts := make(map[int]int64, len(matching))
+	switch p.Kind {
+	case models.PromotionPercent:
+		for _, i := range matching {
+			amounts[i] = percentOf(remaining[i], p.Percent)
+		}
+
+	case models.PromotionFixed:
+		off, err := opts.Rates.Convert(p.Amount, opts.Currency)
+		if err != nil {
+			return nil, err
+		}
+		var left int64
+		for _, i := range matching {
+			left += remaining[i]
+		}
+		spread(amounts, matching, remaining, min(off.Amount, left), left)
+
+	case models.PromotionBuyXGetY:
+		// Of every group of buy+get units, the most expensive first, the last get units are discounted
+		type unit struct {
+			line  int
+			price int64
+		}
+		var units []unit
+		for _, i := range matching {
+			for n := 0; n < lines[i].Quantity; n++ {
+				units = append(units, unit{line: i, price: lines[i].UnitPrice.Amount})
+			}
+		}
+		sort.SliceStable(units, func(a, b int) bool { return units[a].price > units[b].price })
+		group := p.BuyQuantity + p.GetQuantity
+		if p.GetQuantity <= 0 || group <= 0 {
+			break
+		}
+		for n := group - 1; n < len(units); n += group {
+			for free := 0; free < p.GetQuantity; free++ {
+				u := units[n-free]
+				amounts[u.line] += percentOf(u.price, p.Percent)
+			}
+		}
+	}
+	return amounts, nil
+}
+
+// spread divides an amount over lines in proportion to what is left of them. The minor units
+// lost to rounding down go to the lines in order, so the parts add up to the amount.
+func spread(amounts map[int]int64, matching []int, remaining []int64, amount, left int64) {
+	if amount <= 0 || left <= 0 {
+		return
+	}
+	var given int64
+	for _, i := range matching {
+		amounts[i] = amount * remaining[i] / left
+		given += amounts[i]
+	}
+	for _, i := range matching {
+		if given == amount {
+			break
+		}
+		if amounts[i] < remaining[i] {
+			amounts[i]++
+			given++
+		}
+	}
+}
+
+// percentOf returns percent of an amount, rounding half up
+func percentOf(amount int64, percent int) int64 {
+	return (amount*int64(percent) + 50) / 100
+}
@@ -0,0 +1,118 @@
+package promotions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alwilion/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func usd(cents int64) models.Money {
+	return models.Money{Amount: cents, Currency: "USD"}
+}
+
+func code(text string) *string {
+	return &text
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	rates, _ := models.NewRates("USD", []models.ExchangeRate{{Currency: "EUR", Rate: "0.5"}})
+	opts := Options{Currency: "USD", Rates: rates, Now: now}
+	sellerID := uint(7)
+	lines := []Line{
+		{ItemID: 1, ProductID: 10, SellerID: &sellerID, CategoryIDs: []uint{3, 1}, UnitPrice: usd(1000), Quantity: 2},
+		{ItemID: 2, ProductID: 11, CategoryIDs: []uint{2}, UnitPrice: usd(500), Quantity: 1},
+	}
+
+	// Percent promotions apply to the lines in scope, rounding half up
+	tenOff := models.Promotion{ID: 1, Name: "10% off", Kind: models.PromotionPercent, Percent: 10, Scope: models.PromotionScopeCategories, TargetIDs: models.IDList{1}, Active: true}
+	result, err := Evaluate(lines, []models.Promotion{tenOff}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, usd(200), result.Total)
+	assert.Equal(t, usd(200), result.Line(1))
+	assert.Equal(t, usd(0), result.Line(2))
+
+	// Stacked promotions apply by priority to what is left; fixed amounts are converted and spread
+	fiveEuros := models.Promotion{ID: 2, Name: "5 EUR off", Kind: models.PromotionFixed, Amount: models.Money{Amount: 500, Currency: "EUR"}, Scope: models.PromotionScopeAll, Priority: 1, Active: true}
+	result, err = Evaluate(lines, []models.Promotion{tenOff, fiveEuros}, opts)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Discounts, 2) {
+		assert.Equal(t, uint(2), result.Discounts[0].PromotionID)
+		assert.Equal(t, usd(1000), result.Discounts[0].Amount)
+		assert.Equal(t, []LineDiscount{{ItemID: 1, Amount: usd(800)}, {ItemID: 2, Amount: usd(200)}}, result.Discounts[0].Lines)
+		assert.Equal(t, usd(120), result.Discounts[1].Amount, "10% of the 1200 left of line 1")
+	}
+	assert.Equal(t, usd(1120), result.Total)
+
+	// Buy two, get one free: the cheapest unit of every three is free
+	bogo := models.Promotion{ID: 3, Name: "3 for 2", Kind: models.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Percent: 100, Scope: models.PromotionScopeAll, Active: true}
+	result, err = Evaluate(lines, []models.Promotion{bogo}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, usd(500), result.Total)
+	assert.Equal(t, usd(500), result.Line(2))
+
+	// Exclusive promotions win only when they save more than all others combined
+	half := models.Promotion{ID: 4, Name: "30% off", Kind: models.PromotionPercent, Percent: 30, Scope: models.PromotionScopeSellers, TargetIDs: models.IDList{7}, Exclusive: true, Active: true}
+	result, err = Evaluate(lines, []models.Promotion{tenOff, bogo, half}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, usd(700), result.Total, "10% and 3 for 2 beat 30% off line 1")
+	result, err = Evaluate(lines, []models.Promotion{tenOff, half}, opts)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Discounts, 1) {
+		assert.Equal(t, uint(4), result.Discounts[0].PromotionID)
+	}
+
+	// Free shipping is flagged without an amount
+	shipping := models.Promotion{ID: 5, Name: "Free shipping", Kind: models.PromotionFreeShipping, Scope: models.PromotionScopeProducts, TargetIDs: models.IDList{11}, Active: true}
+	result, err = Evaluate(lines, []models.Promotion{shipping}, opts)
+	assert.NoError(t, err)
+	assert.True(t, result.FreeShipping)
+	assert.Equal(t, usd(0), result.Total)
+
+	// Promotions outside their window, inactive or used up do not apply
+	past := now.Add(-time.Hour)
+	ended := tenOff
+	ended.EndsAt = &past
+	inactive := tenOff
+	inactive.Active = false
+	usedUp := tenOff
+	usedUp.MaxUses, usedUp.Uses = 5, 5
+	result, err = Evaluate(lines, []models.Promotion{ended, inactive, usedUp}, opts)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Discounts)
+}
+
+func TestEvaluate_Coupons(t *testing.T) {
+	now := time.Now()
+	rates, _ := models.NewRates("USD", nil)
+	lines := []Line{{ItemID: 1, ProductID: 10, UnitPrice: usd(1000), Quantity: 1}}
+	coupon := models.Promotion{ID: 1, Name: "Welcome", Code: code("WELCOME"), Kind: models.PromotionPercent, Percent: 20, Scope: models.PromotionScopeAll, MaxUsesPerUser: 1, Active: true}
+	evaluate := func(text string, userUses map[uint]int, promotions ...models.Promotion) (*Result, error) {
+		return Evaluate(lines, promotions, Options{Currency: "USD", Rates: rates, Now: now, Code: text, UserUses: userUses})
+	}
+
+	result, err := evaluate("", nil, coupon)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Discounts, "coupons need their code")
+	result, err = evaluate(" welcome ", map[uint]int{}, coupon)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Discounts, 1) {
+		assert.Equal(t, "WELCOME", result.Discounts[0].Code)
+	}
+
+	_, err = evaluate("NOPE", nil, coupon)
+	assert.ErrorIs(t, err, ErrUnknownCoupon)
+	_, err = evaluate("WELCOME", map[uint]int{1: 1}, coupon)
+	assert.ErrorIs(t, err, ErrCouponExhausted)
+	future := now.Add(time.Hour)
+	early := coupon
+	early.StartsAt = &future
+	_, err = evaluate("WELCOME", nil, early)
+	assert.ErrorIs(t, err, ErrCouponExpired)
+	elsewhere := coupon
+	elsewhere.Scope, elsewhere.TargetIDs = models.PromotionScopeProducts, models.IDList{99}
+	_, err = evaluate("WELCOME", nil, elsewhere)
+	assert.ErrorIs(t, err, ErrCouponNotApplicable)
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alwilion/models"
+)
+
+// ErrCheckoutInProgress is returned when a cart is checked out while another checkout of it runs
+var ErrCheckoutInProgress = errors.New("checkout in progress")
+
+// checkoutTimeout is how long a checkout holds its cart at most, so a checkout that never
+// finished does not block the cart forever
+const checkoutTimeout = time.Minute
+
+// mergeCartItems combines the items of a cart being merged with the items of the cart it is
+// merged into. Items for a product or variant already in the target add their quantity to it,
+// keeping the price snapshot of the target so that price changes since are still reported.
+// It returns the target items whose quantity grew and the items that move to the target.
+func mergeCartItems(into *models.Cart, from []models.CartItem) (updated, moved []models.CartItem) {
+	for _, item := range from {
+		if existing := into.Item(item.ProductID, item.VariantID); existing != nil {
+			existing.Quantity += item.Quantity
+			updated = append(updated, *existing)
+			continue
+		}
+		item.CartID = into.ID
+		moved = append(moved, item)
+	}
+	return updated, moved
+}
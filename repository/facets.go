@@ -0,0 +1,40 @@
+package repository
+
+// FacetRequest selects the facet counts to compute for a product listing
+type FacetRequest struct {
+	CategoryGroups map[uint][]uint // Categories to count by ID, each with the IDs of the categories counting towards it
+	PriceBreaks    []int64         // Ascending prices in minor units of the base currency, splitting the products into len(PriceBreaks)+1 ranges
+	Tags           bool            // Count products per tag
+	Attributes     bool            // Count products per attribute value
+}
+
+// FacetCounts are the number of products matching a listing query per facet value
+type FacetCounts struct {
+	Total      int64                       // Products matching the query
+	Categories map[uint]int64              // Products per category group of the request
+	Prices     []int64                     // Products per price range: below the first break, between breaks, from the last break up
+	Tags       map[string]int64            // Products per tag
+	Attributes map[string]map[string]int64 // Products per attribute name and value, in the form of models.AttributeIndexValue
+}
+
+// newFacetCounts returns empty counts shaped after the request
+func newFacetCounts(request FacetRequest) *FacetCounts {
+	counts := &FacetCounts{
+		Categories: make(map[uint]int64),
+		Tags:       make(map[string]int64),
+		Attributes: make(map[string]map[string]int64),
+	}
+	if len(request.PriceBreaks) > 0 {
+		counts.Prices = make([]int64, len(request.PriceBreaks)+1)
+	}
+	return counts
+}
+
+// priceRange returns the index of the price range of the breaks a price falls into
+func priceRange(breaks []int64, price int64) int {
+	i := 0
+	for i < len(breaks) && price >= breaks[i] {
+		i++
+	}
+	return i
+}
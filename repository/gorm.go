@@ -0,0 +1,1943 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alwilion/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// translate maps GORM errors onto the errors exposed by this package
+func translate(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GormProductRepository is a ProductRepository backed by a GORM database
+type GormProductRepository struct {
+	db *gorm.DB
+}
+
+// NewGormProductRepository creates a ProductRepository using db
+func NewGormProductRepository(db *gorm.DB) *GormProductRepository {
+	return &GormProductRepository{db: db}
+}
+
+// List returns all products
+func (r *GormProductRepository) List() ([]models.Product, error) {
+	var products []models.Product
+	err := r.db.Order("id").Find(&products).Error
+	return products, err
+}
+
+// ListBySeller returns the products listed by one seller
+func (r *GormProductRepository) ListBySeller(sellerID uint) ([]models.Product, error) {
+	var products []models.Product
+	err := r.db.Where("seller_id = ?", sellerID).Order("id").Find(&products).Error
+	return products, err
+}
+
+// Find returns one page of the products matching the query
+func (r *GormProductRepository) Find(q ProductQuery) (*ProductPage, error) {
+	if err := q.validateCursor(); err != nil {
+		return nil, err
+	}
+
+	// Apply the filters, then count the matches before paginating
+	db := r.filtered(q)
+	var total int64
+	if err := db.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	// Continue after (or before) the cursor using the sort column and the ID as tie-breaker
+	column := productSortColumns[q.Sort.Column]
+	op, dir := ">", "ASC"
+	if q.descending() {
+		op, dir = "<", "DESC"
+	}
+	if q.Cursor != nil {
+		value, err := cursorValue(q.Cursor, q.Sort.Column)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		db = db.Where(fmt.Sprintf("(%[1]s %[2]s ?) OR (%[1]s = ? AND id %[2]s ?)", column, op), value, value, q.Cursor.ID)
+	} else if q.Offset > 0 {
+		db = db.Offset(q.Offset)
+	}
+
+	var rows []models.Product
+	err := db.Order(column + " " + dir).Order("id " + dir).Limit(q.pageSize() + 1).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return buildPage(q, rows, total), nil
+}
+
+// filtered returns a query for the products matching the filters of q
+func (r *GormProductRepository) filtered(q ProductQuery) *gorm.DB {
+	db := r.db.Model(&models.Product{})
+	if q.MinPrice != nil {
+		db = db.Where("base_price >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		db = db.Where("base_price <= ?", *q.MaxPrice)
+	}
+	if q.Name != "" {
+		db = db.Where("LOWER(name) LIKE ? ESCAPE '!'", likePattern(q.Name))
+	}
+	if q.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *q.CreatedAfter)
+	}
+	if q.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *q.CreatedBefore)
+	}
+	if q.SellerID != nil {
+		db = db.Where("seller_id = ?", *q.SellerID)
+	}
+	if len(q.CategoryIDs) > 0 {
+		db = db.Where("id IN (?)", r.db.Model(&productCategory{}).Select("product_id").Where("category_id IN ?", q.CategoryIDs))
+	}
+	for name, value := range q.Attributes {
+		db = db.Where("id IN (?)", r.db.Model(&productAttribute{}).Select("product_id").Where("name = ? AND value = ?", name, value))
+	}
+	for _, tag := range q.Tags {
+		db = db.Where("id IN (?)", r.db.Model(&productTag{}).Select("product_id").Where("tag = ?", tag))
+	}
+	return db
+}
+
+// Facets counts the products matching the filters of q by category group, price range,
+// tag and attribute value. Each count is one GROUP BY over the filtered product IDs.
+func (r *GormProductRepository) Facets(q ProductQuery, request FacetRequest) (*FacetCounts, error) {
+	counts := newFacetCounts(request)
+	if err := r.filtered(q).Count(&counts.Total).Error; err != nil {
+		return nil, err
+	}
+	ids := r.filtered(q).Select("id")
+
+	// Assignments to any category of a group count once per product
+	if len(request.CategoryGroups) > 0 {
+		groupOf := make(map[uint][]uint)
+		var categoryIDs []uint
+		for group, members := range request.CategoryGroups {
+			for _, id := range members {
+				groupOf[id] = append(groupOf[id], group)
+				categoryIDs = append(categoryIDs, id)
+			}
+		}
+		var rows []productCategory
+		err := r.db.Model(&productCategory{}).Where("category_id IN ? AND product_id IN (?)", categoryIDs, ids).Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[[2]uint]bool)
+		for _, row := range rows {
+			for _, group := range groupOf[row.CategoryID] {
+				if key := [2]uint{group, row.ProductID}; !seen[key] {
+					seen[key] = true
+					counts.Categories[group]++
+				}
+			}
+		}
+	}
+
+	// One conditional sum per price range
+	if len(request.PriceBreaks) > 0 {
+		sums := make([]string, len(counts.Prices))
+		args := []interface{}{}
+		for i := range counts.Prices {
+			switch {
+			case i == 0:
+				sums[i] = "SUM(CASE WHEN base_price < ? THEN 1 ELSE 0 END)"
+				args = append(args, request.PriceBreaks[0])
+			case i == len(request.PriceBreaks):
+				sums[i] = "SUM(CASE WHEN base_price >= ? THEN 1 ELSE 0 END)"
+				args = append(args, request.PriceBreaks[i-1])
+			default:
+				sums[i] = "SUM(CASE WHEN base_price >= ? AND base_price < ? THEN 1 ELSE 0 END)"
+				args = append(args, request.PriceBreaks[i-1], request.PriceBreaks[i])
+			}
+		}
+		values := make([]interface{}, len(counts.Prices))
+		nulls := make([]*int64, len(counts.Prices))
+		for i := range values {
+			values[i] = &nulls[i]
+		}
+		row := r.filtered(q).Select(strings.Join(sums, ", "), args...).Row()
+		if err := row.Scan(values...); err != nil {
+			return nil, err
+		}
+		for i, n := range nulls {
+			if n != nil {
+				counts.Prices[i] = *n
+			}
+		}
+	}
+
+	if request.Tags {
+		var rows []struct {
+			Tag   string
+			Count int64
+		}
+		err := r.db.Model(&productTag{}).Select("tag, COUNT(*) AS count").Where("product_id IN (?)", ids).Group("tag").Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts.Tags[row.Tag] = row.Count
+		}
+	}
+
+	if request.Attributes {
+		var rows []struct {
+			Name  string
+			Value string
+			Count int64
+		}
+		err := r.db.Model(&productAttribute{}).Select("name, value, COUNT(*) AS count").Where("product_id IN (?)", ids).Group("name, value").Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if counts.Attributes[row.Name] == nil {
+				counts.Attributes[row.Name] = make(map[string]int64)
+			}
+			counts.Attributes[row.Name][row.Value] = row.Count
+		}
+	}
+	return counts, nil
+}
+
+// Search returns one page of the products matching a full-text search, most relevant first.
+// PostgreSQL matches and ranks with its tsvector column; other databases look up candidates
+// in the product_terms index and rank them like the in-memory repository.
+func (r *GormProductRepository) Search(q SearchQuery) (*SearchPage, error) {
+	// Expand plain words with the indexed words they may be typos of
+	alternatives := make([][]string, len(q.Clauses))
+	for i, clause := range q.Clauses {
+		alternatives[i] = clause.Words
+		if !clause.Phrase && !clause.Prefix {
+			similar, err := r.similarTerms(clause.Words[0])
+			if err != nil {
+				return nil, err
+			}
+			alternatives[i] = similar
+		}
+	}
+	if r.db.Dialector.Name() == "postgres" {
+		return r.searchPostgres(q, alternatives)
+	}
+
+	// Every word of every clause must be indexed for the product. Search words consist of
+	// letters and digits only, so they never contain LIKE wildcards.
+	db := r.db.Model(&models.Product{})
+	for i, clause := range q.Clauses {
+		for _, word := range clause.Words {
+			terms := r.db.Model(&productTerm{}).Select("product_id")
+			switch {
+			case clause.Prefix:
+				terms = terms.Where("term LIKE ?", word+"%")
+			case clause.Phrase:
+				terms = terms.Where("term = ?", word)
+			default:
+				terms = terms.Where("term IN ?", alternatives[i])
+			}
+			db = db.Where("id IN (?)", terms)
+		}
+	}
+	var candidates []models.Product
+	if err := db.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	// Check phrases and compute the relevance of the candidates
+	var results []SearchResult
+	for _, p := range candidates {
+		if result, ok := matchProduct(p, q); ok {
+			results = append(results, result)
+		}
+	}
+	return pageResults(q, results), nil
+}
+
+// searchPostgres runs a search as a tsquery, ranking name matches five times higher
+func (r *GormProductRepository) searchPostgres(q SearchQuery, alternatives [][]string) (*SearchPage, error) {
+	parts := make([]string, len(q.Clauses))
+	for i, clause := range q.Clauses {
+		switch {
+		case clause.Prefix:
+			parts[i] = clause.Words[0] + ":*"
+		case clause.Phrase:
+			parts[i] = "(" + strings.Join(clause.Words, " <-> ") + ")"
+		default:
+			parts[i] = "(" + strings.Join(alternatives[i], " | ") + ")"
+		}
+	}
+	query := strings.Join(parts, " & ")
+
+	db := r.db.Model(&models.Product{}).Where("search_vector @@ to_tsquery('simple', ?)", query)
+	var total int64
+	if err := db.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		models.Product
+		Score float64
+	}
+	err := db.Select("products.*, ts_rank_cd('{0.1, 0.2, 0.2, 1.0}', search_vector, to_tsquery('simple', ?)) AS score", query).
+		Order("score DESC").Order("id").Limit(q.pageSize()).Offset(q.Offset).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Highlight the matches the same way on every database
+	page := &SearchPage{Results: make([]SearchResult, len(rows)), Total: total}
+	for i, row := range rows {
+		page.Results[i], _ = matchProduct(row.Product, q)
+		page.Results[i].Product = row.Product
+		page.Results[i].Score = row.Score
+	}
+	return page, nil
+}
+
+// similarTerms returns word and the indexed words that are typos of it
+func (r *GormProductRepository) similarTerms(word string) ([]string, error) {
+	similar := []string{word}
+	if maxEdits(word) == 0 {
+		return similar, nil
+	}
+	first, _ := utf8.DecodeRuneInString(word)
+	var candidates []string
+	err := r.db.Model(&productTerm{}).Distinct("term").Where("term LIKE ?", string(first)+"%").Pluck("term", &candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range candidates {
+		if term != word && isTypo(term, word) {
+			similar = append(similar, term)
+		}
+	}
+	return similar, nil
+}
+
+// productTerm is a row of the product_terms search index
+type productTerm struct {
+	Term      string
+	ProductID uint
+}
+
+// TableName maps productTerm onto the product_terms table
+func (productTerm) TableName() string {
+	return "product_terms"
+}
+
+// productAttribute is a row of the product_attributes filter index
+type productAttribute struct {
+	ProductID uint
+	Name      string
+	Value     string
+}
+
+// TableName maps productAttribute onto the product_attributes table
+func (productAttribute) TableName() string {
+	return "product_attributes"
+}
+
+// productTag is a row of the product_tags filter index
+type productTag struct {
+	ProductID uint
+	Tag       string
+}
+
+// TableName maps productTag onto the product_tags table
+func (productTag) TableName() string {
+	return "product_tags"
+}
+
+// indexProduct replaces the indexed words, attribute values and tags of a product
+func indexProduct(tx *gorm.DB, product *models.Product) error {
+	if err := indexTerms(tx, product); err != nil {
+		return err
+	}
+
+	if err := tx.Where("product_id = ?", product.ID).Delete(&productAttribute{}).Error; err != nil {
+		return err
+	}
+	if len(product.Attributes) > 0 {
+		rows := make([]productAttribute, 0, len(product.Attributes))
+		for name, value := range product.Attributes {
+			rows = append(rows, productAttribute{ProductID: product.ID, Name: name, Value: models.AttributeIndexValue(value)})
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("product_id = ?", product.ID).Delete(&productTag{}).Error; err != nil {
+		return err
+	}
+	if len(product.Tags) > 0 {
+		rows := make([]productTag, len(product.Tags))
+		for i, tag := range product.Tags {
+			rows[i] = productTag{ProductID: product.ID, Tag: tag}
+		}
+		return tx.Create(&rows).Error
+	}
+	return nil
+}
+
+// indexTerms replaces the indexed words of a product
+func indexTerms(tx *gorm.DB, product *models.Product) error {
+	if err := tx.Where("product_id = ?", product.ID).Delete(&productTerm{}).Error; err != nil {
+		return err
+	}
+	words := productTerms(product)
+	if len(words) == 0 {
+		return nil
+	}
+	rows := make([]productTerm, len(words))
+	for i, word := range words {
+		rows[i] = productTerm{Term: word, ProductID: product.ID}
+	}
+	return tx.CreateInBatches(rows, 500).Error
+}
+
+// Reindex rebuilds the search and filter indexes of every product, deleted ones included, and returns
+// the number of products indexed. It is needed once for products created before the index existed.
+func (r *GormProductRepository) Reindex() (int, error) {
+	count := 0
+	var batch []models.Product
+	err := r.db.Unscoped().FindInBatches(&batch, 500, func(*gorm.DB, int) error {
+		return r.db.Transaction(func(tx *gorm.DB) error {
+			for i := range batch {
+				if err := indexProduct(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			count += len(batch)
+			return nil
+		})
+	}).Error
+	return count, err
+}
+
+// Get returns the product with the given ID
+func (r *GormProductRepository) Get(id uint) (*models.Product, error) {
+	var product models.Product
+	if err := r.db.First(&product, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &product, nil
+}
+
+// Create inserts a new product and indexes it for search and filtering
+func (r *GormProductRepository) Create(product *models.Product) error {
+	product.Version = 1
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(product).Error; err != nil {
+			return err
+		}
+		return indexProduct(tx, product)
+	})
+}
+
+// Update saves all fields of an existing product and reindexes it for search and filtering
+func (r *GormProductRepository) Update(product *models.Product) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return updateProduct(tx, product)
+	})
+}
+
+// UpdateCategories saves all fields of an existing product and replaces its categories in the
+// same transaction, so a version conflict leaves both untouched
+func (r *GormProductRepository) UpdateCategories(product *models.Product, categoryIDs []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := updateProduct(tx, product); err != nil {
+			return err
+		}
+		return setCategories(tx, product.ID, categoryIDs)
+	})
+}
+
+// updateProduct saves all fields of a product unless its version changed since it was read,
+// then reindexes it. The version is incremented, or left as it was on failure.
+func updateProduct(tx *gorm.DB, product *models.Product) error {
+	expected := product.Version
+	product.Version = expected + 1
+	result := tx.Model(product).Where("version = ?", expected).Select("*").Updates(product)
+	err := result.Error
+	if err == nil && result.RowsAffected == 0 {
+		if _, err = lockProduct(tx, product.ID); err == nil {
+			err = ErrVersionConflict
+		}
+	}
+	if err == nil {
+		err = indexProduct(tx, product)
+	}
+	if err != nil {
+		product.Version = expected
+	}
+	return err
+}
+
+// Delete soft-deletes the product with the given ID
+func (r *GormProductRepository) Delete(id uint) error {
+	result := r.db.Delete(&models.Product{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reprice recomputes the base price of every product priced in the currency, including
+// deleted ones, after its exchange rate changed
+func (r *GormProductRepository) Reprice(currency string, rates *models.Rates) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var products []models.Product
+		err := tx.Unscoped().Select("id", "price_amount", "price_currency").Where("price_currency = ?", currency).Find(&products).Error
+		if err != nil {
+			return err
+		}
+		for _, p := range products {
+			base, err := rates.Convert(p.Price, rates.Base)
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&models.Product{}).Where("id = ?", p.ID).UpdateColumn("base_price", base.Amount).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CountByCurrency counts the products, including deleted ones, priced in the currency
+func (r *GormProductRepository) CountByCurrency(currency string) (int64, error) {
+	var count int64
+	err := r.db.Unscoped().Model(&models.Product{}).Where("price_currency = ?", currency).Count(&count).Error
+	return count, err
+}
+
+// Trash returns the soft-deleted products, most recently deleted first
+func (r *GormProductRepository) Trash(sellerID *uint) ([]models.Product, error) {
+	products := []models.Product{}
+	db := r.db.Unscoped().Where("deleted_at IS NOT NULL")
+	if sellerID != nil {
+		db = db.Where("seller_id = ?", *sellerID)
+	}
+	err := db.Order("deleted_at DESC, id").Find(&products).Error
+	return products, err
+}
+
+// GetDeleted returns the soft-deleted product with the given ID
+func (r *GormProductRepository) GetDeleted(id uint) (*models.Product, error) {
+	var product models.Product
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").First(&product, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &product, nil
+}
+
+// Restore clears the deletion time of a soft-deleted product. Its search index entries,
+// categories and attributes were kept while it was in the trash.
+func (r *GormProductRepository) Restore(id uint) error {
+	result := r.db.Unscoped().Model(&models.Product{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Purge permanently deletes a product. Variants, stock, prices, images, categories and the
+// search index entries of the product go with it through the cascading foreign keys.
+func (r *GormProductRepository) Purge(id uint) error {
+	result := r.db.Unscoped().Delete(&models.Product{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// productCategory is a row of the product_categories join table
+type productCategory struct {
+	ProductID  uint
+	CategoryID uint
+}
+
+// TableName maps productCategory onto the product_categories table
+func (productCategory) TableName() string {
+	return "product_categories"
+}
+
+// SetCategories replaces the categories the product is assigned to
+func (r *GormProductRepository) SetCategories(productID uint, categoryIDs []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return setCategories(tx, productID, categoryIDs)
+	})
+}
+
+// setCategories replaces the category assignments of a product within a transaction
+func setCategories(tx *gorm.DB, productID uint, categoryIDs []uint) error {
+	if err := tx.Where("product_id = ?", productID).Delete(&productCategory{}).Error; err != nil {
+		return err
+	}
+	if len(categoryIDs) == 0 {
+		return nil
+	}
+	rows := make([]productCategory, len(categoryIDs))
+	for i, id := range categoryIDs {
+		rows[i] = productCategory{ProductID: productID, CategoryID: id}
+	}
+	return tx.Create(&rows).Error
+}
+
+// CategoryIDs returns the categories the product is assigned to, ordered by ID
+func (r *GormProductRepository) CategoryIDs(productID uint) ([]uint, error) {
+	ids := []uint{}
+	err := r.db.Model(&productCategory{}).Where("product_id = ?", productID).Order("category_id").Pluck("category_id", &ids).Error
+	return ids, err
+}
+
+// GormVariantRepository is a VariantRepository backed by a GORM database
+type GormVariantRepository struct {
+	db *gorm.DB
+}
+
+// NewGormVariantRepository creates a VariantRepository using db
+func NewGormVariantRepository(db *gorm.DB) *GormVariantRepository {
+	return &GormVariantRepository{db: db}
+}
+
+// List returns the variants of a product ordered by ID
+func (r *GormVariantRepository) List(productID uint) ([]models.Variant, error) {
+	variants := []models.Variant{}
+	err := r.db.Where("product_id = ?", productID).Order("id").Find(&variants).Error
+	return variants, err
+}
+
+// Get returns the variant with the given ID
+func (r *GormVariantRepository) Get(id uint) (*models.Variant, error) {
+	var variant models.Variant
+	if err := r.db.First(&variant, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &variant, nil
+}
+
+// GetBySKU returns the variant with the given SKU
+func (r *GormVariantRepository) GetBySKU(sku string) (*models.Variant, error) {
+	var variant models.Variant
+	if err := r.db.Where("sku = ?", sku).First(&variant).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &variant, nil
+}
+
+// skuTaken reports whether another variant than id already uses the SKU
+func skuTaken(tx *gorm.DB, sku string, id uint) (bool, error) {
+	var count int64
+	err := tx.Model(&models.Variant{}).Where("sku = ? AND id <> ?", sku, id).Count(&count).Error
+	return count > 0, err
+}
+
+// Create inserts a new variant. The unique index on sku backs up the check for
+// concurrent inserts.
+func (r *GormVariantRepository) Create(variant *models.Variant) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if taken, err := skuTaken(tx, variant.SKU, 0); err != nil || taken {
+			if taken {
+				return ErrDuplicateSKU
+			}
+			return err
+		}
+		return tx.Create(variant).Error
+	})
+}
+
+// Update saves all fields of an existing variant
+func (r *GormVariantRepository) Update(variant *models.Variant) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if taken, err := skuTaken(tx, variant.SKU, variant.ID); err != nil || taken {
+			if taken {
+				return ErrDuplicateSKU
+			}
+			return err
+		}
+		return tx.Save(variant).Error
+	})
+}
+
+// Delete removes the variant with the given ID
+func (r *GormVariantRepository) Delete(id uint) error {
+	result := r.db.Delete(&models.Variant{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GormInventoryRepository is an InventoryRepository backed by a GORM database
+type GormInventoryRepository struct {
+	db *gorm.DB
+}
+
+// NewGormInventoryRepository creates an InventoryRepository using db
+func NewGormInventoryRepository(db *gorm.DB) *GormInventoryRepository {
+	return &GormInventoryRepository{db: db}
+}
+
+// Levels returns the stock of a product and its variants ordered by variant ID
+func (r *GormInventoryRepository) Levels(productID uint) ([]models.StockLevel, error) {
+	levels := []models.StockLevel{}
+	err := r.db.Where("product_id = ?", productID).Order("variant_id").Find(&levels).Error
+	return levels, err
+}
+
+// Movements returns the latest ledger entries of a product, newest first
+func (r *GormInventoryRepository) Movements(productID uint, limit int) ([]models.StockMovement, error) {
+	movements := []models.StockMovement{}
+	err := r.db.Where("product_id = ?", productID).Order("id DESC").Limit(limit).Find(&movements).Error
+	return movements, err
+}
+
+// lockLevel creates the stock level of a product or variant if it does not exist yet and
+// locks its row until the end of the transaction. SQLite has no row locks and relies on
+// its database-wide write lock instead.
+func lockLevel(tx *gorm.DB, productID, variantID uint) (*models.StockLevel, error) {
+	level := models.StockLevel{ProductID: productID, VariantID: variantID, UpdatedAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error; err != nil {
+		return nil, err
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND variant_id = ?", productID, variantID).
+		First(&level).Error
+	return &level, err
+}
+
+// move applies a movement to a locked stock level and appends it to the ledger
+func move(tx *gorm.DB, level *models.StockLevel, movement *models.StockMovement) error {
+	if err := applyMovement(level, movement); err != nil {
+		return err
+	}
+	level.UpdatedAt = time.Now()
+	err := tx.Model(&models.StockLevel{}).
+		Where("product_id = ? AND variant_id = ?", level.ProductID, level.VariantID).
+		Updates(map[string]interface{}{"on_hand": level.OnHand, "reserved": level.Reserved, "updated_at": level.UpdatedAt}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(movement).Error
+}
+
+// closeReservation ends an active reservation whose stock level is locked
+func closeReservation(tx *gorm.DB, level *models.StockLevel, reservation *models.Reservation, status models.ReservationStatus, userID *uint) error {
+	if err := move(tx, level, closingMovement(reservation, status, userID)); err != nil {
+		return err
+	}
+	reservation.Status = status
+	return tx.Model(reservation).Update("status", status).Error
+}
+
+// expireLevel releases the expired reservations of a locked stock level
+func expireLevel(tx *gorm.DB, level *models.StockLevel, now time.Time) error {
+	var expired []models.Reservation
+	err := tx.Where("product_id = ? AND variant_id = ? AND status = ? AND expires_at <= ?",
+		level.ProductID, level.VariantID, models.ReservationActive, now).Order("id").Find(&expired).Error
+	if err != nil {
+		return err
+	}
+	for i := range expired {
+		if err := closeReservation(tx, level, &expired[i], models.ReservationExpired, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record applies a receive, adjust or sell movement to the stock it names
+func (r *GormInventoryRepository) Record(movement *models.StockMovement) error {
+	if movement.Kind == models.MovementReserve || movement.Kind == models.MovementRelease || movement.ReservationID != nil {
+		return ErrInvalidMovement
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		level, err := lockLevel(tx, movement.ProductID, movement.VariantID)
+		if err != nil {
+			return err
+		}
+		return move(tx, level, movement)
+	})
+}
+
+// Reserve holds units of the stock the reservation names. Expired reservations of the same
+// stock are released first so their units count as available again.
+func (r *GormInventoryRepository) Reserve(reservation *models.Reservation, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		level, err := lockLevel(tx, reservation.ProductID, reservation.VariantID)
+		if err != nil {
+			return err
+		}
+		if err := expireLevel(tx, level, now); err != nil {
+			return err
+		}
+		if level.Available() < reservation.Quantity {
+			return ErrInsufficientStock
+		}
+		reservation.Status = models.ReservationActive
+		if err := tx.Create(reservation).Error; err != nil {
+			return err
+		}
+		return move(tx, level, &models.StockMovement{Kind: models.MovementReserve, Quantity: reservation.Quantity, ReservationID: &reservation.ID})
+	})
+}
+
+// Reservation returns the reservation with the given ID
+func (r *GormInventoryRepository) Reservation(id uint) (*models.Reservation, error) {
+	var reservation models.Reservation
+	if err := r.db.First(&reservation, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &reservation, nil
+}
+
+// finishReservation ends an active reservation with the given status, or releases it as
+// expired if it has expired. Inactive reservations are returned with ErrReservationInactive.
+func finishReservation(tx *gorm.DB, id uint, status models.ReservationStatus, userID *uint, now time.Time) (*models.Reservation, error) {
+	var reservation models.Reservation
+	if err := tx.First(&reservation, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	level, err := lockLevel(tx, reservation.ProductID, reservation.VariantID)
+	if err != nil {
+		return nil, err
+	}
+	// Read the reservation again now that nothing else can close it
+	if err := tx.First(&reservation, id).Error; err != nil {
+		return nil, err
+	}
+	if reservation.Status != models.ReservationActive {
+		return &reservation, ErrReservationInactive
+	}
+	if !reservation.ExpiresAt.After(now) {
+		status = models.ReservationExpired
+	}
+	if err := closeReservation(tx, level, &reservation, status, userID); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// finish ends an active reservation with the given status in a transaction of its own, so a
+// reservation found expired stays released as expired
+func (r *GormInventoryRepository) finish(id uint, status models.ReservationStatus, userID *uint, now time.Time) (*models.Reservation, error) {
+	var reservation *models.Reservation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		reservation, err = finishReservation(tx, id, status, userID, now)
+		return err
+	})
+	return finished(reservation, err)
+}
+
+// Release returns the units of an active reservation to the available stock
+func (r *GormInventoryRepository) Release(id uint, userID *uint, now time.Time) (*models.Reservation, error) {
+	return r.finish(id, models.ReservationReleased, userID, now)
+}
+
+// Sell takes the units of an active reservation out of the stock
+func (r *GormInventoryRepository) Sell(id uint, userID *uint, now time.Time) (*models.Reservation, error) {
+	return r.finish(id, models.ReservationSold, userID, now)
+}
+
+// ExpireReservations releases every reservation that has expired, one stock level at a time
+func (r *GormInventoryRepository) ExpireReservations(now time.Time) error {
+	var levels []models.StockLevel
+	err := r.db.Model(&models.Reservation{}).Distinct("product_id", "variant_id").
+		Where("status = ? AND expires_at <= ?", models.ReservationActive, now).Find(&levels).Error
+	if err != nil {
+		return err
+	}
+	for _, stale := range levels {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			level, err := lockLevel(tx, stale.ProductID, stale.VariantID)
+			if err != nil {
+				return err
+			}
+			return expireLevel(tx, level, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GormPriceRepository is a PriceRepository backed by a GORM database
+type GormPriceRepository struct {
+	db *gorm.DB
+}
+
+// NewGormPriceRepository creates a PriceRepository using db
+func NewGormPriceRepository(db *gorm.DB) *GormPriceRepository {
+	return &GormPriceRepository{db: db}
+}
+
+// History returns the latest price changes of a product, newest first
+func (r *GormPriceRepository) History(productID uint, limit int) ([]models.PriceChange, error) {
+	changes := []models.PriceChange{}
+	err := r.db.Where("product_id = ?", productID).Order("id DESC").Limit(limit).Find(&changes).Error
+	return changes, err
+}
+
+// Change saves a product whose price was changed by hand, replaces its categories and appends
+// the change to the history in the same transaction, so no price goes live without its history entry
+func (r *GormPriceRepository) Change(product *models.Product, change *models.PriceChange, categoryIDs []uint) error {
+	expected := product.Version
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := updateProduct(tx, product); err != nil {
+			return err
+		}
+		if err := setCategories(tx, product.ID, categoryIDs); err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+	if err != nil {
+		product.Version = expected
+	}
+	return err
+}
+
+// Schedules returns the schedules of a product ordered by start
+func (r *GormPriceRepository) Schedules(productID uint) ([]models.PriceSchedule, error) {
+	schedules := []models.PriceSchedule{}
+	err := r.db.Where("product_id = ?", productID).Order("starts_at, id").Find(&schedules).Error
+	return schedules, err
+}
+
+// Schedule returns the schedule with the given ID
+func (r *GormPriceRepository) Schedule(id uint) (*models.PriceSchedule, error) {
+	var schedule models.PriceSchedule
+	if err := r.db.First(&schedule, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &schedule, nil
+}
+
+// lockProduct locks the row of a product until the end of the transaction, serializing the
+// schedule changes of the product
+func lockProduct(tx *gorm.DB, productID uint) (*models.Product, error) {
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &product, nil
+}
+
+// CreateSchedule adds a pending schedule unless it is a sale overlapping another sale
+func (r *GormPriceRepository) CreateSchedule(schedule *models.PriceSchedule) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockProduct(tx, schedule.ProductID); err != nil {
+			return err
+		}
+		var others []models.PriceSchedule
+		if err := tx.Where("product_id = ?", schedule.ProductID).Find(&others).Error; err != nil {
+			return err
+		}
+		if err := checkOverlap(schedule, others); err != nil {
+			return err
+		}
+		schedule.Status = models.SchedulePending
+		return tx.Create(schedule).Error
+	})
+}
+
+// CancelSchedule cancels a pending schedule or ends an active sale now
+func (r *GormPriceRepository) CancelSchedule(id uint, now time.Time) (*models.PriceSchedule, error) {
+	var schedule models.PriceSchedule
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&schedule, id).Error; err != nil {
+			return translate(err)
+		}
+		if err := cancelSchedule(&schedule, now); err != nil {
+			return err
+		}
+		return tx.Save(&schedule).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// Due returns the pending schedules that have started and the active sales that have ended
+func (r *GormPriceRepository) Due(now time.Time) ([]models.PriceSchedule, error) {
+	schedules := []models.PriceSchedule{}
+	err := r.db.Where("status = ? AND starts_at <= ?", models.SchedulePending, now).
+		Or("status = ? AND ends_at <= ?", models.ScheduleActive, now).
+		Order("id").Find(&schedules).Error
+	return schedules, err
+}
+
+// Apply advances a due schedule. The product row is locked, so a schedule applied twice at
+// the same time only changes the price once.
+func (r *GormPriceRepository) Apply(id uint, rates *models.Rates, now time.Time) (*models.PriceChange, error) {
+	var change *models.PriceChange
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var schedule models.PriceSchedule
+		if err := tx.First(&schedule, id).Error; err != nil {
+			return translate(err)
+		}
+		product, err := lockProduct(tx, schedule.ProductID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		// Read the schedule again now that nothing else can apply it
+		if err := tx.First(&schedule, id).Error; err != nil {
+			return err
+		}
+		if change = applySchedule(product, &schedule, now); change != nil {
+			base, err := rates.Convert(product.Price, rates.Base)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&models.Product{}).Where("id = ?", product.ID).
+				Updates(map[string]interface{}{"price_amount": product.Price.Amount, "base_price": base.Amount, "updated_at": now, "version": gorm.Expr("version + 1")}).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(change).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(&schedule).Error
+	})
+	return change, err
+}
+
+// GormImageRepository is an ImageRepository backed by a GORM database
+type GormImageRepository struct {
+	db *gorm.DB
+}
+
+// NewGormImageRepository creates an ImageRepository using db
+func NewGormImageRepository(db *gorm.DB) *GormImageRepository {
+	return &GormImageRepository{db: db}
+}
+
+// List returns the images of a product ordered by position
+func (r *GormImageRepository) List(productID uint) ([]models.ProductImage, error) {
+	images := []models.ProductImage{}
+	err := r.db.Where("product_id = ?", productID).Order("position, id").Find(&images).Error
+	return images, err
+}
+
+// Get returns the image with the given ID
+func (r *GormImageRepository) Get(id uint) (*models.ProductImage, error) {
+	var image models.ProductImage
+	if err := r.db.First(&image, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &image, nil
+}
+
+// Create appends the image to the gallery of its product. The product row is locked so that
+// concurrent uploads get distinct positions and only one becomes primary.
+func (r *GormImageRepository) Create(image *models.ProductImage) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockProduct(tx, image.ProductID); err != nil {
+			return err
+		}
+		var last struct {
+			Count    int64
+			Position int
+		}
+		err := tx.Model(&models.ProductImage{}).Select("COUNT(*) AS count, COALESCE(MAX(position), 0) AS position").
+			Where("product_id = ?", image.ProductID).Scan(&last).Error
+		if err != nil {
+			return err
+		}
+		image.Position = last.Position + 1
+		image.Primary = last.Count == 0
+		return tx.Create(image).Error
+	})
+}
+
+// Delete removes the image with the given ID. When it was the primary image, the first
+// remaining image of the gallery takes its place.
+func (r *GormImageRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var image models.ProductImage
+		if err := tx.First(&image, id).Error; err != nil {
+			return translate(err)
+		}
+		if err := tx.Delete(&image).Error; err != nil {
+			return err
+		}
+		if !image.Primary {
+			return nil
+		}
+		var next models.ProductImage
+		err := tx.Where("product_id = ?", image.ProductID).Order("position, id").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("is_primary", true).Error
+	})
+}
+
+// Reorder sets the gallery order of a product to the order of ids
+func (r *GormImageRepository) Reorder(productID uint, ids []uint) ([]models.ProductImage, error) {
+	images := []models.ProductImage{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", productID).Find(&images).Error; err != nil {
+			return err
+		}
+		if err := orderImages(images, ids); err != nil {
+			return err
+		}
+		for _, image := range images {
+			if err := tx.Model(&image).Update("position", image.Position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// SetPrimary makes the image the primary image of its product
+func (r *GormImageRepository) SetPrimary(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var image models.ProductImage
+		if err := tx.First(&image, id).Error; err != nil {
+			return translate(err)
+		}
+		err := tx.Model(&models.ProductImage{}).Where("product_id = ? AND id <> ?", image.ProductID, id).Update("is_primary", false).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&image).Update("is_primary", true).Error
+	})
+}
+
+// Primary returns the primary images of the products by product ID
+func (r *GormImageRepository) Primary(productIDs []uint) (map[uint]models.ProductImage, error) {
+	primary := make(map[uint]models.ProductImage)
+	if len(productIDs) == 0 {
+		return primary, nil
+	}
+	var images []models.ProductImage
+	if err := r.db.Where("product_id IN ? AND is_primary = ?", productIDs, true).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		primary[image.ProductID] = image
+	}
+	return primary, nil
+}
+
+// GormCartRepository is a CartRepository backed by a GORM database
+type GormCartRepository struct {
+	db *gorm.DB
+}
+
+// NewGormCartRepository creates a CartRepository using db
+func NewGormCartRepository(db *gorm.DB) *GormCartRepository {
+	return &GormCartRepository{db: db}
+}
+
+// find returns the first cart matching the condition together with its items
+func (r *GormCartRepository) find(db *gorm.DB, query string, args ...interface{}) (*models.Cart, error) {
+	var cart models.Cart
+	if err := db.Where(query, args...).First(&cart).Error; err != nil {
+		return nil, translate(err)
+	}
+	cart.Items = []models.CartItem{}
+	if err := db.Where("cart_id = ?", cart.ID).Order("id").Find(&cart.Items).Error; err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// touch sets the update time of a cart, returning ErrNotFound when it does not exist
+func (r *GormCartRepository) touch(tx *gorm.DB, id uint, now time.Time) error {
+	result := tx.Model(&models.Cart{}).Where("id = ?", id).Update("updated_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Get returns the cart with the given ID
+func (r *GormCartRepository) Get(id uint) (*models.Cart, error) {
+	return r.find(r.db, "id = ?", id)
+}
+
+// ForUser returns the cart of a user
+func (r *GormCartRepository) ForUser(userID uint) (*models.Cart, error) {
+	return r.find(r.db, "user_id = ?", userID)
+}
+
+// ForToken returns the guest cart with the given token hash
+func (r *GormCartRepository) ForToken(tokenHash string) (*models.Cart, error) {
+	return r.find(r.db, "token_hash = ? AND user_id IS NULL", tokenHash)
+}
+
+// Create inserts an empty cart
+func (r *GormCartRepository) Create(cart *models.Cart) error {
+	cart.Items = []models.CartItem{}
+	return r.db.Create(cart).Error
+}
+
+// SaveItem inserts a new item or saves the quantity and price of an existing one
+func (r *GormCartRepository) SaveItem(item *models.CartItem) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.touch(tx, item.CartID, time.Now()); err != nil {
+			return err
+		}
+		if item.ID == 0 {
+			return tx.Create(item).Error
+		}
+		result := tx.Model(item).Where("cart_id = ?", item.CartID).Select("quantity", "price_amount", "price_currency", "updated_at").Updates(item)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteItem removes an item of the cart
+func (r *GormCartRepository) DeleteItem(cartID, itemID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND cart_id = ?", itemID, cartID).Delete(&models.CartItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return r.touch(tx, cartID, time.Now())
+	})
+}
+
+// Clear removes all items of the cart
+func (r *GormCartRepository) Clear(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.touch(tx, id, time.Now()); err != nil {
+			return err
+		}
+		return tx.Where("cart_id = ?", id).Delete(&models.CartItem{}).Error
+	})
+}
+
+// StartCheckout claims the cart for one checkout and returns it as it is now. The claim is a
+// conditional update, so of two concurrent checkouts only one gets it.
+func (r *GormCartRepository) StartCheckout(id uint, now time.Time) (*models.Cart, error) {
+	var cart *models.Cart
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Cart{}).
+			Where("id = ? AND (checkout_started_at IS NULL OR checkout_started_at <= ?)", id, now.Add(-checkoutTimeout)).
+			UpdateColumn("checkout_started_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		var err error
+		cart, err = r.find(tx, "id = ?", id)
+		if err == nil && result.RowsAffected == 0 {
+			err = ErrCheckoutInProgress
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// FinishCheckout releases the claim of StartCheckout
+func (r *GormCartRepository) FinishCheckout(id uint) error {
+	return r.db.Model(&models.Cart{}).Where("id = ?", id).UpdateColumn("checkout_started_at", nil).Error
+}
+
+// Merge moves the items of one cart into another and deletes the emptied cart
+func (r *GormCartRepository) Merge(fromID, intoID uint) (*models.Cart, error) {
+	var cart *models.Cart
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		from, err := r.find(tx, "id = ?", fromID)
+		if err != nil {
+			return err
+		}
+		into, err := r.find(tx, "id = ?", intoID)
+		if err != nil {
+			return err
+		}
+		updated, moved := mergeCartItems(into, from.Items)
+		for _, item := range updated {
+			if err := tx.Model(&item).Update("quantity", item.Quantity).Error; err != nil {
+				return err
+			}
+		}
+		for _, item := range moved {
+			if err := tx.Model(&item).Update("cart_id", intoID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("cart_id = ?", fromID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Cart{}, fromID).Error; err != nil {
+			return err
+		}
+		if err := r.touch(tx, intoID, time.Now()); err != nil {
+			return err
+		}
+		cart, err = r.find(tx, "id = ?", intoID)
+		return err
+	})
+	return cart, err
+}
+
+// PurgeGuests deletes the guest carts that have not changed since before
+func (r *GormCartRepository) PurgeGuests(before time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		stale := tx.Model(&models.Cart{}).Select("id").Where("user_id IS NULL AND updated_at < ?", before)
+		if err := tx.Where("cart_id IN (?)", stale).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id IS NULL AND updated_at < ?", before).Delete(&models.Cart{}).Error
+	})
+}
+
+// GormOrderRepository is an OrderRepository backed by a GORM database
+type GormOrderRepository struct {
+	db *gorm.DB
+}
+
+// NewGormOrderRepository creates an OrderRepository using db
+func NewGormOrderRepository(db *gorm.DB) *GormOrderRepository {
+	return &GormOrderRepository{db: db}
+}
+
+// withLines loads the lines of the orders
+func (r *GormOrderRepository) withLines(db *gorm.DB, orders []models.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(orders))
+	byID := make(map[uint]*models.Order, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+		orders[i].Lines = []models.OrderLine{}
+		byID[orders[i].ID] = &orders[i]
+	}
+	var lines []models.OrderLine
+	if err := db.Where("order_id IN ?", ids).Order("id").Find(&lines).Error; err != nil {
+		return err
+	}
+	for _, line := range lines {
+		order := byID[line.OrderID]
+		order.Lines = append(order.Lines, line)
+	}
+	return nil
+}
+
+// Create inserts a pending order with its lines
+func (r *GormOrderRepository) Create(order *models.Order) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		order.Status = models.OrderPending
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		for i := range order.Lines {
+			order.Lines[i].OrderID = order.ID
+		}
+		if len(order.Lines) == 0 {
+			return nil
+		}
+		return tx.Create(&order.Lines).Error
+	})
+}
+
+// Get returns the order with the given ID
+func (r *GormOrderRepository) Get(id uint) (*models.Order, error) {
+	var order models.Order
+	if err := r.db.First(&order, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	orders := []models.Order{order}
+	if err := r.withLines(r.db, orders); err != nil {
+		return nil, err
+	}
+	return &orders[0], nil
+}
+
+// List returns the orders matching the query, newest first
+func (r *GormOrderRepository) List(q OrderQuery) ([]models.Order, error) {
+	db := r.db
+	if q.BuyerID != nil {
+		db = db.Where("buyer_id = ?", *q.BuyerID)
+	}
+	if q.SellerID != nil {
+		db = db.Where("seller_id = ?", *q.SellerID)
+	}
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	orders := []models.Order{}
+	if err := db.Order("id DESC").Limit(q.limit()).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, r.withLines(r.db, orders)
+}
+
+// Transition moves the order to another state while its row is locked, recording the time.
+// The reservations of its lines are sold or released in the same transaction, so the stock
+// always matches the state the order ends up in.
+func (r *GormOrderRepository) Transition(id uint, to models.OrderStatus, userID *uint, now time.Time) (*models.Order, error) {
+	var order models.Order
+	lines := []models.OrderLine{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+			return translate(err)
+		}
+		if !order.SetStatus(to, now) {
+			return ErrInvalidTransition
+		}
+		if err := tx.Where("order_id = ?", id).Order("id").Find(&lines).Error; err != nil {
+			return err
+		}
+		err := settleReservations(lines, to, func(id uint, status models.ReservationStatus) (*models.Reservation, error) {
+			return finishReservation(tx, id, status, userID, now)
+		})
+		if err != nil {
+			return err
+		}
+		order.UpdatedAt = now
+		return tx.Select("*").Updates(&order).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	order.Lines = lines
+	return &order, nil
+}
+
+// Expired returns the pending orders that were not paid in time, oldest first
+func (r *GormOrderRepository) Expired(now time.Time) ([]models.Order, error) {
+	orders := []models.Order{}
+	err := r.db.Where("status = ? AND expires_at <= ?", models.OrderPending, now).Order("id").Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+	return orders, r.withLines(r.db, orders)
+}
+
+// GormPaymentRepository is a PaymentRepository backed by a GORM database
+type GormPaymentRepository struct {
+	db *gorm.DB
+}
+
+// NewGormPaymentRepository creates a PaymentRepository using db
+func NewGormPaymentRepository(db *gorm.DB) *GormPaymentRepository {
+	return &GormPaymentRepository{db: db}
+}
+
+// Create inserts the payment
+func (r *GormPaymentRepository) Create(payment *models.Payment) error {
+	return r.db.Create(payment).Error
+}
+
+// ForOrder returns the payments of an order ordered by ID
+func (r *GormPaymentRepository) ForOrder(orderID uint) ([]models.Payment, error) {
+	payments := []models.Payment{}
+	err := r.db.Where("order_id = ?", orderID).Order("id").Find(&payments).Error
+	return payments, err
+}
+
+// ByReference returns the payment a provider knows by reference
+func (r *GormPaymentRepository) ByReference(provider, reference string) (*models.Payment, error) {
+	var payment models.Payment
+	if err := r.db.Where("provider = ? AND reference = ?", provider, reference).First(&payment).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &payment, nil
+}
+
+// Transition moves the payment on from the expected state while its row is locked
+func (r *GormPaymentRepository) Transition(id uint, from, to models.PaymentStatus, reason string) (*models.Payment, error) {
+	var payment models.Payment
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, id).Error; err != nil {
+			return translate(err)
+		}
+		if err := setPaymentStatus(&payment, from, to, reason); err != nil {
+			return err
+		}
+		return tx.Select("status", "action_url", "failure_reason", "updated_at").Updates(&payment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GormPromotionRepository is a PromotionRepository backed by a GORM database
+type GormPromotionRepository struct {
+	db *gorm.DB
+}
+
+// NewGormPromotionRepository creates a PromotionRepository using db
+func NewGormPromotionRepository(db *gorm.DB) *GormPromotionRepository {
+	return &GormPromotionRepository{db: db}
+}
+
+// List returns all promotions ordered by ID
+func (r *GormPromotionRepository) List() ([]models.Promotion, error) {
+	promotions := []models.Promotion{}
+	err := r.db.Order("id").Find(&promotions).Error
+	return promotions, err
+}
+
+// ListActive returns the promotions not switched off, ordered by ID
+func (r *GormPromotionRepository) ListActive() ([]models.Promotion, error) {
+	promotions := []models.Promotion{}
+	err := r.db.Where("active = ?", true).Order("id").Find(&promotions).Error
+	return promotions, err
+}
+
+// Get returns the promotion with the given ID
+func (r *GormPromotionRepository) Get(id uint) (*models.Promotion, error) {
+	var promotion models.Promotion
+	if err := r.db.First(&promotion, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &promotion, nil
+}
+
+// codeTaken reports whether another promotion than id already uses the code
+func codeTaken(tx *gorm.DB, code *string, id uint) (bool, error) {
+	if code == nil {
+		return false, nil
+	}
+	var count int64
+	err := tx.Model(&models.Promotion{}).Where("code = ? AND id <> ?", *code, id).Count(&count).Error
+	return count > 0, err
+}
+
+// Create inserts a new promotion. The unique index on code backs up the check for
+// concurrent inserts.
+func (r *GormPromotionRepository) Create(promotion *models.Promotion) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if taken, err := codeTaken(tx, promotion.Code, 0); err != nil || taken {
+			if taken {
+				return ErrDuplicateCode
+			}
+			return err
+		}
+		return tx.Create(promotion).Error
+	})
+}
+
+// Update saves all fields of an existing promotion except its use count, which only
+// redemptions change
+func (r *GormPromotionRepository) Update(promotion *models.Promotion) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var current models.Promotion
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, promotion.ID).Error; err != nil {
+			return translate(err)
+		}
+		if taken, err := codeTaken(tx, promotion.Code, promotion.ID); err != nil || taken {
+			if taken {
+				return ErrDuplicateCode
+			}
+			return err
+		}
+		promotion.Uses, promotion.CreatedAt = current.Uses, current.CreatedAt
+		return tx.Save(promotion).Error
+	})
+}
+
+// Delete removes the promotion, its redemptions go with it
+func (r *GormPromotionRepository) Delete(id uint) error {
+	result := r.db.Delete(&models.Promotion{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UserUses counts the redemptions of each promotion by a user
+func (r *GormPromotionRepository) UserUses(userID uint) (map[uint]int, error) {
+	var rows []struct {
+		PromotionID uint
+		Uses        int
+	}
+	err := r.db.Model(&models.PromotionRedemption{}).Select("promotion_id, COUNT(*) AS uses").
+		Where("user_id = ?", userID).Group("promotion_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	uses := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		uses[row.PromotionID] = row.Uses
+	}
+	return uses, nil
+}
+
+// Redeem records the redemptions while the promotions are locked, so that concurrent
+// checkouts cannot exceed the caps
+func (r *GormPromotionRepository) Redeem(redemptions []models.PromotionRedemption) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for i := range redemptions {
+			redemption := &redemptions[i]
+			var promotion models.Promotion
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&promotion, redemption.PromotionID).Error; err != nil {
+				return translate(err)
+			}
+			var userUses int64
+			err := tx.Model(&models.PromotionRedemption{}).Where("promotion_id = ? AND user_id = ?", promotion.ID, redemption.UserID).Count(&userUses).Error
+			if err != nil {
+				return err
+			}
+			if err := checkCaps(promotion, int(userUses)); err != nil {
+				return err
+			}
+			if err := tx.Create(redemption).Error; err != nil {
+				return err
+			}
+			err = tx.Model(&models.Promotion{}).Where("id = ?", promotion.ID).Update("uses", gorm.Expr("uses + 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GormCategoryRepository is a CategoryRepository backed by a GORM database
+type GormCategoryRepository struct {
+	db *gorm.DB
+}
+
+// NewGormCategoryRepository creates a CategoryRepository using db
+func NewGormCategoryRepository(db *gorm.DB) *GormCategoryRepository {
+	return &GormCategoryRepository{db: db}
+}
+
+// List returns all categories ordered by ID
+func (r *GormCategoryRepository) List() ([]models.Category, error) {
+	var categories []models.Category
+	err := r.db.Order("id").Find(&categories).Error
+	return categories, err
+}
+
+// Get returns the category with the given ID
+func (r *GormCategoryRepository) Get(id uint) (*models.Category, error) {
+	var category models.Category
+	if err := r.db.First(&category, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &category, nil
+}
+
+// GetBySlug returns the category with the given slug
+func (r *GormCategoryRepository) GetBySlug(slug string) (*models.Category, error) {
+	var category models.Category
+	if err := r.db.Where("slug = ?", slug).First(&category).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &category, nil
+}
+
+// Create inserts a new category
+func (r *GormCategoryRepository) Create(category *models.Category) error {
+	return r.db.Create(category).Error
+}
+
+// Update saves all fields of an existing category. Moving a category only changes its
+// parent, so the products assigned to it and to its descendants move along.
+func (r *GormCategoryRepository) Update(category *models.Category) error {
+	return r.db.Save(category).Error
+}
+
+// Delete removes the category with the given ID together with its product assignments
+// and attribute definitions
+func (r *GormCategoryRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("category_id = ?", id).Delete(&productCategory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("category_id = ?", id).Delete(&models.AttributeDefinition{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Category{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// Attributes returns the attributes defined for the given categories, ordered by name
+func (r *GormCategoryRepository) Attributes(categoryIDs ...uint) ([]models.AttributeDefinition, error) {
+	attributes := []models.AttributeDefinition{}
+	if len(categoryIDs) == 0 {
+		return attributes, nil
+	}
+	err := r.db.Where("category_id IN ?", categoryIDs).Order("name").Order("id").Find(&attributes).Error
+	return attributes, err
+}
+
+// CreateAttribute defines a new attribute for a category
+func (r *GormCategoryRepository) CreateAttribute(attribute *models.AttributeDefinition) error {
+	return r.db.Create(attribute).Error
+}
+
+// DeleteAttribute removes an attribute definition. Values already stored on products are kept.
+func (r *GormCategoryRepository) DeleteAttribute(id uint) error {
+	result := r.db.Delete(&models.AttributeDefinition{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GormExchangeRateRepository is an ExchangeRateRepository backed by a GORM database
+type GormExchangeRateRepository struct {
+	db *gorm.DB
+}
+
+// NewGormExchangeRateRepository creates an ExchangeRateRepository using db
+func NewGormExchangeRateRepository(db *gorm.DB) *GormExchangeRateRepository {
+	return &GormExchangeRateRepository{db: db}
+}
+
+// List returns all exchange rates ordered by currency
+func (r *GormExchangeRateRepository) List() ([]models.ExchangeRate, error) {
+	rates := []models.ExchangeRate{}
+	err := r.db.Order("currency").Find(&rates).Error
+	return rates, err
+}
+
+// Get returns the rate of a currency
+func (r *GormExchangeRateRepository) Get(currency string) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	if err := r.db.Where("currency = ?", currency).First(&rate).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &rate, nil
+}
+
+// Save inserts or replaces the rate of a currency
+func (r *GormExchangeRateRepository) Save(rate *models.ExchangeRate) error {
+	rate.UpdatedAt = time.Now()
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(rate).Error
+}
+
+// Delete removes the rate of a currency
+func (r *GormExchangeRateRepository) Delete(currency string) error {
+	result := r.db.Where("currency = ?", currency).Delete(&models.ExchangeRate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GormTaxRateRepository is a TaxRateRepository backed by a GORM database
+type GormTaxRateRepository struct {
+	db *gorm.DB
+}
+
+// NewGormTaxRateRepository creates a TaxRateRepository using db
+func NewGormTaxRateRepository(db *gorm.DB) *GormTaxRateRepository {
+	return &GormTaxRateRepository{db: db}
+}
+
+// List returns all tax rates ordered by jurisdiction, class and name
+func (r *GormTaxRateRepository) List() ([]models.TaxRate, error) {
+	rates := []models.TaxRate{}
+	err := r.db.Order("jurisdiction, class, name").Find(&rates).Error
+	return rates, err
+}
+
+// Replace swaps the whole table for the given rates in one transaction, so checkouts never
+// see a partial table
+func (r *GormTaxRateRepository) Replace(rates []models.TaxRate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.TaxRate{}).Error; err != nil {
+			return err
+		}
+		for i := range rates {
+			rates[i].ID = 0
+		}
+		if len(rates) == 0 {
+			return nil
+		}
+		return tx.Create(&rates).Error
+	})
+}
+
+// GormUserRepository is a UserRepository backed by a GORM database
+type GormUserRepository struct {
+	db *gorm.DB
+}
+
+// NewGormUserRepository creates a UserRepository using db
+func NewGormUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
+}
+
+// Get returns the user with the given ID
+func (r *GormUserRepository) Get(id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+// GetByEmail returns the user with the given email address
+func (r *GormUserRepository) GetByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+// Create inserts a new user
+func (r *GormUserRepository) Create(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+// SetRoles replaces the roles of the user
+func (r *GormUserRepository) SetRoles(id uint, roles models.Roles) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Update("roles", roles)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GormTokenRepository is a TokenRepository backed by a GORM database
+type GormTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewGormTokenRepository creates a TokenRepository using db
+func NewGormTokenRepository(db *gorm.DB) *GormTokenRepository {
+	return &GormTokenRepository{db: db}
+}
+
+// CreateRefreshToken inserts a new refresh token
+func (r *GormTokenRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+// GetRefreshToken finds a refresh token by its hash
+func (r *GormTokenRepository) GetRefreshToken(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks the token as exchanged. The conditional update makes
+// concurrent exchanges of the same token race safely: only one of them wins.
+func (r *GormTokenRepository) UseRefreshToken(id uint, now time.Time) error {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenReused
+	}
+	return nil
+}
+
+// RevokeFamily revokes every refresh token of a login
+func (r *GormTokenRepository) RevokeFamily(family string, now time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family = ? AND revoked_at IS NULL", family).
+		Update("revoked_at", now).Error
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func (r *GormTokenRepository) RevokeUserRefreshTokens(userID uint, now time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeAccessToken adds an access token to the revocation list
+func (r *GormTokenRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	return r.db.Save(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+// IsAccessTokenRevoked reports whether the access token was revoked
+func (r *GormTokenRepository) IsAccessTokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeExpired deletes refresh tokens and revocations that have expired
+func (r *GormTokenRepository) PurgeExpired(now time.Time) error {
+	if err := r.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
+}
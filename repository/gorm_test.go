@@ -0,0 +1,1119 @@
+package repository
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alwilion/config"
+	"github.com/alwilion/database"
+	"github.com/alwilion/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// openTestDB returns a migrated in-memory SQLite database
+func openTestDB(t *testing.T) *gorm.DB {
+	cfg := config.DatabaseConfig{Driver: config.DriverSQLiteMemory}
+	db, err := database.Open(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, database.Migrate(db, cfg))
+	return db
+}
+
+// usd returns a price in US dollars, the base currency of the tests
+func usd(dollars int64) models.Money {
+	return models.Money{Amount: dollars * 100, Currency: "USD"}
+}
+
+// The GORM and in-memory repositories must behave the same way
+func TestProductRepositories(t *testing.T) {
+	repos := map[string]ProductRepository{
+		"gorm":   NewGormProductRepository(openTestDB(t)),
+		"memory": NewMemoryProductRepository(),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			product := &models.Product{Name: "Lamp", Description: "Desk lamp", Price: models.Money{Amount: 1250, Currency: "USD"}, BasePrice: 1250}
+			assert.NoError(t, repo.Create(product))
+			assert.NotZero(t, product.ID)
+
+			product.Price, product.BasePrice = usd(15), 1500
+			stale := *product
+			assert.NoError(t, repo.Update(product))
+			got, err := repo.Get(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, usd(15), got.Price)
+			assert.Equal(t, int64(1500), got.BasePrice)
+			assert.Equal(t, uint(2), got.Version)
+
+			// Updates based on an outdated version are rejected
+			stale.Name = "Floor lamp"
+			assert.ErrorIs(t, repo.Update(&stale), ErrVersionConflict)
+			assert.Equal(t, uint(1), stale.Version)
+			got, err = repo.Get(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "Lamp", got.Name)
+
+			list, err := repo.List()
+			assert.NoError(t, err)
+			assert.Len(t, list, 1)
+			list, err = repo.ListBySeller(1)
+			assert.NoError(t, err)
+			assert.Empty(t, list)
+
+			assert.NoError(t, repo.Delete(product.ID))
+			_, err = repo.Get(product.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.ErrorIs(t, repo.Delete(product.ID), ErrNotFound)
+		})
+	}
+}
+
+func TestProductRepositories_Find(t *testing.T) {
+	repos := map[string]ProductRepository{
+		"gorm":   NewGormProductRepository(openTestDB(t)),
+		"memory": NewMemoryProductRepository(),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			for i, price := range []int64{30, 10, 20, 10, 50} {
+				assert.NoError(t, repo.Create(&models.Product{Name: "Item " + string(rune('A'+i)), Price: usd(price), BasePrice: price * 100}))
+			}
+			ids := func(page *ProductPage) []uint {
+				var ids []uint
+				for _, p := range page.Products {
+					ids = append(ids, p.ID)
+				}
+				return ids
+			}
+
+			// Filters and the total count
+			min, max := int64(1000), int64(3000)
+			page, err := repo.Find(ProductQuery{MinPrice: &min, MaxPrice: &max, Name: "item"})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4), page.Total)
+			assert.Equal(t, []uint{1, 2, 3, 4}, ids(page))
+
+			// Cursor pagination forwards and backwards, ties broken by ID
+			sort, _ := ParseProductSort("price")
+			page, err = repo.Find(ProductQuery{Sort: sort, Limit: 2})
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{2, 4}, ids(page))
+			assert.Empty(t, page.PrevCursor)
+			cursor, _ := DecodeCursor(page.NextCursor)
+			page, err = repo.Find(ProductQuery{Sort: sort, Limit: 2, Cursor: cursor})
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{3, 1}, ids(page))
+			cursor, _ = DecodeCursor(page.PrevCursor)
+			page, err = repo.Find(ProductQuery{Sort: sort, Limit: 2, Cursor: cursor})
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{2, 4}, ids(page))
+			assert.Empty(t, page.PrevCursor)
+
+			// Descending offset pagination
+			sort, _ = ParseProductSort("-price")
+			page, err = repo.Find(ProductQuery{Sort: sort, Limit: 2, Offset: 2})
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{3, 4}, ids(page))
+
+			// Cursors only fit the sort they were created for
+			_, err = repo.Find(ProductQuery{Cursor: cursor})
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestProductRepositories_Search(t *testing.T) {
+	repos := map[string]ProductRepository{
+		"gorm":   NewGormProductRepository(openTestDB(t)),
+		"memory": NewMemoryProductRepository(),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			lamp := &models.Product{Name: "Desk lamp", Description: "A reading light with a flexible arm"}
+			light := &models.Product{Name: "Reading light", Description: "Clip-on lamp for books"}
+			chair := &models.Product{Name: "Office chair", Description: "Adjustable chair with armrests"}
+			for _, p := range []*models.Product{lamp, light, chair} {
+				assert.NoError(t, repo.Create(p))
+			}
+			names := func(text string) []string {
+				q, err := ParseSearch(text)
+				assert.NoError(t, err)
+				page, err := repo.Search(q)
+				assert.NoError(t, err)
+				var names []string
+				for _, r := range page.Results {
+					names = append(names, r.Name)
+				}
+				assert.Equal(t, int64(len(names)), page.Total)
+				return names
+			}
+
+			// Name matches rank above description matches
+			assert.Equal(t, []string{"Desk lamp", "Reading light"}, names("lamp"))
+			assert.Equal(t, []string{"Reading light", "Desk lamp"}, names("light"))
+			// Every clause has to match
+			assert.Equal(t, []string{"Desk lamp"}, names("lamp desk"))
+			// Phrases, prefixes and typos
+			assert.Equal(t, []string{"Reading light", "Desk lamp"}, names(`"reading light"`))
+			assert.Empty(t, names(`"light reading"`))
+			assert.Equal(t, []string{"Desk lamp", "Office chair"}, names("arm*"))
+			assert.Equal(t, []string{"Office chair"}, names("chiar"))
+
+			// Updates are reindexed and deleted products disappear
+			chair.Name = "Office stool"
+			assert.NoError(t, repo.Update(chair))
+			assert.Equal(t, []string{"Office stool"}, names("stool"))
+			assert.NoError(t, repo.Delete(chair.ID))
+			assert.Empty(t, names("stool"))
+		})
+	}
+}
+
+// Products written without the repository are found once the index is rebuilt
+func TestGormProductRepository_Reindex(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewGormProductRepository(db)
+	assert.NoError(t, db.Create(&models.Product{Name: "Bookshelf"}).Error)
+
+	q, _ := ParseSearch("bookshelf")
+	page, err := repo.Search(q)
+	assert.NoError(t, err)
+	assert.Empty(t, page.Results)
+
+	count, err := repo.Reindex()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	page, err = repo.Search(q)
+	assert.NoError(t, err)
+	assert.Len(t, page.Results, 1)
+}
+
+func TestCategoryRepositories(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			home := &models.Category{Name: "Home", Slug: "home"}
+			assert.NoError(t, store.Categories.Create(home))
+			lighting := &models.Category{Name: "Lighting", Slug: "lighting", ParentID: &home.ID}
+			assert.NoError(t, store.Categories.Create(lighting))
+			got, err := store.Categories.GetBySlug("lighting")
+			assert.NoError(t, err)
+			assert.Equal(t, home.ID, *got.ParentID)
+
+			// Products are filtered by their category assignments
+			lamp := &models.Product{Name: "Lamp", Price: usd(10), BasePrice: 1000}
+			chair := &models.Product{Name: "Chair", Price: usd(20), BasePrice: 2000}
+			assert.NoError(t, store.Products.Create(lamp))
+			assert.NoError(t, store.Products.Create(chair))
+			assert.NoError(t, store.Products.SetCategories(lamp.ID, []uint{lighting.ID, home.ID}))
+			ids, err := store.Products.CategoryIDs(lamp.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{home.ID, lighting.ID}, ids)
+			page, err := store.Products.Find(ProductQuery{CategoryIDs: []uint{lighting.ID}})
+			assert.NoError(t, err)
+			assert.Len(t, page.Products, 1)
+			assert.Equal(t, lamp.ID, page.Products[0].ID)
+
+			// Categories replaced with a stale product are left as they were
+			stale := *chair
+			assert.NoError(t, store.Products.UpdateCategories(chair, []uint{home.ID}))
+			assert.ErrorIs(t, store.Products.UpdateCategories(&stale, []uint{lighting.ID}), ErrVersionConflict)
+			ids, err = store.Products.CategoryIDs(chair.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{home.ID}, ids)
+
+			// Attribute values and tags are stored with the product and filtered through the index
+			color := &models.AttributeDefinition{CategoryID: home.ID, Name: "color", Type: models.AttributeEnum, Options: models.StringList{"Red", "Blue"}}
+			assert.NoError(t, store.Categories.CreateAttribute(color))
+			attributes, err := store.Categories.Attributes(home.ID, lighting.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, models.StringList{"Red", "Blue"}, attributes[0].Options)
+			lamp.Attributes = models.Attributes{"color": "Red", "watts": 40.0}
+			lamp.Tags = models.Tags{"sale", "new"}
+			assert.NoError(t, store.Products.Update(lamp))
+			got2, err := store.Products.Get(lamp.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, lamp.Attributes, got2.Attributes)
+			assert.Equal(t, lamp.Tags, got2.Tags)
+			page, err = store.Products.Find(ProductQuery{Attributes: map[string]string{"color": "red", "watts": "40"}, Tags: []string{"sale"}})
+			assert.NoError(t, err)
+			assert.Len(t, page.Products, 1)
+			page, err = store.Products.Find(ProductQuery{Attributes: map[string]string{"color": "blue"}})
+			assert.NoError(t, err)
+			assert.Empty(t, page.Products)
+			page, err = store.Products.Find(ProductQuery{Tags: []string{"sale", "clearance"}})
+			assert.NoError(t, err)
+			assert.Empty(t, page.Products)
+			assert.NoError(t, store.Categories.DeleteAttribute(color.ID))
+			assert.ErrorIs(t, store.Categories.DeleteAttribute(color.ID), ErrNotFound)
+
+			assert.NoError(t, store.Categories.Delete(lighting.ID))
+			assert.ErrorIs(t, store.Categories.Delete(lighting.ID), ErrNotFound)
+			list, err := store.Categories.List()
+			assert.NoError(t, err)
+			assert.Len(t, list, 1)
+		})
+	}
+}
+
+func TestProductRepositories_Facets(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			lamp := &models.Product{Name: "Lamp", Price: usd(5), BasePrice: 500, Tags: models.Tags{"sale"}, Attributes: models.Attributes{"color": "Red"}}
+			chair := &models.Product{Name: "Chair", Price: usd(30), BasePrice: 3000, Tags: models.Tags{"sale", "new"}, Attributes: models.Attributes{"color": "red"}}
+			table := &models.Product{Name: "Table", Price: usd(300), BasePrice: 30000, Attributes: models.Attributes{"color": "Blue"}}
+			for _, product := range []*models.Product{lamp, chair, table} {
+				assert.NoError(t, store.Products.Create(product))
+			}
+			home := &models.Category{Name: "Home", Slug: "home"}
+			assert.NoError(t, store.Categories.Create(home))
+			lighting := &models.Category{Name: "Lighting", Slug: "lighting", ParentID: &home.ID}
+			assert.NoError(t, store.Categories.Create(lighting))
+			garden := &models.Category{Name: "Garden", Slug: "garden"}
+			assert.NoError(t, store.Categories.Create(garden))
+			assert.NoError(t, store.Products.SetCategories(lamp.ID, []uint{home.ID, lighting.ID}))
+			assert.NoError(t, store.Products.SetCategories(chair.ID, []uint{lighting.ID}))
+
+			// A product assigned to several categories of a group counts once
+			counts, err := store.Products.Facets(ProductQuery{}, FacetRequest{
+				CategoryGroups: map[uint][]uint{home.ID: {home.ID, lighting.ID}, garden.ID: {garden.ID}},
+				PriceBreaks:    []int64{1000, 10000},
+				Tags:           true,
+				Attributes:     true,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), counts.Total)
+			assert.Equal(t, int64(2), counts.Categories[home.ID])
+			assert.Zero(t, counts.Categories[garden.ID])
+			assert.Equal(t, []int64{1, 1, 1}, counts.Prices)
+			assert.Equal(t, map[string]int64{"sale": 2, "new": 1}, counts.Tags)
+			assert.Equal(t, map[string]int64{"red": 2, "blue": 1}, counts.Attributes["color"])
+
+			// Counts follow the filters of the listing
+			counts, err = store.Products.Facets(ProductQuery{Tags: []string{"new"}}, FacetRequest{Attributes: true})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), counts.Total)
+			assert.Equal(t, map[string]int64{"red": 1}, counts.Attributes["color"])
+		})
+	}
+}
+
+func TestVariantRepositories(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			shirt := &models.Product{Name: "Shirt", Price: usd(20), BasePrice: 2000}
+			assert.NoError(t, store.Products.Create(shirt))
+			price := int64(2500)
+			small := &models.Variant{ProductID: shirt.ID, SKU: "SHIRT-S", Options: models.OptionValues{"size": "S"}, Stock: 3}
+			large := &models.Variant{ProductID: shirt.ID, SKU: "SHIRT-L", Options: models.OptionValues{"size": "L"}, Price: &price}
+			assert.NoError(t, store.Variants.Create(small))
+			assert.NoError(t, store.Variants.Create(large))
+
+			// SKUs are unique across all products
+			assert.ErrorIs(t, store.Variants.Create(&models.Variant{ProductID: shirt.ID, SKU: "SHIRT-S"}), ErrDuplicateSKU)
+			large.SKU = "SHIRT-S"
+			assert.ErrorIs(t, store.Variants.Update(large), ErrDuplicateSKU)
+			large.SKU = "SHIRT-XL"
+			assert.NoError(t, store.Variants.Update(large))
+
+			got, err := store.Variants.GetBySKU("SHIRT-XL")
+			assert.NoError(t, err)
+			assert.Equal(t, models.OptionValues{"size": "L"}, got.Options)
+			assert.Equal(t, usd(25), got.EffectivePrice(*shirt))
+			variants, err := store.Variants.List(shirt.ID)
+			assert.NoError(t, err)
+			assert.Len(t, variants, 2)
+			assert.Equal(t, small.ID, variants[0].ID)
+			assert.Nil(t, variants[0].Price)
+
+			assert.NoError(t, store.Variants.Delete(small.ID))
+			assert.ErrorIs(t, store.Variants.Delete(small.ID), ErrNotFound)
+			_, err = store.Variants.Get(small.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestInventoryRepositories(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			product := &models.Product{Name: "Mug", Price: usd(8), BasePrice: 800}
+			assert.NoError(t, store.Products.Create(product))
+			now := time.Now()
+
+			assert.NoError(t, store.Inventory.Record(&models.StockMovement{ProductID: product.ID, Kind: models.MovementReceive, Quantity: 5}))
+			assert.ErrorIs(t, store.Inventory.Record(&models.StockMovement{ProductID: product.ID, Kind: models.MovementAdjust, Quantity: -6}), ErrInsufficientStock)
+			assert.ErrorIs(t, store.Inventory.Record(&models.StockMovement{ProductID: product.ID, Kind: models.MovementReceive, Quantity: -1}), ErrInvalidMovement)
+
+			// Concurrent reservations never hold more than the stock
+			var wg sync.WaitGroup
+			var reserved atomic.Int32
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := store.Inventory.Reserve(&models.Reservation{ProductID: product.ID, Quantity: 1, ExpiresAt: now.Add(time.Minute)}, now)
+					if err == nil {
+						reserved.Add(1)
+					} else {
+						assert.ErrorIs(t, err, ErrInsufficientStock)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, int32(5), reserved.Load())
+
+			// Selling a reservation takes its units out of the stock, releasing returns them
+			levels, err := store.Inventory.Levels(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, []int{5, 5}, []int{levels[0].OnHand, levels[0].Reserved})
+			sold, err := store.Inventory.Sell(1, nil, now)
+			assert.NoError(t, err)
+			assert.Equal(t, models.ReservationSold, sold.Status)
+			_, err = store.Inventory.Release(sold.ID, nil, now)
+			assert.ErrorIs(t, err, ErrReservationInactive)
+			assert.ErrorIs(t, store.Inventory.Record(&models.StockMovement{ProductID: product.ID, Kind: models.MovementSell, Quantity: -1}), ErrInsufficientStock)
+
+			// Expired reservations return their units
+			assert.NoError(t, store.Inventory.ExpireReservations(now.Add(2*time.Minute)))
+			levels, _ = store.Inventory.Levels(product.ID)
+			assert.Equal(t, []int{4, 0}, []int{levels[0].OnHand, levels[0].Reserved})
+			movements, err := store.Inventory.Movements(product.ID, 100)
+			assert.NoError(t, err)
+			assert.Len(t, movements, 1+5+1+4)
+			assert.Equal(t, models.MovementRelease, movements[0].Kind)
+			assert.Equal(t, 4, movements[0].OnHand)
+		})
+	}
+}
+
+func TestUserRepositories(t *testing.T) {
+	repos := map[string]UserRepository{
+		"gorm":   NewGormUserRepository(openTestDB(t)),
+		"memory": NewMemoryUserRepository(),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			user := &models.User{Name: "Ann", Email: "ann@example.com", Password: []byte("hash"), Roles: models.Roles{models.RoleBuyer}}
+			assert.NoError(t, repo.Create(user))
+			assert.Error(t, repo.Create(&models.User{Name: "Ann 2", Email: "ann@example.com"}))
+
+			assert.NoError(t, repo.SetRoles(user.ID, models.Roles{models.RoleBuyer, models.RoleSeller}))
+			got, err := repo.GetByEmail("ann@example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, user.ID, got.ID)
+			assert.Equal(t, models.Roles{models.RoleBuyer, models.RoleSeller}, got.Roles)
+			assert.ErrorIs(t, repo.SetRoles(user.ID+100, nil), ErrNotFound)
+
+			_, err = repo.Get(user.ID + 100)
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestTokenRepositories(t *testing.T) {
+	db := openTestDB(t)
+	user := &models.User{Name: "Ann", Email: "ann@example.com"}
+	assert.NoError(t, NewGormUserRepository(db).Create(user))
+
+	repos := map[string]TokenRepository{
+		"gorm":   NewGormTokenRepository(db),
+		"memory": NewMemoryTokenRepository(),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			token := &models.RefreshToken{UserID: user.ID, Family: "f1", TokenHash: name, ExpiresAt: now.Add(time.Hour)}
+			assert.NoError(t, repo.CreateRefreshToken(token))
+
+			// A token can only be exchanged once
+			assert.NoError(t, repo.UseRefreshToken(token.ID, now))
+			assert.ErrorIs(t, repo.UseRefreshToken(token.ID, now), ErrTokenReused)
+
+			assert.NoError(t, repo.RevokeFamily("f1", now))
+			got, err := repo.GetRefreshToken(name)
+			assert.NoError(t, err)
+			assert.NotNil(t, got.RevokedAt)
+
+			assert.NoError(t, repo.RevokeAccessToken("jti-"+name, now.Add(-time.Minute)))
+			revoked, err := repo.IsAccessTokenRevoked("jti-" + name)
+			assert.NoError(t, err)
+			assert.True(t, revoked)
+
+			// Expired revocations are purged
+			assert.NoError(t, repo.PurgeExpired(now))
+			revoked, _ = repo.IsAccessTokenRevoked("jti-" + name)
+			assert.False(t, revoked)
+		})
+	}
+}
+
+func TestExchangeRateRepositories(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, store.Rates.Save(&models.ExchangeRate{Currency: "EUR", Rate: "0.5"}))
+			assert.NoError(t, store.Rates.Save(&models.ExchangeRate{Currency: "EUR", Rate: "0.8"}))
+			assert.NoError(t, store.Rates.Save(&models.ExchangeRate{Currency: "CHF", Rate: "0.9"}))
+			table, err := store.Rates.List()
+			assert.NoError(t, err)
+			assert.Len(t, table, 2)
+			assert.Equal(t, "CHF", table[0].Currency)
+			got, err := store.Rates.Get("EUR")
+			assert.NoError(t, err)
+			assert.Equal(t, "0.8", got.Rate)
+
+			// Base prices follow the rate of the currency a product is listed in
+			euro := &models.Product{Name: "Vase", Price: models.Money{Amount: 1000, Currency: "EUR"}}
+			dollar := &models.Product{Name: "Bowl", Price: usd(10), BasePrice: 1000}
+			assert.NoError(t, store.Products.Create(euro))
+			assert.NoError(t, store.Products.Create(dollar))
+			rates, err := models.NewRates("USD", table)
+			assert.NoError(t, err)
+			assert.NoError(t, store.Products.Reprice("EUR", rates))
+			product, err := store.Products.Get(euro.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1250), product.BasePrice)
+			product, err = store.Products.Get(dollar.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1000), product.BasePrice)
+
+			count, err := store.Products.CountByCurrency("EUR")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+
+			assert.NoError(t, store.Rates.Delete("CHF"))
+			assert.ErrorIs(t, store.Rates.Delete("CHF"), ErrNotFound)
+			_, err = store.Rates.Get("CHF")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestTaxRateRepositories(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, store.TaxRates.Replace([]models.TaxRate{
+				{Jurisdiction: "US-NY", Class: "standard", Name: "NY State Sales Tax", Rate: "4"},
+				{Jurisdiction: "DE", Class: "standard", Name: "MwSt", Rate: "19"},
+				{Jurisdiction: "DE", Class: "reduced", Name: "MwSt", Rate: "7"},
+			}))
+			rates, err := store.TaxRates.List()
+			assert.NoError(t, err)
+			if assert.Len(t, rates, 3) {
+				assert.Equal(t, "reduced", rates[0].Class)
+				assert.Equal(t, "US-NY", rates[2].Jurisdiction)
+				assert.NotZero(t, rates[2].ID)
+			}
+
+			// Replacing swaps the whole table
+			assert.NoError(t, store.TaxRates.Replace([]models.TaxRate{{Jurisdiction: "DE", Class: "standard", Name: "MwSt", Rate: "16"}}))
+			rates, err = store.TaxRates.List()
+			assert.NoError(t, err)
+			if assert.Len(t, rates, 1) {
+				assert.Equal(t, "16", rates[0].Rate)
+			}
+			assert.NoError(t, store.TaxRates.Replace(nil))
+			rates, err = store.TaxRates.List()
+			assert.NoError(t, err)
+			assert.Empty(t, rates)
+		})
+	}
+}
+
+func TestPriceRepositories(t *testing.T) {
+	db := openTestDB(t)
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	rates, _ := models.NewRates("USD", nil)
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	end := start.Add(24 * time.Hour)
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			product := &models.Product{Name: "Kettle", Price: usd(10), BasePrice: 1000}
+			assert.NoError(t, store.Products.Create(product))
+
+			// Sales of a product cannot overlap
+			sale := &models.PriceSchedule{ProductID: product.ID, Price: usd(8), StartsAt: start, EndsAt: &end}
+			assert.NoError(t, store.Prices.CreateSchedule(sale))
+			assert.Equal(t, models.SchedulePending, sale.Status)
+			overlapping := &models.PriceSchedule{ProductID: product.ID, Price: usd(7), StartsAt: start.Add(time.Hour), EndsAt: &end}
+			assert.ErrorIs(t, store.Prices.CreateSchedule(overlapping), ErrScheduleConflict)
+			change := &models.PriceSchedule{ProductID: product.ID, Price: usd(12), StartsAt: end.Add(time.Hour)}
+			assert.NoError(t, store.Prices.CreateSchedule(change))
+
+			due, err := store.Prices.Due(start.Add(-time.Minute))
+			assert.NoError(t, err)
+			assert.Empty(t, due)
+
+			// The sale sets its price and restores the previous one when it ends
+			due, err = store.Prices.Due(start)
+			assert.NoError(t, err)
+			assert.Len(t, due, 1)
+			applied, err := store.Prices.Apply(sale.ID, rates, start)
+			assert.NoError(t, err)
+			assert.Equal(t, models.PriceChangeSaleStart, applied.Reason)
+			got, err := store.Products.Get(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, usd(8), got.Price)
+			assert.Equal(t, int64(800), got.BasePrice)
+
+			// Applying again before the end changes nothing
+			applied, err = store.Prices.Apply(sale.ID, rates, start)
+			assert.NoError(t, err)
+			assert.Nil(t, applied)
+
+			applied, err = store.Prices.Apply(sale.ID, rates, end)
+			assert.NoError(t, err)
+			assert.Equal(t, usd(10), applied.NewPrice)
+			got, err = store.Products.Get(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, usd(10), got.Price)
+			schedule, err := store.Prices.Schedule(sale.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, models.ScheduleDone, schedule.Status)
+
+			// Pending schedules can be cancelled, finished ones not
+			schedule, err = store.Prices.CancelSchedule(change.ID, end)
+			assert.NoError(t, err)
+			assert.Equal(t, models.ScheduleCancelled, schedule.Status)
+			_, err = store.Prices.CancelSchedule(change.ID, end)
+			assert.ErrorIs(t, err, ErrScheduleInactive)
+			due, err = store.Prices.Due(end.Add(48 * time.Hour))
+			assert.NoError(t, err)
+			assert.Empty(t, due)
+
+			// Manual changes save the product and its history entry together
+			got.Price = usd(11)
+			stale := *got
+			assert.NoError(t, store.Prices.Change(got, &models.PriceChange{ProductID: product.ID, OldPrice: usd(10), NewPrice: usd(11), Reason: models.PriceChangeManual}, nil))
+			stale.Price = usd(12)
+			assert.ErrorIs(t, store.Prices.Change(&stale, &models.PriceChange{ProductID: product.ID, OldPrice: usd(10), NewPrice: usd(12), Reason: models.PriceChangeManual}, nil), ErrVersionConflict)
+			got, err = store.Products.Get(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, usd(11), got.Price)
+			history, err := store.Prices.History(product.ID, 2)
+			assert.NoError(t, err)
+			assert.Len(t, history, 2)
+			assert.Equal(t, models.PriceChangeManual, history[0].Reason)
+			assert.Equal(t, models.PriceChangeSaleEnd, history[1].Reason)
+			schedules, err := store.Prices.Schedules(product.ID)
+			assert.NoError(t, err)
+			assert.Len(t, schedules, 2)
+		})
+	}
+}
+
+func TestImageRepositories(t *testing.T) {
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(openTestDB(t)),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			product := &models.Product{Name: "Vase", Price: usd(30), BasePrice: 3000}
+			assert.NoError(t, store.Products.Create(product))
+			assert.ErrorIs(t, store.Images.Create(&models.ProductImage{ProductID: product.ID + 1}), ErrNotFound)
+
+			// Images are appended to the gallery and the first one becomes primary
+			var ids []uint
+			for i := 0; i < 3; i++ {
+				image := &models.ProductImage{ProductID: product.ID, Key: "products/x", ContentType: "image/png", ThumbnailType: "image/png", Size: 10, Width: 2, Height: 2}
+				assert.NoError(t, store.Images.Create(image))
+				assert.Equal(t, i+1, image.Position)
+				assert.Equal(t, i == 0, image.Primary)
+				ids = append(ids, image.ID)
+			}
+
+			// Reordering needs every image exactly once
+			_, err := store.Images.Reorder(product.ID, []uint{ids[0], ids[1]})
+			assert.ErrorIs(t, err, ErrInvalidOrder)
+			_, err = store.Images.Reorder(product.ID, []uint{ids[0], ids[0], ids[1]})
+			assert.ErrorIs(t, err, ErrInvalidOrder)
+			images, err := store.Images.Reorder(product.ID, []uint{ids[2], ids[0], ids[1]})
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{ids[2], ids[0], ids[1]}, imageIDs(images))
+			images, err = store.Images.List(product.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{ids[2], ids[0], ids[1]}, imageIDs(images))
+			assert.Equal(t, []int{1, 2, 3}, []int{images[0].Position, images[1].Position, images[2].Position})
+
+			// There is only one primary image
+			assert.NoError(t, store.Images.SetPrimary(ids[1]))
+			primary, err := store.Images.Primary([]uint{product.ID, product.ID + 1})
+			assert.NoError(t, err)
+			assert.Len(t, primary, 1)
+			assert.Equal(t, ids[1], primary[product.ID].ID)
+
+			// Deleting the primary image promotes the first of the gallery
+			assert.NoError(t, store.Images.Delete(ids[1]))
+			assert.ErrorIs(t, store.Images.Delete(ids[1]), ErrNotFound)
+			primary, err = store.Images.Primary([]uint{product.ID})
+			assert.NoError(t, err)
+			assert.Equal(t, ids[2], primary[product.ID].ID)
+			image, err := store.Images.Get(ids[2])
+			assert.NoError(t, err)
+			assert.True(t, image.Primary)
+			_, err = store.Images.Get(ids[1])
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestCartRepositories(t *testing.T) {
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(openTestDB(t)),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			user := &models.User{Name: "Ann", Email: "ann@example.com", Password: []byte("x")}
+			assert.NoError(t, store.Users.Create(user))
+			lamp := &models.Product{Name: "Lamp", Price: usd(20), BasePrice: 2000}
+			vase := &models.Product{Name: "Vase", Price: usd(30), BasePrice: 3000}
+			assert.NoError(t, store.Products.Create(lamp))
+			assert.NoError(t, store.Products.Create(vase))
+
+			hash := "guest-token-hash"
+			guest := &models.Cart{TokenHash: &hash}
+			owned := &models.Cart{UserID: &user.ID}
+			assert.NoError(t, store.Carts.Create(guest))
+			assert.NoError(t, store.Carts.Create(owned))
+			_, err := store.Carts.ForUser(user.ID + 1)
+			assert.ErrorIs(t, err, ErrNotFound)
+			found, err := store.Carts.ForToken(hash)
+			assert.NoError(t, err)
+			assert.Equal(t, guest.ID, found.ID)
+			assert.Empty(t, found.Items)
+
+			// Items are saved with their price snapshot and only change within their cart
+			item := &models.CartItem{CartID: guest.ID, ProductID: lamp.ID, Quantity: 1, Price: usd(20)}
+			assert.NoError(t, store.Carts.SaveItem(item))
+			assert.NoError(t, store.Carts.SaveItem(&models.CartItem{CartID: guest.ID, ProductID: vase.ID, Quantity: 1, Price: usd(30)}))
+			assert.NoError(t, store.Carts.SaveItem(&models.CartItem{CartID: owned.ID, ProductID: lamp.ID, Quantity: 2, Price: usd(18)}))
+			item.Quantity = 3
+			assert.NoError(t, store.Carts.SaveItem(item))
+			assert.ErrorIs(t, store.Carts.DeleteItem(owned.ID, item.ID), ErrNotFound)
+			assert.ErrorIs(t, store.Carts.SaveItem(&models.CartItem{CartID: owned.ID + 10, ProductID: lamp.ID, Quantity: 1, Price: usd(20)}), ErrNotFound)
+
+			// Merging adds up the quantities, keeps the snapshot of the target and deletes the guest cart
+			cart, err := store.Carts.Merge(guest.ID, owned.ID)
+			assert.NoError(t, err)
+			assert.Len(t, cart.Items, 2)
+			if merged := cart.Item(lamp.ID, 0); assert.NotNil(t, merged) {
+				assert.Equal(t, 5, merged.Quantity)
+				assert.Equal(t, usd(18), merged.Price)
+			}
+			moved := cart.Item(vase.ID, 0)
+			if assert.NotNil(t, moved) {
+				assert.Equal(t, owned.ID, moved.CartID)
+			}
+			_, err = store.Carts.ForToken(hash)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// One checkout holds the cart until it finishes or times out
+			now := time.Now()
+			claimed, err := store.Carts.StartCheckout(owned.ID, now)
+			assert.NoError(t, err)
+			assert.Len(t, claimed.Items, 2)
+			_, err = store.Carts.StartCheckout(owned.ID, now)
+			assert.ErrorIs(t, err, ErrCheckoutInProgress)
+			_, err = store.Carts.StartCheckout(owned.ID, now.Add(checkoutTimeout))
+			assert.NoError(t, err)
+			assert.NoError(t, store.Carts.FinishCheckout(owned.ID))
+			_, err = store.Carts.StartCheckout(owned.ID, now)
+			assert.NoError(t, err)
+			assert.NoError(t, store.Carts.FinishCheckout(owned.ID))
+			_, err = store.Carts.StartCheckout(owned.ID+10, now)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			assert.NoError(t, store.Carts.DeleteItem(owned.ID, moved.ID))
+			assert.NoError(t, store.Carts.Clear(owned.ID))
+			cart, err = store.Carts.ForUser(user.ID)
+			assert.NoError(t, err)
+			assert.Empty(t, cart.Items)
+
+			// Only guest carts that have not changed since the cutoff are purged
+			stale := &models.Cart{TokenHash: &hash}
+			assert.NoError(t, store.Carts.Create(stale))
+			assert.NoError(t, store.Carts.SaveItem(&models.CartItem{CartID: stale.ID, ProductID: vase.ID, Quantity: 1, Price: usd(30)}))
+			assert.NoError(t, store.Carts.PurgeGuests(time.Now().Add(time.Hour)))
+			_, err = store.Carts.Get(stale.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = store.Carts.Get(owned.ID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestOrderRepositories(t *testing.T) {
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(openTestDB(t)),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			buyer := &models.User{Name: "Ann", Email: "ann@example.com", Password: []byte("x")}
+			seller := &models.User{Name: "Bob", Email: "bob@example.com", Password: []byte("x")}
+			assert.NoError(t, store.Users.Create(buyer))
+			assert.NoError(t, store.Users.Create(seller))
+			lamp := &models.Product{Name: "Lamp", Price: usd(20), BasePrice: 2000, SellerID: &seller.ID}
+			assert.NoError(t, store.Products.Create(lamp))
+
+			now := time.Now().Truncate(time.Second)
+			expiresAt := now.Add(time.Minute)
+			newOrder := func() *models.Order {
+				return &models.Order{
+					BuyerID:      buyer.ID,
+					SellerID:     &seller.ID,
+					Subtotal:     usd(40),
+					Tax:          usd(4),
+					Jurisdiction: "US-NY",
+					Taxes:        models.TaxLines{{Name: "NY State Sales Tax", Jurisdiction: "US-NY", Class: "standard", Rate: "10", Taxable: usd(40), Amount: usd(4)}},
+					Total:        usd(44),
+					ExpiresAt:    &expiresAt,
+					Lines:        []models.OrderLine{{ProductID: &lamp.ID, Name: "Lamp", Quantity: 2, UnitPrice: usd(20), Total: usd(40), TaxClass: "standard", Tax: usd(4)}},
+				}
+			}
+			first, second := newOrder(), newOrder()
+			assert.NoError(t, store.Orders.Create(first))
+			assert.NoError(t, store.Orders.Create(second))
+			assert.Equal(t, models.OrderPending, first.Status)
+			assert.Equal(t, first.ID, first.Lines[0].OrderID)
+
+			got, err := store.Orders.Get(first.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, first.Lines, got.Lines)
+			assert.Equal(t, first.Taxes, got.Taxes)
+			assert.Equal(t, usd(44), got.Total)
+			_, err = store.Orders.Get(second.ID + 1)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// Transitions follow the lifecycle and record their time
+			_, err = store.Orders.Transition(first.ID, models.OrderShipped, nil, now)
+			assert.ErrorIs(t, err, ErrInvalidTransition)
+			paid, err := store.Orders.Transition(first.ID, models.OrderPaid, nil, now)
+			assert.NoError(t, err)
+			assert.Equal(t, models.OrderPaid, paid.Status)
+			assert.True(t, paid.PaidAt.Equal(now))
+			assert.Len(t, paid.Lines, 1)
+			_, err = store.Orders.Transition(second.ID+1, models.OrderPaid, nil, now)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// Listings select by buyer, seller and status, newest first
+			orders, err := store.Orders.List(OrderQuery{BuyerID: &buyer.ID})
+			assert.NoError(t, err)
+			if assert.Len(t, orders, 2) {
+				assert.Equal(t, second.ID, orders[0].ID)
+				assert.Len(t, orders[0].Lines, 1)
+			}
+			orders, err = store.Orders.List(OrderQuery{SellerID: &seller.ID, Status: models.OrderPaid})
+			assert.NoError(t, err)
+			assert.Len(t, orders, 1)
+			orders, err = store.Orders.List(OrderQuery{SellerID: &buyer.ID})
+			assert.NoError(t, err)
+			assert.Empty(t, orders)
+
+			// Only pending orders past their payment time expire
+			orders, err = store.Orders.Expired(now)
+			assert.NoError(t, err)
+			assert.Empty(t, orders)
+			orders, err = store.Orders.Expired(expiresAt)
+			assert.NoError(t, err)
+			if assert.Len(t, orders, 1) {
+				assert.Equal(t, second.ID, orders[0].ID)
+			}
+
+			// Payments sell the reserved units and cancellations release them together with the
+			// state change; a payment that cannot sell every line changes nothing
+			assert.NoError(t, store.Inventory.Record(&models.StockMovement{ProductID: lamp.ID, Kind: models.MovementReceive, Quantity: 4}))
+			held := &models.Reservation{ProductID: lamp.ID, Quantity: 2, ExpiresAt: expiresAt}
+			late := &models.Reservation{ProductID: lamp.ID, Quantity: 2, ExpiresAt: expiresAt}
+			assert.NoError(t, store.Inventory.Reserve(held, now))
+			assert.NoError(t, store.Inventory.Reserve(late, now))
+			third, fourth := newOrder(), newOrder()
+			third.Lines[0].ReservationID, fourth.Lines[0].ReservationID = &held.ID, &late.ID
+			assert.NoError(t, store.Orders.Create(third))
+			assert.NoError(t, store.Orders.Create(fourth))
+			_, err = store.Orders.Transition(fourth.ID, models.OrderPaid, &buyer.ID, expiresAt)
+			assert.ErrorIs(t, err, ErrReservationInactive)
+			got, err = store.Orders.Get(fourth.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, models.OrderPending, got.Status)
+			_, err = store.Orders.Transition(third.ID, models.OrderPaid, &buyer.ID, now)
+			assert.NoError(t, err)
+			reservation, err := store.Inventory.Reservation(held.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, models.ReservationSold, reservation.Status)
+			_, err = store.Orders.Transition(third.ID, models.OrderCancelled, nil, now)
+			assert.ErrorIs(t, err, ErrInvalidTransition)
+			cancelled, err := store.Orders.Transition(fourth.ID, models.OrderCancelled, nil, expiresAt)
+			assert.NoError(t, err)
+			assert.Equal(t, models.OrderCancelled, cancelled.Status)
+			levels, err := store.Inventory.Levels(lamp.ID)
+			assert.NoError(t, err)
+			if assert.Len(t, levels, 1) {
+				assert.Equal(t, 2, levels[0].OnHand)
+				assert.Equal(t, 0, levels[0].Reserved)
+			}
+		})
+	}
+}
+
+// imageIDs returns the IDs of images in order
+func imageIDs(images []models.ProductImage) []uint {
+	ids := []uint{}
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
+	return ids
+}
+
+func TestProductTrash(t *testing.T) {
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(openTestDB(t)),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			first, second := uint(1), uint(2)
+			lamp := &models.Product{Name: "Lamp", Price: usd(10), BasePrice: 1000, SellerID: &first}
+			desk := &models.Product{Name: "Desk", Price: usd(90), BasePrice: 9000, SellerID: &second}
+			assert.NoError(t, store.Products.Create(lamp))
+			assert.NoError(t, store.Products.Create(desk))
+			assert.NoError(t, store.Variants.Create(&models.Variant{ProductID: lamp.ID, SKU: "LAMP-1", Options: models.OptionValues{"color": "red"}}))
+			assert.NoError(t, store.Images.Create(&models.ProductImage{ProductID: lamp.ID, Key: "products/1/images/a", ContentType: "image/png", ThumbnailType: "image/png"}))
+
+			trash, err := store.Products.Trash(nil)
+			assert.NoError(t, err)
+			assert.Empty(t, trash)
+			_, err = store.Products.GetDeleted(lamp.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.ErrorIs(t, store.Products.Restore(lamp.ID), ErrNotFound)
+
+			// Deleted products show up in the trash of their seller
+			assert.NoError(t, store.Products.Delete(lamp.ID))
+			assert.NoError(t, store.Products.Delete(desk.ID))
+			trash, err = store.Products.Trash(nil)
+			assert.NoError(t, err)
+			assert.Len(t, trash, 2)
+			trash, err = store.Products.Trash(&first)
+			assert.NoError(t, err)
+			assert.Len(t, trash, 1)
+			assert.Equal(t, lamp.ID, trash[0].ID)
+			assert.True(t, trash[0].DeletedAt.Valid)
+
+			// Restoring brings a product back
+			assert.NoError(t, store.Products.Restore(desk.ID))
+			got, err := store.Products.Get(desk.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "Desk", got.Name)
+
+			// Purging removes it for good, from the trash or not
+			_, err = store.Products.GetDeleted(lamp.ID)
+			assert.NoError(t, err)
+			assert.NoError(t, store.Products.Purge(lamp.ID))
+			assert.NoError(t, store.Products.Purge(desk.ID))
+			assert.ErrorIs(t, store.Products.Purge(lamp.ID), ErrNotFound)
+			_, err = store.Products.GetDeleted(lamp.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = store.Products.Get(desk.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			if name == "gorm" {
+				variants, err := store.Variants.List(lamp.ID)
+				assert.NoError(t, err)
+				assert.Empty(t, variants, "variants are deleted with their product")
+				images, err := store.Images.List(lamp.ID)
+				assert.NoError(t, err)
+				assert.Empty(t, images, "images are deleted with their product")
+			}
+		})
+	}
+}
+
+// Purging a product removes every record referring to it, but keeps the orders it was sold in
+func TestGormProductRepository_PurgeDependents(t *testing.T) {
+	db := openTestDB(t)
+	store := NewGormStore(db)
+	buyer := &models.User{Name: "Ann", Email: "ann@example.com", Password: []byte("x")}
+	assert.NoError(t, store.Users.Create(buyer))
+	home := &models.Category{Name: "Home", Slug: "home"}
+	assert.NoError(t, store.Categories.Create(home))
+	lamp := &models.Product{Name: "Lamp", Price: usd(10), BasePrice: 1000, Attributes: models.Attributes{"watts": "40"}, Tags: models.Tags{"desk"}}
+	assert.NoError(t, store.Products.Create(lamp))
+	assert.NoError(t, store.Products.SetCategories(lamp.ID, []uint{home.ID}))
+
+	now := time.Now()
+	starts := now.Add(time.Hour)
+	assert.NoError(t, store.Variants.Create(&models.Variant{ProductID: lamp.ID, SKU: "LAMP-1", Options: models.OptionValues{"color": "red"}}))
+	assert.NoError(t, store.Images.Create(&models.ProductImage{ProductID: lamp.ID, Key: "products/1/images/a", ContentType: "image/png", ThumbnailType: "image/png"}))
+	assert.NoError(t, store.Inventory.Record(&models.StockMovement{ProductID: lamp.ID, Kind: models.MovementReceive, Quantity: 5}))
+	assert.NoError(t, store.Inventory.Reserve(&models.Reservation{ProductID: lamp.ID, Quantity: 1, ExpiresAt: now.Add(time.Minute)}, now))
+	lamp.Price = usd(12)
+	assert.NoError(t, store.Prices.Change(lamp, &models.PriceChange{ProductID: lamp.ID, OldPrice: usd(10), NewPrice: usd(12), Reason: models.PriceChangeManual}, nil))
+	assert.NoError(t, store.Prices.CreateSchedule(&models.PriceSchedule{ProductID: lamp.ID, Price: usd(9), StartsAt: starts}))
+	cart := &models.Cart{UserID: &buyer.ID}
+	assert.NoError(t, store.Carts.Create(cart))
+	assert.NoError(t, store.Carts.SaveItem(&models.CartItem{CartID: cart.ID, ProductID: lamp.ID, Quantity: 1, Price: usd(12)}))
+	order := &models.Order{BuyerID: buyer.ID, Subtotal: usd(12), Total: usd(12), Lines: []models.OrderLine{{ProductID: &lamp.ID, Name: "Lamp", Quantity: 1, UnitPrice: usd(12), Total: usd(12)}}}
+	assert.NoError(t, store.Orders.Create(order))
+
+	assert.NoError(t, store.Products.Purge(lamp.ID))
+	tables := []string{"product_terms", "product_categories", "product_attributes", "product_tags", "product_variants", "inventory_levels",
+		"stock_reservations", "stock_movements", "price_changes", "price_schedules", "product_images", "cart_items"}
+	for _, table := range tables {
+		var count int64
+		assert.NoError(t, db.Table(table).Where("product_id = ?", lamp.ID).Count(&count).Error)
+		assert.Zero(t, count, table)
+	}
+	got, err := store.Orders.Get(order.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got.Lines[0].ProductID)
+	assert.Equal(t, "Lamp", got.Lines[0].Name)
+}
+
+func TestPaymentRepositories(t *testing.T) {
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(openTestDB(t)),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			buyer := &models.User{Name: "Ann", Email: "ann@example.com", Password: []byte("x")}
+			assert.NoError(t, store.Users.Create(buyer))
+			order := &models.Order{BuyerID: buyer.ID, Subtotal: usd(40), Total: usd(40)}
+			assert.NoError(t, store.Orders.Create(order))
+
+			declined := &models.Payment{OrderID: order.ID, Provider: "fake", Reference: "fake_1", Status: models.PaymentDeclined, Amount: usd(40), FailureReason: "card_declined"}
+			pending := &models.Payment{OrderID: order.ID, Provider: "fake", Reference: "fake_2", Status: models.PaymentRequiresAction, Amount: usd(40), ActionURL: "http://localhost/3ds/fake_2"}
+			assert.NoError(t, store.Payments.Create(declined))
+			assert.NoError(t, store.Payments.Create(pending))
+			assert.Error(t, store.Payments.Create(&models.Payment{OrderID: order.ID, Provider: "fake", Reference: "fake_1", Status: models.PaymentDeclined, Amount: usd(40)}), "references are unique per provider")
+
+			payments, err := store.Payments.ForOrder(order.ID)
+			assert.NoError(t, err)
+			if assert.Len(t, payments, 2) {
+				assert.Equal(t, declined.ID, payments[0].ID)
+				assert.Equal(t, "card_declined", payments[0].FailureReason)
+			}
+			payments, err = store.Payments.ForOrder(order.ID + 1)
+			assert.NoError(t, err)
+			assert.Empty(t, payments)
+
+			found, err := store.Payments.ByReference("fake", "fake_2")
+			assert.NoError(t, err)
+			assert.Equal(t, pending.ID, found.ID)
+			assert.Equal(t, pending.ActionURL, found.ActionURL)
+			_, err = store.Payments.ByReference("other", "fake_2")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// Transitions only apply from the expected state, so redelivered events are noticed
+			authorized, err := store.Payments.Transition(pending.ID, models.PaymentRequiresAction, models.PaymentAuthorized, "")
+			assert.NoError(t, err)
+			assert.Equal(t, models.PaymentAuthorized, authorized.Status)
+			assert.Empty(t, authorized.ActionURL)
+			_, err = store.Payments.Transition(pending.ID, models.PaymentRequiresAction, models.PaymentAuthorized, "")
+			assert.ErrorIs(t, err, ErrPaymentChanged)
+			_, err = store.Payments.Transition(pending.ID+1, models.PaymentAuthorized, models.PaymentCaptured, "")
+			assert.ErrorIs(t, err, ErrNotFound)
+			found, err = store.Payments.ByReference("fake", "fake_2")
+			assert.NoError(t, err)
+			assert.Equal(t, models.PaymentAuthorized, found.Status)
+			assert.Empty(t, found.ActionURL)
+		})
+	}
+}
+
+func TestPromotionRepositories(t *testing.T) {
+	stores := map[string]*Store{
+		"gorm":   NewGormStore(openTestDB(t)),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ann := &models.User{Name: "Ann", Email: "ann@example.com", Password: []byte("x")}
+			bob := &models.User{Name: "Bob", Email: "bob@example.com", Password: []byte("x")}
+			assert.NoError(t, store.Users.Create(ann))
+			assert.NoError(t, store.Users.Create(bob))
+
+			welcome := "WELCOME"
+			coupon := &models.Promotion{Name: "Welcome", Code: &welcome, Kind: models.PromotionPercent, Percent: 10, Scope: models.PromotionScopeCategories, TargetIDs: models.IDList{3, 4}, MaxUses: 2, MaxUsesPerUser: 1, Active: true}
+			sale := &models.Promotion{Name: "Sale", Kind: models.PromotionFixed, Amount: usd(5), Scope: models.PromotionScopeAll, Exclusive: true}
+			assert.NoError(t, store.Promotions.Create(coupon))
+			assert.NoError(t, store.Promotions.Create(sale))
+			assert.ErrorIs(t, store.Promotions.Create(&models.Promotion{Name: "Copy", Code: &welcome, Kind: models.PromotionPercent, Scope: models.PromotionScopeAll}), ErrDuplicateCode)
+
+			got, err := store.Promotions.Get(coupon.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, models.IDList{3, 4}, got.TargetIDs)
+			assert.Equal(t, "WELCOME", *got.Code)
+			all, err := store.Promotions.List()
+			assert.NoError(t, err)
+			assert.Len(t, all, 2)
+			active, err := store.Promotions.ListActive()
+			assert.NoError(t, err)
+			if assert.Len(t, active, 1) {
+				assert.Equal(t, coupon.ID, active[0].ID)
+			}
+
+			// Redemptions count against the caps, all or nothing
+			assert.NoError(t, store.Promotions.Redeem([]models.PromotionRedemption{{PromotionID: coupon.ID, UserID: ann.ID}, {PromotionID: sale.ID, UserID: ann.ID}}))
+			assert.ErrorIs(t, store.Promotions.Redeem([]models.PromotionRedemption{{PromotionID: sale.ID, UserID: ann.ID}, {PromotionID: coupon.ID, UserID: ann.ID}}), ErrPromotionExhausted)
+			uses, err := store.Promotions.UserUses(ann.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, map[uint]int{coupon.ID: 1, sale.ID: 1}, uses)
+			assert.NoError(t, store.Promotions.Redeem([]models.PromotionRedemption{{PromotionID: coupon.ID, UserID: bob.ID}}))
+			got, _ = store.Promotions.Get(coupon.ID)
+			assert.Equal(t, 2, got.Uses)
+			assert.ErrorIs(t, store.Promotions.Redeem([]models.PromotionRedemption{{PromotionID: coupon.ID, UserID: bob.ID + 1}}), ErrPromotionExhausted)
+
+			// Updates keep the use count
+			got.Uses, got.Active, got.Code = 0, false, nil
+			assert.NoError(t, store.Promotions.Update(got))
+			got, _ = store.Promotions.Get(coupon.ID)
+			assert.Equal(t, 2, got.Uses)
+			assert.Nil(t, got.Code)
+			sale.Code = &welcome
+			assert.NoError(t, store.Promotions.Update(sale), "the code was freed")
+			assert.ErrorIs(t, store.Promotions.Update(&models.Promotion{ID: sale.ID + 1}), ErrNotFound)
+
+			assert.NoError(t, store.Promotions.Delete(coupon.ID))
+			assert.ErrorIs(t, store.Promotions.Delete(coupon.ID), ErrNotFound)
+			uses, _ = store.Promotions.UserUses(ann.ID)
+			assert.Equal(t, map[uint]int{sale.ID: 1}, uses)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/alwilion/models"
+)
+
+// ErrInvalidOrder is returned when reordering the images of a product with IDs that are not
+// exactly its images
+var ErrInvalidOrder = errors.New("image IDs must list every image of the product once")
+
+// orderImages moves the images into the order of ids and renumbers their positions from 1
+func orderImages(images []models.ProductImage, ids []uint) error {
+	if len(ids) != len(images) {
+		return ErrInvalidOrder
+	}
+	positions := make(map[uint]int, len(ids))
+	for i, id := range ids {
+		if _, dup := positions[id]; dup {
+			return ErrInvalidOrder
+		}
+		positions[id] = i + 1
+	}
+	for i := range images {
+		position, ok := positions[images[i].ID]
+		if !ok {
+			return ErrInvalidOrder
+		}
+		images[i].Position = position
+	}
+	sortImages(images)
+	return nil
+}
+
+// sortImages orders images by position, then ID
+func sortImages(images []models.ProductImage) {
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].Position != images[j].Position {
+			return images[i].Position < images[j].Position
+		}
+		return images[i].ID < images[j].ID
+	})
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/alwilion/models"
+)
+
+// ErrInsufficientStock is returned when a movement would sell or reserve more units than are available
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// ErrInvalidMovement is returned for movements whose quantity does not fit their kind
+var ErrInvalidMovement = errors.New("invalid stock movement")
+
+// ErrReservationInactive is returned when releasing or selling a reservation that was already
+// released, sold or has expired
+var ErrReservationInactive = errors.New("reservation is no longer active")
+
+// applyMovement checks a movement against the stock level it changes, applies it and records
+// the resulting balances on the movement. Sales of reservations take the units from the
+// reserved stock, other sales from the available stock.
+func applyMovement(level *models.StockLevel, movement *models.StockMovement) error {
+	q := movement.Quantity
+	switch movement.Kind {
+	case models.MovementReceive:
+		if q <= 0 {
+			return ErrInvalidMovement
+		}
+		level.OnHand += q
+	case models.MovementAdjust:
+		if q == 0 {
+			return ErrInvalidMovement
+		}
+		if level.OnHand+q < level.Reserved {
+			return ErrInsufficientStock
+		}
+		level.OnHand += q
+	case models.MovementSell:
+		if q >= 0 {
+			return ErrInvalidMovement
+		}
+		if movement.ReservationID != nil {
+			level.Reserved += q
+		} else if level.Available() < -q {
+			return ErrInsufficientStock
+		}
+		level.OnHand += q
+	case models.MovementReserve:
+		if q <= 0 {
+			return ErrInvalidMovement
+		}
+		if level.Available() < q {
+			return ErrInsufficientStock
+		}
+		level.Reserved += q
+	case models.MovementRelease:
+		if q >= 0 || level.Reserved+q < 0 {
+			return ErrInvalidMovement
+		}
+		level.Reserved += q
+	default:
+		return ErrInvalidMovement
+	}
+	movement.ProductID, movement.VariantID = level.ProductID, level.VariantID
+	movement.OnHand, movement.Reserved = level.OnHand, level.Reserved
+	return nil
+}
+
+// closingMovement returns the ledger entry that ends a reservation with the given status
+func closingMovement(reservation *models.Reservation, status models.ReservationStatus, userID *uint) *models.StockMovement {
+	movement := &models.StockMovement{Kind: models.MovementRelease, Quantity: -reservation.Quantity, ReservationID: &reservation.ID, UserID: userID}
+	switch status {
+	case models.ReservationSold:
+		movement.Kind = models.MovementSell
+	case models.ReservationExpired:
+		movement.Note = "reservation expired"
+	}
+	return movement
+}
+
+// finished turns the outcome of ending a reservation into the result of Release and Sell,
+// which report a reservation found expired, and so released as expired, as inactive
+func finished(reservation *models.Reservation, err error) (*models.Reservation, error) {
+	if err == nil && reservation.Status == models.ReservationExpired {
+		err = ErrReservationInactive
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
@@ -0,0 +1,2059 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alwilion/models"
+	"gorm.io/gorm"
+)
+
+// ErrDuplicateEmail is returned by MemoryUserRepository when the email is already taken
+var ErrDuplicateEmail = errors.New("email already registered")
+
+// MemoryProductRepository is a ProductRepository kept in memory, mainly for tests and demos
+type MemoryProductRepository struct {
+	mu         sync.RWMutex
+	nextID     uint
+	products   map[uint]models.Product
+	categories map[uint][]uint // Category IDs by product ID
+}
+
+// NewMemoryProductRepository creates an empty in-memory ProductRepository
+func NewMemoryProductRepository() *MemoryProductRepository {
+	return &MemoryProductRepository{products: make(map[uint]models.Product), categories: make(map[uint][]uint)}
+}
+
+// List returns all products that have not been deleted, ordered by ID
+func (r *MemoryProductRepository) List() ([]models.Product, error) {
+	return r.filter(func(models.Product) bool { return true }), nil
+}
+
+// ListBySeller returns the products listed by one seller
+func (r *MemoryProductRepository) ListBySeller(sellerID uint) ([]models.Product, error) {
+	return r.filter(func(p models.Product) bool { return p.SellerID != nil && *p.SellerID == sellerID }), nil
+}
+
+// Find returns one page of the products matching the query
+func (r *MemoryProductRepository) Find(q ProductQuery) (*ProductPage, error) {
+	if err := q.validateCursor(); err != nil {
+		return nil, err
+	}
+
+	// Apply the filters
+	rows := r.matching(q)
+	total := int64(len(rows))
+
+	// Order by the sort column with the ID as tie-breaker, in fetch direction
+	desc := q.descending()
+	less := func(a, b models.Product) bool {
+		c := compareProducts(a, b, q.Sort.Column)
+		if c == 0 {
+			c = compareProducts(a, b, "id")
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+
+	// Skip to the cursor or the offset
+	start := 0
+	if q.Cursor != nil {
+		value, err := cursorValue(q.Cursor, q.Sort.Column)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		pivot := cursorProduct(q.Sort.Column, value, q.Cursor.ID)
+		for start < len(rows) && !less(pivot, rows[start]) {
+			start++
+		}
+	} else if q.Offset > 0 {
+		start = q.Offset
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+	rows = rows[start:]
+	if len(rows) > q.pageSize()+1 {
+		rows = rows[:q.pageSize()+1]
+	}
+	return buildPage(q, rows, total), nil
+}
+
+// Search returns one page of the products matching a full-text search, most relevant first
+func (r *MemoryProductRepository) Search(q SearchQuery) (*SearchPage, error) {
+	var results []SearchResult
+	for _, p := range r.filter(func(models.Product) bool { return true }) {
+		if result, ok := matchProduct(p, q); ok {
+			results = append(results, result)
+		}
+	}
+	return pageResults(q, results), nil
+}
+
+// matching returns the products matching the filters of q, ordered by ID
+func (r *MemoryProductRepository) matching(q ProductQuery) []models.Product {
+	name := strings.ToLower(q.Name)
+	return r.filter(func(p models.Product) bool {
+		return (q.MinPrice == nil || p.BasePrice >= *q.MinPrice) &&
+			(q.MaxPrice == nil || p.BasePrice <= *q.MaxPrice) &&
+			strings.Contains(strings.ToLower(p.Name), name) &&
+			(q.CreatedAfter == nil || !p.CreatedAt.Before(*q.CreatedAfter)) &&
+			(q.CreatedBefore == nil || p.CreatedAt.Before(*q.CreatedBefore)) &&
+			(q.SellerID == nil || (p.SellerID != nil && *p.SellerID == *q.SellerID)) &&
+			(len(q.CategoryIDs) == 0 || overlaps(r.categories[p.ID], q.CategoryIDs)) &&
+			hasAttributes(p, q.Attributes) && hasTags(p, q.Tags)
+	})
+}
+
+// Facets counts the products matching the filters of q by category group, price range,
+// tag and attribute value
+func (r *MemoryProductRepository) Facets(q ProductQuery, request FacetRequest) (*FacetCounts, error) {
+	counts := newFacetCounts(request)
+	products := r.matching(q)
+	counts.Total = int64(len(products))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range products {
+		for group, members := range request.CategoryGroups {
+			if overlaps(r.categories[p.ID], members) {
+				counts.Categories[group]++
+			}
+		}
+		if len(request.PriceBreaks) > 0 {
+			counts.Prices[priceRange(request.PriceBreaks, p.BasePrice)]++
+		}
+		if request.Tags {
+			for _, tag := range p.Tags {
+				counts.Tags[tag]++
+			}
+		}
+		if request.Attributes {
+			for name, value := range p.Attributes {
+				if counts.Attributes[name] == nil {
+					counts.Attributes[name] = make(map[string]int64)
+				}
+				counts.Attributes[name][models.AttributeIndexValue(value)]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// hasAttributes reports whether the product has all of the attribute values, given in indexed form
+func hasAttributes(p models.Product, attributes map[string]string) bool {
+	for name, value := range attributes {
+		have, ok := p.Attributes[name]
+		if !ok || models.AttributeIndexValue(have) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTags reports whether the product carries all of the tags
+func hasTags(p models.Product, tags []string) bool {
+	for _, tag := range tags {
+		if !p.Tags.Has(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether the two lists share an ID
+func overlaps(a, b []uint) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compareProducts compares two products by one sort column
+func compareProducts(a, b models.Product, column string) int {
+	switch column {
+	case "price":
+		return compareOrdered(a.BasePrice, b.BasePrice)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	}
+	return compareOrdered(a.ID, b.ID)
+}
+
+// compareOrdered returns -1, 0 or 1 like strings.Compare
+func compareOrdered[T int64 | uint](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// cursorProduct builds a product holding only the values a cursor points at
+func cursorProduct(column string, value interface{}, id uint) models.Product {
+	p := models.Product{}
+	p.ID = id
+	switch column {
+	case "price":
+		p.BasePrice = value.(int64)
+	case "created_at":
+		p.CreatedAt = value.(time.Time)
+	case "name":
+		p.Name = value.(string)
+	}
+	return p
+}
+
+// filter returns the products that have not been deleted and match, ordered by ID
+func (r *MemoryProductRepository) filter(match func(models.Product) bool) []models.Product {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	products := make([]models.Product, 0, len(r.products))
+	for _, p := range r.products {
+		if !p.DeletedAt.Valid && match(p) {
+			products = append(products, p)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products
+}
+
+// Get returns the product with the given ID
+func (r *MemoryProductRepository) Get(id uint) (*models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.products[id]
+	if !ok || p.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+// Create inserts a new product
+func (r *MemoryProductRepository) Create(product *models.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	product.ID = r.nextID
+	product.Version = 1
+	product.CreatedAt = now
+	product.UpdatedAt = now
+	r.products[product.ID] = *product
+	return nil
+}
+
+// Update saves all fields of an existing product
+func (r *MemoryProductRepository) Update(product *models.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(product)
+}
+
+// UpdateCategories saves all fields of an existing product and replaces its categories
+func (r *MemoryProductRepository) UpdateCategories(product *models.Product, categoryIDs []uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.update(product); err != nil {
+		return err
+	}
+	r.setCategories(product.ID, categoryIDs)
+	return nil
+}
+
+// update saves all fields of a product unless its version changed since it was read
+func (r *MemoryProductRepository) update(product *models.Product) error {
+	existing, ok := r.products[product.ID]
+	if !ok || existing.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if existing.Version != product.Version {
+		return ErrVersionConflict
+	}
+	product.Version++
+	product.CreatedAt = existing.CreatedAt
+	product.UpdatedAt = time.Now()
+	r.products[product.ID] = *product
+	return nil
+}
+
+// Delete soft-deletes the product with the given ID
+func (r *MemoryProductRepository) Delete(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok || p.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	p.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.products[id] = p
+	return nil
+}
+
+// SetCategories replaces the categories the product is assigned to
+func (r *MemoryProductRepository) SetCategories(productID uint, categoryIDs []uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.setCategories(productID, categoryIDs)
+	return nil
+}
+
+// setCategories replaces the category assignments of a product
+func (r *MemoryProductRepository) setCategories(productID uint, categoryIDs []uint) {
+	ids := append([]uint{}, categoryIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	r.categories[productID] = ids
+}
+
+// CategoryIDs returns the categories the product is assigned to, ordered by ID
+func (r *MemoryProductRepository) CategoryIDs(productID uint) ([]uint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]uint{}, r.categories[productID]...), nil
+}
+
+// Reprice recomputes the base price of every product priced in the currency, including
+// deleted ones, after its exchange rate changed
+func (r *MemoryProductRepository) Reprice(currency string, rates *models.Rates) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, p := range r.products {
+		if p.Price.Currency != currency {
+			continue
+		}
+		base, err := rates.Convert(p.Price, rates.Base)
+		if err != nil {
+			return err
+		}
+		p.BasePrice = base.Amount
+		r.products[id] = p
+	}
+	return nil
+}
+
+// CountByCurrency counts the products, including deleted ones, priced in the currency
+func (r *MemoryProductRepository) CountByCurrency(currency string) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, p := range r.products {
+		if p.Price.Currency == currency {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Trash returns the soft-deleted products, most recently deleted first
+func (r *MemoryProductRepository) Trash(sellerID *uint) ([]models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	products := []models.Product{}
+	for _, p := range r.products {
+		if p.DeletedAt.Valid && (sellerID == nil || (p.SellerID != nil && *p.SellerID == *sellerID)) {
+			products = append(products, p)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if !products[i].DeletedAt.Time.Equal(products[j].DeletedAt.Time) {
+			return products[i].DeletedAt.Time.After(products[j].DeletedAt.Time)
+		}
+		return products[i].ID < products[j].ID
+	})
+	return products, nil
+}
+
+// GetDeleted returns the soft-deleted product with the given ID
+func (r *MemoryProductRepository) GetDeleted(id uint) (*models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.products[id]
+	if !ok || !p.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+// Restore undeletes a soft-deleted product
+func (r *MemoryProductRepository) Restore(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok || !p.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	p.DeletedAt = gorm.DeletedAt{}
+	r.products[id] = p
+	return nil
+}
+
+// Purge permanently deletes a product and its category assignments. Unlike the database,
+// the other in-memory repositories keep their records of the product.
+func (r *MemoryProductRepository) Purge(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.products[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.products, id)
+	delete(r.categories, id)
+	return nil
+}
+
+// MemoryVariantRepository is a VariantRepository kept in memory, mainly for tests and demos
+type MemoryVariantRepository struct {
+	mu       sync.RWMutex
+	nextID   uint
+	variants map[uint]models.Variant
+}
+
+// NewMemoryVariantRepository creates an empty in-memory VariantRepository
+func NewMemoryVariantRepository() *MemoryVariantRepository {
+	return &MemoryVariantRepository{variants: make(map[uint]models.Variant)}
+}
+
+// List returns the variants of a product ordered by ID
+func (r *MemoryVariantRepository) List(productID uint) ([]models.Variant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	variants := []models.Variant{}
+	for _, v := range r.variants {
+		if v.ProductID == productID {
+			variants = append(variants, v)
+		}
+	}
+	sort.Slice(variants, func(i, j int) bool { return variants[i].ID < variants[j].ID })
+	return variants, nil
+}
+
+// Get returns the variant with the given ID
+func (r *MemoryVariantRepository) Get(id uint) (*models.Variant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v, ok := r.variants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &v, nil
+}
+
+// GetBySKU returns the variant with the given SKU
+func (r *MemoryVariantRepository) GetBySKU(sku string) (*models.Variant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, v := range r.variants {
+		if v.SKU == sku {
+			return &v, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// skuTaken reports whether another variant than id already uses the SKU
+func (r *MemoryVariantRepository) skuTaken(sku string, id uint) bool {
+	for _, v := range r.variants {
+		if v.SKU == sku && v.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// Create inserts a new variant, enforcing the unique SKU constraint
+func (r *MemoryVariantRepository) Create(variant *models.Variant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.skuTaken(variant.SKU, 0) {
+		return ErrDuplicateSKU
+	}
+	r.nextID++
+	now := time.Now()
+	variant.ID = r.nextID
+	variant.CreatedAt = now
+	variant.UpdatedAt = now
+	r.variants[variant.ID] = *variant
+	return nil
+}
+
+// Update saves all fields of an existing variant, enforcing the unique SKU constraint
+func (r *MemoryVariantRepository) Update(variant *models.Variant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.variants[variant.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.skuTaken(variant.SKU, variant.ID) {
+		return ErrDuplicateSKU
+	}
+	variant.CreatedAt = existing.CreatedAt
+	variant.UpdatedAt = time.Now()
+	r.variants[variant.ID] = *variant
+	return nil
+}
+
+// Delete removes the variant with the given ID
+func (r *MemoryVariantRepository) Delete(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.variants[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.variants, id)
+	return nil
+}
+
+// MemoryPriceRepository is a PriceRepository kept in memory, mainly for tests and demos.
+// It changes the prices of the products kept by a MemoryProductRepository.
+type MemoryPriceRepository struct {
+	mu         sync.Mutex
+	products   *MemoryProductRepository
+	changes    []models.PriceChange
+	schedules  map[uint]models.PriceSchedule
+	nextID     uint
+	nextChange uint
+}
+
+// NewMemoryPriceRepository creates an empty in-memory PriceRepository for the products of repo
+func NewMemoryPriceRepository(products *MemoryProductRepository) *MemoryPriceRepository {
+	return &MemoryPriceRepository{products: products, schedules: make(map[uint]models.PriceSchedule)}
+}
+
+// History returns the latest price changes of a product, newest first
+func (r *MemoryPriceRepository) History(productID uint, limit int) ([]models.PriceChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := []models.PriceChange{}
+	for i := len(r.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		if r.changes[i].ProductID == productID {
+			changes = append(changes, r.changes[i])
+		}
+	}
+	return changes, nil
+}
+
+// Change saves a product whose price was changed by hand, replaces its categories and appends
+// the change to the history
+func (r *MemoryPriceRepository) Change(product *models.Product, change *models.PriceChange, categoryIDs []uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	if err := r.products.update(product); err != nil {
+		return err
+	}
+	r.products.setCategories(product.ID, categoryIDs)
+	r.record(change, product.UpdatedAt)
+	return nil
+}
+
+// record appends a price change to the history
+func (r *MemoryPriceRepository) record(change *models.PriceChange, now time.Time) {
+	r.nextChange++
+	change.ID = r.nextChange
+	change.CreatedAt = now
+	r.changes = append(r.changes, *change)
+}
+
+// Schedules returns the schedules of a product ordered by start
+func (r *MemoryPriceRepository) Schedules(productID uint) ([]models.PriceSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schedules := []models.PriceSchedule{}
+	for _, schedule := range r.schedules {
+		if schedule.ProductID == productID {
+			schedules = append(schedules, schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].StartsAt.Equal(schedules[j].StartsAt) {
+			return schedules[i].StartsAt.Before(schedules[j].StartsAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules, nil
+}
+
+// Schedule returns the schedule with the given ID
+func (r *MemoryPriceRepository) Schedule(id uint) (*models.PriceSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schedule, ok := r.schedules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &schedule, nil
+}
+
+// CreateSchedule adds a pending schedule unless it is a sale overlapping another sale
+func (r *MemoryPriceRepository) CreateSchedule(schedule *models.PriceSchedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.products.Get(schedule.ProductID); err != nil {
+		return err
+	}
+	var others []models.PriceSchedule
+	for _, other := range r.schedules {
+		if other.ProductID == schedule.ProductID {
+			others = append(others, other)
+		}
+	}
+	if err := checkOverlap(schedule, others); err != nil {
+		return err
+	}
+	r.nextID++
+	now := time.Now()
+	schedule.ID = r.nextID
+	schedule.CreatedAt, schedule.UpdatedAt = now, now
+	schedule.Status = models.SchedulePending
+	r.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+// CancelSchedule cancels a pending schedule or ends an active sale now
+func (r *MemoryPriceRepository) CancelSchedule(id uint, now time.Time) (*models.PriceSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schedule, ok := r.schedules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := cancelSchedule(&schedule, now); err != nil {
+		return nil, err
+	}
+	schedule.UpdatedAt = time.Now()
+	r.schedules[id] = schedule
+	return &schedule, nil
+}
+
+// Due returns the pending schedules that have started and the active sales that have ended
+func (r *MemoryPriceRepository) Due(now time.Time) ([]models.PriceSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schedules := []models.PriceSchedule{}
+	for _, schedule := range r.schedules {
+		if due, ok := schedule.DueAt(); ok && !due.After(now) {
+			schedules = append(schedules, schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules, nil
+}
+
+// Apply advances a due schedule, changing the product price and recording the change
+func (r *MemoryPriceRepository) Apply(id uint, rates *models.Rates, now time.Time) (*models.PriceChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	schedule, ok := r.schedules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var product *models.Product
+	if p, ok := r.products.products[schedule.ProductID]; ok && !p.DeletedAt.Valid {
+		product = &p
+	}
+	change := applySchedule(product, &schedule, now)
+	if change != nil {
+		base, err := rates.Convert(product.Price, rates.Base)
+		if err != nil {
+			return nil, err
+		}
+		product.BasePrice = base.Amount
+		product.UpdatedAt = now
+		product.Version++
+		r.products.products[product.ID] = *product
+		r.record(change, now)
+	}
+	schedule.UpdatedAt = now
+	r.schedules[id] = schedule
+	return change, nil
+}
+
+// levelKey identifies the stock level of a product or variant
+type levelKey struct {
+	productID, variantID uint
+}
+
+// MemoryImageRepository is an ImageRepository kept in memory, mainly for tests and demos.
+// It checks that images belong to products kept by a MemoryProductRepository.
+type MemoryImageRepository struct {
+	mu       sync.Mutex
+	products *MemoryProductRepository
+	nextID   uint
+	images   map[uint]models.ProductImage
+}
+
+// NewMemoryImageRepository creates an empty in-memory ImageRepository for the products
+func NewMemoryImageRepository(products *MemoryProductRepository) *MemoryImageRepository {
+	return &MemoryImageRepository{products: products, images: make(map[uint]models.ProductImage)}
+}
+
+// list returns the images of a product ordered by position
+func (r *MemoryImageRepository) list(productID uint) []models.ProductImage {
+	images := []models.ProductImage{}
+	for _, image := range r.images {
+		if image.ProductID == productID {
+			images = append(images, image)
+		}
+	}
+	sortImages(images)
+	return images
+}
+
+// List returns the images of a product ordered by position
+func (r *MemoryImageRepository) List(productID uint) ([]models.ProductImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.list(productID), nil
+}
+
+// Get returns the image with the given ID
+func (r *MemoryImageRepository) Get(id uint) (*models.ProductImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.images[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &image, nil
+}
+
+// Create appends the image to the gallery of its product
+func (r *MemoryImageRepository) Create(image *models.ProductImage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.products.Get(image.ProductID); err != nil {
+		return err
+	}
+	images := r.list(image.ProductID)
+	image.Position = 1
+	if len(images) > 0 {
+		image.Position = images[len(images)-1].Position + 1
+	}
+	image.Primary = len(images) == 0
+	r.nextID++
+	image.ID = r.nextID
+	image.CreatedAt = time.Now()
+	r.images[image.ID] = *image
+	return nil
+}
+
+// Delete removes the image with the given ID, promoting the first remaining image to primary
+func (r *MemoryImageRepository) Delete(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.images[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.images, id)
+	if images := r.list(image.ProductID); image.Primary && len(images) > 0 {
+		next := images[0]
+		next.Primary = true
+		r.images[next.ID] = next
+	}
+	return nil
+}
+
+// Reorder sets the gallery order of a product to the order of ids
+func (r *MemoryImageRepository) Reorder(productID uint, ids []uint) ([]models.ProductImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.products.Get(productID); err != nil {
+		return nil, err
+	}
+	images := r.list(productID)
+	if err := orderImages(images, ids); err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		r.images[image.ID] = image
+	}
+	return images, nil
+}
+
+// SetPrimary makes the image the primary image of its product
+func (r *MemoryImageRepository) SetPrimary(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.images[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, other := range r.list(image.ProductID) {
+		other.Primary = other.ID == id
+		r.images[other.ID] = other
+	}
+	return nil
+}
+
+// Primary returns the primary images of the products by product ID
+func (r *MemoryImageRepository) Primary(productIDs []uint) (map[uint]models.ProductImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[uint]bool, len(productIDs))
+	for _, id := range productIDs {
+		wanted[id] = true
+	}
+	primary := make(map[uint]models.ProductImage)
+	for _, image := range r.images {
+		if image.Primary && wanted[image.ProductID] {
+			primary[image.ProductID] = image
+		}
+	}
+	return primary, nil
+}
+
+// MemoryCartRepository is a CartRepository kept in memory, mainly for tests and demos
+type MemoryCartRepository struct {
+	mu         sync.Mutex
+	carts      map[uint]models.Cart
+	items      map[uint]models.CartItem
+	nextID     uint
+	nextItemID uint
+}
+
+// NewMemoryCartRepository creates an empty in-memory CartRepository
+func NewMemoryCartRepository() *MemoryCartRepository {
+	return &MemoryCartRepository{carts: make(map[uint]models.Cart), items: make(map[uint]models.CartItem)}
+}
+
+// find returns the first cart, by ID, matching the condition together with its items
+func (r *MemoryCartRepository) find(match func(models.Cart) bool) (*models.Cart, error) {
+	var found *models.Cart
+	for _, cart := range r.carts {
+		if match(cart) && (found == nil || cart.ID < found.ID) {
+			cart := cart
+			found = &cart
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	found.Items = []models.CartItem{}
+	for _, item := range r.items {
+		if item.CartID == found.ID {
+			found.Items = append(found.Items, item)
+		}
+	}
+	sort.Slice(found.Items, func(i, j int) bool { return found.Items[i].ID < found.Items[j].ID })
+	return found, nil
+}
+
+// touch sets the update time of a cart, returning ErrNotFound when it does not exist
+func (r *MemoryCartRepository) touch(id uint, now time.Time) error {
+	cart, ok := r.carts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	cart.UpdatedAt = now
+	r.carts[id] = cart
+	return nil
+}
+
+// Get returns the cart with the given ID
+func (r *MemoryCartRepository) Get(id uint) (*models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.find(func(c models.Cart) bool { return c.ID == id })
+}
+
+// ForUser returns the cart of a user
+func (r *MemoryCartRepository) ForUser(userID uint) (*models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.find(func(c models.Cart) bool { return c.UserID != nil && *c.UserID == userID })
+}
+
+// ForToken returns the guest cart with the given token hash
+func (r *MemoryCartRepository) ForToken(tokenHash string) (*models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.find(func(c models.Cart) bool { return c.IsGuest() && c.TokenHash != nil && *c.TokenHash == tokenHash })
+}
+
+// Create inserts an empty cart
+func (r *MemoryCartRepository) Create(cart *models.Cart) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	cart.ID = r.nextID
+	cart.CreatedAt, cart.UpdatedAt = now, now
+	cart.Items = []models.CartItem{}
+	stored := *cart
+	stored.Items = nil
+	r.carts[cart.ID] = stored
+	return nil
+}
+
+// SaveItem inserts a new item or saves the quantity and price of an existing one
+func (r *MemoryCartRepository) SaveItem(item *models.CartItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if err := r.touch(item.CartID, now); err != nil {
+		return err
+	}
+	if item.ID == 0 {
+		r.nextItemID++
+		item.ID = r.nextItemID
+		item.CreatedAt = now
+	} else {
+		existing, ok := r.items[item.ID]
+		if !ok || existing.CartID != item.CartID {
+			return ErrNotFound
+		}
+		existing.Quantity, existing.Price = item.Quantity, item.Price
+		*item = existing
+	}
+	item.UpdatedAt = now
+	r.items[item.ID] = *item
+	return nil
+}
+
+// DeleteItem removes an item of the cart
+func (r *MemoryCartRepository) DeleteItem(cartID, itemID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[itemID]
+	if !ok || item.CartID != cartID {
+		return ErrNotFound
+	}
+	delete(r.items, itemID)
+	return r.touch(cartID, time.Now())
+}
+
+// Clear removes all items of the cart
+func (r *MemoryCartRepository) Clear(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.touch(id, time.Now()); err != nil {
+		return err
+	}
+	r.deleteItems(id)
+	return nil
+}
+
+// deleteItems removes all items of a cart
+func (r *MemoryCartRepository) deleteItems(cartID uint) {
+	for id, item := range r.items {
+		if item.CartID == cartID {
+			delete(r.items, id)
+		}
+	}
+}
+
+// StartCheckout claims the cart for one checkout and returns it as it is now
+func (r *MemoryCartRepository) StartCheckout(id uint, now time.Time) (*models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cart, ok := r.carts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if cart.CheckoutStartedAt != nil && cart.CheckoutStartedAt.After(now.Add(-checkoutTimeout)) {
+		return nil, ErrCheckoutInProgress
+	}
+	cart.CheckoutStartedAt = &now
+	r.carts[id] = cart
+	return r.find(func(c models.Cart) bool { return c.ID == id })
+}
+
+// FinishCheckout releases the claim of StartCheckout
+func (r *MemoryCartRepository) FinishCheckout(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cart, ok := r.carts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	cart.CheckoutStartedAt = nil
+	r.carts[id] = cart
+	return nil
+}
+
+// Merge moves the items of one cart into another and deletes the emptied cart
+func (r *MemoryCartRepository) Merge(fromID, intoID uint) (*models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from, err := r.find(func(c models.Cart) bool { return c.ID == fromID })
+	if err != nil {
+		return nil, err
+	}
+	into, err := r.find(func(c models.Cart) bool { return c.ID == intoID })
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	updated, moved := mergeCartItems(into, from.Items)
+	for _, item := range append(updated, moved...) {
+		item.UpdatedAt = now
+		r.items[item.ID] = item
+	}
+	r.deleteItems(fromID)
+	delete(r.carts, fromID)
+	if err := r.touch(intoID, now); err != nil {
+		return nil, err
+	}
+	return r.find(func(c models.Cart) bool { return c.ID == intoID })
+}
+
+// PurgeGuests deletes the guest carts that have not changed since before
+func (r *MemoryCartRepository) PurgeGuests(before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, cart := range r.carts {
+		if cart.IsGuest() && cart.UpdatedAt.Before(before) {
+			r.deleteItems(id)
+			delete(r.carts, id)
+		}
+	}
+	return nil
+}
+
+// MemoryOrderRepository is an OrderRepository kept in memory, mainly for tests and demos.
+// It settles the reservations of its orders in the inventory it shares.
+type MemoryOrderRepository struct {
+	mu         sync.Mutex
+	inventory  *MemoryInventoryRepository
+	orders     map[uint]models.Order
+	nextID     uint
+	nextLineID uint
+}
+
+// NewMemoryOrderRepository creates an empty in-memory OrderRepository whose orders reserve
+// stock in inventory
+func NewMemoryOrderRepository(inventory *MemoryInventoryRepository) *MemoryOrderRepository {
+	return &MemoryOrderRepository{inventory: inventory, orders: make(map[uint]models.Order)}
+}
+
+// copyOrder returns a copy of a stored order that does not share its lines
+func copyOrder(order models.Order) models.Order {
+	order.Lines = append([]models.OrderLine{}, order.Lines...)
+	return order
+}
+
+// Create inserts a pending order with its lines
+func (r *MemoryOrderRepository) Create(order *models.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	order.ID = r.nextID
+	order.CreatedAt, order.UpdatedAt = now, now
+	order.Status = models.OrderPending
+	for i := range order.Lines {
+		r.nextLineID++
+		order.Lines[i].ID = r.nextLineID
+		order.Lines[i].OrderID = order.ID
+	}
+	r.orders[order.ID] = copyOrder(*order)
+	return nil
+}
+
+// Get returns the order with the given ID
+func (r *MemoryOrderRepository) Get(id uint) (*models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	order = copyOrder(order)
+	return &order, nil
+}
+
+// List returns the orders matching the query, newest first
+func (r *MemoryOrderRepository) List(q OrderQuery) ([]models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orders := []models.Order{}
+	for _, order := range r.orders {
+		if q.matches(order) {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID > orders[j].ID })
+	if len(orders) > q.limit() {
+		orders = orders[:q.limit()]
+	}
+	return orders, nil
+}
+
+// Transition moves the order to another state, recording the time, and sells or releases the
+// reservations of its lines
+func (r *MemoryOrderRepository) Transition(id uint, to models.OrderStatus, userID *uint, now time.Time) (*models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inventory.mu.Lock()
+	defer r.inventory.mu.Unlock()
+
+	order, ok := r.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	order = copyOrder(order)
+	if !order.SetStatus(to, now) {
+		return nil, ErrInvalidTransition
+	}
+	err := settleReservations(order.Lines, to, func(id uint, status models.ReservationStatus) (*models.Reservation, error) {
+		return r.inventory.finish(id, status, userID, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	order.UpdatedAt = now
+	r.orders[id] = copyOrder(order)
+	return &order, nil
+}
+
+// Expired returns the pending orders that were not paid in time, oldest first
+func (r *MemoryOrderRepository) Expired(now time.Time) ([]models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orders := []models.Order{}
+	for _, order := range r.orders {
+		if order.Status == models.OrderPending && order.ExpiresAt != nil && !order.ExpiresAt.After(now) {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders, nil
+}
+
+// MemoryPaymentRepository is a PaymentRepository kept in memory, mainly for tests and demos
+type MemoryPaymentRepository struct {
+	mu       sync.Mutex
+	payments []models.Payment
+}
+
+// NewMemoryPaymentRepository creates an empty in-memory PaymentRepository
+func NewMemoryPaymentRepository() *MemoryPaymentRepository {
+	return &MemoryPaymentRepository{}
+}
+
+// Create inserts the payment
+func (r *MemoryPaymentRepository) Create(payment *models.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.payments {
+		if other.Provider == payment.Provider && other.Reference == payment.Reference {
+			return fmt.Errorf("payment %s of %s already exists", payment.Reference, payment.Provider)
+		}
+	}
+	now := time.Now()
+	payment.ID = uint(len(r.payments) + 1)
+	payment.CreatedAt, payment.UpdatedAt = now, now
+	r.payments = append(r.payments, *payment)
+	return nil
+}
+
+// ForOrder returns the payments of an order ordered by ID
+func (r *MemoryPaymentRepository) ForOrder(orderID uint) ([]models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payments := []models.Payment{}
+	for _, payment := range r.payments {
+		if payment.OrderID == orderID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+// ByReference returns the payment a provider knows by reference
+func (r *MemoryPaymentRepository) ByReference(provider, reference string) (*models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, payment := range r.payments {
+		if payment.Provider == provider && payment.Reference == reference {
+			return &payment, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Transition moves the payment on from the expected state
+func (r *MemoryPaymentRepository) Transition(id uint, from, to models.PaymentStatus, reason string) (*models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id == 0 || int(id) > len(r.payments) {
+		return nil, ErrNotFound
+	}
+	payment := r.payments[id-1]
+	if err := setPaymentStatus(&payment, from, to, reason); err != nil {
+		return nil, err
+	}
+	payment.UpdatedAt = time.Now()
+	r.payments[id-1] = payment
+	return &payment, nil
+}
+
+// MemoryPromotionRepository is a PromotionRepository kept in memory, mainly for tests and demos
+type MemoryPromotionRepository struct {
+	mu          sync.Mutex
+	promotions  map[uint]models.Promotion
+	redemptions []models.PromotionRedemption
+	nextID      uint
+	nextUseID   uint
+}
+
+// NewMemoryPromotionRepository creates an empty in-memory PromotionRepository
+func NewMemoryPromotionRepository() *MemoryPromotionRepository {
+	return &MemoryPromotionRepository{promotions: make(map[uint]models.Promotion)}
+}
+
+// list returns the promotions selected by keep ordered by ID
+func (r *MemoryPromotionRepository) list(keep func(models.Promotion) bool) []models.Promotion {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	promotions := []models.Promotion{}
+	for _, promotion := range r.promotions {
+		if keep(promotion) {
+			promotion.TargetIDs = append(models.IDList{}, promotion.TargetIDs...)
+			promotions = append(promotions, promotion)
+		}
+	}
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].ID < promotions[j].ID })
+	return promotions
+}
+
+// List returns all promotions ordered by ID
+func (r *MemoryPromotionRepository) List() ([]models.Promotion, error) {
+	return r.list(func(models.Promotion) bool { return true }), nil
+}
+
+// ListActive returns the promotions not switched off, ordered by ID
+func (r *MemoryPromotionRepository) ListActive() ([]models.Promotion, error) {
+	return r.list(func(p models.Promotion) bool { return p.Active }), nil
+}
+
+// Get returns the promotion with the given ID
+func (r *MemoryPromotionRepository) Get(id uint) (*models.Promotion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	promotion, ok := r.promotions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	promotion.TargetIDs = append(models.IDList{}, promotion.TargetIDs...)
+	return &promotion, nil
+}
+
+// codeTaken reports whether another promotion than id already uses the code
+func (r *MemoryPromotionRepository) codeTaken(code *string, id uint) bool {
+	for _, other := range r.promotions {
+		if code != nil && other.Code != nil && *other.Code == *code && other.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// Create inserts a new promotion
+func (r *MemoryPromotionRepository) Create(promotion *models.Promotion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.codeTaken(promotion.Code, 0) {
+		return ErrDuplicateCode
+	}
+	r.nextID++
+	now := time.Now()
+	promotion.ID = r.nextID
+	promotion.CreatedAt, promotion.UpdatedAt = now, now
+	stored := *promotion
+	stored.TargetIDs = append(models.IDList{}, promotion.TargetIDs...)
+	r.promotions[promotion.ID] = stored
+	return nil
+}
+
+// Update saves all fields of an existing promotion except its use count
+func (r *MemoryPromotionRepository) Update(promotion *models.Promotion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.promotions[promotion.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.codeTaken(promotion.Code, promotion.ID) {
+		return ErrDuplicateCode
+	}
+	promotion.Uses, promotion.CreatedAt, promotion.UpdatedAt = current.Uses, current.CreatedAt, time.Now()
+	stored := *promotion
+	stored.TargetIDs = append(models.IDList{}, promotion.TargetIDs...)
+	r.promotions[promotion.ID] = stored
+	return nil
+}
+
+// Delete removes the promotion with its redemptions
+func (r *MemoryPromotionRepository) Delete(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.promotions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.promotions, id)
+	kept := r.redemptions[:0]
+	for _, redemption := range r.redemptions {
+		if redemption.PromotionID != id {
+			kept = append(kept, redemption)
+		}
+	}
+	r.redemptions = kept
+	return nil
+}
+
+// userUses counts the redemptions of a promotion by a user
+func (r *MemoryPromotionRepository) userUses(promotionID, userID uint) int {
+	uses := 0
+	for _, redemption := range r.redemptions {
+		if redemption.PromotionID == promotionID && redemption.UserID == userID {
+			uses++
+		}
+	}
+	return uses
+}
+
+// UserUses counts the redemptions of each promotion by a user
+func (r *MemoryPromotionRepository) UserUses(userID uint) (map[uint]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	uses := make(map[uint]int)
+	for _, redemption := range r.redemptions {
+		if redemption.UserID == userID {
+			uses[redemption.PromotionID]++
+		}
+	}
+	return uses, nil
+}
+
+// Redeem checks the caps of every promotion before recording any redemption
+func (r *MemoryPromotionRepository) Redeem(redemptions []models.PromotionRedemption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Redeeming one promotion twice in a checkout counts both times
+	pending := make(map[uint]int)
+	for _, redemption := range redemptions {
+		promotion, ok := r.promotions[redemption.PromotionID]
+		if !ok {
+			return ErrNotFound
+		}
+		promotion.Uses += pending[promotion.ID]
+		if err := checkCaps(promotion, r.userUses(promotion.ID, redemption.UserID)+pending[promotion.ID]); err != nil {
+			return err
+		}
+		pending[promotion.ID]++
+	}
+	now := time.Now()
+	for i := range redemptions {
+		r.nextUseID++
+		redemptions[i].ID = r.nextUseID
+		redemptions[i].CreatedAt = now
+		r.redemptions = append(r.redemptions, redemptions[i])
+		promotion := r.promotions[redemptions[i].PromotionID]
+		promotion.Uses++
+		r.promotions[promotion.ID] = promotion
+	}
+	return nil
+}
+
+// MemoryInventoryRepository is an InventoryRepository kept in memory, mainly for tests and demos.
+// A single mutex stands in for the row locks of the database.
+type MemoryInventoryRepository struct {
+	mu           sync.Mutex
+	levels       map[levelKey]models.StockLevel
+	movements    []models.StockMovement
+	reservations map[uint]models.Reservation
+	nextID       uint
+}
+
+// NewMemoryInventoryRepository creates an empty in-memory InventoryRepository
+func NewMemoryInventoryRepository() *MemoryInventoryRepository {
+	return &MemoryInventoryRepository{levels: make(map[levelKey]models.StockLevel), reservations: make(map[uint]models.Reservation)}
+}
+
+// Levels returns the stock of a product and its variants ordered by variant ID
+func (r *MemoryInventoryRepository) Levels(productID uint) ([]models.StockLevel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	levels := []models.StockLevel{}
+	for key, level := range r.levels {
+		if key.productID == productID {
+			levels = append(levels, level)
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].VariantID < levels[j].VariantID })
+	return levels, nil
+}
+
+// Movements returns the latest ledger entries of a product, newest first
+func (r *MemoryInventoryRepository) Movements(productID uint, limit int) ([]models.StockMovement, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	movements := []models.StockMovement{}
+	for i := len(r.movements) - 1; i >= 0 && len(movements) < limit; i-- {
+		if r.movements[i].ProductID == productID {
+			movements = append(movements, r.movements[i])
+		}
+	}
+	return movements, nil
+}
+
+// move applies a movement to a stock level and appends it to the ledger
+func (r *MemoryInventoryRepository) move(key levelKey, movement *models.StockMovement) error {
+	level, ok := r.levels[key]
+	if !ok {
+		level = models.StockLevel{ProductID: key.productID, VariantID: key.variantID}
+	}
+	if err := applyMovement(&level, movement); err != nil {
+		return err
+	}
+	now := time.Now()
+	level.UpdatedAt = now
+	r.levels[key] = level
+	movement.ID = uint(len(r.movements) + 1)
+	movement.CreatedAt = now
+	r.movements = append(r.movements, *movement)
+	return nil
+}
+
+// closeReservation ends an active reservation
+func (r *MemoryInventoryRepository) closeReservation(reservation *models.Reservation, status models.ReservationStatus, userID *uint) error {
+	if err := r.move(levelKey{reservation.ProductID, reservation.VariantID}, closingMovement(reservation, status, userID)); err != nil {
+		return err
+	}
+	reservation.Status = status
+	reservation.UpdatedAt = time.Now()
+	r.reservations[reservation.ID] = *reservation
+	return nil
+}
+
+// expire releases the expired reservations of the stock levels matching the filter
+func (r *MemoryInventoryRepository) expire(now time.Time, match func(levelKey) bool) error {
+	ids := make([]uint, 0)
+	for id, reservation := range r.reservations {
+		if reservation.Status == models.ReservationActive && !reservation.ExpiresAt.After(now) &&
+			match(levelKey{reservation.ProductID, reservation.VariantID}) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		reservation := r.reservations[id]
+		if err := r.closeReservation(&reservation, models.ReservationExpired, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record applies a receive, adjust or sell movement to the stock it names
+func (r *MemoryInventoryRepository) Record(movement *models.StockMovement) error {
+	if movement.Kind == models.MovementReserve || movement.Kind == models.MovementRelease || movement.ReservationID != nil {
+		return ErrInvalidMovement
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.move(levelKey{movement.ProductID, movement.VariantID}, movement)
+}
+
+// Reserve holds units of the stock the reservation names. Expired reservations of the same
+// stock are released first so their units count as available again.
+func (r *MemoryInventoryRepository) Reserve(reservation *models.Reservation, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := levelKey{reservation.ProductID, reservation.VariantID}
+	if err := r.expire(now, func(k levelKey) bool { return k == key }); err != nil {
+		return err
+	}
+	if level := r.levels[key]; level.Available() < reservation.Quantity {
+		return ErrInsufficientStock
+	}
+
+	r.nextID++
+	reservation.ID = r.nextID
+	reservation.CreatedAt = now
+	reservation.UpdatedAt = now
+	reservation.Status = models.ReservationActive
+	if err := r.move(key, &models.StockMovement{Kind: models.MovementReserve, Quantity: reservation.Quantity, ReservationID: &reservation.ID}); err != nil {
+		return err
+	}
+	r.reservations[reservation.ID] = *reservation
+	return nil
+}
+
+// Reservation returns the reservation with the given ID
+func (r *MemoryInventoryRepository) Reservation(id uint) (*models.Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reservation, ok := r.reservations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &reservation, nil
+}
+
+// finish ends an active reservation with the given status, or releases it as expired if it
+// has expired. Inactive reservations are returned with ErrReservationInactive. The caller
+// holds the lock.
+func (r *MemoryInventoryRepository) finish(id uint, status models.ReservationStatus, userID *uint, now time.Time) (*models.Reservation, error) {
+	reservation, ok := r.reservations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if reservation.Status != models.ReservationActive {
+		return &reservation, ErrReservationInactive
+	}
+	if !reservation.ExpiresAt.After(now) {
+		status, userID = models.ReservationExpired, nil
+	}
+	if err := r.closeReservation(&reservation, status, userID); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// Release returns the units of an active reservation to the available stock
+func (r *MemoryInventoryRepository) Release(id uint, userID *uint, now time.Time) (*models.Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return finished(r.finish(id, models.ReservationReleased, userID, now))
+}
+
+// Sell takes the units of an active reservation out of the stock
+func (r *MemoryInventoryRepository) Sell(id uint, userID *uint, now time.Time) (*models.Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return finished(r.finish(id, models.ReservationSold, userID, now))
+}
+
+// ExpireReservations releases every reservation that has expired
+func (r *MemoryInventoryRepository) ExpireReservations(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.expire(now, func(levelKey) bool { return true })
+}
+
+// MemoryExchangeRateRepository is an ExchangeRateRepository kept in memory, mainly for tests and demos
+type MemoryExchangeRateRepository struct {
+	mu    sync.RWMutex
+	rates map[string]models.ExchangeRate
+}
+
+// NewMemoryExchangeRateRepository creates an empty in-memory ExchangeRateRepository
+func NewMemoryExchangeRateRepository() *MemoryExchangeRateRepository {
+	return &MemoryExchangeRateRepository{rates: make(map[string]models.ExchangeRate)}
+}
+
+// List returns all exchange rates ordered by currency
+func (r *MemoryExchangeRateRepository) List() ([]models.ExchangeRate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rates := []models.ExchangeRate{}
+	for _, rate := range r.rates {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Currency < rates[j].Currency })
+	return rates, nil
+}
+
+// Get returns the rate of a currency
+func (r *MemoryExchangeRateRepository) Get(currency string) (*models.ExchangeRate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rate, ok := r.rates[currency]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &rate, nil
+}
+
+// Save inserts or replaces the rate of a currency
+func (r *MemoryExchangeRateRepository) Save(rate *models.ExchangeRate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rate.UpdatedAt = time.Now()
+	r.rates[rate.Currency] = *rate
+	return nil
+}
+
+// Delete removes the rate of a currency
+func (r *MemoryExchangeRateRepository) Delete(currency string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.rates[currency]; !ok {
+		return ErrNotFound
+	}
+	delete(r.rates, currency)
+	return nil
+}
+
+// MemoryTaxRateRepository is a TaxRateRepository kept in memory, mainly for tests and demos
+type MemoryTaxRateRepository struct {
+	mu     sync.RWMutex
+	rates  []models.TaxRate
+	nextID uint
+}
+
+// NewMemoryTaxRateRepository creates an empty in-memory TaxRateRepository
+func NewMemoryTaxRateRepository() *MemoryTaxRateRepository {
+	return &MemoryTaxRateRepository{nextID: 1}
+}
+
+// List returns all tax rates ordered by jurisdiction, class and name
+func (r *MemoryTaxRateRepository) List() ([]models.TaxRate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rates := append([]models.TaxRate{}, r.rates...)
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Jurisdiction != rates[j].Jurisdiction {
+			return rates[i].Jurisdiction < rates[j].Jurisdiction
+		}
+		if rates[i].Class != rates[j].Class {
+			return rates[i].Class < rates[j].Class
+		}
+		return rates[i].Name < rates[j].Name
+	})
+	return rates, nil
+}
+
+// Replace swaps the whole table for the given rates
+func (r *MemoryTaxRateRepository) Replace(rates []models.TaxRate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rates = make([]models.TaxRate, len(rates))
+	for i := range rates {
+		rates[i].ID = r.nextID
+		r.nextID++
+		r.rates[i] = rates[i]
+	}
+	return nil
+}
+
+// MemoryCategoryRepository is a CategoryRepository kept in memory, mainly for tests and demos
+type MemoryCategoryRepository struct {
+	mu              sync.RWMutex
+	nextID          uint
+	categories      map[uint]models.Category
+	nextAttributeID uint
+	attributes      map[uint]models.AttributeDefinition
+}
+
+// NewMemoryCategoryRepository creates an empty in-memory CategoryRepository
+func NewMemoryCategoryRepository() *MemoryCategoryRepository {
+	return &MemoryCategoryRepository{categories: make(map[uint]models.Category), attributes: make(map[uint]models.AttributeDefinition)}
+}
+
+// List returns all categories ordered by ID
+func (r *MemoryCategoryRepository) List() ([]models.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	categories := make([]models.Category, 0, len(r.categories))
+	for _, c := range r.categories {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
+// Get returns the category with the given ID
+func (r *MemoryCategoryRepository) Get(id uint) (*models.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.categories[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &c, nil
+}
+
+// GetBySlug returns the category with the given slug
+func (r *MemoryCategoryRepository) GetBySlug(slug string) (*models.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.categories {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Create inserts a new category
+func (r *MemoryCategoryRepository) Create(category *models.Category) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	category.ID = r.nextID
+	category.CreatedAt = now
+	category.UpdatedAt = now
+	r.categories[category.ID] = *category
+	return nil
+}
+
+// Update saves all fields of an existing category
+func (r *MemoryCategoryRepository) Update(category *models.Category) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.categories[category.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	category.CreatedAt = existing.CreatedAt
+	category.UpdatedAt = time.Now()
+	r.categories[category.ID] = *category
+	return nil
+}
+
+// Delete removes the category with the given ID
+func (r *MemoryCategoryRepository) Delete(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.categories[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.categories, id)
+	for attributeID, attribute := range r.attributes {
+		if attribute.CategoryID == id {
+			delete(r.attributes, attributeID)
+		}
+	}
+	return nil
+}
+
+// Attributes returns the attributes defined for the given categories, ordered by name
+func (r *MemoryCategoryRepository) Attributes(categoryIDs ...uint) ([]models.AttributeDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attributes := []models.AttributeDefinition{}
+	for _, attribute := range r.attributes {
+		if overlaps([]uint{attribute.CategoryID}, categoryIDs) {
+			attributes = append(attributes, attribute)
+		}
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		if attributes[i].Name != attributes[j].Name {
+			return attributes[i].Name < attributes[j].Name
+		}
+		return attributes[i].ID < attributes[j].ID
+	})
+	return attributes, nil
+}
+
+// CreateAttribute defines a new attribute for a category
+func (r *MemoryCategoryRepository) CreateAttribute(attribute *models.AttributeDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextAttributeID++
+	now := time.Now()
+	attribute.ID = r.nextAttributeID
+	attribute.CreatedAt = now
+	attribute.UpdatedAt = now
+	r.attributes[attribute.ID] = *attribute
+	return nil
+}
+
+// DeleteAttribute removes an attribute definition. Values already stored on products are kept.
+func (r *MemoryCategoryRepository) DeleteAttribute(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.attributes[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.attributes, id)
+	return nil
+}
+
+// MemoryUserRepository is a UserRepository kept in memory, mainly for tests and demos
+type MemoryUserRepository struct {
+	mu     sync.RWMutex
+	nextID uint
+	users  map[uint]models.User
+}
+
+// NewMemoryUserRepository creates an empty in-memory UserRepository
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[uint]models.User)}
+}
+
+// Get returns the user with the given ID
+func (r *MemoryUserRepository) Get(id uint) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+// GetByEmail returns the user with the given email address
+func (r *MemoryUserRepository) GetByEmail(email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Create inserts a new user, enforcing the unique email constraint
+func (r *MemoryUserRepository) Create(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+	r.nextID++
+	now := time.Now()
+	user.ID = r.nextID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	r.users[user.ID] = *user
+	return nil
+}
+
+// SetRoles replaces the roles of the user
+func (r *MemoryUserRepository) SetRoles(id uint, roles models.Roles) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Roles = roles
+	r.users[id] = u
+	return nil
+}
+
+// MemoryTokenRepository is a TokenRepository kept in memory, mainly for tests and demos
+type MemoryTokenRepository struct {
+	mu            sync.Mutex
+	nextID        uint
+	refreshTokens map[uint]models.RefreshToken
+	revoked       map[string]time.Time
+}
+
+// NewMemoryTokenRepository creates an empty in-memory TokenRepository
+func NewMemoryTokenRepository() *MemoryTokenRepository {
+	return &MemoryTokenRepository{
+		refreshTokens: make(map[uint]models.RefreshToken),
+		revoked:       make(map[string]time.Time),
+	}
+}
+
+// CreateRefreshToken inserts a new refresh token
+func (r *MemoryTokenRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	token.ID = r.nextID
+	token.CreatedAt = time.Now()
+	r.refreshTokens[token.ID] = *token
+	return nil
+}
+
+// GetRefreshToken finds a refresh token by its hash
+func (r *MemoryTokenRepository) GetRefreshToken(hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.refreshTokens {
+		if t.TokenHash == hash {
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// UseRefreshToken marks the token as exchanged
+func (r *MemoryTokenRepository) UseRefreshToken(id uint, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.refreshTokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if t.UsedAt != nil {
+		return ErrTokenReused
+	}
+	t.UsedAt = &now
+	r.refreshTokens[id] = t
+	return nil
+}
+
+// revokeWhere revokes the refresh tokens matching the predicate; the caller holds the lock
+func (r *MemoryTokenRepository) revokeWhere(now time.Time, match func(models.RefreshToken) bool) {
+	for id, t := range r.refreshTokens {
+		if t.RevokedAt == nil && match(t) {
+			t.RevokedAt = &now
+			r.refreshTokens[id] = t
+		}
+	}
+}
+
+// RevokeFamily revokes every refresh token of a login
+func (r *MemoryTokenRepository) RevokeFamily(family string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revokeWhere(now, func(t models.RefreshToken) bool { return t.Family == family })
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func (r *MemoryTokenRepository) RevokeUserRefreshTokens(userID uint, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revokeWhere(now, func(t models.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+// RevokeAccessToken adds an access token to the revocation list
+func (r *MemoryTokenRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether the access token was revoked
+func (r *MemoryTokenRepository) IsAccessTokenRevoked(jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.revoked[jti]
+	return ok, nil
+}
+
+// PurgeExpired deletes refresh tokens and revocations that have expired
+func (r *MemoryTokenRepository) PurgeExpired(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for jti, expiresAt := range r.revoked {
+		if expiresAt.Before(now) {
+			delete(r.revoked, jti)
+		}
+	}
+	for id, t := range r.refreshTokens {
+		if t.ExpiresAt.Before(now) {
+			delete(r.refreshTokens, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/alwilion/models"
+)
+
+// ErrInvalidTransition is returned when an order cannot move from its current state to the requested one
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// Number of orders returned by a listing unless the query asks for fewer
+const maxOrderLimit = 500
+
+// OrderQuery selects orders by buyer, seller and status. Nil IDs and an empty status match
+// every order.
+type OrderQuery struct {
+	BuyerID  *uint
+	SellerID *uint
+	Status   models.OrderStatus
+	Limit    int // Maximum number of orders, capped at 500
+}
+
+// limit returns the number of orders the query returns at most
+func (q OrderQuery) limit() int {
+	if q.Limit <= 0 || q.Limit > maxOrderLimit {
+		return maxOrderLimit
+	}
+	return q.Limit
+}
+
+// matches reports whether the order is selected by the query
+func (q OrderQuery) matches(order models.Order) bool {
+	if q.BuyerID != nil && order.BuyerID != *q.BuyerID {
+		return false
+	}
+	if q.SellerID != nil && (order.SellerID == nil || *order.SellerID != *q.SellerID) {
+		return false
+	}
+	return q.Status == "" || order.Status == q.Status
+}
+
+// settleReservations ends the reservations of the lines of an order moving to another state
+// using finish: payments sell them, cancellations release them. Refunds leave the stock alone;
+// returned units are received like any other delivery. Reservations that ended before are
+// fine for a cancellation, and for a payment only if they were sold.
+func settleReservations(lines []models.OrderLine, to models.OrderStatus, finish func(id uint, status models.ReservationStatus) (*models.Reservation, error)) error {
+	var status models.ReservationStatus
+	switch to {
+	case models.OrderPaid:
+		status = models.ReservationSold
+	case models.OrderCancelled:
+		status = models.ReservationReleased
+	default:
+		return nil
+	}
+	for _, line := range lines {
+		if line.ReservationID == nil {
+			continue
+		}
+		reservation, err := finish(*line.ReservationID, status)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrReservationInactive) {
+			return err
+		}
+		if to == models.OrderPaid && reservation.Status != models.ReservationSold {
+			return ErrReservationInactive
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/alwilion/models"
+)
+
+// ErrPaymentChanged is returned when a payment is no longer in the state a change expects,
+// because a concurrent request or a redelivered webhook got there first
+var ErrPaymentChanged = errors.New("payment status changed")
+
+// setPaymentStatus moves a payment on from the expected state. Payments leaving the state that
+// requires action drop their action URL.
+func setPaymentStatus(payment *models.Payment, from, to models.PaymentStatus, reason string) error {
+	if payment.Status != from {
+		return ErrPaymentChanged
+	}
+	payment.Status = to
+	if to != models.PaymentRequiresAction {
+		payment.ActionURL = ""
+	}
+	if reason != "" {
+		payment.FailureReason = reason
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alwilion/models"
+)
+
+// ErrScheduleConflict is returned when a sale would overlap another sale of the same product
+var ErrScheduleConflict = errors.New("overlapping sale")
+
+// ErrScheduleInactive is returned when cancelling a schedule that is done or already cancelled
+var ErrScheduleInactive = errors.New("price schedule is no longer pending")
+
+// applySchedule advances a due schedule: a pending schedule sets its price, an active sale
+// restores the price before it. It returns the resulting price change, or nil when the price
+// stays the same. Schedules of deleted products, or of products whose currency changed since,
+// are cancelled. Sales whose price was changed meanwhile end without restoring the old price.
+func applySchedule(product *models.Product, schedule *models.PriceSchedule, now time.Time) *models.PriceChange {
+	due, ok := schedule.DueAt()
+	if !ok || due.After(now) {
+		return nil
+	}
+
+	if schedule.Status == models.SchedulePending {
+		if product == nil || product.Price.Currency != schedule.Price.Currency {
+			schedule.Status = models.ScheduleCancelled
+			return nil
+		}
+		change := &models.PriceChange{ProductID: product.ID, OldPrice: product.Price, NewPrice: schedule.Price, Reason: models.PriceChangeScheduled, ScheduleID: &schedule.ID, UserID: schedule.UserID}
+		schedule.Status = models.ScheduleDone
+		if schedule.IsSale() {
+			amount := product.Price.Amount
+			schedule.RevertAmount = &amount
+			schedule.Status = models.ScheduleActive
+			change.Reason = models.PriceChangeSaleStart
+		}
+		product.Price = schedule.Price
+		if change.OldPrice == change.NewPrice {
+			return nil
+		}
+		return change
+	}
+
+	schedule.Status = models.ScheduleDone
+	if product == nil || product.Price != schedule.Price || schedule.RevertAmount == nil {
+		return nil
+	}
+	restored := models.Money{Amount: *schedule.RevertAmount, Currency: product.Price.Currency}
+	change := &models.PriceChange{ProductID: product.ID, OldPrice: product.Price, NewPrice: restored, Reason: models.PriceChangeSaleEnd, ScheduleID: &schedule.ID, UserID: schedule.UserID}
+	product.Price = restored
+	return change
+}
+
+// checkOverlap returns ErrScheduleConflict when a new sale overlaps one of the pending or
+// active schedules of its product
+func checkOverlap(schedule *models.PriceSchedule, others []models.PriceSchedule) error {
+	for _, other := range others {
+		if _, due := other.DueAt(); due && schedule.Overlaps(other) {
+			return ErrScheduleConflict
+		}
+	}
+	return nil
+}
+
+// cancelSchedule cancels a pending schedule, or ends an active sale now so that applying it
+// restores the price before the sale
+func cancelSchedule(schedule *models.PriceSchedule, now time.Time) error {
+	switch schedule.Status {
+	case models.SchedulePending:
+		schedule.Status = models.ScheduleCancelled
+	case models.ScheduleActive:
+		schedule.EndsAt = &now
+	default:
+		return ErrScheduleInactive
+	}
+	return nil
+}
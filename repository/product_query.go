@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alwilion/models"
+)
+
+// Limits applied to a page of products
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned for cursors that cannot be decoded or belong to another sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// productSortColumns maps the sort keys accepted by the API onto product columns
+var productSortColumns = map[string]string{
+	"id":         "id",
+	"price":      "base_price",
+	"created_at": "created_at",
+	"name":       "name",
+}
+
+// ProductSort is the order of a product listing; ties are always broken by ID
+type ProductSort struct {
+	Column string // One of the keys of productSortColumns
+	Desc   bool
+}
+
+// String returns the sort in API notation, such as "-created_at"
+func (s ProductSort) String() string {
+	if s.Desc {
+		return "-" + s.Column
+	}
+	return s.Column
+}
+
+// ParseProductSort parses "price", "-created_at", "name" and so on; empty means by ID
+func ParseProductSort(value string) (ProductSort, error) {
+	if value == "" {
+		return ProductSort{Column: "id"}, nil
+	}
+	sort := ProductSort{Column: strings.TrimPrefix(value, "-"), Desc: strings.HasPrefix(value, "-")}
+	if _, ok := productSortColumns[sort.Column]; !ok {
+		return sort, fmt.Errorf("cannot sort by %q", sort.Column)
+	}
+	return sort, nil
+}
+
+// Cursor marks a position in a sorted product listing
+type Cursor struct {
+	Sort   string `json:"s"`           // Sort the cursor was created for
+	Value  string `json:"v"`           // Sort column value of the product at the position
+	ID     uint   `json:"id"`          // ID of the product at the position
+	Before bool   `json:"b,omitempty"` // Page backwards from the position instead of forwards
+}
+
+// Encode returns the opaque form handed to clients
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ProductQuery selects, orders and paginates products
+type ProductQuery struct {
+	MinPrice      *int64            // Only products at or above this price, in minor units of the base currency
+	MaxPrice      *int64            // Only products at or below this price, in minor units of the base currency
+	Name          string            // Case-insensitive substring of the name
+	CreatedAfter  *time.Time        // Only products created at or after this time
+	CreatedBefore *time.Time        // Only products created before this time
+	SellerID      *uint             // Only products of this seller
+	CategoryIDs   []uint            // Only products assigned to one of these categories
+	Attributes    map[string]string // Only products with these attribute values, in the form of models.AttributeIndexValue
+	Tags          []string          // Only products carrying all of these tags
+	Sort          ProductSort
+	Limit         int     // Page size, DefaultPageSize when zero
+	Offset        int     // Rows to skip, for offset pagination
+	Cursor        *Cursor // Position to continue from, for cursor pagination
+}
+
+// ProductPage is one page of a product listing
+type ProductPage struct {
+	Products   []models.Product
+	Total      int64  // Number of products matching the filters, across all pages
+	NextCursor string // Cursor of the following page, empty on the last page
+	PrevCursor string // Cursor of the preceding page, empty on the first page
+}
+
+// pageSize returns the effective page size of the query
+func (q ProductQuery) pageSize() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// descending reports whether rows are fetched in descending order, which is
+// the reverse of the sort order when paging backwards from a cursor
+func (q ProductQuery) descending() bool {
+	if q.Cursor != nil && q.Cursor.Before {
+		return !q.Sort.Desc
+	}
+	return q.Sort.Desc
+}
+
+// validateCursor defaults the sort to ID and checks that the cursor belongs to the sort order of the query
+func (q *ProductQuery) validateCursor() error {
+	if q.Sort.Column == "" {
+		q.Sort.Column = "id"
+	}
+	if q.Cursor != nil && q.Cursor.Sort != q.Sort.String() {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// sortValue returns the value of the sort column of a product in cursor form
+func sortValue(p models.Product, column string) string {
+	switch column {
+	case "price":
+		return strconv.FormatInt(p.BasePrice, 10)
+	case "created_at":
+		return p.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "name":
+		return p.Name
+	}
+	return strconv.FormatUint(uint64(p.ID), 10)
+}
+
+// cursorValue converts a cursor value back into the type of the sort column
+func cursorValue(c *Cursor, column string) (interface{}, error) {
+	switch column {
+	case "price":
+		return strconv.ParseInt(c.Value, 10, 64)
+	case "created_at":
+		return time.Parse(time.RFC3339Nano, c.Value)
+	case "name":
+		return c.Value, nil
+	}
+	id, err := strconv.ParseUint(c.Value, 10, 64)
+	return uint(id), err
+}
+
+// buildPage turns the rows fetched for q, at most one more than the page size and in
+// fetch order, into a page with the cursors of its neighbours
+func buildPage(q ProductQuery, rows []models.Product, total int64) *ProductPage {
+	size := q.pageSize()
+	more := len(rows) > size
+	if more {
+		rows = rows[:size]
+	}
+
+	// Rows fetched backwards are returned in the requested order
+	backwards := q.Cursor != nil && q.Cursor.Before
+	if backwards {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := &ProductPage{Products: rows, Total: total}
+	if len(rows) == 0 {
+		return page
+	}
+	first, last := rows[0], rows[len(rows)-1]
+	sort := q.Sort.String()
+
+	// A further page exists in the fetch direction when the extra row was found,
+	// and in the opposite direction whenever we started from a cursor or an offset
+	hasNext, hasPrev := more, q.Cursor != nil || q.Offset > 0
+	if backwards {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.NextCursor = Cursor{Sort: sort, Value: sortValue(last, q.Sort.Column), ID: last.ID}.Encode()
+	}
+	if hasPrev {
+		page.PrevCursor = Cursor{Sort: sort, Value: sortValue(first, q.Sort.Column), ID: first.ID, Before: true}.Encode()
+	}
+	return page
+}
+
+// likePattern builds a LIKE pattern matching substring anywhere, escaping wildcards with '!'
+func likePattern(substring string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(substring))
+	return "%" + escaped + "%"
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/alwilion/models"
+)
+
+// ErrDuplicateCode is returned when a promotion would reuse the coupon code of another promotion
+var ErrDuplicateCode = errors.New("promotion code already in use")
+
+// ErrPromotionExhausted is returned when redeeming a promotion that reached one of its usage caps
+var ErrPromotionExhausted = errors.New("promotion usage cap reached")
+
+// checkCaps returns ErrPromotionExhausted when the promotion may not be redeemed once more by
+// a buyer who redeemed it userUses times
+func checkCaps(promotion models.Promotion, userUses int) error {
+	if promotion.MaxUses > 0 && promotion.Uses >= promotion.MaxUses {
+		return ErrPromotionExhausted
+	}
+	if promotion.MaxUsesPerUser > 0 && userUses >= promotion.MaxUsesPerUser {
+		return ErrPromotionExhausted
+	}
+	return nil
+}
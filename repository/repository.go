@@ -0,0 +1,245 @@
+// Package repository defines the storage interfaces used by the controllers,
+// together with a GORM implementation and an in-memory implementation.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alwilion/models"
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicateSKU is returned when a variant would reuse the SKU of another variant
+var ErrDuplicateSKU = errors.New("SKU already in use")
+
+// ErrVersionConflict is returned when updating a record that was changed since it was read
+var ErrVersionConflict = errors.New("record was modified concurrently")
+
+// ErrTokenReused is returned when a refresh token that was already exchanged is presented again
+var ErrTokenReused = errors.New("refresh token already used")
+
+// Store groups the repositories the controllers depend on
+type Store struct {
+	Products   ProductRepository
+	Variants   VariantRepository
+	Inventory  InventoryRepository
+	Prices     PriceRepository
+	Images     ImageRepository
+	Carts      CartRepository
+	Orders     OrderRepository
+	Payments   PaymentRepository
+	Promotions PromotionRepository
+	Categories CategoryRepository
+	Rates      ExchangeRateRepository
+	TaxRates   TaxRateRepository
+	Users      UserRepository
+	Tokens     TokenRepository
+}
+
+// NewGormStore creates a Store whose repositories all use db
+func NewGormStore(db *gorm.DB) *Store {
+	return &Store{
+		Products:   NewGormProductRepository(db),
+		Variants:   NewGormVariantRepository(db),
+		Inventory:  NewGormInventoryRepository(db),
+		Prices:     NewGormPriceRepository(db),
+		Images:     NewGormImageRepository(db),
+		Carts:      NewGormCartRepository(db),
+		Orders:     NewGormOrderRepository(db),
+		Payments:   NewGormPaymentRepository(db),
+		Promotions: NewGormPromotionRepository(db),
+		Categories: NewGormCategoryRepository(db),
+		Rates:      NewGormExchangeRateRepository(db),
+		TaxRates:   NewGormTaxRateRepository(db),
+		Users:      NewGormUserRepository(db),
+		Tokens:     NewGormTokenRepository(db),
+	}
+}
+
+// NewMemoryStore creates a Store whose repositories are kept in memory
+func NewMemoryStore() *Store {
+	products := NewMemoryProductRepository()
+	inventory := NewMemoryInventoryRepository()
+	return &Store{
+		Products:   products,
+		Variants:   NewMemoryVariantRepository(),
+		Inventory:  inventory,
+		Prices:     NewMemoryPriceRepository(products),
+		Images:     NewMemoryImageRepository(products),
+		Carts:      NewMemoryCartRepository(),
+		Orders:     NewMemoryOrderRepository(inventory),
+		Payments:   NewMemoryPaymentRepository(),
+		Promotions: NewMemoryPromotionRepository(),
+		Categories: NewMemoryCategoryRepository(),
+		Rates:      NewMemoryExchangeRateRepository(),
+		TaxRates:   NewMemoryTaxRateRepository(),
+		Users:      NewMemoryUserRepository(),
+		Tokens:     NewMemoryTokenRepository(),
+	}
+}
+
+// ProductRepository stores products
+type ProductRepository interface {
+	List() ([]models.Product, error)                                    // List returns all products
+	ListBySeller(sellerID uint) ([]models.Product, error)               // ListBySeller returns the products listed by one seller
+	Find(q ProductQuery) (*ProductPage, error)                          // Find returns one page of the products matching the query
+	Search(q SearchQuery) (*SearchPage, error)                          // Search returns one page of full-text matches, most relevant first
+	Facets(q ProductQuery, request FacetRequest) (*FacetCounts, error)  // Facets counts the products matching the filters of q per facet value
+	Get(id uint) (*models.Product, error)                               // Get returns the product with the given ID or ErrNotFound
+	Create(product *models.Product) error                               // Create inserts the product and fills in its ID and timestamps
+	Update(product *models.Product) error                               // Update saves all fields of a product unless its version changed since it was read, then increments the version
+	UpdateCategories(product *models.Product, categoryIDs []uint) error // UpdateCategories saves a product like Update and replaces its categories in the same transaction
+	Delete(id uint) error                                               // Delete soft-deletes the product or returns ErrNotFound
+	SetCategories(productID uint, categoryIDs []uint) error             // SetCategories replaces the categories the product is assigned to
+	CategoryIDs(productID uint) ([]uint, error)                         // CategoryIDs returns the categories the product is assigned to
+	Reprice(currency string, rates *models.Rates) error                 // Reprice recomputes the base price of every product priced in the currency
+	CountByCurrency(currency string) (int64, error)                     // CountByCurrency counts the products, including deleted ones, priced in the currency
+	Trash(sellerID *uint) ([]models.Product, error)                     // Trash returns the soft-deleted products, of one seller unless sellerID is nil, most recently deleted first
+	GetDeleted(id uint) (*models.Product, error)                        // GetDeleted returns the soft-deleted product with the given ID or ErrNotFound
+	Restore(id uint) error                                              // Restore undeletes a soft-deleted product or returns ErrNotFound
+	Purge(id uint) error                                                // Purge permanently deletes a product, soft-deleted or not, together with the records referring to it
+}
+
+// VariantRepository stores the variants of products
+type VariantRepository interface {
+	List(productID uint) ([]models.Variant, error) // List returns the variants of a product ordered by ID
+	Get(id uint) (*models.Variant, error)          // Get returns the variant with the given ID or ErrNotFound
+	GetBySKU(sku string) (*models.Variant, error)  // GetBySKU returns the variant with the given SKU or ErrNotFound
+	Create(variant *models.Variant) error          // Create inserts the variant, or returns ErrDuplicateSKU if its SKU is taken
+	Update(variant *models.Variant) error          // Update saves all fields of an existing variant, or returns ErrDuplicateSKU
+	Delete(id uint) error                          // Delete removes the variant or returns ErrNotFound
+}
+
+// InventoryRepository keeps the stock of products and variants. Every change is recorded
+// in an append-only ledger of movements while the stock level it changes is locked.
+type InventoryRepository interface {
+	Levels(productID uint) ([]models.StockLevel, error)                        // Levels returns the stock of a product and its variants ordered by variant ID
+	Movements(productID uint, limit int) ([]models.StockMovement, error)       // Movements returns the latest ledger entries of a product, newest first
+	Record(movement *models.StockMovement) error                               // Record applies a receive, adjust or sell movement, or returns ErrInsufficientStock
+	Reserve(reservation *models.Reservation, now time.Time) error              // Reserve holds units until the reservation expires, or returns ErrInsufficientStock
+	Reservation(id uint) (*models.Reservation, error)                          // Reservation returns the reservation with the given ID or ErrNotFound
+	Release(id uint, userID *uint, now time.Time) (*models.Reservation, error) // Release returns reserved units to the available stock
+	Sell(id uint, userID *uint, now time.Time) (*models.Reservation, error)    // Sell takes reserved units out of the stock
+	ExpireReservations(now time.Time) error                                    // ExpireReservations releases every reservation that has expired
+}
+
+// CategoryRepository stores the product taxonomy
+type CategoryRepository interface {
+	List() ([]models.Category, error)                                     // List returns all categories ordered by ID
+	Get(id uint) (*models.Category, error)                                // Get returns the category with the given ID or ErrNotFound
+	GetBySlug(slug string) (*models.Category, error)                      // GetBySlug returns the category with the given slug or ErrNotFound
+	Create(category *models.Category) error                               // Create inserts the category and fills in its ID and timestamps
+	Update(category *models.Category) error                               // Update saves all fields of an existing category
+	Delete(id uint) error                                                 // Delete removes the category or returns ErrNotFound
+	Attributes(categoryIDs ...uint) ([]models.AttributeDefinition, error) // Attributes returns the attributes defined for the categories
+	CreateAttribute(attribute *models.AttributeDefinition) error          // CreateAttribute defines a new attribute for a category
+	DeleteAttribute(id uint) error                                        // DeleteAttribute removes an attribute definition or returns ErrNotFound
+}
+
+// PriceRepository stores the price history of products and their scheduled price changes
+type PriceRepository interface {
+	History(productID uint, limit int) ([]models.PriceChange, error)                      // History returns the latest price changes of a product, newest first
+	Change(product *models.Product, change *models.PriceChange, categoryIDs []uint) error // Change saves a product like ProductRepository.UpdateCategories and appends its manual price change to the history
+	Schedules(productID uint) ([]models.PriceSchedule, error)                             // Schedules returns the schedules of a product ordered by start
+	Schedule(id uint) (*models.PriceSchedule, error)                                      // Schedule returns the schedule with the given ID
+	CreateSchedule(schedule *models.PriceSchedule) error                                  // CreateSchedule adds a pending schedule, or returns ErrScheduleConflict for overlapping sales
+	CancelSchedule(id uint, now time.Time) (*models.PriceSchedule, error)                 // CancelSchedule cancels a pending schedule or ends an active sale now
+	Due(now time.Time) ([]models.PriceSchedule, error)                                    // Due returns the schedules that have to be applied, oldest first
+	Apply(id uint, rates *models.Rates, now time.Time) (*models.PriceChange, error)       // Apply advances a due schedule, changing the product price and recording the change
+}
+
+// ImageRepository stores the images of products. Every product with images has exactly one
+// primary image, the first one uploaded unless another is chosen.
+type ImageRepository interface {
+	List(productID uint) ([]models.ProductImage, error)                // List returns the images of a product ordered by position
+	Get(id uint) (*models.ProductImage, error)                         // Get returns the image with the given ID or ErrNotFound
+	Create(image *models.ProductImage) error                           // Create appends the image to the gallery of its product, making the first image primary
+	Delete(id uint) error                                              // Delete removes the image, promoting the next one if it was primary
+	Reorder(productID uint, ids []uint) ([]models.ProductImage, error) // Reorder sets the gallery order, or returns ErrInvalidOrder unless ids lists every image once
+	SetPrimary(id uint) error                                          // SetPrimary makes the image the primary image of its product
+	Primary(productIDs []uint) (map[uint]models.ProductImage, error)   // Primary returns the primary images of the products by product ID
+}
+
+// CartRepository stores the shopping carts of users and guests. Carts are returned with their
+// items ordered by ID; changing an item touches the cart.
+type CartRepository interface {
+	Get(id uint) (*models.Cart, error)                          // Get returns the cart with the given ID or ErrNotFound
+	ForUser(userID uint) (*models.Cart, error)                  // ForUser returns the cart of a user or ErrNotFound
+	ForToken(tokenHash string) (*models.Cart, error)            // ForToken returns the guest cart with the given token hash or ErrNotFound
+	Create(cart *models.Cart) error                             // Create inserts an empty cart and fills in its ID and timestamps
+	SaveItem(item *models.CartItem) error                       // SaveItem inserts a new item or saves the quantity and price of an existing one
+	DeleteItem(cartID, itemID uint) error                       // DeleteItem removes an item of the cart or returns ErrNotFound
+	Clear(id uint) error                                        // Clear removes all items of the cart
+	StartCheckout(id uint, now time.Time) (*models.Cart, error) // StartCheckout claims the cart for one checkout and returns it, or returns ErrCheckoutInProgress
+	FinishCheckout(id uint) error                               // FinishCheckout releases the claim of StartCheckout
+	Merge(fromID, intoID uint) (*models.Cart, error)            // Merge moves the items of one cart into another, adding up the quantities of the same product, and deletes the emptied cart
+	PurgeGuests(before time.Time) error                         // PurgeGuests deletes the guest carts that have not changed since before
+}
+
+// OrderRepository stores orders and enforces their lifecycle. Orders are returned with their
+// lines ordered by ID.
+type OrderRepository interface {
+	Create(order *models.Order) error                                                              // Create inserts a pending order with its lines and fills in their IDs
+	Get(id uint) (*models.Order, error)                                                            // Get returns the order with the given ID or ErrNotFound
+	List(q OrderQuery) ([]models.Order, error)                                                     // List returns the orders matching the query, newest first
+	Transition(id uint, to models.OrderStatus, userID *uint, now time.Time) (*models.Order, error) // Transition moves the order to another state, selling the reservations of its lines when paid and releasing them when cancelled, or returns ErrInvalidTransition
+	Expired(now time.Time) ([]models.Order, error)                                                 // Expired returns the pending orders that were not paid in time, oldest first
+}
+
+// PaymentRepository stores the attempts to pay orders
+type PaymentRepository interface {
+	Create(payment *models.Payment) error                                                      // Create inserts the payment and fills in its ID
+	ForOrder(orderID uint) ([]models.Payment, error)                                           // ForOrder returns the payments of an order ordered by ID
+	ByReference(provider, reference string) (*models.Payment, error)                           // ByReference returns the payment a provider knows by reference or ErrNotFound
+	Transition(id uint, from, to models.PaymentStatus, reason string) (*models.Payment, error) // Transition moves the payment on from the expected state, or returns ErrPaymentChanged
+}
+
+// PromotionRepository stores promotions and counts their redemptions
+type PromotionRepository interface {
+	List() ([]models.Promotion, error)                     // List returns all promotions ordered by ID
+	ListActive() ([]models.Promotion, error)               // ListActive returns the promotions not switched off, whether or not they are running
+	Get(id uint) (*models.Promotion, error)                // Get returns the promotion with the given ID or ErrNotFound
+	Create(promotion *models.Promotion) error              // Create inserts the promotion, or returns ErrDuplicateCode
+	Update(promotion *models.Promotion) error              // Update saves all fields but the use count, or returns ErrDuplicateCode
+	Delete(id uint) error                                  // Delete removes the promotion with its redemptions or returns ErrNotFound
+	UserUses(userID uint) (map[uint]int, error)            // UserUses counts the redemptions of each promotion by a user
+	Redeem(redemptions []models.PromotionRedemption) error // Redeem records the redemptions of a checkout at once, or none of them with ErrPromotionExhausted
+}
+
+// ExchangeRateRepository stores the exchange rates maintained by admins
+type ExchangeRateRepository interface {
+	List() ([]models.ExchangeRate, error)              // List returns all exchange rates ordered by currency
+	Get(currency string) (*models.ExchangeRate, error) // Get returns the rate of a currency or ErrNotFound
+	Save(rate *models.ExchangeRate) error              // Save inserts or replaces the rate of a currency
+	Delete(currency string) error                      // Delete removes the rate of a currency or returns ErrNotFound
+}
+
+// TaxRateRepository stores the tax rates maintained by finance
+type TaxRateRepository interface {
+	List() ([]models.TaxRate, error)      // List returns all tax rates ordered by jurisdiction, class and name
+	Replace(rates []models.TaxRate) error // Replace swaps the whole table for the given rates at once
+}
+
+// UserRepository stores users
+type UserRepository interface {
+	Get(id uint) (*models.User, error)             // Get returns the user with the given ID or ErrNotFound
+	GetByEmail(email string) (*models.User, error) // GetByEmail returns the user with the given email or ErrNotFound
+	Create(user *models.User) error                // Create inserts the user and fills in its ID and timestamps
+	SetRoles(id uint, roles models.Roles) error    // SetRoles replaces the roles of the user or returns ErrNotFound
+}
+
+// TokenRepository stores refresh tokens and the list of revoked access tokens
+type TokenRepository interface {
+	CreateRefreshToken(token *models.RefreshToken) error       // CreateRefreshToken inserts a new refresh token
+	GetRefreshToken(hash string) (*models.RefreshToken, error) // GetRefreshToken finds a refresh token by its hash or returns ErrNotFound
+	UseRefreshToken(id uint, now time.Time) error              // UseRefreshToken marks the token as exchanged, or returns ErrTokenReused if it already was
+	RevokeFamily(family string, now time.Time) error           // RevokeFamily revokes every refresh token of a login
+	RevokeUserRefreshTokens(userID uint, now time.Time) error  // RevokeUserRefreshTokens revokes every refresh token of a user
+	RevokeAccessToken(jti string, expiresAt time.Time) error   // RevokeAccessToken adds an access token to the revocation list
+	IsAccessTokenRevoked(jti string) (bool, error)             // IsAccessTokenRevoked reports whether the access token was revoked
+	PurgeExpired(now time.Time) error                          // PurgeExpired deletes refresh tokens and revocations that have expired
+}
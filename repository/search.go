@@ -0,0 +1,352 @@
+package repository
+
+import (
+	"errors"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alwilion/models"
+)
+
+// Limits applied to a search
+const (
+	MaxSearchClauses = 10 // Words, prefixes and phrases per query
+	maxTermLength    = 64 // Longer words are not indexed
+	snippetLength    = 160
+)
+
+// Relevance weights: matches in the name count five times as much as matches in the description,
+// and inexact matches count less than exact ones
+const (
+	nameWeight   = 5.0
+	phraseWeight = 1.5
+	exactWeight  = 1.0
+	prefixWeight = 0.8
+	typoWeight   = 0.5
+)
+
+// ErrEmptySearch is returned for queries without a single searchable word
+var ErrEmptySearch = errors.New("empty search query")
+
+// SearchClause is one part of a search query that every result must match
+type SearchClause struct {
+	Words  []string // Lower-case words, more than one for a phrase
+	Phrase bool     // Words must appear next to each other in this order
+	Prefix bool     // The single word may be the start of a longer word
+}
+
+// SearchQuery is a parsed full-text search
+type SearchQuery struct {
+	Clauses []SearchClause
+	Limit   int // Page size, DefaultPageSize when zero
+	Offset  int // Results to skip
+}
+
+// SearchResult is a product matching a search, with its relevance and highlighted text
+type SearchResult struct {
+	models.Product
+	Score      float64    `json:"score"`
+	Highlights Highlights `json:"highlights"`
+}
+
+// Highlights are HTML-escaped excerpts of a product with the matches wrapped in <mark> tags
+type Highlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"` // Snippet around the first match, or the start of the description
+}
+
+// SearchPage is one page of search results, most relevant first
+type SearchPage struct {
+	Results []SearchResult
+	Total   int64 // Number of matching products across all pages
+}
+
+// ParseSearch parses a query such as `desk lam* "reading light"`: plain words tolerate typos,
+// a trailing * matches prefixes and double quotes match phrases
+func ParseSearch(text string) (SearchQuery, error) {
+	var q SearchQuery
+	for i, part := range strings.Split(text, `"`) {
+		// Odd parts are inside quotes
+		if i%2 == 1 {
+			if words := terms(part); len(words) == 1 {
+				q.Clauses = append(q.Clauses, SearchClause{Words: words})
+			} else if len(words) > 1 {
+				q.Clauses = append(q.Clauses, SearchClause{Words: words, Phrase: true})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			words := terms(field)
+			for j, word := range words {
+				q.Clauses = append(q.Clauses, SearchClause{Words: []string{word}, Prefix: prefix && j == len(words)-1})
+			}
+		}
+	}
+	if len(q.Clauses) == 0 {
+		return q, ErrEmptySearch
+	}
+	if len(q.Clauses) > MaxSearchClauses {
+		q.Clauses = q.Clauses[:MaxSearchClauses]
+	}
+	return q, nil
+}
+
+// pageSize returns the effective page size of the search
+func (q SearchQuery) pageSize() int {
+	return ProductQuery{Limit: q.Limit}.pageSize()
+}
+
+// token is a word of a text with its byte offsets
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower-case words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text + " " {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if term := strings.ToLower(text[start:i]); utf8.RuneCountInString(term) <= maxTermLength {
+				tokens = append(tokens, token{term: term, start: start, end: i})
+			}
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// terms returns the words of text
+func terms(text string) []string {
+	tokens := tokenize(text)
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.term
+	}
+	return words
+}
+
+// productTerms returns the distinct words of the name and description of a product
+func productTerms(p *models.Product) []string {
+	seen := map[string]bool{}
+	var words []string
+	for _, word := range terms(p.Name + " " + p.Description) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// maxEdits returns how many typos a word of the given length tolerates
+func maxEdits(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and transpositions
+// turning a into b, or limit+1 once it is certain to exceed limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// wordWeight returns how well an indexed word matches a search word, zero for no match
+func wordWeight(term, word string, prefix bool) float64 {
+	switch {
+	case term == word:
+		return exactWeight
+	case prefix && strings.HasPrefix(term, word):
+		return prefixWeight
+	case !prefix && isTypo(term, word):
+		return typoWeight
+	}
+	return 0
+}
+
+// isTypo reports whether term is within the tolerated number of typos of word. The first
+// letter has to be right, which lets the databases narrow down the candidates with an index.
+func isTypo(term, word string) bool {
+	first, _ := utf8.DecodeRuneInString(word)
+	return maxEdits(word) > 0 && strings.HasPrefix(term, string(first)) &&
+		editDistance(term, word, maxEdits(word)) <= maxEdits(word)
+}
+
+// span is a matched range of bytes in a text
+type span struct{ start, end int }
+
+// matchField finds the matches of a clause in the tokens of one field and returns
+// their relevance, the best match weight damped by the number of matches
+func matchField(tokens []token, clause SearchClause) (float64, []span) {
+	var best float64
+	var spans []span
+	if clause.Phrase {
+		for i := 0; i+len(clause.Words) <= len(tokens); i++ {
+			match := true
+			for j, word := range clause.Words {
+				if tokens[i+j].term != word {
+					match = false
+					break
+				}
+			}
+			if match {
+				best = phraseWeight
+				spans = append(spans, span{tokens[i].start, tokens[i+len(clause.Words)-1].end})
+			}
+		}
+	} else {
+		for _, t := range tokens {
+			if w := wordWeight(t.term, clause.Words[0], clause.Prefix); w > 0 {
+				best = math.Max(best, w)
+				spans = append(spans, span{t.start, t.end})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return 0, nil
+	}
+	return best * (1 + math.Log(float64(len(spans)))), spans
+}
+
+// matchProduct scores a product against a search, returning false unless every clause matches
+func matchProduct(p models.Product, q SearchQuery) (SearchResult, bool) {
+	nameTokens, descriptionTokens := tokenize(p.Name), tokenize(p.Description)
+	result := SearchResult{Product: p}
+	var nameSpans, descriptionSpans []span
+	for _, clause := range q.Clauses {
+		nameScore, inName := matchField(nameTokens, clause)
+		descriptionScore, inDescription := matchField(descriptionTokens, clause)
+		if inName == nil && inDescription == nil {
+			return result, false
+		}
+		result.Score += nameWeight*nameScore + descriptionScore
+		nameSpans = append(nameSpans, inName...)
+		descriptionSpans = append(descriptionSpans, inDescription...)
+	}
+	result.Highlights = Highlights{
+		Name:        highlight(p.Name, nameSpans, 0),
+		Description: highlight(p.Description, descriptionSpans, snippetLength),
+	}
+	return result, true
+}
+
+// highlight escapes text and marks the spans. With a positive maxLength only a snippet
+// of about that many bytes around the first span is kept.
+func highlight(text string, spans []span, maxLength int) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	from, to := 0, len(text)
+	if maxLength > 0 && len(text) > maxLength {
+		if len(spans) > 0 {
+			from = max(0, spans[0].start-maxLength/4)
+		}
+		to = min(len(text), from+maxLength)
+		from, to = wordBoundary(text, from, false), wordBoundary(text, to, true)
+		if from > 0 && len(spans) > 0 && from > spans[0].start {
+			from = spans[0].start
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.start < pos || s.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:s.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[s.start:s.end]) + "</mark>")
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// wordBoundary moves a cut position back to the start of its word, so snippets never split
+// words; start positions land after the preceding space, end positions on it
+func wordBoundary(text string, pos int, end bool) int {
+	if pos <= 0 || pos >= len(text) {
+		return pos
+	}
+	if i := strings.LastIndexByte(text[:pos], ' '); i > 0 {
+		if end {
+			return i
+		}
+		return i + 1
+	}
+	for pos > 0 && !utf8.RuneStart(text[pos]) {
+		pos--
+	}
+	return pos
+}
+
+// rankResults orders results by relevance, then by ID
+func rankResults(results []SearchResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+}
+
+// pageResults ranks results and cuts out the requested page
+func pageResults(q SearchQuery, results []SearchResult) *SearchPage {
+	rankResults(results)
+	page := &SearchPage{Total: int64(len(results))}
+	start := min(q.Offset, len(results))
+	end := min(start+q.pageSize(), len(results))
+	page.Results = results[start:end]
+	return page
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/alwilion/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearch(t *testing.T) {
+	q, err := ParseSearch(`Desk lam* "Reading  light" "arm"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []SearchClause{
+		{Words: []string{"desk"}},
+		{Words: []string{"lam"}, Prefix: true},
+		{Words: []string{"reading", "light"}, Phrase: true},
+		{Words: []string{"arm"}},
+	}, q.Clauses)
+
+	_, err = ParseSearch(` "" * `)
+	assert.ErrorIs(t, err, ErrEmptySearch)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("lamp", "lamp", 2))
+	assert.Equal(t, 1, editDistance("lamp", "lmap", 2)) // transposition
+	assert.Equal(t, 1, editDistance("lamp", "lamps", 2))
+	assert.Equal(t, 2, editDistance("lamp", "clamps", 2))
+	assert.Equal(t, 2, editDistance("lamp", "table", 1)) // exceeds the limit
+	assert.True(t, isTypo("chair", "chiar"))
+	assert.False(t, isTypo("chair", "hair")) // different first letter
+	assert.False(t, isTypo("cat", "car"))    // too short for typos
+}
+
+func TestMatchProduct_Highlights(t *testing.T) {
+	q, _ := ParseSearch("lamp")
+	p := models.Product{Name: "Lamp <b>", Description: "Bright lamp"}
+	result, ok := matchProduct(p, q)
+	assert.True(t, ok)
+	assert.Equal(t, "<mark>Lamp</mark> &lt;b&gt;", result.Highlights.Name)
+	assert.Equal(t, "Bright <mark>lamp</mark>", result.Highlights.Description)
+
+	// Long descriptions are cut into a snippet around the first match
+	long := ""
+	for i := 0; i < 40; i++ {
+		long += "filler "
+	}
+	p.Description = long + "lamp " + long
+	result, _ = matchProduct(p, q)
+	assert.Contains(t, result.Highlights.Description, "<mark>lamp</mark>")
+	assert.True(t, len(result.Highlights.Description) < snippetLength+40)
+	assert.Equal(t, "…", result.Highlights.Description[:len("…")])
+}
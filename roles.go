@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alwilion/config"
+	"github.com/alwilion/database"
+	"github.com/alwilion/models"
+	"github.com/alwilion/repository"
+)
+
+// rolesUsage describes the roles subcommand
+const rolesUsage = `usage: app [flags] roles <grant|revoke> <email> <role>
+
+Grants or revokes admin, seller or buyer for the user with the given email.
+Use it to bootstrap the first admin, who can then manage roles over the API.`
+
+// runRoles executes the roles subcommand with the remaining command-line arguments
+func runRoles(cfg config.DatabaseConfig, args []string) error {
+	if len(args) != 3 || (args[0] != "grant" && args[0] != "revoke") {
+		return errors.New(rolesUsage)
+	}
+	email, role := args[1], args[2]
+	if !models.IsValidRole(role) {
+		return fmt.Errorf("unknown role %q\n%s", role, rolesUsage)
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	if err := database.Migrate(db, cfg); err != nil {
+		return err
+	}
+	users := repository.NewGormUserRepository(db)
+
+	user, err := users.GetByEmail(email)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", email, err)
+	}
+	if args[0] == "grant" {
+		user.Roles = user.Roles.With(role)
+	} else {
+		user.Roles = user.Roles.Without(role)
+	}
+	if err := users.SetRoles(user.ID, user.Roles); err != nil {
+		return err
+	}
+	fmt.Printf("%s now has roles %v\n", email, user.Roles)
+	return nil
+}
@@ -6,16 +6,103 @@ import (
 )
 
 // Setup initializes the routes for the application
-func Setup(app *fiber.App) {
+func Setup(app *fiber.App, ctl *controllers.Controllers) {
+	// Publish the token verification keys
+	app.Get("/.well-known/jwks.json", ctl.JWKS)
+
 	// Create a route group under the path "/user"
 	api := app.Group("/user")
 
 	// Define routes and associate them with corresponding controller functions
-	api.Post("/login", controllers.Login)         // Route for user login
-	api.Post("/register", controllers.Register)   // Route for user registration
-
-	api.Get("/products", controllers.GetProductList)          // Route to get a list of products
-	api.Get("/products/:id", controllers.GetProductById)      // Route to get a product by ID
-	api.Delete("/products/:id", controllers.DeleteProductById) // Route to delete a product by ID
-	api.Post("/products", controllers.AddProduct)              // Route to add a new product
-	api.Put
+	api.Post("/login", ctl.Login)       // Route for user login
+	api.Post("/register", ctl.Register) // Route for user registration
+	api.Post("/refresh", ctl.Refresh)   // Route to exchange a refresh token for new tokens
+	api.Post("/logout", ctl.Logout)     // Route to revoke the current tokens
+	api.Get("/", ctl.User)              // Route to get the authenticated user
+
+	// Product routes, each guarded by the permission it needs
+	api.Get("/products", ctl.Require(controllers.PermReadProducts), ctl.GetProductList)                             // Route to get a list of products
+	api.Get("/products/search", ctl.Require(controllers.PermReadProducts), ctl.SearchProducts)                      // Route to search products by words in their name and description
+	api.Get("/products/trash", ctl.Require(controllers.PermDeleteProducts), ctl.ListTrash)                          // Route to get the deleted products that can be restored
+	api.Post("/products/:id/restore", ctl.Require(controllers.PermDeleteProducts), ctl.RestoreProduct)              // Route to restore a deleted product
+	api.Get("/products/:id", ctl.Require(controllers.PermReadProducts), ctl.GetProductById)                         // Route to get a product by ID
+	api.Delete("/products/:id", ctl.Require(controllers.PermDeleteProducts), ctl.DeleteProductById)                 // Route to delete a product by ID
+	api.Post("/products", ctl.Require(controllers.PermCreateProducts), ctl.AddProduct)                              // Route to add a new product
+	api.Put("/products/:id", ctl.Require(controllers.PermUpdateProducts), ctl.UpdateProduct)                        // Route to update a product by ID
+	api.Patch("/products/:id", ctl.Require(controllers.PermUpdateProducts), ctl.UpdateProduct)                      // Route to update some fields of a product by ID
+	api.Get("/products/:id/categories", ctl.Require(controllers.PermReadProducts), ctl.GetProductCategories)        // Route to get the categories of a product
+	api.Put("/products/:id/categories", ctl.Require(controllers.PermUpdateProducts), ctl.SetProductCategories)      // Route to assign a product to categories
+	api.Get("/products/:id/variants", ctl.Require(controllers.PermReadProducts), ctl.ListVariants)                  // Route to get the variants of a product
+	api.Post("/products/:id/variants", ctl.Require(controllers.PermUpdateProducts), ctl.CreateVariant)              // Route to add a variant to a product
+	api.Get("/products/:id/variants/:variantId", ctl.Require(controllers.PermReadProducts), ctl.GetVariant)         // Route to get a variant of a product
+	api.Put("/products/:id/variants/:variantId", ctl.Require(controllers.PermUpdateProducts), ctl.UpdateVariant)    // Route to update a variant of a product
+	api.Delete("/products/:id/variants/:variantId", ctl.Require(controllers.PermUpdateProducts), ctl.DeleteVariant) // Route to delete a variant of a product
+	api.Get("/products/:id/inventory", ctl.Require(controllers.PermUpdateProducts), ctl.GetInventory)               // Route to get the stock and inventory ledger of a product
+	api.Post("/products/:id/inventory", ctl.Require(controllers.PermUpdateProducts), ctl.RecordInventory)           // Route to receive, adjust, reserve, release or sell stock
+	api.Get("/me/products", ctl.Require(controllers.PermReadProducts), ctl.GetMyProducts)                           // Route to get the products listed by the user
+
+	// Price history of products and their scheduled price changes and sales
+	api.Get("/products/:id/prices", ctl.Require(controllers.PermReadProducts), ctl.GetPriceHistory)                               // Route to get the price history of a product
+	api.Get("/products/:id/price-schedules", ctl.Require(controllers.PermUpdateProducts), ctl.ListPriceSchedules)                 // Route to get the scheduled price changes of a product
+	api.Post("/products/:id/price-schedules", ctl.Require(controllers.PermUpdateProducts), ctl.CreatePriceSchedule)               // Route to schedule a price change or sale
+	api.Delete("/products/:id/price-schedules/:scheduleId", ctl.Require(controllers.PermUpdateProducts), ctl.CancelPriceSchedule) // Route to cancel a scheduled price change or end a sale
+
+	// Image galleries of products
+	api.Get("/products/:id/images", ctl.Require(controllers.PermReadProducts), ctl.ListProductImages)                         // Route to get the images of a product
+	api.Post("/products/:id/images", ctl.Require(controllers.PermUpdateProducts), ctl.UploadProductImage)                     // Route to upload an image of a product
+	api.Put("/products/:id/images/order", ctl.Require(controllers.PermUpdateProducts), ctl.ReorderProductImages)              // Route to change the gallery order of a product
+	api.Put("/products/:id/images/:imageId/primary", ctl.Require(controllers.PermUpdateProducts), ctl.SetPrimaryProductImage) // Route to choose the image shown in listings
+	api.Delete("/products/:id/images/:imageId", ctl.Require(controllers.PermUpdateProducts), ctl.DeleteProductImage)          // Route to delete an image of a product
+
+	// Storefront of a single seller
+	app.Get("/sellers/:id/products", ctl.Require(controllers.PermReadProducts), ctl.GetSellerProducts) // Route to get the products listed by a seller
+
+	// Category taxonomy for browsing
+	categories := app.Group("/categories", ctl.Require(controllers.PermReadProducts))
+	categories.Get("/", ctl.ListCategories)                      // Route to get all categories as a flat list
+	categories.Get("/tree", ctl.GetCategoryTree)                 // Route to get the categories nested under their parents
+	categories.Get("/:id", ctl.GetCategory)                      // Route to get a category by ID
+	categories.Get("/:id/attributes", ctl.GetCategoryAttributes) // Route to get the attributes products of a category may carry
+
+	// Exchange rates used to show prices in other currencies
+	app.Get("/exchange-rates", ctl.Require(controllers.PermReadProducts), ctl.ListExchangeRates) // Route to get the exchange rate table
+
+	// Shopping cart of the authenticated user, or of a guest identified by the X-Cart-Token header
+	cart := app.Group("/cart")
+	cart.Get("/", ctl.GetCart)                                                     // Route to get the cart priced at the current prices
+	cart.Delete("/", ctl.ClearCart)                                                // Route to remove every item from the cart
+	cart.Post("/items", ctl.AddCartItem)                                           // Route to add a product or variant to the cart
+	cart.Put("/items/:itemId", ctl.UpdateCartItem)                                 // Route to change the quantity of a cart item
+	cart.Delete("/items/:itemId", ctl.RemoveCartItem)                              // Route to remove an item from the cart
+	cart.Post("/checkout", ctl.Require(controllers.PermPlaceOrders), ctl.Checkout) // Route to place the cart as one order per seller
+
+	// Orders placed by the user, or of the user's listings
+	orders := app.Group("/orders", ctl.Require())
+	orders.Get("/", ctl.ListOrders)                  // Route to get the orders of the user
+	orders.Get("/:id", ctl.GetOrder)                 // Route to get an order with its lines
+	orders.Put("/:id/status", ctl.UpdateOrderStatus) // Route to move an order along its lifecycle
+	orders.Post("/:id/pay", ctl.PayOrder)            // Route to pay a pending order
+
+	// Callbacks of the payment provider, authenticated by their signature
+	app.Post("/payments/webhook", ctl.PaymentWebhook)              // Route receiving payment webhook callbacks
+	app.Post("/payments/fake/:reference", ctl.CompleteFakePayment) // Route completing challenges of the fake payment provider
+
+	// Create a route group under the path "/admin" for administrators
+	admin := app.Group("/admin")
+	admin.Post("/users/:id/roles", ctl.Require(controllers.PermManageRoles), ctl.GrantRole)                              // Route to grant a role to a user
+	admin.Delete("/users/:id/roles/:role", ctl.Require(controllers.PermManageRoles), ctl.RevokeRole)                     // Route to revoke a role from a user
+	admin.Post("/categories", ctl.Require(controllers.PermManageCategories), ctl.CreateCategory)                         // Route to add a category
+	admin.Put("/categories/:id", ctl.Require(controllers.PermManageCategories), ctl.UpdateCategory)                      // Route to rename or move a category
+	admin.Delete("/categories/:id", ctl.Require(controllers.PermManageCategories), ctl.DeleteCategory)                   // Route to delete a category
+	admin.Post("/categories/:id/attributes", ctl.Require(controllers.PermManageCategories), ctl.CreateCategoryAttribute) // Route to define an attribute for a category
+	admin.Delete("/attributes/:id", ctl.Require(controllers.PermManageCategories), ctl.DeleteAttribute)                  // Route to delete an attribute definition
+	admin.Put("/exchange-rates/:currency", ctl.Require(controllers.PermManageRates), ctl.SetExchangeRate)                // Route to set the exchange rate of a currency
+	admin.Delete("/exchange-rates/:currency", ctl.Require(controllers.PermManageRates), ctl.DeleteExchangeRate)          // Route to delete the exchange rate of a currency
+	admin.Get("/promotions", ctl.Require(controllers.PermManagePromotions), ctl.ListPromotions)                          // Route to list promotions and coupons
+	admin.Post("/promotions", ctl.Require(controllers.PermManagePromotions), ctl.CreatePromotion)                        // Route to add a promotion or coupon
+	admin.Get("/promotions/:id", ctl.Require(controllers.PermManagePromotions), ctl.GetPromotion)                        // Route to fetch a promotion
+	admin.Put("/promotions/:id", ctl.Require(controllers.PermManagePromotions), ctl.UpdatePromotion)                     // Route to change a promotion
+	admin.Delete("/promotions/:id", ctl.Require(controllers.PermManagePromotions), ctl.DeletePromotion)                  // Route to delete a promotion
+	admin.Get("/tax-rates", ctl.Require(controllers.PermManageTaxes), ctl.ListTaxRates)                                  // Route to list or export the tax rates
+	admin.Put("/tax-rates", ctl.Require(controllers.PermManageTaxes), ctl.ImportTaxRates)                                // Route to replace the tax rates with a CSV table
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alwilion/config"
+	"github.com/alwilion/database"
+	"github.com/alwilion/repository"
+)
+
+// searchUsage describes the search subcommand
+const searchUsage = `usage: app [flags] search reindex
+
+Rebuilds the full-text search index of all products. Products are indexed as they
+are saved, so this is only needed for products created before the index existed.`
+
+// runSearch executes the search subcommand with the remaining command-line arguments
+func runSearch(cfg config.DatabaseConfig, args []string) error {
+	if len(args) != 1 || args[0] != "reindex" {
+		return errors.New(searchUsage)
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	if err := database.Migrate(db, cfg); err != nil {
+		return err
+	}
+	count, err := repository.NewGormProductRepository(db).Reindex()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("indexed %d products\n", count)
+	return nil
+}
@@ -0,0 +1,35 @@
+// Package storage keeps uploaded files, such as product images, in a local directory or an
+// S3-compatible object store. Files are addressed by slash-separated keys like
+// "products/1/images/<uuid>/original.jpg" and served from a public URL.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned when reading a key that holds no file
+var ErrNotFound = errors.New("file not found")
+
+// Storage stores files by key
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error // Put creates or replaces the file of a key
+	Get(ctx context.Context, key string) ([]byte, error)                        // Get returns the content of a file or ErrNotFound
+	Delete(ctx context.Context, key string) error                               // Delete removes a file; deleting a missing file is not an error
+	URL(key string) string                                                      // URL returns the public address of a file
+}
+
+// checkKey rejects keys that are empty, absolute or could escape the storage root
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package tax
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alwilion/models"
+)
+
+// Columns is the header of a tax rate table in CSV
+var Columns = []string{"jurisdiction", "class", "name", "rate"}
+
+// ReadCSV reads a tax rate table: a header row naming the columns jurisdiction, class, name
+// and rate, in any order, followed by one rate per row. Rates are decimal percents such as
+// 8.875. Jurisdictions are converted to upper case and classes to lower case. Errors name
+// the row they were found in; a rate given twice for the same jurisdiction, class and name
+// is an error.
+func ReadCSV(r io.Reader) ([]models.TaxRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("missing header row, expected " + strings.Join(Columns, ","))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Spreadsheets may start the file with a byte order mark
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))] = i
+	}
+	for _, column := range Columns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("missing column %s, expected %s", column, strings.Join(Columns, ","))
+		}
+	}
+
+	rates := []models.TaxRate{}
+	seen := make(map[string]int)
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(column string) string {
+			return strings.TrimSpace(record[index[column]])
+		}
+
+		jurisdiction, err := models.NormalizeJurisdiction(field("jurisdiction"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		class, err := models.NormalizeTaxClass(field("class"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		name := field("name")
+		if name == "" {
+			return nil, fmt.Errorf("row %d: missing name", row)
+		}
+		if _, err := models.ParseTaxRate(field("rate")); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		key := jurisdiction + "\x00" + class + "\x00" + name
+		if first, ok := seen[key]; ok {
+			return nil, fmt.Errorf("row %d: %s %s %q is already given in row %d", row, jurisdiction, class, name, first)
+		}
+		seen[key] = row
+		rates = append(rates, models.TaxRate{Jurisdiction: jurisdiction, Class: class, Name: name, Rate: field("rate")})
+	}
+}
+
+// WriteCSV writes a tax rate table in the format ReadCSV reads
+func WriteCSV(w io.Writer, rates []models.TaxRate) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(Columns); err != nil {
+		return err
+	}
+	for _, r := range rates {
+		if err := writer.Write([]string{r.Jurisdiction, r.Class, r.Name, r.Rate}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,251 @@
+// Package tax computes the taxes of carts and orders from the rates of the jurisdiction they
+// are taxed in.
+//
+// Every line belongs to a tax class, which products inherit from their categories. A
+// jurisdiction levies its own rates on each class together with the rates of the
+// jurisdictions containing it, so US-NY-NYC levies the city, state and country rates. Prices
+// either exclude tax, which is then added to the total, or include it, in which case the tax
+// contained in the price is broken out. Taxes are rounded to minor units either on every
+// line or once per order and rate.
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/alwilion/models"
+)
+
+// Rounding tells when taxes are rounded to minor units
+type Rounding string
+
+// Rounding modes
+const (
+	RoundLine  Rounding = "line"  // Every line is taxed and rounded on its own, the order tax is the sum
+	RoundOrder Rounding = "order" // Each rate is applied to the sum of the lines it taxes and rounded once, then spread over the lines
+)
+
+// IsValid reports whether the rounding is one of the known modes
+func (r Rounding) IsValid() bool {
+	return r == RoundLine || r == RoundOrder
+}
+
+// ErrUnknownJurisdiction is returned when neither the jurisdiction nor one containing it has rates
+var ErrUnknownJurisdiction = errors.New("no tax rates for jurisdiction")
+
+// rate is a tax rate with its parsed value
+type rate struct {
+	models.TaxRate
+	value *big.Rat // Fraction of the taxed amount
+}
+
+// Table holds the tax rates of all jurisdictions
+type Table struct {
+	byJurisdiction map[string][]rate
+}
+
+// NewTable builds a table from stored tax rates
+func NewTable(rates []models.TaxRate) (*Table, error) {
+	t := &Table{byJurisdiction: make(map[string][]rate)}
+	for _, entry := range rates {
+		value, err := models.ParseTaxRate(entry.Rate)
+		if err != nil {
+			return nil, err
+		}
+		t.byJurisdiction[entry.Jurisdiction] = append(t.byJurisdiction[entry.Jurisdiction], rate{TaxRate: entry, value: value})
+	}
+	return t, nil
+}
+
+// Has reports whether the jurisdiction, or one containing it, has rates
+func (t *Table) Has(jurisdiction string) bool {
+	for _, code := range containing(jurisdiction) {
+		if len(t.byJurisdiction[code]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// levied returns the rates a jurisdiction levies on a tax class, the widest jurisdiction first
+func (t *Table) levied(jurisdiction, class string) []rate {
+	var rates []rate
+	for _, code := range containing(jurisdiction) {
+		for _, r := range t.byJurisdiction[code] {
+			if r.Class == class {
+				rates = append(rates, r)
+			}
+		}
+	}
+	return rates
+}
+
+// containing returns the jurisdiction code and the codes of the jurisdictions containing it,
+// the widest first: US, US-NY and US-NY-NYC for US-NY-NYC
+func containing(jurisdiction string) []string {
+	parts := strings.Split(jurisdiction, "-")
+	codes := make([]string, len(parts))
+	for i := range parts {
+		codes[i] = strings.Join(parts[:i+1], "-")
+	}
+	return codes
+}
+
+// Line is a cart or order line to tax
+type Line struct {
+	ItemID uint         // ID of the cart item
+	Class  string       // Tax class of the product
+	Amount models.Money // Total of the line less its discount, in the currency of the order
+}
+
+// Options describe how an order is taxed
+type Options struct {
+	Jurisdiction string   // Jurisdiction the order is taxed in, empty to levy no tax
+	Currency     string   // Currency of the order, the line amounts and the taxes
+	Inclusive    bool     // Whether the line amounts include tax
+	Rounding     Rounding // When taxes are rounded, per line by default
+}
+
+// Result is the tax breakdown of an order
+type Result struct {
+	Jurisdiction string          `json:"jurisdiction,omitempty"`
+	Inclusive    bool            `json:"inclusive"`
+	Taxes        models.TaxLines `json:"taxes"` // Tax of every rate levied, in the order of the lines' classes
+	Total        models.Money    `json:"total"` // Sum of the taxes
+
+	lines map[uint]int64 // Tax of each line by cart item ID
+}
+
+// Line returns the sum of the taxes of a line
+func (r *Result) Line(itemID uint) models.Money {
+	return models.Money{Amount: r.lines[itemID], Currency: r.Total.Currency}
+}
+
+// Add merges the taxes of another order, such as one placed from the same cart, into the result
+func (r *Result) Add(other *Result) {
+	for itemID, amount := range other.lines {
+		r.lines[itemID] += amount
+	}
+	for _, line := range other.Taxes {
+		merged := false
+		for i := range r.Taxes {
+			existing := &r.Taxes[i]
+			if existing.Name == line.Name && existing.Jurisdiction == line.Jurisdiction && existing.Class == line.Class && existing.Rate == line.Rate {
+				existing.Taxable.Amount += line.Taxable.Amount
+				existing.Amount.Amount += line.Amount.Amount
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			r.Taxes = append(r.Taxes, line)
+		}
+	}
+	r.Total.Amount += other.Total.Amount
+}
+
+// Calculate computes the taxes of one order. It returns ErrUnknownJurisdiction when the
+// jurisdiction has no rates; lines whose class has none are not taxed.
+func (t *Table) Calculate(lines []Line, opts Options) (*Result, error) {
+	result := &Result{
+		Jurisdiction: opts.Jurisdiction,
+		Inclusive:    opts.Inclusive,
+		Taxes:        models.TaxLines{},
+		Total:        models.Money{Currency: opts.Currency},
+		lines:        make(map[uint]int64),
+	}
+	if opts.Jurisdiction == "" {
+		return result, nil
+	}
+	if !t.Has(opts.Jurisdiction) {
+		return nil, fmt.Errorf("%w %s", ErrUnknownJurisdiction, opts.Jurisdiction)
+	}
+
+	// Group the lines by class, in the order the classes first appear
+	var classes []string
+	byClass := make(map[string][]int)
+	for i, line := range lines {
+		if _, ok := byClass[line.Class]; !ok {
+			classes = append(classes, line.Class)
+		}
+		byClass[line.Class] = append(byClass[line.Class], i)
+	}
+
+	for _, class := range classes {
+		rates := t.levied(opts.Jurisdiction, class)
+
+		// Prices including tax hold the net price plus every tax of the class
+		divisor := big.NewRat(1, 1)
+		if opts.Inclusive {
+			for _, r := range rates {
+				divisor.Add(divisor, r.value)
+			}
+		}
+
+		indexes := byClass[class]
+		for _, r := range rates {
+			share := new(big.Rat).Quo(r.value, divisor)
+			amounts := make([]int64, len(indexes))
+			var taxable, total int64
+			for n, i := range indexes {
+				taxable += lines[i].Amount.Amount
+				amounts[n] = lines[i].Amount.Amount
+			}
+			if opts.Rounding == RoundOrder {
+				total = apply(taxable, share)
+				allocate(amounts, total, taxable)
+			} else {
+				for n := range amounts {
+					amounts[n] = apply(amounts[n], share)
+					total += amounts[n]
+				}
+			}
+
+			for n, i := range indexes {
+				result.lines[lines[i].ItemID] += amounts[n]
+			}
+			result.Taxes = append(result.Taxes, models.TaxLine{
+				Name:         r.Name,
+				Jurisdiction: r.Jurisdiction,
+				Class:        class,
+				Rate:         r.Rate,
+				Taxable:      models.Money{Amount: taxable, Currency: opts.Currency},
+				Amount:       models.Money{Amount: total, Currency: opts.Currency},
+			})
+			result.Total.Amount += total
+		}
+	}
+	return result, nil
+}
+
+// apply returns the share of an amount, rounded half away from zero
+func apply(amount int64, share *big.Rat) int64 {
+	return models.RoundRat(new(big.Rat).Mul(new(big.Rat).SetInt64(amount), share))
+}
+
+// allocate replaces the amounts of the lines with their part of a total, in proportion to
+// the amounts. The minor units lost to rounding down go to the lines in order, so the parts
+// add up to the total.
+func allocate(amounts []int64, total, sum int64) {
+	weights := append([]int64{}, amounts...)
+	var given int64
+	for n, weight := range weights {
+		amounts[n] = 0
+		if sum > 0 {
+			part := new(big.Int).Mul(big.NewInt(total), big.NewInt(weight))
+			amounts[n] = part.Quo(part, big.NewInt(sum)).Int64()
+		}
+		given += amounts[n]
+	}
+	for n, weight := range weights {
+		if given >= total {
+			break
+		}
+		if weight > 0 {
+			amounts[n]++
+			given++
+		}
+	}
+}
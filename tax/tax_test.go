@@ -0,0 +1,119 @@
+package tax
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alwilion/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func eur(cents int64) models.Money {
+	return models.Money{Amount: cents, Currency: "EUR"}
+}
+
+func usd(cents int64) models.Money {
+	return models.Money{Amount: cents, Currency: "USD"}
+}
+
+func TestCalculate(t *testing.T) {
+	table, err := NewTable([]models.TaxRate{
+		{Jurisdiction: "DE", Class: "standard", Name: "MwSt", Rate: "19"},
+		{Jurisdiction: "DE", Class: "reduced", Name: "MwSt ermäßigt", Rate: "7"},
+		{Jurisdiction: "US-NY", Class: "standard", Name: "NY State Sales Tax", Rate: "4"},
+		{Jurisdiction: "US-NY-NYC", Class: "standard", Name: "NYC Sales Tax", Rate: "4.5"},
+		{Jurisdiction: "US-NY-NYC", Class: "standard", Name: "MCTD", Rate: "0.375"},
+	})
+	assert.NoError(t, err)
+	lines := []Line{
+		{ItemID: 1, Class: "standard", Amount: eur(1012)},
+		{ItemID: 2, Class: "standard", Amount: eur(1012)},
+		{ItemID: 3, Class: "reduced", Amount: eur(500)},
+	}
+
+	// Rounding every line loses what rounding once per order keeps
+	result, err := table.Calculate(lines, Options{Jurisdiction: "DE", Currency: "EUR"})
+	assert.NoError(t, err)
+	assert.Equal(t, eur(384+35), result.Total)
+	assert.Equal(t, eur(192), result.Line(1))
+	if assert.Len(t, result.Taxes, 2) {
+		assert.Equal(t, models.TaxLine{Name: "MwSt", Jurisdiction: "DE", Class: "standard", Rate: "19", Taxable: eur(2024), Amount: eur(384)}, result.Taxes[0])
+		assert.Equal(t, eur(35), result.Taxes[1].Amount)
+	}
+	result, err = table.Calculate(lines, Options{Jurisdiction: "DE", Currency: "EUR", Rounding: RoundOrder})
+	assert.NoError(t, err)
+	assert.Equal(t, eur(385+35), result.Total)
+	assert.Equal(t, eur(193), result.Line(1))
+	assert.Equal(t, eur(192), result.Line(2))
+
+	// Prices including tax hold it, rather than having it added
+	result, err = table.Calculate([]Line{{ItemID: 1, Class: "standard", Amount: eur(1190)}, {ItemID: 2, Class: "standard", Amount: eur(999)}}, Options{Jurisdiction: "DE", Currency: "EUR", Inclusive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, eur(190), result.Line(1))
+	assert.Equal(t, eur(160), result.Line(2))
+
+	// Jurisdictions levy the rates of those containing them; classes without rates are untaxed
+	nyc := []Line{{ItemID: 1, Class: "standard", Amount: usd(10000)}, {ItemID: 2, Class: "reduced", Amount: usd(500)}}
+	result, err = table.Calculate(nyc, Options{Jurisdiction: "US-NY-NYC", Currency: "USD"})
+	assert.NoError(t, err)
+	assert.Equal(t, usd(400+450+38), result.Total)
+	assert.Equal(t, usd(0), result.Line(2))
+	if assert.Len(t, result.Taxes, 3) {
+		assert.Equal(t, "US-NY", result.Taxes[0].Jurisdiction)
+		assert.Equal(t, "NYC Sales Tax", result.Taxes[1].Name)
+	}
+	result, err = table.Calculate([]Line{{ItemID: 1, Class: "standard", Amount: usd(10888)}}, Options{Jurisdiction: "US-NY-NYC", Currency: "USD", Inclusive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, usd(888), result.Total)
+	result, err = table.Calculate(nyc, Options{Jurisdiction: "US-NY-BUF", Currency: "USD"})
+	assert.NoError(t, err)
+	assert.Equal(t, usd(400), result.Total)
+
+	// Merging orders adds up the taxes of the same rates
+	other, _ := table.Calculate([]Line{{ItemID: 3, Class: "standard", Amount: usd(2000)}}, Options{Jurisdiction: "US-NY-BUF", Currency: "USD"})
+	result.Add(other)
+	assert.Equal(t, usd(480), result.Total)
+	assert.Equal(t, usd(80), result.Line(3))
+	if assert.Len(t, result.Taxes, 1) {
+		assert.Equal(t, usd(12000), result.Taxes[0].Taxable)
+	}
+
+	// Without a jurisdiction nothing is taxed; unknown jurisdictions are errors
+	result, err = table.Calculate(nyc, Options{Currency: "USD"})
+	assert.NoError(t, err)
+	assert.Zero(t, result.Total.Amount)
+	assert.Empty(t, result.Taxes)
+	_, err = table.Calculate(nyc, Options{Jurisdiction: "FR", Currency: "EUR"})
+	assert.ErrorIs(t, err, ErrUnknownJurisdiction)
+}
+
+func TestReadCSV(t *testing.T) {
+	rates, err := ReadCSV(strings.NewReader("\ufeffRate,Jurisdiction,Class,Name\n19,de,Standard,MwSt\n8.875, us-ny-nyc ,standard,\"Sales Tax, combined\"\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.TaxRate{
+		{Jurisdiction: "DE", Class: "standard", Name: "MwSt", Rate: "19"},
+		{Jurisdiction: "US-NY-NYC", Class: "standard", Name: "Sales Tax, combined", Rate: "8.875"},
+	}, rates)
+
+	var out bytes.Buffer
+	assert.NoError(t, WriteCSV(&out, rates))
+	assert.Equal(t, "jurisdiction,class,name,rate\nDE,standard,MwSt,19\nUS-NY-NYC,standard,\"Sales Tax, combined\",8.875\n", out.String())
+	again, err := ReadCSV(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, rates, again)
+
+	for input, message := range map[string]string{
+		"":                          "missing header row",
+		"jurisdiction,class,name\n": "missing column rate",
+		"jurisdiction,class,name,rate\nDE,standard,MwSt,abc\n":                    "row 2",
+		"jurisdiction,class,name,rate\nDE,standard,MwSt,150\n":                    "exceeds 100 percent",
+		"jurisdiction,class,name,rate\nDE,standard,,19\n":                         "row 2: missing name",
+		"jurisdiction,class,name,rate\nD E,standard,MwSt,19\n":                    "row 2: jurisdiction",
+		"jurisdiction,class,name,rate\nDE,standard,MwSt,19\nde,standard,MwSt,7\n": "row 3: DE standard \"MwSt\" is already given in row 2",
+		"jurisdiction,class,name,rate\nDE,standard,MwSt,19,extra\n":               "wrong number of fields",
+	} {
+		_, err := ReadCSV(strings.NewReader(input))
+		assert.ErrorContains(t, err, message, input)
+	}
+}
@@ -1,8 +1,14 @@
 package utils
 
 import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"math/rand"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // GenerateRandomString generates a random string of the specified length
@@ -25,3 +31,37 @@ func GenerateRandomString(length int) string {
 	// Convert the byte slice to a string and return the result
 	return string(result)
 }
+
+// GenerateSecureToken returns a URL-safe random string built from n bytes of
+// cryptographically secure randomness, suitable for secrets such as refresh tokens
+func GenerateSecureToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := cryptorand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 digest of a token, used to store secrets without keeping them in clear
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Slugify turns a name into a lower-case URL-friendly identifier, such as "home-garden" for "Home & Garden"
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}